@@ -0,0 +1,204 @@
+// Package admin serves the health, readiness and administration endpoints
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/bucketer"
+	log "github.com/cihub/seelog"
+	"net/http"
+	"time"
+)
+
+// Timeout bounds how long a request waits on the Bucketer or a check
+const Timeout = time.Second * 5
+
+// Check is a readiness check, which returns an error if gostat is not ready
+type Check struct {
+	Name  string
+	Check func() error
+}
+
+// Bucketer is a Bucketer or a Pool of them
+type Bucketer interface {
+	Status(timeout time.Duration) (bucketer.Status, error)
+	Flush(timeout time.Duration) error
+}
+
+// Queue reports on a queue between stages of the pipeline
+type Queue interface {
+	Name() string
+	Len() int
+	Dropped() uint64
+}
+
+// Admin serves the health endpoints
+//
+//	GET  /healthz          200 while the process is alive
+//	GET  /readyz           200 if every readiness check passes, otherwise 503
+//
+// and, since they are not authenticated, on a separate address that only
+// operators can reach, the admin endpoints
+//
+//	GET  /admin/status     the state of the Bucketer, queues and Cassandra writes, and the configuration
+//	POST /admin/flush      publishes the Bucketer's current and previous buckets now
+//	POST /admin/log-level  changes the log level to the level parameter
+type Admin struct {
+	Bucketer    Bucketer
+	Queues      []Queue
+	Checks      []Check
+	LastWrite   func() time.Time       // when a raw stat was last written to Cassandra
+	Config      map[string]interface{} // the settings gostat is running with
+	SetLogLevel func(level string) error
+
+	started time.Time
+}
+
+// Register registers the health endpoints on mux
+func (a *Admin) Register(mux *http.ServeMux) {
+	a.start()
+	mux.HandleFunc("/healthz", a.healthz)
+	mux.HandleFunc("/readyz", a.readyz)
+}
+
+// RegisterAdmin registers the admin endpoints on mux, which must not be
+// reachable by the public
+func (a *Admin) RegisterAdmin(mux *http.ServeMux) {
+	a.start()
+	mux.HandleFunc("/admin/status", a.status)
+	mux.HandleFunc("/admin/flush", a.flush)
+	mux.HandleFunc("/admin/log-level", a.logLevel)
+}
+
+// start records when gostat started, the first time an endpoint is registered
+func (a *Admin) start() {
+	if a.started.IsZero() {
+		a.started = time.Now().UTC()
+	}
+}
+
+func (a *Admin) healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// readyz runs every check, reporting the result of each
+func (a *Admin) readyz(w http.ResponseWriter, r *http.Request) {
+	results := make(map[string]string)
+	ready := true
+
+	for _, c := range a.Checks {
+		if err := check(c); err != nil {
+			ready = false
+			results[c.Name] = err.Error()
+		} else {
+			results[c.Name] = "ok"
+		}
+	}
+
+	if ready {
+		writeJson(w, http.StatusOK, results)
+	} else {
+		writeJson(w, http.StatusServiceUnavailable, results)
+	}
+}
+
+// check runs a check, failing it if it does not finish within the Timeout
+func check(c Check) error {
+	result := make(chan error, 1)
+	go func() { result <- c.Check() }()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(Timeout):
+		return fmt.Errorf("no result within %v", Timeout)
+	}
+}
+
+type queueStatus struct {
+	Depth   int    `json:"depth"`
+	Dropped uint64 `json:"dropped"`
+}
+
+type status struct {
+	Started   time.Time              `json:"started"`
+	Uptime    string                 `json:"uptime"`
+	Bucketer  interface{}            `json:"bucketer"`
+	Queues    map[string]queueStatus `json:"queues"`
+	LastWrite *time.Time             `json:"lastCassandraWrite"`
+	Config    map[string]interface{} `json:"config"`
+}
+
+func (a *Admin) status(w http.ResponseWriter, r *http.Request) {
+	s := status{
+		Started: a.started,
+		Uptime:  time.Since(a.started).Truncate(time.Second).String(),
+		Queues:  make(map[string]queueStatus),
+		Config:  a.Config,
+	}
+
+	if a.Bucketer != nil {
+		if b, err := a.Bucketer.Status(Timeout); err != nil {
+			s.Bucketer = err.Error()
+		} else {
+			s.Bucketer = b
+		}
+	}
+
+	for _, q := range a.Queues {
+		s.Queues[q.Name()] = queueStatus{Depth: q.Len(), Dropped: q.Dropped()}
+	}
+
+	if a.LastWrite != nil {
+		if t := a.LastWrite(); !t.IsZero() {
+			s.LastWrite = &t
+		}
+	}
+
+	writeJson(w, http.StatusOK, s)
+}
+
+func (a *Admin) flush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.Bucketer == nil {
+		http.Error(w, "no Bucketer", http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := a.Bucketer.Flush(Timeout); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	log.Info("admin: flushed the Bucketer")
+	fmt.Fprintln(w, "flushed")
+}
+
+func (a *Admin) logLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.SetLogLevel == nil {
+		http.Error(w, "the log level cannot be changed", http.StatusNotImplemented)
+		return
+	}
+
+	level := r.FormValue("level")
+	if err := a.SetLogLevel(level); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Info("admin: changed the log level to ", level)
+	fmt.Fprintln(w, "log level", level)
+}
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("admin: error writing response: ", err)
+	}
+}
@@ -0,0 +1,160 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/CapillarySoftware/gostat/bucketer"
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+)
+
+type fakeQueue struct {
+	name    string
+	depth   int
+	dropped uint64
+}
+
+func (q fakeQueue) Name() string    { return q.name }
+func (q fakeQueue) Len() int        { return q.depth }
+func (q fakeQueue) Dropped() uint64 { return q.dropped }
+
+var _ = Describe("Admin", func() {
+	var (
+		a   *Admin
+		mux *http.ServeMux
+	)
+
+	BeforeEach(func() {
+		a = &Admin{}
+		mux = http.NewServeMux()
+	})
+
+	serve := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		a.Register(mux)
+		a.RegisterAdmin(mux)
+		var r *http.Request
+		if form != nil {
+			r = httptest.NewRequest(method, path+"?"+form.Encode(), nil)
+		} else {
+			r = httptest.NewRequest(method, path, nil)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	It("should only register the admin endpoints with RegisterAdmin", func() {
+		a.Register(mux)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "/admin/flush", nil))
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+
+	Describe("/healthz", func() {
+		It("should respond OK", func() {
+			Expect(serve("GET", "/healthz", nil).Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Describe("/readyz", func() {
+		It("should respond OK if every check passes", func() {
+			a.Checks = []Check{
+				{Name: "one", Check: func() error { return nil }},
+				{Name: "two", Check: func() error { return nil }},
+			}
+			w := serve("GET", "/readyz", nil)
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			var results map[string]string
+			Expect(json.Unmarshal(w.Body.Bytes(), &results)).To(Succeed())
+			Expect(results).To(Equal(map[string]string{"one": "ok", "two": "ok"}))
+		})
+
+		It("should respond unavailable with the failures if a check fails", func() {
+			a.Checks = []Check{
+				{Name: "one", Check: func() error { return nil }},
+				{Name: "two", Check: func() error { return errors.New("not yet") }},
+			}
+			w := serve("GET", "/readyz", nil)
+			Expect(w.Code).To(Equal(http.StatusServiceUnavailable))
+
+			var results map[string]string
+			Expect(json.Unmarshal(w.Body.Bytes(), &results)).To(Succeed())
+			Expect(results).To(Equal(map[string]string{"one": "ok", "two": "not yet"}))
+		})
+	})
+
+	Describe("/admin/status", func() {
+		It("should report the queues, the last write and the config", func() {
+			lastWrite := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+			a.Queues = []Queue{fakeQueue{"stats", 3, 1}}
+			a.LastWrite = func() time.Time { return lastWrite }
+			a.Config = map[string]interface{}{"sim": true}
+
+			w := serve("GET", "/admin/status", nil)
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			var s status
+			Expect(json.Unmarshal(w.Body.Bytes(), &s)).To(Succeed())
+			Expect(s.Queues).To(Equal(map[string]queueStatus{"stats": {Depth: 3, Dropped: 1}}))
+			Expect(*s.LastWrite).To(Equal(lastWrite))
+			Expect(s.Config).To(Equal(map[string]interface{}{"sim": true}))
+		})
+
+		It("should report the buckets of a running Bucketer", func() {
+			shutdown := make(chan bool)
+			defer close(shutdown)
+			b := bucketer.NewBucketer(make(chan *stat.Stat), make(chan []*stat.Stat), shutdown)
+			go b.Run(time.Hour)
+			a.Bucketer = b
+
+			w := serve("GET", "/admin/status", nil)
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			var s struct{ Bucketer bucketer.Status }
+			Expect(json.Unmarshal(w.Body.Bytes(), &s)).To(Succeed())
+			Expect(s.Bucketer.Current.Start).NotTo(BeZero())
+		})
+	})
+
+	Describe("/admin/flush", func() {
+		It("should flush a running Bucketer", func() {
+			output := make(chan []*stat.Stat, 1)
+			shutdown := make(chan bool)
+			defer close(shutdown)
+			input := make(chan *stat.Stat)
+			b := bucketer.NewBucketer(input, output, shutdown)
+			go b.Run(time.Hour)
+			a.Bucketer = b
+
+			s := stat.Stat{Name: "foo", Timestamp: time.Now().UTC(), Value: 1}
+			input <- &s
+			Expect(serve("POST", "/admin/flush", nil).Code).To(Equal(http.StatusOK))
+			Expect(<-output).To(ConsistOf(&s))
+		})
+
+		It("should only accept POST", func() {
+			Expect(serve("GET", "/admin/flush", nil).Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
+	Describe("/admin/log-level", func() {
+		It("should change the log level", func() {
+			var level string
+			a.SetLogLevel = func(l string) error { level = l; return nil }
+
+			Expect(serve("POST", "/admin/log-level", url.Values{"level": {"debug"}}).Code).To(Equal(http.StatusOK))
+			Expect(level).To(Equal("debug"))
+		})
+
+		It("should reject a level that cannot be set", func() {
+			a.SetLogLevel = func(l string) error { return errors.New("unknown log level") }
+			Expect(serve("POST", "/admin/log-level", url.Values{"level": {"loud"}}).Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
@@ -0,0 +1,86 @@
+package aggregator
+
+import (
+	"github.com/CapillarySoftware/gostat/clock"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"time"
+)
+
+// Aggregator aggregates the buckets published by a Bucketer.
+//
+// A Bucketer publishes its current and previous buckets repeatedly as stats
+// arrive, so the same name and minute is aggregated more than once. Each
+// BucketAggregate covers the whole bucket as it was when published, and
+// supersedes any earlier BucketAggregate with the same name and time
+type Aggregator struct {
+	input    <-chan []*stat.Stat       // buckets of stats are read from this channel
+	outputs  []chan<- *BucketAggregate // aggregates are written to each of these channels
+	shutdown <-chan bool               // signals a graceful shutdown
+	clock    clock.Clock               // tells the time of shutting down
+}
+
+// NewAggregator constructs an Aggregator running on the system clock
+func NewAggregator(bucketedStats <-chan []*stat.Stat, shutdown <-chan bool, outputs ...chan<- *BucketAggregate) *Aggregator {
+	return NewAggregatorWithClock(bucketedStats, shutdown, clock.Real, outputs...)
+}
+
+// NewAggregatorWithClock constructs an Aggregator running on a clock
+func NewAggregatorWithClock(bucketedStats <-chan []*stat.Stat, shutdown <-chan bool, c clock.Clock, outputs ...chan<- *BucketAggregate) *Aggregator {
+	return &Aggregator{
+		input:    bucketedStats,
+		outputs:  outputs,
+		shutdown: shutdown,
+		clock:    c,
+	}
+}
+
+// Run is a goroutine that aggregates each bucket read from the input channel
+// and writes the result to every output channel
+func (a *Aggregator) Run() {
+	done := false
+
+	for !done {
+		select {
+		case bucket := <-a.input:
+			a.aggregate(bucket)
+		case <-a.shutdown:
+			log.Info("Aggregator shutting down ", a.clock.Now())
+			done = true
+			a.drain()
+		}
+	}
+
+	log.Info("Aggregator Run() exiting ", a.clock.Now())
+}
+
+// drain aggregates the buckets already waiting on the input channel, such as
+// the final buckets a Bucketer publishes when it shuts down
+func (a *Aggregator) drain() {
+	for {
+		select {
+		case bucket := <-a.input:
+			a.aggregate(bucket)
+		default:
+			return
+		}
+	}
+}
+
+// aggregate aggregates a bucket and publishes the result
+func (a *Aggregator) aggregate(bucket []*stat.Stat) {
+	if len(bucket) == 0 {
+		return
+	}
+
+	agg := &BucketAggregate{
+		Name:           bucket[0].Name,
+		Time:           bucket[0].Timestamp.UTC().Truncate(time.Minute),
+		StatsAggregate: Aggregate(bucket),
+	}
+	log.Debugf("Aggregator aggregate: %#v", agg)
+
+	for _, output := range a.outputs {
+		output <- agg
+	}
+}
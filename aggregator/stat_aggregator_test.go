@@ -0,0 +1,55 @@
+package aggregator
+
+import (
+	"github.com/CapillarySoftware/gostat/clock"
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("Aggregator", func() {
+
+	Describe("aggregate", func() {
+		It("should publish the aggregate of a bucket to every output", func(done Done) {
+			minute := time.Date(2014, 10, 1, 12, 30, 0, 0, time.UTC)
+			output1 := make(chan *BucketAggregate, 1)
+			output2 := make(chan *BucketAggregate, 1)
+			x := NewAggregator(make(chan []*stat.Stat), make(chan bool), output1, output2)
+
+			x.aggregate([]*stat.Stat{
+				{Name: "foo", Timestamp: minute.Add(time.Second * 5), Value: 1},
+				{Name: "foo", Timestamp: minute.Add(time.Second * 10), Value: 3}})
+
+			expected := &BucketAggregate{Name: "foo", Time: minute, StatsAggregate: StatsAggregate{Average: 2, Min: 1, Max: 3, Count: 2}}
+			Expect(<-output1).To(Equal(expected))
+			Expect(<-output2).To(Equal(expected))
+			close(done)
+		})
+
+		It("should not publish anything for an empty bucket", func() {
+			output := make(chan *BucketAggregate, 1)
+			x := NewAggregator(make(chan []*stat.Stat), make(chan bool), output)
+
+			x.aggregate([]*stat.Stat{})
+			Expect(output).To(BeEmpty())
+		})
+	})
+
+	Describe("Run", func() {
+		It("should aggregate the buckets waiting on the input when shut down", func(done Done) {
+			minute := time.Date(2014, 10, 1, 12, 30, 0, 0, time.UTC)
+			input := make(chan []*stat.Stat, 1)
+			output := make(chan *BucketAggregate, 1)
+			shutdown := make(chan bool)
+			x := NewAggregatorWithClock(input, shutdown, clock.NewFake(minute), output)
+
+			input <- []*stat.Stat{{Name: "foo", Timestamp: minute, Value: 1}}
+			close(shutdown)
+			x.Run()
+
+			Expect(<-output).To(Equal(&BucketAggregate{Name: "foo", Time: minute, StatsAggregate: StatsAggregate{Average: 1, Min: 1, Max: 1, Count: 1}}))
+			close(done)
+		})
+	})
+})
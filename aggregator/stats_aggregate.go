@@ -1,8 +1,18 @@
 // Package aggregator aggregates statistics
 package aggregator
 
+import "time"
+
 // StatsAggregate represents the computed aggregation of a collection of stats
 type StatsAggregate struct {
 	Average, Min, Max float64
 	Count int
-}
\ No newline at end of file
+}
+
+// BucketAggregate is the aggregation of one bucket of stats: the stats that
+// share a name and fall within the same minute
+type BucketAggregate struct {
+	Name string
+	Time time.Time // the start of the bucket's minute
+	StatsAggregate
+}
@@ -0,0 +1,123 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/query"
+	"io/ioutil"
+	"time"
+)
+
+// Config is the format of a rules file: the rules to evaluate, and how to
+// notify people when they change state
+type Config struct {
+	Rules     []*Rule           `json:"rules"`
+	Notifiers []*NotifierConfig `json:"notifiers"`
+	Silences  []*Silence        `json:"silences"`
+
+	// Dashboard is the base URL of the gostat dashboard, used to link back to a stat
+	Dashboard string `json:"dashboard"`
+
+	// Subject and Body are text/template templates of the notification message
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+
+	// GroupWait is how long to wait for other alerts to send in the same notification
+	GroupWait string `json:"groupWait"`
+
+	// RepeatInterval is how long to wait before notifying the same rule firing again
+	RepeatInterval string `json:"repeatInterval"`
+
+	// Retries is how many times a failed notification is retried, waiting Backoff
+	// before the first retry and twice as long before each one after it
+	Retries int    `json:"retries"`
+	Backoff string `json:"backoff"`
+
+	groupWait, repeatInterval, backoff time.Duration
+}
+
+// LoadConfig reads and validates a JSON rules file
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("alert: error parsing rules file %s: %v", path, err)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// validate checks the configuration and fills in its defaults
+func (c *Config) validate() error {
+	names := make(map[string]bool)
+	for _, r := range c.Rules {
+		if err := r.validate(); err != nil {
+			return err
+		}
+		if names[r.Name] {
+			return fmt.Errorf("alert: duplicate rule name %q", r.Name)
+		}
+		names[r.Name] = true
+	}
+
+	for _, n := range c.Notifiers {
+		if _, err := n.notifier(); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range c.Silences {
+		if !s.End.After(s.Start) {
+			return fmt.Errorf("alert: silence of %q must end after it starts", s.Rule)
+		}
+	}
+
+	if c.Subject == "" {
+		c.Subject = defaultSubject
+	}
+	if c.Body == "" {
+		c.Body = defaultBody
+	}
+	if _, err := newTemplates(c.Subject, c.Body); err != nil {
+		return err
+	}
+
+	var err error
+	if c.groupWait, err = parseDuration("groupWait", c.GroupWait, time.Second*30); err != nil {
+		return err
+	}
+	if c.repeatInterval, err = parseDuration("repeatInterval", c.RepeatInterval, time.Hour*4); err != nil {
+		return err
+	}
+	if c.backoff, err = parseDuration("backoff", c.Backoff, time.Second*5); err != nil {
+		return err
+	}
+	if c.Retries < 0 {
+		return fmt.Errorf("alert: retries must not be negative")
+	}
+	return nil
+}
+
+// parseDuration parses a duration setting, a query duration such as 4h or 1d,
+// or a Go duration such as 500ms, returning def if it is not set
+func parseDuration(name, s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	d, err := query.ParseDuration(s)
+	if err != nil {
+		if d, goErr := time.ParseDuration(s); goErr == nil && d >= 0 {
+			return d, nil
+		}
+		return 0, fmt.Errorf("alert: %s: %v", name, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,209 @@
+package alert
+
+import (
+	log "github.com/cihub/seelog"
+	"sync"
+	"time"
+)
+
+// Dispatcher notifies people of alerts. Alerts that fire or resolve close
+// together are grouped into one notification, a rule that fires again soon
+// after it was last notified is not notified again, and silenced rules are not
+// notified at all. Failed notifications are retried with exponential backoff.
+// When shut down, it waits for the notifications being sent, which give up
+// retrying, so that it is held up by at most one attempt of each
+type Dispatcher struct {
+	notifiers []Notifier
+	silences  []*Silence
+	templates *templates
+	dashboard string
+
+	groupWait      time.Duration
+	repeatInterval time.Duration
+	retries        int
+	backoff        time.Duration
+
+	group      []*Alert             // alerts waiting to be sent together
+	lastFiring map[string]time.Time // when each rule was last notified as firing
+	firing     map[string]bool      // rules notified as firing but not yet as resolved
+	sending    sync.WaitGroup       // the notifications being sent
+
+	input    <-chan *Event // state changes are read from this channel
+	shutdown <-chan bool   // signals a graceful shutdown
+}
+
+// NewDispatcher constructs a Dispatcher from the notification settings of a
+// rules file
+func NewDispatcher(config *Config, events <-chan *Event, shutdown <-chan bool) (*Dispatcher, error) {
+	t, err := newTemplates(config.Subject, config.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Dispatcher{
+		silences:       config.Silences,
+		templates:      t,
+		dashboard:      config.Dashboard,
+		groupWait:      config.groupWait,
+		repeatInterval: config.repeatInterval,
+		retries:        config.Retries,
+		backoff:        config.backoff,
+		lastFiring:     make(map[string]time.Time),
+		firing:         make(map[string]bool),
+		input:          events,
+		shutdown:       shutdown,
+	}
+
+	for _, c := range config.Notifiers {
+		n, err := c.notifier()
+		if err != nil {
+			return nil, err
+		}
+		d.notifiers = append(d.notifiers, n)
+	}
+
+	return d, nil
+}
+
+// Run is a goroutine that groups the events read from the input channel into
+// notifications
+func (d *Dispatcher) Run() {
+	done := false
+	var groupTimer <-chan time.Time
+
+	for !done {
+		select {
+		case e := <-d.input:
+			if d.add(e, time.Now()) && groupTimer == nil {
+				groupTimer = time.After(d.groupWait)
+			}
+		case <-groupTimer:
+			groupTimer = nil
+			d.dispatch(d.flush())
+		case <-d.shutdown:
+			log.Info("alert Dispatcher shutting down ", time.Now())
+			done = true
+			d.drain()
+			d.dispatch(d.flush())
+			d.sending.Wait()
+		}
+	}
+
+	log.Info("alert Dispatcher Run() exiting ", time.Now())
+}
+
+// drain adds the events already waiting on the input channel to the group
+func (d *Dispatcher) drain() {
+	for {
+		select {
+		case e := <-d.input:
+			d.add(e, time.Now())
+		default:
+			return
+		}
+	}
+}
+
+// add adds an event to the group waiting to be sent, unless it should not be
+// notified. It reports whether the event was added
+func (d *Dispatcher) add(e *Event, now time.Time) bool {
+	name := e.Rule.Name
+
+	switch e.State {
+	case Firing:
+		if last, ok := d.lastFiring[name]; ok && now.Sub(last) < d.repeatInterval {
+			log.Infof("not notifying alert %q firing again within %v", name, d.repeatInterval)
+			return false
+		}
+	case Resolved:
+		if !d.firing[name] {
+			return false
+		}
+	default:
+		return false // only firing and resolved alerts are notified
+	}
+
+	for _, s := range d.silences {
+		if s.silences(name, now) {
+			log.Infof("alert %q %s is silenced until %v", name, e.State, s.End)
+			return false
+		}
+	}
+
+	if e.State == Firing {
+		d.lastFiring[name] = now
+		d.firing[name] = true
+	} else {
+		delete(d.firing, name)
+	}
+
+	// a newer state replaces any older one for the same rule in the group
+	alert := newAlert(e, d.dashboard)
+	for i, a := range d.group {
+		if a.Rule == name {
+			d.group = append(d.group[:i], d.group[i+1:]...)
+			break
+		}
+	}
+	d.group = append(d.group, alert)
+	return true
+}
+
+// flush renders the group of alerts waiting to be sent as a notification, or
+// returns nil if there are none
+func (d *Dispatcher) flush() *Notification {
+	if len(d.group) == 0 {
+		return nil
+	}
+
+	n, err := d.templates.render(d.group)
+	d.group = nil
+	if err != nil {
+		log.Error("error rendering alert notification: ", err)
+		return nil
+	}
+	return n
+}
+
+// dispatch sends a notification, if not nil, via each notifier in the
+// background
+func (d *Dispatcher) dispatch(n *Notification) {
+	if n == nil {
+		return
+	}
+	for _, notifier := range d.notifiers {
+		d.sending.Add(1)
+		go func(notifier Notifier) {
+			defer d.sending.Done()
+			d.send(notifier, n)
+		}(notifier)
+	}
+}
+
+// send delivers a notification, retrying with exponential backoff if it fails,
+// until the Dispatcher is shut down
+func (d *Dispatcher) send(notifier Notifier, n *Notification) {
+	backoff := d.backoff
+
+	for attempt := 0; ; attempt++ {
+		err := notifier.Notify(n)
+		if err == nil {
+			log.Infof("sent alert notification %q via %s", n.Subject, notifier)
+			return
+		}
+
+		if attempt >= d.retries {
+			log.Errorf("giving up sending alert notification %q via %s after %d attempts: %v", n.Subject, notifier, attempt+1, err)
+			return
+		}
+
+		log.Warnf("error sending alert notification via %s, retrying in %v: %v", notifier, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-d.shutdown:
+			log.Errorf("giving up sending alert notification %q via %s, shutting down: %v", n.Subject, notifier, err)
+			return
+		}
+		backoff *= 2
+	}
+}
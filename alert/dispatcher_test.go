@@ -0,0 +1,202 @@
+package alert
+
+import (
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sync"
+	"time"
+)
+
+// fakeNotifier records notifications, failing the first failures of them
+type fakeNotifier struct {
+	sync.Mutex
+	failures      int
+	attempts      int
+	notifications []*Notification
+}
+
+func (f *fakeNotifier) Notify(n *Notification) error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.attempts++
+	if f.attempts <= f.failures {
+		return errors.New("unavailable")
+	}
+	f.notifications = append(f.notifications, n)
+	return nil
+}
+
+func (f *fakeNotifier) String() string {
+	return "fake"
+}
+
+// blockingNotifier signals started when asked to notify, and then waits to be
+// released
+type blockingNotifier struct {
+	started, release chan bool
+	sent             bool
+}
+
+func (b *blockingNotifier) Notify(n *Notification) error {
+	close(b.started)
+	<-b.release
+	b.sent = true
+	return nil
+}
+
+func (b *blockingNotifier) String() string {
+	return "blocking"
+}
+
+var _ = Describe("Dispatcher", func() {
+
+	var config *Config
+	var now time.Time
+	latency := &Rule{Name: "latency", Stat: "api.latency", Aggregate: "avg", Op: ">", Threshold: 300}
+	apiErrors := &Rule{Name: "errors", Stat: "api.errors", Aggregate: "sum", Op: ">", Threshold: 10}
+
+	newDispatcher := func() *Dispatcher {
+		Expect(config.validate()).To(BeNil())
+		d, err := NewDispatcher(config, make(chan *Event), make(chan bool))
+		Expect(err).To(BeNil())
+		return d
+	}
+
+	event := func(r *Rule, state State, value float64) *Event {
+		return &Event{Rule: r, State: state, Value: value, Time: now.Truncate(time.Minute)}
+	}
+
+	BeforeEach(func() {
+		config = &Config{Dashboard: "http://gostat:5000/", Backoff: "1ms", RepeatInterval: "1h"}
+		now = time.Date(2014, 10, 1, 12, 0, 30, 0, time.UTC)
+	})
+
+	It("should group firing and resolved alerts into one notification", func() {
+		d := newDispatcher()
+
+		Expect(d.add(event(latency, Firing, 400), now)).To(BeTrue())
+		Expect(d.add(event(apiErrors, Firing, 11), now)).To(BeTrue())
+
+		n := d.flush()
+		Expect(n.Alerts).To(HaveLen(2))
+		Expect(n.Subject).To(Equal("[gostat] latency firing, errors firing"))
+		Expect(n.Body).To(ContainSubstring("latency is firing: avg of api.latency was 400 (> 300)"))
+		Expect(n.Body).To(ContainSubstring("http://gostat:5000/?stat=api.latency"))
+
+		Expect(d.flush()).To(BeNil())
+	})
+
+	It("should not notify pending or ok alerts", func() {
+		d := newDispatcher()
+
+		Expect(d.add(event(latency, Pending, 400), now)).To(BeFalse())
+		Expect(d.add(event(latency, OK, 100), now)).To(BeFalse())
+		Expect(d.flush()).To(BeNil())
+	})
+
+	It("should only notify a resolved alert that was notified as firing", func() {
+		d := newDispatcher()
+
+		Expect(d.add(event(latency, Resolved, 100), now)).To(BeFalse())
+
+		Expect(d.add(event(latency, Firing, 400), now)).To(BeTrue())
+		Expect(d.add(event(latency, Resolved, 100), now)).To(BeTrue())
+
+		// the resolution replaces the firing alert that was not sent yet
+		n := d.flush()
+		Expect(n.Alerts).To(HaveLen(1))
+		Expect(n.Alerts[0].State).To(Equal(Resolved))
+	})
+
+	It("should not notify a rule firing again within the repeat interval", func() {
+		d := newDispatcher()
+
+		Expect(d.add(event(latency, Firing, 400), now)).To(BeTrue())
+		Expect(d.add(event(latency, Resolved, 100), now.Add(time.Minute))).To(BeTrue())
+		Expect(d.add(event(latency, Firing, 400), now.Add(time.Minute*2))).To(BeFalse())
+		Expect(d.add(event(latency, Resolved, 100), now.Add(time.Minute*3))).To(BeFalse())
+		Expect(d.add(event(latency, Firing, 400), now.Add(time.Hour*2))).To(BeTrue())
+	})
+
+	It("should not notify silenced rules during the silence", func() {
+		config.Silences = []*Silence{{Rule: "lat*", Start: now.Add(-time.Minute), End: now.Add(time.Minute)}}
+		d := newDispatcher()
+
+		Expect(d.add(event(latency, Firing, 400), now)).To(BeFalse())
+		Expect(d.add(event(apiErrors, Firing, 11), now)).To(BeTrue())
+		Expect(d.add(event(latency, Firing, 400), now.Add(time.Minute))).To(BeTrue())
+	})
+
+	It("should render custom templates", func() {
+		config.Subject = "{{len .Alerts}} alerts"
+		config.Body = "{{range .Alerts}}{{.Stat}}={{.Value}} {{end}}"
+		d := newDispatcher()
+
+		d.add(event(latency, Firing, 400), now)
+		n := d.flush()
+		Expect(n.Subject).To(Equal("1 alerts"))
+		Expect(n.Body).To(Equal("api.latency=400 "))
+	})
+
+	It("should retry a failed notification with backoff", func() {
+		config.Retries = 2
+		d := newDispatcher()
+		notifier := &fakeNotifier{failures: 2}
+
+		d.add(event(latency, Firing, 400), now)
+		d.send(notifier, d.flush())
+		Expect(notifier.attempts).To(Equal(3))
+		Expect(notifier.notifications).To(HaveLen(1))
+	})
+
+	It("should wait for the notifications being sent when shut down", func() {
+		config.GroupWait = "1ms"
+		events, shutdown := make(chan *Event), make(chan bool)
+		Expect(config.validate()).To(BeNil())
+		d, err := NewDispatcher(config, events, shutdown)
+		Expect(err).To(BeNil())
+		notifier := &blockingNotifier{started: make(chan bool), release: make(chan bool)}
+		d.notifiers = []Notifier{notifier}
+
+		exited := make(chan bool)
+		go func() {
+			d.Run()
+			close(exited)
+		}()
+		events <- event(latency, Firing, 400)
+		<-notifier.started
+
+		close(shutdown)
+		Consistently(exited, "50ms").ShouldNot(BeClosed())
+		close(notifier.release)
+		Eventually(exited).Should(BeClosed())
+		Expect(notifier.sent).To(BeTrue())
+	})
+
+	It("should stop retrying a notification when shut down", func() {
+		config.Retries, config.Backoff = 5, "1h"
+		shutdown := make(chan bool)
+		Expect(config.validate()).To(BeNil())
+		d, err := NewDispatcher(config, make(chan *Event), shutdown)
+		Expect(err).To(BeNil())
+		notifier := &fakeNotifier{failures: 5}
+
+		d.add(event(latency, Firing, 400), now)
+		close(shutdown)
+		d.send(notifier, d.flush())
+		Expect(notifier.attempts).To(Equal(1))
+	})
+
+	It("should give up after the configured number of retries", func() {
+		config.Retries = 1
+		d := newDispatcher()
+		notifier := &fakeNotifier{failures: 5}
+
+		d.add(event(latency, Firing, 400), now)
+		d.send(notifier, d.flush())
+		Expect(notifier.attempts).To(Equal(2))
+		Expect(notifier.notifications).To(BeEmpty())
+	})
+})
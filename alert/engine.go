@@ -0,0 +1,314 @@
+package alert
+
+import (
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/clock"
+	"github.com/CapillarySoftware/gostat/query"
+	log "github.com/cihub/seelog"
+	"sync"
+	"time"
+)
+
+// Event records a rule changing state
+type Event struct {
+	Rule     *Rule
+	State    State
+	Previous State
+	Value    float64
+	Time     time.Time // the bucket the rule was evaluated for
+}
+
+// Engine evaluates alert rules once a minute against the aggregates produced by
+// an Aggregator. The state of every rule is saved after each evaluation so that
+// pending and firing alerts survive a restart. Buckets only count as
+// consecutive if they are evaluated one after another, so a rule pending before
+// minutes were skipped, e.g. by a restart, starts counting again.
+//
+// A minute is evaluated once the Bucketer has finalized it, every source Awaited
+// has passed it, and the Aggregator has had an interval to catch up. Minutes
+// before the first whole minute the Bucketer saw are never evaluated, since
+// their stats were received, if at all, before a restart
+type Engine struct {
+	rules     []*Rule
+	states    map[string]*RuleState
+	statePath string
+
+	buckets   map[string]map[time.Time]aggregator.StatsAggregate // the latest aggregate of each stat, by minute
+	retention time.Duration                                      // the longest rule window
+
+	input    <-chan *aggregator.BucketAggregate // aggregates are read from this channel
+	output   chan<- *Event                      // state changes are written to this channel, if not nil
+	shutdown <-chan bool                        // signals a graceful shutdown
+	clock    clock.Clock                        // schedules evaluations
+
+	mu        sync.Mutex
+	finalized time.Time   // the time before which the Bucketer has finalized every minute
+	horizons  []time.Time // the time before which each source Awaited has sent every aggregate
+	started   time.Time   // the first minute evaluated
+}
+
+// NewEngine constructs an Engine, restoring any rule states saved at statePath
+func NewEngine(rules []*Rule, statePath string, aggregates <-chan *aggregator.BucketAggregate, events chan<- *Event, shutdown <-chan bool) (*Engine, error) {
+	return NewEngineWithClock(rules, statePath, aggregates, events, shutdown, clock.Real)
+}
+
+// NewEngineWithClock constructs an Engine evaluating on the ticks of a clock
+func NewEngineWithClock(rules []*Rule, statePath string, aggregates <-chan *aggregator.BucketAggregate, events chan<- *Event, shutdown <-chan bool, c clock.Clock) (*Engine, error) {
+	states, err := loadStates(statePath)
+	if err != nil {
+		return nil, log.Errorf("error loading alert states from %s: %v", statePath, err)
+	}
+
+	e := &Engine{
+		rules:     rules,
+		states:    make(map[string]*RuleState),
+		statePath: statePath,
+		buckets:   make(map[string]map[time.Time]aggregator.StatsAggregate),
+		retention: time.Minute,
+		input:     aggregates,
+		output:    events,
+		shutdown:  shutdown,
+		clock:     c,
+	}
+
+	for _, r := range rules {
+		if s, ok := states[r.Name]; ok {
+			e.states[r.Name] = s
+		} else {
+			e.states[r.Name] = &RuleState{State: OK}
+		}
+
+		if r.window > e.retention {
+			e.retention = r.window
+		}
+	}
+
+	return e, nil
+}
+
+// Finalized records the time before which the Bucketer will no longer publish
+// any stats. It is passed to Bucketer.OnFinalized
+func (e *Engine) Finalized(before time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.started.IsZero() {
+		// the Bucketer started during the minute at before, so that minute
+		// lacks the stats received before then
+		e.started = before.Add(time.Minute)
+	}
+	e.finalized = before
+}
+
+// Await registers a source sending aggregates of a minute after the Bucketer
+// finalizes it, such as the anomaly Detector or the relay Merger, returning the
+// function it tells the time before which it has sent every aggregate. No
+// minute is evaluated until every source has passed it
+func (e *Engine) Await() func(before time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	i := len(e.horizons)
+	e.horizons = append(e.horizons, time.Time{})
+	return func(before time.Time) {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.horizons[i] = before
+	}
+}
+
+// finalizedBefore returns the time before which every minute is finalized and
+// has been passed by every source awaited
+func (e *Engine) finalizedBefore() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	before := e.finalized
+	for _, h := range e.horizons {
+		if h.Before(before) {
+			before = h
+		}
+	}
+	return before
+}
+
+func (e *Engine) startedAt() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.started
+}
+
+// Run is a goroutine that records aggregates read from the input channel and
+// evaluates the rules each time a minute is finalized, writing any state
+// changes to the output channel
+func (e *Engine) Run() {
+	done := false
+	evaluateTicker := e.clock.NewTicker(time.Second * 10)
+
+	var settled time.Time // the finalized time as of the last tick
+
+	for !done {
+		select {
+		case a := <-e.input:
+			e.record(a)
+		case <-e.shutdown:
+			log.Info("alert Engine shutting down ", e.clock.Now())
+			done = true
+			evaluateTicker.Stop()
+			e.drain()
+		case <-evaluateTicker.C():
+			for _, event := range e.evaluateBefore(settled) {
+				if e.output != nil {
+					e.output <- event
+				}
+			}
+			settled = e.finalizedBefore()
+		}
+	}
+
+	log.Info("alert Engine Run() exiting ", e.clock.Now())
+}
+
+// drain records the aggregates already waiting on the input channel
+func (e *Engine) drain() {
+	for {
+		select {
+		case a := <-e.input:
+			e.record(a)
+		default:
+			return
+		}
+	}
+}
+
+// record keeps the latest aggregate for a stat's bucket
+func (e *Engine) record(a *aggregator.BucketAggregate) {
+	minutes, ok := e.buckets[a.Name]
+	if !ok {
+		minutes = make(map[time.Time]aggregator.StatsAggregate)
+		e.buckets[a.Name] = minutes
+	}
+	minutes[a.Time] = a.StatsAggregate
+}
+
+// evaluateBefore evaluates every rule for each finalized minute before before
+// it has not been evaluated for yet, and returns the resulting state changes
+func (e *Engine) evaluateBefore(before time.Time) []*Event {
+	started := e.startedAt()
+	if before.IsZero() || started.IsZero() {
+		return nil
+	}
+	closed := before.Add(-time.Minute)
+	var events []*Event
+	evaluated := false
+
+	for _, r := range e.rules {
+		state := e.states[r.Name]
+
+		// catch up on the minutes missed since the last evaluation, but never
+		// further back than the data that is still held
+		t := state.Evaluated.Add(time.Minute)
+		if t.Before(closed.Add(-e.retention)) {
+			t = closed
+		}
+		if t.Before(started) {
+			t = started
+		}
+
+		for ; !t.After(closed); t = t.Add(time.Minute) {
+			evaluated = true
+			if event := e.evaluate(r, state, t); event != nil {
+				events = append(events, event)
+			}
+		}
+	}
+
+	e.prune(closed.Add(-e.retention))
+
+	if evaluated {
+		if err := saveStates(e.statePath, e.states); err != nil {
+			log.Error("error saving alert states to ", e.statePath, ": ", err)
+		}
+	}
+	return events
+}
+
+// evaluate evaluates a rule for the bucket starting at t, returning an Event
+// if the rule changed state
+func (e *Engine) evaluate(r *Rule, state *RuleState, t time.Time) *Event {
+	a := e.aggregate(r, t)
+	value := r.value(a)
+
+	// without any stats there is no average, min or max to compare
+	breached := r.breached(value)
+	if a.Count == 0 && r.Aggregate != "count" && r.Aggregate != "sum" {
+		breached = false
+	}
+
+	previous := state.State
+	if !t.Equal(state.Evaluated.Add(time.Minute)) {
+		state.Consecutive = 0 // the minutes between were not evaluated
+	}
+	state.Value = value
+	state.Evaluated = t
+
+	if breached {
+		state.Consecutive++
+		if state.Consecutive >= int(r.For) || previous == Firing {
+			state.State = Firing
+		} else {
+			state.State = Pending
+		}
+	} else {
+		state.Consecutive = 0
+		if previous == Firing {
+			state.State = Resolved
+		} else {
+			state.State = OK
+		}
+	}
+
+	if state.State == previous {
+		return nil
+	}
+	state.Since = t
+
+	event := &Event{Rule: r, State: state.State, Previous: previous, Value: value, Time: t}
+	if event.State == Firing {
+		log.Warnf("alert %q firing: %s (value %v at %v)", r.Name, r, value, t)
+	} else {
+		log.Infof("alert %q %s: %s (value %v at %v)", r.Name, event.State, r, value, t)
+	}
+	return event
+}
+
+// aggregate combines the aggregates of every stat matching the rule over the
+// rule's window ending with the bucket starting at t
+func (e *Engine) aggregate(r *Rule, t time.Time) (a aggregator.StatsAggregate) {
+	from := t.Add(-r.window)
+
+	for name, minutes := range e.buckets {
+		if !query.MatchName(r.Stat, name) {
+			continue
+		}
+
+		for m, b := range minutes {
+			if m.After(from) && !m.After(t) {
+				a = aggregator.AppendStatsAggregate(a, b)
+			}
+		}
+	}
+	return
+}
+
+// prune discards aggregates for minutes before t
+func (e *Engine) prune(t time.Time) {
+	for name, minutes := range e.buckets {
+		for m := range minutes {
+			if m.Before(t) {
+				delete(minutes, m)
+			}
+		}
+
+		if len(minutes) == 0 {
+			delete(e.buckets, name)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package alert
+
+import (
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/anomaly"
+	"github.com/CapillarySoftware/gostat/clock"
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("Engine", func() {
+
+	var dir, statePath string
+	var start time.Time
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "gostat-alert")
+		Expect(err).To(BeNil())
+		statePath = filepath.Join(dir, "state.json")
+		start = time.Date(2014, 10, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	newEngine := func(rules ...*Rule) *Engine {
+		for _, r := range rules {
+			Expect(r.validate()).To(BeNil())
+		}
+		e, err := NewEngine(rules, statePath, make(chan *aggregator.BucketAggregate), nil, make(chan bool))
+		Expect(err).To(BeNil())
+		e.started = start
+		return e
+	}
+
+	// minute returns the start of the nth minute after start
+	minute := func(n int) time.Time {
+		return start.Add(time.Minute * time.Duration(n))
+	}
+
+	record := func(e *Engine, name string, n int, value float64) {
+		e.record(&aggregator.BucketAggregate{Name: name, Time: minute(n),
+			StatsAggregate: aggregator.StatsAggregate{Average: value, Min: value, Max: value, Count: 1}})
+	}
+
+	// evaluate evaluates the engine for the nth minute, which is final once the
+	// Bucketer has finalized the minutes before the following one
+	evaluate := func(e *Engine, n int) []*Event {
+		return e.evaluateBefore(minute(n + 1))
+	}
+
+	It("should go from ok to pending to firing to resolved to ok", func() {
+		e := newEngine(&Rule{Name: "latency", Stat: "api.latency", Aggregate: "avg", Op: ">", Threshold: 300, For: 3})
+
+		record(e, "api.latency", 0, 100)
+		Expect(evaluate(e, 0)).To(BeEmpty())
+		Expect(e.states["latency"].State).To(Equal(OK))
+
+		record(e, "api.latency", 1, 400)
+		events := evaluate(e, 1)
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].State).To(Equal(Pending))
+		Expect(events[0].Previous).To(Equal(OK))
+
+		record(e, "api.latency", 2, 400)
+		Expect(evaluate(e, 2)).To(BeEmpty())
+		Expect(e.states["latency"].State).To(Equal(Pending))
+
+		record(e, "api.latency", 3, 400)
+		events = evaluate(e, 3)
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].State).To(Equal(Firing))
+		Expect(events[0].Value).To(Equal(400.0))
+		Expect(events[0].Time).To(Equal(minute(3)))
+
+		record(e, "api.latency", 4, 100)
+		events = evaluate(e, 4)
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].State).To(Equal(Resolved))
+
+		record(e, "api.latency", 5, 100)
+		events = evaluate(e, 5)
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].State).To(Equal(OK))
+	})
+
+	It("should aggregate over the rule's window", func() {
+		e := newEngine(&Rule{Name: "latency", Stat: "api.latency", Aggregate: "avg", Window: "3m", Op: ">", Threshold: 300})
+
+		record(e, "api.latency", 0, 100)
+		record(e, "api.latency", 1, 100)
+		record(e, "api.latency", 2, 1000)
+		events := evaluate(e, 2)
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Value).To(Equal(400.0))
+		Expect(events[0].State).To(Equal(Firing))
+	})
+
+	It("should combine every stat matching the rule's pattern", func() {
+		e := newEngine(&Rule{Name: "requests", Stat: "web.*.requests", Aggregate: "sum", Op: ">", Threshold: 10})
+
+		record(e, "web.host1.requests", 0, 6)
+		record(e, "web.host2.requests", 0, 6)
+		record(e, "db.host1.requests", 0, 100)
+		events := evaluate(e, 0)
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Value).To(Equal(12.0))
+	})
+
+	It("should fire when a heartbeat stops", func() {
+		e := newEngine(&Rule{Name: "heartbeat", Stat: "heartbeat", Aggregate: "count", Op: "==", Threshold: 0, For: 2})
+
+		record(e, "heartbeat", 0, 1)
+		Expect(evaluate(e, 0)).To(BeEmpty())
+		Expect(evaluate(e, 1)[0].State).To(Equal(Pending))
+		Expect(evaluate(e, 2)[0].State).To(Equal(Firing))
+	})
+
+	It("should not treat a missing average as breaching", func() {
+		e := newEngine(&Rule{Name: "latency", Stat: "api.latency", Aggregate: "avg", Op: "<", Threshold: 10})
+
+		Expect(evaluate(e, 0)).To(BeEmpty())
+		Expect(e.states["latency"].State).To(Equal(OK))
+	})
+
+	It("should keep the aggregate most recently published for a bucket", func() {
+		e := newEngine(&Rule{Name: "latency", Stat: "api.latency", Aggregate: "avg", Op: ">", Threshold: 300})
+
+		record(e, "api.latency", 0, 1000)
+		record(e, "api.latency", 0, 100)
+		Expect(evaluate(e, 0)).To(BeEmpty())
+	})
+
+	It("should restore rule states after a restart", func() {
+		rule := &Rule{Name: "latency", Stat: "api.latency", Aggregate: "avg", Op: ">", Threshold: 300, For: 2}
+		e := newEngine(rule)
+
+		record(e, "api.latency", 0, 400)
+		Expect(evaluate(e, 0)[0].State).To(Equal(Pending))
+
+		restarted := newEngine(rule)
+		Expect(restarted.states["latency"].State).To(Equal(Pending))
+		Expect(restarted.states["latency"].Consecutive).To(Equal(1))
+
+		record(restarted, "api.latency", 1, 400)
+		Expect(evaluate(restarted, 1)[0].State).To(Equal(Firing))
+	})
+
+	It("should save the progress of a pending rule that did not change state", func() {
+		rule := &Rule{Name: "latency", Stat: "api.latency", Aggregate: "avg", Op: ">", Threshold: 300, For: 3}
+		e := newEngine(rule)
+
+		record(e, "api.latency", 0, 400)
+		Expect(evaluate(e, 0)[0].State).To(Equal(Pending))
+		record(e, "api.latency", 1, 400)
+		Expect(evaluate(e, 1)).To(BeEmpty())
+
+		restarted := newEngine(rule)
+		Expect(restarted.states["latency"].Consecutive).To(Equal(2))
+		Expect(restarted.states["latency"].Evaluated).To(Equal(minute(1)))
+
+		record(restarted, "api.latency", 2, 400)
+		Expect(evaluate(restarted, 2)[0].State).To(Equal(Firing))
+	})
+
+	It("should count buckets again after skipping minutes", func() {
+		rule := &Rule{Name: "latency", Stat: "api.latency", Aggregate: "avg", Op: ">", Threshold: 300, For: 2}
+		e := newEngine(rule)
+		record(e, "api.latency", 0, 400)
+		Expect(evaluate(e, 0)[0].State).To(Equal(Pending))
+
+		restarted := newEngine(rule)
+		restarted.started = minute(5)
+		record(restarted, "api.latency", 5, 400)
+		Expect(evaluate(restarted, 5)).To(BeEmpty(), "minute 5 does not follow minute 0")
+		Expect(restarted.states["latency"].State).To(Equal(Pending))
+		Expect(restarted.states["latency"].Consecutive).To(Equal(1))
+
+		record(restarted, "api.latency", 6, 400)
+		Expect(evaluate(restarted, 6)[0].State).To(Equal(Firing))
+	})
+
+	It("should not evaluate the minutes missed before a restart", func() {
+		rule := &Rule{Name: "heartbeat", Stat: "heartbeat", Aggregate: "count", Op: "==", Threshold: 0, For: 2}
+		e := newEngine(rule)
+		Expect(evaluate(e, 0)[0].State).To(Equal(Pending))
+
+		restarted := newEngine(rule)
+		restarted.started = time.Time{}
+		restarted.Finalized(minute(9))
+		Expect(restarted.evaluateBefore(minute(9))).To(BeEmpty())
+
+		record(restarted, "heartbeat", 10, 1)
+		events := evaluate(restarted, 10)
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Previous).To(Equal(Pending))
+		Expect(events[0].State).To(Equal(OK))
+		Expect(restarted.states["heartbeat"].Evaluated).To(Equal(minute(10)))
+	})
+
+	It("should wait for the sources awaited to pass a minute before evaluating it", func() {
+		e := newEngine(&Rule{Name: "heartbeat", Stat: "heartbeat", Aggregate: "count", Op: "==", Threshold: 0})
+		scored := e.Await()
+		e.Finalized(minute(5))
+		Expect(e.finalizedBefore().IsZero()).To(BeTrue())
+
+		scored(minute(3))
+		Expect(e.finalizedBefore()).To(Equal(minute(3)))
+		scored(minute(6))
+		Expect(e.finalizedBefore()).To(Equal(minute(5)))
+	})
+
+	It("should fire a rule on the anomaly Detector's scores", func() {
+		fake := clock.NewFake(start)
+		rule := &Rule{Name: "api latency anomaly", Stat: "gostat.anomaly.api.latency.score", Aggregate: "max", Op: ">", Threshold: 3, For: 2}
+		Expect(rule.validate()).To(BeNil())
+
+		aggregates, metaStats := make(chan *aggregator.BucketAggregate), make(chan *stat.Stat, 1000)
+		scores, events := make(chan *aggregator.BucketAggregate), make(chan *Event, 10)
+		shutdown, stopped := make(chan bool), make(chan bool)
+
+		params := anomaly.DefaultParams
+		params.Season, params.Delta, params.Warmup = time.Minute, 0.01, 5
+		d, err := anomaly.NewDetectorWithClock(params, filepath.Join(dir, "baselines.json"), aggregates, metaStats, shutdown, fake)
+		Expect(err).To(BeNil())
+		e, err := NewEngineWithClock([]*Rule{rule}, statePath, scores, events, shutdown, fake)
+		Expect(err).To(BeNil())
+		// the meta stats of a minute reach the Engine before the Engine learns
+		// the minute is scored
+		awaited := e.Await()
+		d.OnScored(func(before time.Time) {
+			for len(metaStats) > 0 {
+				scores <- anomaly.MetaAggregate(<-metaStats)
+			}
+			awaited(before)
+		})
+		e.Finalized(start)
+		go func() { d.Run(); stopped <- true }()
+		go func() { e.Run(); stopped <- true }()
+		defer func() {
+			close(shutdown)
+			<-stopped
+			<-stopped
+		}()
+		Eventually(fake.Waiters).Should(Equal(3))
+
+		// a steady latency, then two minutes far above it
+		for n := 0; n < 22; n++ {
+			v := 100.0 + float64(n%2)
+			if n >= 20 {
+				v = 1000
+			}
+			aggregates <- &aggregator.BucketAggregate{Name: "api.latency", Time: minute(n),
+				StatsAggregate: aggregator.StatsAggregate{Average: v, Min: v, Max: v, Count: 1}}
+		}
+
+		var states []State
+		received := func() []State {
+			fake.Advance(time.Second * 10)
+			for {
+				select {
+				case event := <-events:
+					states = append(states, event.State)
+				default:
+					return states
+				}
+			}
+		}
+
+		// the rule is only evaluated for a minute once the Detector has scored it
+		for n, expected := range [][]State{{Pending}, {Pending, Firing}} {
+			d.Finalized(minute(21 + n))
+			e.Finalized(minute(21 + n))
+			Eventually(received).Should(Equal(expected))
+		}
+	})
+
+	It("should not evaluate a minute before the Bucketer finalizes it", func() {
+		e := newEngine(&Rule{Name: "heartbeat", Stat: "heartbeat", Aggregate: "count", Op: "==", Threshold: 0})
+
+		Expect(e.evaluateBefore(time.Time{})).To(BeEmpty())
+		Expect(e.states["heartbeat"].Evaluated.IsZero()).To(BeTrue())
+	})
+})
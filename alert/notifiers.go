@@ -0,0 +1,212 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// NotifierConfig configures one notifier. Type selects the notifier, and
+// determines which of the other fields apply:
+//
+//	webhook: URL
+//	email:   SMTP (host:port), From, To, and optionally Username, Password and
+//	         Timeout, 30s by default
+//	exec:    Command and Args, and optionally Timeout, 30s by default
+type NotifierConfig struct {
+	Type string `json:"type"`
+
+	URL string `json:"url"`
+
+	SMTP     string   `json:"smtp"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Timeout string   `json:"timeout"`
+}
+
+// notifier constructs the configured notifier
+func (c *NotifierConfig) notifier() (Notifier, error) {
+	switch c.Type {
+	case "webhook":
+		if c.URL == "" {
+			return nil, fmt.Errorf("alert: webhook notifier has no url")
+		}
+		return &WebhookNotifier{URL: c.URL, Client: &http.Client{Timeout: time.Second * 10}}, nil
+	case "email":
+		if c.SMTP == "" || c.From == "" || len(c.To) == 0 {
+			return nil, fmt.Errorf("alert: email notifier needs smtp, from and to")
+		}
+		timeout, err := parseDuration("timeout", c.Timeout, time.Second*30)
+		if err != nil {
+			return nil, err
+		}
+		return &EmailNotifier{Addr: c.SMTP, From: c.From, To: c.To, Username: c.Username, Password: c.Password, Timeout: timeout}, nil
+	case "exec":
+		if c.Command == "" {
+			return nil, fmt.Errorf("alert: exec notifier has no command")
+		}
+		timeout, err := parseDuration("timeout", c.Timeout, time.Second*30)
+		if err != nil {
+			return nil, err
+		}
+		return &ExecNotifier{Command: c.Command, Args: c.Args, Timeout: timeout}, nil
+	}
+	return nil, fmt.Errorf("alert: unknown notifier type %q", c.Type)
+}
+
+// WebhookNotifier POSTs each notification as JSON to a URL
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (w *WebhookNotifier) Notify(n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	res, err := w.Client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook %s returned %s", w.URL, res.Status)
+	}
+	return nil
+}
+
+func (w *WebhookNotifier) String() string {
+	return "webhook " + w.URL
+}
+
+// EmailNotifier sends each notification as an email over SMTP
+type EmailNotifier struct {
+	Addr               string // host:port of the SMTP server
+	From               string
+	To                 []string
+	Username, Password string        // used for PLAIN authentication, if set
+	Timeout            time.Duration // for the whole exchange with the SMTP server, if not zero
+}
+
+func (e *EmailNotifier) Notify(n *Notification) error {
+	host, _, err := net.SplitHostPort(e.Addr)
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", n.Subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(n.Body, "\n", "\r\n", -1))
+
+	return e.send(host, msg.Bytes())
+}
+
+// send delivers a message as smtp.SendMail does, but within the Timeout
+func (e *EmailNotifier) send(host string, msg []byte) error {
+	conn, err := net.DialTimeout("tcp", e.Addr, e.Timeout)
+	if err != nil {
+		return err
+	}
+	if e.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(e.Timeout))
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if e.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.From); err != nil {
+		return err
+	}
+	for _, to := range e.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (e *EmailNotifier) String() string {
+	return "email to " + strings.Join(e.To, ", ")
+}
+
+// ExecNotifier runs a local command for each notification. The body of the
+// notification is written to the command's standard input, and the subject and
+// the notification as JSON are passed in the GOSTAT_SUBJECT and
+// GOSTAT_NOTIFICATION environment variables. A command still running after
+// Timeout is killed
+type ExecNotifier struct {
+	Command string
+	Args    []string
+	Timeout time.Duration
+}
+
+func (x *ExecNotifier) Notify(n *Notification) error {
+	notification, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), x.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, x.Command, x.Args...)
+	cmd.WaitDelay = time.Second // don't wait on any children left holding its output
+	cmd.Stdin = strings.NewReader(n.Body)
+	cmd.Env = append(os.Environ(), "GOSTAT_SUBJECT="+n.Subject, "GOSTAT_NOTIFICATION="+string(notification))
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s: killed after %v", x.Command, x.Timeout)
+		}
+		return fmt.Errorf("%s: %v: %s", x.Command, err, out)
+	}
+	return nil
+}
+
+func (x *ExecNotifier) String() string {
+	return "exec " + x.Command
+}
@@ -0,0 +1,97 @@
+package alert
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("Notifiers", func() {
+
+	notification := &Notification{
+		Alerts:  []*Alert{{Rule: "latency", State: Firing, Stat: "api.latency", Value: 400}},
+		Subject: "latency firing",
+		Body:    "latency is firing\n",
+	}
+
+	Describe("WebhookNotifier", func() {
+		It("should POST the notification as JSON", func() {
+			var received Notification
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Method).To(Equal("POST"))
+				Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+				Expect(json.NewDecoder(r.Body).Decode(&received)).To(BeNil())
+			}))
+			defer server.Close()
+
+			n, err := (&NotifierConfig{Type: "webhook", URL: server.URL}).notifier()
+			Expect(err).To(BeNil())
+			Expect(n.Notify(notification)).To(BeNil())
+			Expect(received).To(Equal(*notification))
+		})
+
+		It("should return an error when the webhook does not succeed", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer server.Close()
+
+			n, _ := (&NotifierConfig{Type: "webhook", URL: server.URL}).notifier()
+			Expect(n.Notify(notification)).NotTo(BeNil())
+		})
+	})
+
+	Describe("ExecNotifier", func() {
+		It("should run the command with the body on standard input", func() {
+			dir, err := ioutil.TempDir("", "gostat-alert")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+			out := filepath.Join(dir, "out")
+
+			n, err := (&NotifierConfig{Type: "exec", Command: "sh", Args: []string{"-c", `cat > "$0"; echo "$GOSTAT_SUBJECT" >> "$0"`, out}}).notifier()
+			Expect(err).To(BeNil())
+			Expect(n.Notify(notification)).To(BeNil())
+
+			written, err := ioutil.ReadFile(out)
+			Expect(err).To(BeNil())
+			Expect(string(written)).To(Equal("latency is firing\nlatency firing\n"))
+		})
+
+		It("should return an error when the command fails", func() {
+			n, _ := (&NotifierConfig{Type: "exec", Command: "false"}).notifier()
+			Expect(n.Notify(notification)).NotTo(BeNil())
+		})
+
+		It("should kill a command that runs past its timeout", func() {
+			n, err := (&NotifierConfig{Type: "exec", Command: "sleep", Args: []string{"10"}, Timeout: "50ms"}).notifier()
+			Expect(err).To(BeNil())
+
+			started := time.Now()
+			err = n.Notify(notification)
+			Expect(err).NotTo(BeNil())
+			Expect(err.Error()).To(ContainSubstring("killed after 50ms"))
+			Expect(time.Since(started)).To(BeNumerically("<", time.Second*5))
+		})
+	})
+
+	Describe("NotifierConfig", func() {
+		It("should reject incomplete or unknown notifiers", func() {
+			for _, c := range []*NotifierConfig{
+				{Type: "webhook"},
+				{Type: "email", SMTP: "localhost:25"},
+				{Type: "exec"},
+				{Type: "exec", Command: "true", Timeout: "soon"},
+				{Type: "pager"},
+			} {
+				_, err := c.notifier()
+				Expect(err).NotTo(BeNil(), c.Type)
+			}
+		})
+	})
+})
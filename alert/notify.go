@@ -0,0 +1,118 @@
+package alert
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultSubject = `[gostat] {{range $i, $a := .Alerts}}{{if $i}}, {{end}}{{$a.Rule}} {{$a.State}}{{end}}`
+	defaultBody    = `{{range .Alerts}}{{.Rule}} is {{.State}}: {{.Aggregate}} of {{.Stat}} was {{.Value}} ({{.Op}} {{.Threshold}}) at {{.Time}}
+{{if .Link}}{{.Link}}
+{{end}}
+{{end}}`
+)
+
+// Alert describes one rule changing state, for use in notification templates
+type Alert struct {
+	Rule      string    `json:"rule"`
+	State     State     `json:"state"`
+	Stat      string    `json:"stat"`
+	Aggregate string    `json:"aggregate"`
+	Op        string    `json:"op"`
+	Threshold float64   `json:"threshold"`
+	Value     float64   `json:"value"`
+	Time      time.Time `json:"time"`
+	Link      string    `json:"link,omitempty"` // the stat on the dashboard
+}
+
+// Notification is a group of alerts sent together
+type Notification struct {
+	Alerts  []*Alert `json:"alerts"`
+	Subject string   `json:"subject"`
+	Body    string   `json:"body"`
+}
+
+// Notifier delivers notifications
+type Notifier interface {
+	Notify(n *Notification) error
+	String() string
+}
+
+// Silence suppresses notifications for rules whose names match Rule between
+// Start and End
+type Silence struct {
+	Rule    string    `json:"rule"` // a rule name, or a pattern such as "db *"
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Comment string    `json:"comment"`
+}
+
+// silences reports whether the silence applies to a rule at time t
+func (s *Silence) silences(rule string, t time.Time) bool {
+	if t.Before(s.Start) || !t.Before(s.End) {
+		return false
+	}
+	matched, err := path.Match(s.Rule, rule)
+	return err == nil && matched
+}
+
+// newAlert describes an Event for the notification templates
+func newAlert(e *Event, dashboard string) *Alert {
+	a := &Alert{
+		Rule:      e.Rule.Name,
+		State:     e.State,
+		Stat:      e.Rule.Stat,
+		Aggregate: e.Rule.Aggregate,
+		Op:        e.Rule.Op,
+		Threshold: e.Rule.Threshold,
+		Value:     e.Value,
+		Time:      e.Time,
+	}
+
+	if dashboard != "" {
+		a.Link = strings.TrimSuffix(dashboard, "/") + "/?stat=" + url.QueryEscape(e.Rule.Stat)
+	}
+	return a
+}
+
+type templates struct {
+	subject, body *template.Template
+}
+
+func newTemplates(subject, body string) (*templates, error) {
+	var t templates
+	var err error
+
+	if t.subject, err = template.New("subject").Parse(subject); err != nil {
+		return nil, fmt.Errorf("alert: error parsing subject template: %v", err)
+	}
+	if t.body, err = template.New("body").Parse(body); err != nil {
+		return nil, fmt.Errorf("alert: error parsing body template: %v", err)
+	}
+	return &t, nil
+}
+
+// render builds a notification of alerts
+func (t *templates) render(alerts []*Alert) (*Notification, error) {
+	n := &Notification{Alerts: alerts}
+
+	var buf bytes.Buffer
+	if err := t.subject.Execute(&buf, n); err != nil {
+		return nil, err
+	}
+	n.Subject = buf.String()
+
+	buf.Reset()
+	if err := t.body.Execute(&buf, n); err != nil {
+		return nil, err
+	}
+	n.Body = buf.String()
+
+	return n, nil
+}
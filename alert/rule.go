@@ -0,0 +1,145 @@
+// Package alert evaluates threshold rules against aggregated stats
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/query"
+	"time"
+)
+
+// Rule fires when an aggregate of a stat over a window of minutes crosses a
+// threshold for a number of consecutive buckets, e.g. "avg of api.latency over
+// 5m > 300 for 3 consecutive buckets" is
+//
+//	{"name": "api latency", "stat": "api.latency", "aggregate": "avg",
+//	 "window": "5m", "op": ">", "threshold": 300, "for": 3}
+//
+// and "count of heartbeat == 0 for 2m" is
+//
+//	{"name": "heartbeat", "stat": "heartbeat", "aggregate": "count",
+//	 "window": "1m", "op": "==", "threshold": 0, "for": "2m"}
+type Rule struct {
+	Name      string  `json:"name"`
+	Stat      string  `json:"stat"`      // a stat name or query.MatchName pattern
+	Aggregate string  `json:"aggregate"` // avg, min, max, sum or count
+	Window    string  `json:"window"`    // e.g. 5m, defaults to one minute
+	Op        string  `json:"op"`        // >, >=, <, <=, == or !=
+	Threshold float64 `json:"threshold"`
+	For       Buckets `json:"for"` // consecutive buckets the condition must hold, defaults to 1
+
+	window time.Duration
+}
+
+// Buckets is a number of consecutive one minute buckets. In JSON it is either a
+// number or a duration such as "3m"
+type Buckets int
+
+// UnmarshalJSON decodes a number of buckets or a duration
+func (b *Buckets) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*b = Buckets(n)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("alert: 'for' must be a number of buckets or a duration: %s", data)
+	}
+
+	d, err := query.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*b = Buckets(d / time.Minute)
+	return nil
+}
+
+// LoadRules reads and validates the rules in a JSON rules file
+func LoadRules(path string) ([]*Rule, error) {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return config.Rules, nil
+}
+
+// validate checks a rule and fills in its defaults
+func (r *Rule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("alert: rule for %q has no name", r.Stat)
+	}
+	if r.Stat == "" {
+		return fmt.Errorf("alert: rule %q has no stat", r.Name)
+	}
+
+	switch r.Aggregate {
+	case "avg", "min", "max", "sum", "count":
+	default:
+		return fmt.Errorf("alert: rule %q has unknown aggregate %q", r.Name, r.Aggregate)
+	}
+
+	switch r.Op {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return fmt.Errorf("alert: rule %q has unknown op %q", r.Name, r.Op)
+	}
+
+	r.window = time.Minute
+	if r.Window != "" {
+		d, err := query.ParseDuration(r.Window)
+		if err != nil {
+			return fmt.Errorf("alert: rule %q: %v", r.Name, err)
+		}
+		if d < time.Minute || d%time.Minute != 0 {
+			return fmt.Errorf("alert: rule %q: window must be a whole number of minutes", r.Name)
+		}
+		r.window = d
+	}
+
+	if r.For < 1 {
+		r.For = 1
+	}
+	return nil
+}
+
+// value computes the rule's aggregate
+func (r *Rule) value(a aggregator.StatsAggregate) float64 {
+	switch r.Aggregate {
+	case "min":
+		return a.Min
+	case "max":
+		return a.Max
+	case "sum":
+		return a.Average * float64(a.Count)
+	case "count":
+		return float64(a.Count)
+	}
+	return a.Average
+}
+
+// breached reports whether value crosses the rule's threshold
+func (r *Rule) breached(value float64) bool {
+	switch r.Op {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	case "==":
+		return value == r.Threshold
+	case "!=":
+		return value != r.Threshold
+	}
+	return false
+}
+
+// String describes the rule, e.g. "avg of api.latency over 5m > 300 for 3 buckets"
+func (r *Rule) String() string {
+	return fmt.Sprintf("%s of %s over %v %s %v for %d buckets", r.Aggregate, r.Stat, r.window, r.Op, r.Threshold, r.For)
+}
@@ -0,0 +1,95 @@
+package alert
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("Rule", func() {
+
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "gostat-alert")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	writeRules := func(json string) string {
+		path := filepath.Join(dir, "rules.json")
+		Expect(ioutil.WriteFile(path, []byte(json), 0644)).To(BeNil())
+		return path
+	}
+
+	Describe("LoadRules", func() {
+		It("should load rules and fill in their defaults", func() {
+			rules, err := LoadRules(writeRules(`{"rules": [
+				{"name": "latency", "stat": "api.latency", "aggregate": "avg", "window": "5m", "op": ">", "threshold": 300, "for": 3},
+				{"name": "heartbeat", "stat": "heartbeat", "aggregate": "count", "op": "==", "threshold": 0, "for": "2m"},
+				{"name": "errors", "stat": "api.errors", "aggregate": "sum", "op": ">=", "threshold": 10}
+			]}`))
+			Expect(err).To(BeNil())
+			Expect(rules).To(HaveLen(3))
+
+			Expect(rules[0].window).To(Equal(time.Minute * 5))
+			Expect(rules[0].For).To(Equal(Buckets(3)))
+			Expect(rules[0].String()).To(Equal("avg of api.latency over 5m0s > 300 for 3 buckets"))
+
+			Expect(rules[1].window).To(Equal(time.Minute))
+			Expect(rules[1].For).To(Equal(Buckets(2)))
+
+			Expect(rules[2].For).To(Equal(Buckets(1)))
+		})
+
+		It("should reject unknown aggregates and operators", func() {
+			_, err := LoadRules(writeRules(`{"rules": [{"name": "x", "stat": "x", "aggregate": "median", "op": ">"}]}`))
+			Expect(err).NotTo(BeNil())
+
+			_, err = LoadRules(writeRules(`{"rules": [{"name": "x", "stat": "x", "aggregate": "avg", "op": "=>"}]}`))
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("should reject windows that are not whole minutes", func() {
+			_, err := LoadRules(writeRules(`{"rules": [{"name": "x", "stat": "x", "aggregate": "avg", "op": ">", "window": "90s"}]}`))
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("should reject duplicate rule names", func() {
+			_, err := LoadRules(writeRules(`{"rules": [
+				{"name": "x", "stat": "x", "aggregate": "avg", "op": ">"},
+				{"name": "x", "stat": "y", "aggregate": "avg", "op": ">"}
+			]}`))
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("should return an error for a missing file", func() {
+			_, err := LoadRules(filepath.Join(dir, "missing.json"))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("breached", func() {
+		It("should compare values with each operator", func() {
+			r := &Rule{Threshold: 10}
+			for op, expected := range map[string][]bool{
+				">":  {false, false, true},
+				">=": {false, true, true},
+				"<":  {true, false, false},
+				"<=": {true, true, false},
+				"==": {false, true, false},
+				"!=": {true, false, true},
+			} {
+				r.Op = op
+				Expect([]bool{r.breached(9), r.breached(10), r.breached(11)}).To(Equal(expected), op)
+			}
+		})
+	})
+})
@@ -0,0 +1,55 @@
+package alert
+
+import (
+	"encoding/json"
+	"github.com/CapillarySoftware/gostat/atomicfile"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// State is the state of an alert
+type State string
+
+const (
+	OK       State = "ok"       // the rule's condition does not hold
+	Pending  State = "pending"  // the condition holds, but not yet for enough consecutive buckets
+	Firing   State = "firing"   // the condition has held for enough consecutive buckets
+	Resolved State = "resolved" // the condition stopped holding while firing
+)
+
+// RuleState is the evaluation state of one rule
+type RuleState struct {
+	State       State     `json:"state"`
+	Consecutive int       `json:"consecutive"` // consecutive buckets the condition has held for
+	Value       float64   `json:"value"`       // the value at the last evaluation
+	Since       time.Time `json:"since"`       // when the rule entered its current state
+	Evaluated   time.Time `json:"evaluated"`   // the last bucket the rule was evaluated for
+}
+
+// loadStates reads the rule states saved at path. A missing file is not an
+// error, since nothing has been saved yet on the first run
+func loadStates(path string) (map[string]*RuleState, error) {
+	states := make(map[string]*RuleState)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return states, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// saveStates writes the rule states to path, replacing the file atomically
+func saveStates(path string, states map[string]*RuleState) error {
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, data)
+}
@@ -0,0 +1,13 @@
+package anomaly
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestAnomaly(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Anomaly Suite")
+}
@@ -0,0 +1,312 @@
+package anomaly
+
+import (
+	"encoding/json"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/atomicfile"
+	"github.com/CapillarySoftware/gostat/clock"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetaStatPrefix prefixes the names of the stats a Detector emits
+const MetaStatPrefix = "gostat.anomaly."
+
+// Detector learns a Model of the per-minute average of every series it is fed,
+// and scores each minute against the model once the Bucketer has finalized it,
+// every source Awaited has passed it, and the Aggregator has had an interval to
+// catch up. For a series named foo it emits the stats
+//
+//	gostat.anomaly.foo.score     how many standard deviations foo was from its forecast
+//	gostat.anomaly.foo.expected  the forecast
+//	gostat.anomaly.foo.upper     the upper bound of the normal band
+//	gostat.anomaly.foo.lower     the lower bound of the normal band
+//
+// so that anomalies can be graphed, and alerted on with a rule such as
+// "max of gostat.anomaly.foo.score > 3". The meta stats of a minute are emitted
+// after the Bucketer has finalized it, so they are stored and aggregated
+// directly rather than bucketed, and the stages they feed learn when a minute
+// is scored from OnScored. The models are saved periodically, and restored when
+// the Detector is constructed
+type Detector struct {
+	params       Params
+	models       map[string]*Model
+	pending      map[string]map[time.Time]float64 // the latest average of each series' unscored minutes
+	baselinePath string
+
+	input    <-chan *aggregator.BucketAggregate // aggregates are read from this channel
+	output   chan<- *stat.Stat                  // meta stats are written to this channel without blocking
+	shutdown <-chan bool                        // signals a graceful shutdown
+	clock    clock.Clock                        // schedules scoring and saving
+	scored   []func(before time.Time)           // told the time before which every minute has been scored
+
+	mu        sync.Mutex
+	finalized time.Time   // the time before which the Bucketer has finalized every minute
+	horizons  []time.Time // the time before which each source Awaited has sent every aggregate
+}
+
+// NewDetector constructs a Detector, restoring any models saved at baselinePath
+func NewDetector(params Params, baselinePath string, aggregates <-chan *aggregator.BucketAggregate, metaStats chan<- *stat.Stat, shutdown <-chan bool) (*Detector, error) {
+	return NewDetectorWithClock(params, baselinePath, aggregates, metaStats, shutdown, clock.Real)
+}
+
+// NewDetectorWithClock constructs a Detector scoring on the ticks of a clock
+func NewDetectorWithClock(params Params, baselinePath string, aggregates <-chan *aggregator.BucketAggregate, metaStats chan<- *stat.Stat, shutdown <-chan bool, c clock.Clock) (*Detector, error) {
+	models, err := loadModels(baselinePath)
+	if err != nil {
+		return nil, log.Errorf("error loading anomaly baselines from %s: %v", baselinePath, err)
+	}
+
+	// a model learned for a different season length cannot be reused
+	for name, m := range models {
+		if len(m.Season) != seasonLength(params) {
+			log.Warnf("discarding anomaly baseline of %s learned for a different season", name)
+			delete(models, name)
+		}
+	}
+
+	return &Detector{
+		params:       params,
+		models:       models,
+		pending:      make(map[string]map[time.Time]float64),
+		baselinePath: baselinePath,
+		input:        aggregates,
+		output:       metaStats,
+		shutdown:     shutdown,
+		clock:        c,
+	}, nil
+}
+
+// OnScored registers a function told, after the meta stats of the minutes
+// scored are emitted, the time before which every minute has been scored
+func (d *Detector) OnScored(f func(before time.Time)) {
+	d.scored = append(d.scored, f)
+}
+
+// MetaAggregate returns the aggregate of the minute of a meta stat, which is
+// the only stat of its series in that minute
+func MetaAggregate(s *stat.Stat) *aggregator.BucketAggregate {
+	return &aggregator.BucketAggregate{Name: s.Name, Time: s.Timestamp,
+		StatsAggregate: aggregator.StatsAggregate{Average: s.Value, Min: s.Value, Max: s.Value, Count: 1}}
+}
+
+// Finalized records the time before which the Bucketer will no longer publish
+// any stats. It is passed to Bucketer.OnFinalized
+func (d *Detector) Finalized(before time.Time) {
+	d.mu.Lock()
+	d.finalized = before
+	d.mu.Unlock()
+}
+
+// Await registers a source sending aggregates of a minute after the Bucketer
+// finalizes it, such as the relay Merger, returning the function it tells the
+// time before which it has sent every aggregate. No minute is scored until
+// every source has passed it
+func (d *Detector) Await() func(before time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	i := len(d.horizons)
+	d.horizons = append(d.horizons, time.Time{})
+	return func(before time.Time) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.horizons[i] = before
+	}
+}
+
+// finalizedBefore returns the time before which every minute is finalized and
+// has been passed by every source awaited
+func (d *Detector) finalizedBefore() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	before := d.finalized
+	for _, h := range d.horizons {
+		if h.Before(before) {
+			before = h
+		}
+	}
+	return before
+}
+
+// Run is a goroutine that records aggregates read from the input channel,
+// scores each minute once it is finalized, and saves the models every few
+// minutes
+func (d *Detector) Run() {
+	done := false
+	scoreTicker := d.clock.NewTicker(time.Second * 10)
+	saveTicker := d.clock.NewTicker(time.Minute * 5)
+
+	var settled time.Time // the finalized time as of the last tick
+
+	for !done {
+		select {
+		case a := <-d.input:
+			d.record(a)
+		case <-scoreTicker.C():
+			for _, s := range d.scoreBefore(settled) {
+				select {
+				case d.output <- s:
+				default:
+					log.Warn("anomaly Detector dropping meta stat, output is full: ", s.Name)
+				}
+			}
+			for _, f := range d.scored {
+				f(settled)
+			}
+			settled = d.finalizedBefore()
+		case <-saveTicker.C():
+			d.save()
+		case <-d.shutdown:
+			log.Info("anomaly Detector shutting down ", d.clock.Now())
+			done = true
+			d.drain()
+			scoreTicker.Stop()
+			saveTicker.Stop()
+			d.save()
+		}
+	}
+
+	log.Info("anomaly Detector Run() exiting ", d.clock.Now())
+}
+
+// drain records the aggregates already waiting on the input channel
+func (d *Detector) drain() {
+	for {
+		select {
+		case a := <-d.input:
+			d.record(a)
+		default:
+			return
+		}
+	}
+}
+
+// record keeps the latest average of a series' minute
+func (d *Detector) record(a *aggregator.BucketAggregate) {
+	if strings.HasPrefix(a.Name, MetaStatPrefix) || a.Count == 0 {
+		return // never model the detector's own output
+	}
+
+	minutes, ok := d.pending[a.Name]
+	if !ok {
+		minutes = make(map[time.Time]float64)
+		d.pending[a.Name] = minutes
+	}
+	minutes[a.Time] = a.Average
+}
+
+// scoreBefore scores and learns from every recorded minute before before,
+// returning the resulting meta stats
+func (d *Detector) scoreBefore(before time.Time) []*stat.Stat {
+	var metaStats []*stat.Stat
+
+	for name, minutes := range d.pending {
+		var closed []time.Time
+		for t := range minutes {
+			if t.Before(before) {
+				closed = append(closed, t)
+			}
+		}
+		sort.Sort(byTime(closed))
+
+		m, ok := d.models[name]
+		if !ok {
+			m = newModel(seasonLength(d.params))
+			d.models[name] = m
+		}
+
+		for _, t := range closed {
+			v := minutes[t]
+			delete(minutes, t)
+
+			if !t.After(m.Last) && m.Points > 0 {
+				continue // already learned, e.g. before a restart
+			}
+
+			s, ok := m.Update(t, v, d.params)
+			if !ok {
+				continue
+			}
+
+			if s.Anomalous {
+				log.Warnf("anomaly in %s at %v: %v is %.1f standard deviations from the expected %v", name, t, v, s.Score, s.Expected)
+			}
+			metaStats = append(metaStats, metaStatsOf(name, t, s, d.params.Sensitivity)...)
+		}
+
+		if len(minutes) == 0 {
+			delete(d.pending, name)
+		}
+	}
+
+	return metaStats
+}
+
+// metaStatsOf returns the meta stats of a series' scored minute
+func metaStatsOf(name string, t time.Time, s Score, sensitivity float64) []*stat.Stat {
+	metric, tags := stat.ParseName(name)
+	named := func(suffix string, v float64) *stat.Stat {
+		return &stat.Stat{Name: stat.FormatName(MetaStatPrefix+metric+"."+suffix, tags), Timestamp: t, Value: v}
+	}
+
+	return []*stat.Stat{
+		named("score", s.Score),
+		named("expected", s.Expected),
+		named("upper", s.Expected+sensitivity*s.Deviation),
+		named("lower", s.Expected-sensitivity*s.Deviation),
+	}
+}
+
+// seasonLength returns the number of minutes in a season
+func seasonLength(p Params) int {
+	if n := int(p.Season / time.Minute); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// save saves the models to the baseline file
+func (d *Detector) save() {
+	if err := saveModels(d.baselinePath, d.models); err != nil {
+		log.Error("error saving anomaly baselines to ", d.baselinePath, ": ", err)
+	}
+}
+
+// loadModels reads the models saved at path. A missing file is not an error,
+// since nothing has been saved yet on the first run
+func loadModels(path string) (map[string]*Model, error) {
+	models := make(map[string]*Model)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return models, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &models); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// saveModels writes the models to path, replacing the file atomically
+func saveModels(path string, models map[string]*Model) error {
+	data, err := json.Marshal(models)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, data)
+}
+
+type byTime []time.Time
+
+func (t byTime) Len() int           { return len(t) }
+func (t byTime) Less(i, j int) bool { return t[i].Before(t[j]) }
+func (t byTime) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
@@ -0,0 +1,130 @@
+package anomaly
+
+import (
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("Detector", func() {
+
+	var dir, baselinePath string
+	var start time.Time
+	var params Params
+
+	minute := func(n int) time.Time {
+		return start.Add(time.Minute * time.Duration(n))
+	}
+
+	newDetector := func() *Detector {
+		d, err := NewDetector(params, baselinePath, make(chan *aggregator.BucketAggregate), make(chan *stat.Stat), make(chan bool))
+		Expect(err).To(BeNil())
+		return d
+	}
+
+	record := func(d *Detector, name string, n int, value float64) {
+		d.record(&aggregator.BucketAggregate{Name: name, Time: minute(n),
+			StatsAggregate: aggregator.StatsAggregate{Average: value, Min: value, Max: value, Count: 1}})
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "gostat-anomaly")
+		Expect(err).To(BeNil())
+		baselinePath = filepath.Join(dir, "baselines.json")
+
+		start = time.Date(2014, 10, 1, 0, 0, 0, 0, time.UTC)
+		params = DefaultParams
+		params.Season = time.Minute * 10
+		params.Warmup = 2
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should only score minutes that have been finalized", func() {
+		d := newDetector()
+		for i := 0; i < 4; i++ {
+			record(d, "foo", i, 10)
+		}
+
+		// minute 3 is not yet finalized
+		Expect(d.scoreBefore(time.Time{})).To(BeEmpty())
+		Expect(d.scoreBefore(minute(3))).To(HaveLen(4))
+		Expect(d.models["foo"].Points).To(Equal(3))
+
+		Expect(d.scoreBefore(minute(4))).To(HaveLen(4))
+		Expect(d.models["foo"].Points).To(Equal(4))
+	})
+
+	It("should emit score, expected and band meta stats, keeping the series' tags", func() {
+		d := newDetector()
+		for i := 0; i < 3; i++ {
+			record(d, "api.latency;host=web1", i, 10)
+		}
+
+		metaStats := d.scoreBefore(minute(3))
+		Expect(metaStats).To(HaveLen(4))
+
+		names := make([]string, len(metaStats))
+		for i, s := range metaStats {
+			names[i] = s.Name
+			Expect(s.Timestamp).To(Equal(minute(2)))
+		}
+		Expect(names).To(Equal([]string{
+			"gostat.anomaly.api.latency.score;host=web1",
+			"gostat.anomaly.api.latency.expected;host=web1",
+			"gostat.anomaly.api.latency.upper;host=web1",
+			"gostat.anomaly.api.latency.lower;host=web1",
+		}))
+	})
+
+	It("should learn from the latest aggregate published for a minute", func() {
+		d := newDetector()
+		record(d, "foo", 0, 10)
+		record(d, "foo", 0, 20)
+		d.scoreBefore(minute(1))
+
+		Expect(d.models["foo"].Level).To(Equal(20.0))
+	})
+
+	It("should not model its own meta stats", func() {
+		d := newDetector()
+		record(d, MetaStatPrefix+"foo.score", 0, 10)
+		Expect(d.scoreBefore(minute(1))).To(BeEmpty())
+		Expect(d.models).To(BeEmpty())
+	})
+
+	It("should restore its baselines after a restart", func() {
+		d := newDetector()
+		for i := 0; i < 5; i++ {
+			record(d, "foo", i, 10)
+		}
+		d.scoreBefore(minute(5))
+		d.save()
+
+		restarted := newDetector()
+		Expect(restarted.models["foo"]).To(Equal(d.models["foo"]))
+
+		// minutes learned before the restart are not learned twice
+		record(restarted, "foo", 4, 10)
+		Expect(restarted.scoreBefore(minute(5))).To(BeEmpty())
+		Expect(restarted.models["foo"].Points).To(Equal(5))
+	})
+
+	It("should discard baselines learned for a different season", func() {
+		d := newDetector()
+		record(d, "foo", 0, 10)
+		d.scoreBefore(minute(1))
+		d.save()
+
+		params.Season = time.Minute * 20
+		Expect(newDetector().models).To(BeEmpty())
+	})
+})
@@ -0,0 +1,102 @@
+// Package anomaly detects anomalies in per-minute aggregates by learning a
+// seasonal baseline for every series
+package anomaly
+
+import (
+	"math"
+	"time"
+)
+
+// Params tune the models learned for each series
+type Params struct {
+	Season time.Duration // the length of a season, e.g. a day for traffic shaped stats
+
+	// Alpha, Beta and Gamma are the Holt-Winters smoothing factors of the level,
+	// trend and seasonal components, and Delta is the smoothing factor of the
+	// variance of the forecast errors. Each is between 0 and 1, and higher
+	// values adapt faster to recent values
+	Alpha, Beta, Gamma, Delta float64
+
+	Sensitivity float64 // the width of the normal band, in standard deviations
+	Warmup      int     // the number of values to learn from before detecting anomalies
+}
+
+// DefaultParams are suitable for stats that follow a daily pattern
+var DefaultParams = Params{
+	Season:      time.Hour * 24,
+	Alpha:       0.1,
+	Beta:        0.01,
+	Gamma:       0.1,
+	Delta:       0.1,
+	Sensitivity: 3,
+	Warmup:      60,
+}
+
+// Model is an additive Holt-Winters model of one series with one value per
+// minute, together with the variance of its forecast errors
+type Model struct {
+	Level    float64   `json:"level"`
+	Trend    float64   `json:"trend"`
+	Season   []float64 `json:"season"` // the seasonal component of each minute of the season
+	Variance float64   `json:"variance"`
+	Points   int       `json:"points"` // the number of values learned from
+	Last     time.Time `json:"last"`   // the minute of the last value learned from
+}
+
+// Score is the result of comparing a value with its forecast
+type Score struct {
+	Expected  float64 // the forecast value
+	Deviation float64 // the standard deviation of the forecast errors
+	Score     float64 // how many standard deviations the value is from the forecast
+	Anomalous bool    // whether the value is outside the normal band
+}
+
+// newModel constructs a Model for a season of the given number of minutes
+func newModel(seasonLength int) *Model {
+	return &Model{Season: make([]float64, seasonLength)}
+}
+
+// index returns the position of the minute t in the season
+func (m *Model) index(t time.Time) int {
+	return int((t.Unix() / 60) % int64(len(m.Season)))
+}
+
+// Forecast returns the value the model expects at the minute t
+func (m *Model) Forecast(t time.Time) float64 {
+	return m.Level + m.Trend + m.Season[m.index(t)]
+}
+
+// Update scores the value v at the minute t against the model's forecast, and
+// then learns from it. The score is only meaningful once the model has warmed
+// up, which Update reports with ok
+func (m *Model) Update(t time.Time, v float64, p Params) (s Score, ok bool) {
+	i := m.index(t)
+
+	if m.Points == 0 {
+		m.Level = v
+		m.Points = 1
+		m.Last = t
+		return Score{Expected: v}, false
+	}
+
+	s.Expected = m.Forecast(t)
+	s.Deviation = math.Sqrt(m.Variance)
+	residual := v - s.Expected
+
+	// never divide by zero, e.g. when a series has been constant until now
+	deviation := math.Max(s.Deviation, 1e-6*math.Max(math.Abs(s.Expected), 1))
+	s.Score = residual / deviation
+	s.Anomalous = math.Abs(s.Score) > p.Sensitivity
+	ok = m.Points >= p.Warmup
+
+	m.Variance = p.Delta*residual*residual + (1-p.Delta)*m.Variance
+
+	level := p.Alpha*(v-m.Season[i]) + (1-p.Alpha)*(m.Level+m.Trend)
+	m.Trend = p.Beta*(level-m.Level) + (1-p.Beta)*m.Trend
+	m.Level = level
+	m.Season[i] = p.Gamma*(v-m.Level) + (1-p.Gamma)*m.Season[i]
+
+	m.Points++
+	m.Last = t
+	return s, ok
+}
@@ -0,0 +1,90 @@
+package anomaly
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"math"
+	"time"
+)
+
+var _ = Describe("Model", func() {
+
+	var start time.Time
+	var params Params
+
+	minute := func(n int) time.Time {
+		return start.Add(time.Minute * time.Duration(n))
+	}
+
+	BeforeEach(func() {
+		start = time.Date(2014, 10, 1, 0, 0, 0, 0, time.UTC)
+		params = DefaultParams
+		params.Season = time.Minute * 10
+		params.Warmup = 20
+	})
+
+	It("should not report a score until it has warmed up", func() {
+		m := newModel(seasonLength(params))
+		for i := 0; i < params.Warmup; i++ {
+			_, ok := m.Update(minute(i), 10, params)
+			Expect(ok).To(BeFalse())
+		}
+
+		_, ok := m.Update(minute(params.Warmup), 10, params)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should flag a spike in an otherwise noisy but steady series", func() {
+		m := newModel(seasonLength(params))
+		for i := 0; i < 200; i++ {
+			s, ok := m.Update(minute(i), 100+float64(i%3), params)
+			if ok {
+				Expect(s.Anomalous).To(BeFalse(), "minute %d", i)
+			}
+		}
+
+		s, ok := m.Update(minute(200), 200, params)
+		Expect(ok).To(BeTrue())
+		Expect(s.Anomalous).To(BeTrue())
+		Expect(s.Score).To(BeNumerically(">", params.Sensitivity))
+	})
+
+	It("should flag a drop as a negative score", func() {
+		m := newModel(seasonLength(params))
+		for i := 0; i < 200; i++ {
+			m.Update(minute(i), 100+float64(i%3), params)
+		}
+
+		s, _ := m.Update(minute(200), 0, params)
+		Expect(s.Anomalous).To(BeTrue())
+		Expect(s.Score).To(BeNumerically("<", -params.Sensitivity))
+	})
+
+	It("should learn a seasonal pattern", func() {
+		m := newModel(seasonLength(params))
+		value := func(i int) float64 {
+			return 100 + 50*math.Sin(2*math.Pi*float64(i%10)/10)
+		}
+
+		for i := 0; i < 1000; i++ {
+			m.Update(minute(i), value(i), params)
+		}
+
+		for i := 1000; i < 1010; i++ {
+			Expect(m.Forecast(minute(i))).To(BeNumerically("~", value(i), 5))
+			s, _ := m.Update(minute(i), value(i), params)
+			Expect(s.Anomalous).To(BeFalse())
+		}
+	})
+
+	It("should not divide by zero for a constant series", func() {
+		m := newModel(seasonLength(params))
+		for i := 0; i < 100; i++ {
+			m.Update(minute(i), 5, params)
+		}
+
+		s, _ := m.Update(minute(100), 6, params)
+		Expect(math.IsInf(s.Score, 0)).To(BeFalse())
+		Expect(s.Anomalous).To(BeTrue())
+	})
+})
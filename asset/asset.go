@@ -0,0 +1,9 @@
+// Package asset holds the dashboard served over HTTP, built into gostat
+package asset
+
+import "embed"
+
+// Files are the static files of the dashboard
+//
+//go:embed *.html *.css *.js
+var Files embed.FS
@@ -0,0 +1,987 @@
+// The gostat dashboard: a series browser, a time range picker and graphs of
+// raw or aggregated stats, kept up to date over socket.io. The dashboard is
+// described by the URL's hash, so it can be bookmarked and shared, and can be
+// saved in gostat through the dashboards API
+(function () {
+  'use strict';
+
+  var SECOND = 1000, MINUTE = 60 * SECOND, HOUR = 60 * MINUTE, DAY = 24 * HOUR;
+
+  // ranges of at most RAW_RANGE are charted from raw stats in the auto view, up
+  // to RAW_LIMIT of them; wider ranges are aggregated into steps of about
+  // PIXELS_PER_STEP pixels
+  var RAW_RANGE = HOUR, RAW_LIMIT = 10000, PIXELS_PER_STEP = 2;
+
+  var STEPS = [SECOND, 2 * SECOND, 5 * SECOND, 10 * SECOND, 15 * SECOND, 30 * SECOND,
+    MINUTE, 2 * MINUTE, 5 * MINUTE, 10 * MINUTE, 15 * MINUTE, 30 * MINUTE,
+    HOUR, 2 * HOUR, 3 * HOUR, 6 * HOUR, 12 * HOUR, DAY, 2 * DAY, 7 * DAY, 30 * DAY];
+
+  var COLORS = ['#1f77b4', '#ff7f0e', '#2ca02c', '#d62728', '#9467bd', '#8c564b', '#e377c2', '#17becf'];
+
+  var $ = function (id) { return document.getElementById(id); };
+
+  // state is what the URL's hash describes
+  var state = {
+    id: '',        // the saved dashboard shown, if any
+    version: 0,    // the version of it the changes are made to
+    title: '',
+    range: '1h',   // a preset like 1h, or custom for from and to
+    from: 0,       // ms since the epoch, for a custom range
+    to: 0,
+    refresh: 0,    // seconds between refreshes, or 0
+    live: true,    // stream new stats, for a preset range
+    graphs: []     // each {series: [name...], query: '', view: auto|raw|aggregated}, charting series or a query
+  };
+
+  var names = [];        // every series, for the browser
+  var charts = [];       // the graphs drawn, parallel to state.graphs
+  var refreshTimer = null;
+  var socket = io();
+
+  // parseDuration parses a period like 15m, 24h or 7d into ms
+  function parseDuration(s) {
+    var m = /^(\d+)(ms|s|min|m|h|d|w)$/.exec(s);
+    if (!m) {
+      return 0;
+    }
+    return Number(m[1]) * {ms: 1, s: SECOND, min: MINUTE, m: MINUTE, h: HOUR, d: DAY, w: 7 * DAY}[m[2]];
+  }
+
+  // currentRange returns the time range charted, in ms since the epoch
+  function currentRange() {
+    if (state.range === 'custom') {
+      return {from: state.from, to: state.to};
+    }
+    var now = Date.now();
+    return {from: now - parseDuration(state.range), to: now};
+  }
+
+  function isLive() {
+    return state.live && state.range !== 'custom';
+  }
+
+  // ---- the URL's hash ----
+
+  // load reads the dashboard from the URL's hash, returning whether it only
+  // names a saved dashboard, which has to be opened
+  function load() {
+    var params = new URLSearchParams(location.hash.replace(/^#/, ''));
+    if (params.get('d') !== state.id) {
+      state.id = params.get('d') || '';
+      state.version = 0;
+      state.title = '';
+    }
+    if (state.id && !params.has('range')) {
+      return true;
+    }
+    state.version = Number(params.get('v')) || state.version;
+    state.range = params.get('range') || '1h';
+    state.from = Number(params.get('from')) || Date.now() - HOUR;
+    state.to = Number(params.get('to')) || Date.now();
+    state.refresh = Number(params.get('refresh')) || 0;
+    state.live = params.get('live') !== '0';
+    state.graphs = params.getAll('g').map(function (g) {
+      var bar = g.lastIndexOf('|');
+      var view = bar >= 0 ? g.slice(bar + 1) : 'auto';
+      g = bar >= 0 ? g.slice(0, bar) : g;
+      if (g.indexOf('q:') === 0) {
+        return {series: [], query: g.slice(2), view: view};
+      }
+      return {series: g.split(',').filter(Boolean), query: '', view: view};
+    }).filter(function (g) { return g.series.length > 0 || g.query; });
+    return false;
+  }
+
+  function save() {
+    var params = new URLSearchParams();
+    if (state.id) {
+      params.set('d', state.id);
+      params.set('v', state.version);
+    }
+    params.set('range', state.range);
+    if (state.range === 'custom') {
+      params.set('from', state.from);
+      params.set('to', state.to);
+    }
+    if (state.refresh) {
+      params.set('refresh', state.refresh);
+    }
+    if (!state.live) {
+      params.set('live', '0');
+    }
+    state.graphs.forEach(function (g) {
+      params.append('g', (g.query ? 'q:' + g.query : g.series.join(',')) + (g.view === 'auto' ? '' : '|' + g.view));
+    });
+    history.replaceState(null, '', '#' + params.toString());
+  }
+
+  // ---- the time range picker ----
+
+  function toLocalInput(ms) {
+    var d = new Date(ms - new Date(ms).getTimezoneOffset() * MINUTE);
+    return d.toISOString().slice(0, 19);
+  }
+
+  function showRange() {
+    $('preset').value = state.range;
+    $('custom').className = state.range === 'custom' ? 'shown' : '';
+    var w = currentRange();
+    $('from').value = toLocalInput(w.from);
+    $('to').value = toLocalInput(w.to);
+    $('refresh').value = String(state.refresh);
+    $('live').checked = state.live;
+  }
+
+  $('preset').addEventListener('change', function () {
+    $('custom').className = $('preset').value === 'custom' ? 'shown' : '';
+  });
+
+  $('range').addEventListener('submit', function (e) {
+    e.preventDefault();
+    state.range = $('preset').value;
+    if (state.range === 'custom') {
+      state.from = new Date($('from').value).getTime();
+      state.to = new Date($('to').value).getTime();
+      if (!(state.from < state.to)) {
+        alert('The start of the range must be before its end');
+        return;
+      }
+    }
+    state.refresh = Number($('refresh').value);
+    state.live = $('live').checked;
+    apply();
+  });
+
+  // ---- the series browser ----
+
+  function isPattern(s) {
+    return /[*?\[{]/.test(s);
+  }
+
+  function fetchJSON(url) {
+    return fetch(url).then(function (res) {
+      if (!res.ok) {
+        return res.text().then(function (text) { throw new Error(text.trim() || res.statusText); });
+      }
+      return res.json();
+    });
+  }
+
+  function loadNames(match) {
+    return fetchJSON('/series' + (match ? '?match=' + encodeURIComponent(match) : ''));
+  }
+
+  function showNames(list, message) {
+    var ul = $('series');
+    ul.innerHTML = '';
+    if (message) {
+      var li = document.createElement('li');
+      li.className = 'message';
+      li.textContent = message;
+      ul.appendChild(li);
+      return;
+    }
+    var charted = {};
+    state.graphs.forEach(function (g) {
+      g.series.forEach(function (name) { charted[name] = true; });
+    });
+    list.slice(0, 1000).forEach(function (name) {
+      var li = document.createElement('li');
+      li.textContent = name;
+      if (charted[name]) {
+        li.className = 'charted';
+      }
+      li.addEventListener('click', function (e) { addSeries(name, e.shiftKey); });
+      ul.appendChild(li);
+    });
+    if (list.length > 1000) {
+      var more = document.createElement('li');
+      more.className = 'message';
+      more.textContent = (list.length - 1000) + ' more, filter to find them';
+      ul.appendChild(more);
+    }
+  }
+
+  function filterNames() {
+    var filter = $('filter').value.trim();
+    if (isPattern(filter)) {
+      loadNames(filter).then(function (list) {
+        showNames(list, list.length ? '' : 'No series match ' + filter);
+      }, function (err) { showNames([], err.message); });
+      return;
+    }
+    var lower = filter.toLowerCase();
+    showNames(names.filter(function (name) { return name.toLowerCase().indexOf(lower) >= 0; }));
+  }
+
+  $('filter').addEventListener('input', function () {
+    if (!isPattern($('filter').value)) {
+      filterNames();
+    }
+  });
+  $('filter').addEventListener('keydown', function (e) {
+    if (e.key === 'Enter') {
+      filterNames();
+    }
+  });
+
+  function addSeries(name, toLast) {
+    var last = state.graphs[state.graphs.length - 1];
+    if (toLast && last && !last.query) {
+      if (last.series.indexOf(name) < 0) {
+        last.series.push(name);
+      }
+    } else {
+      state.graphs.push({series: [name], query: '', view: 'auto'});
+    }
+    apply();
+  }
+
+  $('add-query').addEventListener('submit', function (e) {
+    e.preventDefault();
+    var q = $('query').value.trim();
+    if (q) {
+      state.graphs.push({series: [], query: q, view: 'raw'});
+      $('query').value = '';
+      apply();
+    }
+  });
+
+  // ---- fetching stats ----
+
+  // niceStep returns the step of aggregates giving about one per
+  // PIXELS_PER_STEP pixels of a chart width wide
+  function niceStep(range, width) {
+    var wanted = range / Math.max(width / PIXELS_PER_STEP, 1);
+    for (var i = 0; i < STEPS.length; i++) {
+      if (STEPS[i] >= wanted) {
+        return STEPS[i];
+      }
+    }
+    return STEPS[STEPS.length - 1];
+  }
+
+  function rangeQuery(name, w) {
+    return '?name=' + encodeURIComponent(name) + '&startDate=' + Math.floor(w.from) +
+      '&endDate=' + Math.ceil(w.to) + '&precision=ms';
+  }
+
+  // fetchRaw resolves to the raw stats of a series as {ts, value} points, or
+  // to null if there are more than RAW_LIMIT
+  function fetchRaw(name, w) {
+    return fetchJSON('/rawStats' + rangeQuery(name, w) + '&limit=' + RAW_LIMIT).then(function (res) {
+      return res.cursor ? null : res.stats;
+    });
+  }
+
+  // fetchAggregates resolves to the stats of a series aggregated in steps of
+  // step ms, as {ts, average, min, max, count} points
+  function fetchAggregates(name, w, step) {
+    return fetchJSON('/aggregates' + rangeQuery(name, w) + '&step=' + step / SECOND + 's');
+  }
+
+  // fetchSeries resolves to the data of a series charted in a view, as
+  // {points, step}, where step is 0 for raw stats
+  function fetchSeries(name, view, w, width) {
+    var step = niceStep(w.to - w.from, width);
+    if (view === 'aggregated' || (view === 'auto' && w.to - w.from > RAW_RANGE)) {
+      return fetchAggregates(name, w, step).then(function (points) { return {points: points, step: step}; });
+    }
+    return fetchRaw(name, w).then(function (points) {
+      if (points) {
+        return {points: points, step: 0};
+      }
+      if (view === 'raw') {
+        throw new Error('more than ' + RAW_LIMIT + ' stats in the range, showing the first would mislead, aggregate them instead');
+      }
+      return fetchAggregates(name, w, step).then(function (agg) { return {points: agg, step: step}; });
+    });
+  }
+
+  // fetchQuery resolves to the series a query returns, by name, as
+  // {points, step} of raw stats
+  function fetchQuery(q, w) {
+    return fetchJSON('/query?query=' + encodeURIComponent(q) + '&startDate=' + Math.floor(w.from) +
+      '&endDate=' + Math.ceil(w.to) + '&precision=ms').then(function (results) {
+      var data = {};
+      results.forEach(function (r) { data[r.name] = {points: r.stats, step: 0}; });
+      return data;
+    });
+  }
+
+  // ---- graphs ----
+
+  function Chart(graph) {
+    var self = this;
+    this.graph = graph;
+    this.data = {}; // by series name, {points, step}
+    this.el = document.createElement('div');
+    this.el.className = 'graph';
+
+    var title = document.createElement('div');
+    title.className = 'title';
+    this.legend = document.createElement('div');
+    this.legend.className = 'legend';
+    title.appendChild(this.legend);
+
+    var view = document.createElement('select');
+    ['auto', 'raw', 'aggregated'].forEach(function (v) {
+      var o = document.createElement('option');
+      o.value = v;
+      o.textContent = v === 'auto' ? 'Auto' : v === 'raw' ? 'Raw' : 'Min/avg/max';
+      view.appendChild(o);
+    });
+    view.value = graph.view;
+    view.addEventListener('change', function () {
+      graph.view = view.value;
+      save();
+      self.fetch();
+    });
+    if (!graph.query) {
+      title.appendChild(view); // queries return raw stats
+    }
+
+    var remove = document.createElement('button');
+    remove.textContent = '×';
+    remove.title = 'remove the graph';
+    remove.addEventListener('click', function () {
+      state.graphs.splice(state.graphs.indexOf(graph), 1);
+      apply();
+    });
+    title.appendChild(remove);
+    this.el.appendChild(title);
+
+    this.canvas = document.createElement('canvas');
+    this.el.appendChild(this.canvas);
+    this.status = document.createElement('div');
+    this.status.className = 'status';
+    this.el.appendChild(this.status);
+
+    this.canvas.addEventListener('mousemove', function (e) { self.hover(e); });
+    this.canvas.addEventListener('mouseleave', function () {
+      self.cursor = null;
+      $('tooltip').style.display = 'none';
+      self.draw();
+    });
+    this.showLegend();
+  }
+
+  // names returns the names of the series charted
+  Chart.prototype.names = function () {
+    return this.graph.query ? Object.keys(this.data).sort() : this.graph.series;
+  };
+
+  Chart.prototype.showLegend = function () {
+    var self = this, graph = this.graph;
+    this.legend.innerHTML = '';
+    if (graph.query) {
+      var q = document.createElement('span');
+      q.textContent = graph.query;
+      q.className = 'query';
+      this.legend.appendChild(q);
+    }
+    this.names().forEach(function (name, i) {
+      var span = document.createElement('span');
+      var swatch = document.createElement('i');
+      swatch.style.background = COLORS[i % COLORS.length];
+      span.appendChild(swatch);
+      span.appendChild(document.createTextNode(name));
+      if (!graph.query && graph.series.length > 1) {
+        var drop = document.createElement('a');
+        drop.textContent = '×';
+        drop.title = 'remove the series from the graph';
+        drop.addEventListener('click', function () {
+          graph.series.splice(graph.series.indexOf(name), 1);
+          apply();
+        });
+        span.appendChild(drop);
+      }
+      self.legend.appendChild(span);
+    });
+  };
+
+  Chart.prototype.fetch = function () {
+    var self = this;
+    var w = currentRange();
+    this.window = w;
+    var width = this.canvas.clientWidth || 600;
+    var fetched;
+    if (this.graph.query) {
+      fetched = fetchQuery(this.graph.query, w).then(function (data) {
+        self.data = data;
+        self.showLegend();
+      });
+    } else {
+      fetched = Promise.all(this.graph.series.map(function (name) {
+        return fetchSeries(name, self.graph.view, w, width).then(function (data) {
+          self.data[name] = data;
+        });
+      }));
+    }
+    fetched.then(function () {
+      self.status.textContent = '';
+      self.draw();
+    }, function (err) {
+      self.status.textContent = err.message;
+      self.draw();
+    });
+  };
+
+  // append adds a stat streamed live to a series, folding it into the last
+  // aggregate if it falls in its step
+  Chart.prototype.append = function (name, stat) {
+    var data = this.data[name];
+    if (!data) {
+      return;
+    }
+    var points = data.points;
+    if (data.step === 0) {
+      if (points.length === 0 || points[points.length - 1].ts <= stat.ts) {
+        points.push(stat);
+      }
+      return;
+    }
+    var ts = stat.ts - ((stat.ts % data.step) + data.step) % data.step;
+    var last = points[points.length - 1];
+    if (last && last.ts === ts) {
+      last.average = (last.average * last.count + stat.value) / (last.count + 1);
+      last.min = Math.min(last.min, stat.value);
+      last.max = Math.max(last.max, stat.value);
+      last.count++;
+    } else if (!last || last.ts < ts) {
+      points.push({ts: ts, average: stat.value, min: stat.value, max: stat.value, count: 1});
+    }
+  };
+
+  // slide moves a live graph's window up to now, dropping points before it
+  Chart.prototype.slide = function () {
+    var w = currentRange();
+    this.window = w;
+    var self = this;
+    Object.keys(this.data).forEach(function (name) {
+      var points = self.data[name].points;
+      var drop = 0;
+      while (drop < points.length && points[drop].ts < w.from - (self.data[name].step || 0)) {
+        drop++;
+      }
+      if (drop > 0) {
+        points.splice(0, drop);
+      }
+    });
+  };
+
+  Chart.prototype.layout = function () {
+    var ratio = window.devicePixelRatio || 1;
+    var width = this.canvas.clientWidth, height = this.canvas.clientHeight;
+    if (this.canvas.width !== width * ratio || this.canvas.height !== height * ratio) {
+      this.canvas.width = width * ratio;
+      this.canvas.height = height * ratio;
+    }
+    return {ratio: ratio, width: width, height: height, left: 56, right: 8, top: 8, bottom: 22};
+  };
+
+  // bounds returns the range of values charted
+  Chart.prototype.bounds = function () {
+    var lo = Infinity, hi = -Infinity;
+    var self = this;
+    this.names().forEach(function (name) {
+      var data = self.data[name];
+      if (!data) {
+        return;
+      }
+      data.points.forEach(function (p) {
+        var min = data.step ? p.min : p.value, max = data.step ? p.max : p.value;
+        if (min < lo) { lo = min; }
+        if (max > hi) { hi = max; }
+      });
+    });
+    if (lo === Infinity) {
+      return {lo: 0, hi: 1};
+    }
+    if (lo === hi) {
+      return {lo: lo - 1, hi: hi + 1};
+    }
+    var pad = (hi - lo) * 0.05;
+    return {lo: lo - pad, hi: hi + pad};
+  };
+
+  Chart.prototype.draw = function () {
+    var l = this.layout();
+    var ctx = this.canvas.getContext('2d');
+    ctx.setTransform(l.ratio, 0, 0, l.ratio, 0, 0);
+    ctx.clearRect(0, 0, l.width, l.height);
+    var w = this.window || currentRange();
+    var b = this.bounds();
+    var plotW = l.width - l.left - l.right, plotH = l.height - l.top - l.bottom;
+    var x = function (ts) { return l.left + (ts - w.from) / (w.to - w.from) * plotW; };
+    var y = function (v) { return l.top + (1 - (v - b.lo) / (b.hi - b.lo)) * plotH; };
+    this.x = x;
+    this.plot = {left: l.left, right: l.left + plotW};
+
+    // axes
+    ctx.font = '11px Helvetica, Arial, sans-serif';
+    ctx.fillStyle = '#777';
+    ctx.strokeStyle = '#eee';
+    ctx.lineWidth = 1;
+    ctx.textAlign = 'right';
+    ctx.textBaseline = 'middle';
+    valueTicks(b.lo, b.hi, 5).forEach(function (v) {
+      ctx.beginPath();
+      ctx.moveTo(l.left, Math.round(y(v)) + 0.5);
+      ctx.lineTo(l.left + plotW, Math.round(y(v)) + 0.5);
+      ctx.stroke();
+      ctx.fillText(formatValue(v), l.left - 6, y(v));
+    });
+    ctx.textAlign = 'center';
+    ctx.textBaseline = 'top';
+    timeTicks(w.from, w.to, Math.max(Math.floor(plotW / 110), 2)).forEach(function (t) {
+      ctx.fillText(formatTime(t, w.to - w.from), x(t), l.top + plotH + 6);
+    });
+
+    ctx.save();
+    ctx.beginPath();
+    ctx.rect(l.left, l.top, plotW, plotH);
+    ctx.clip();
+
+    var self = this;
+    this.names().forEach(function (name, i) {
+      var data = self.data[name];
+      if (!data || data.points.length === 0) {
+        return;
+      }
+      var color = COLORS[i % COLORS.length];
+      var points = data.points;
+      if (data.step) {
+        // the min/max band, then the average
+        ctx.beginPath();
+        points.forEach(function (p, j) {
+          var px = x(p.ts + data.step / 2);
+          if (j === 0) { ctx.moveTo(px, y(p.max)); } else { ctx.lineTo(px, y(p.max)); }
+        });
+        for (var j = points.length - 1; j >= 0; j--) {
+          ctx.lineTo(x(points[j].ts + data.step / 2), y(points[j].min));
+        }
+        ctx.closePath();
+        ctx.globalAlpha = 0.18;
+        ctx.fillStyle = color;
+        ctx.fill();
+        ctx.globalAlpha = 1;
+      }
+      ctx.beginPath();
+      points.forEach(function (p, j) {
+        var px = x(data.step ? p.ts + data.step / 2 : p.ts), py = y(data.step ? p.average : p.value);
+        if (j === 0) { ctx.moveTo(px, py); } else { ctx.lineTo(px, py); }
+      });
+      ctx.strokeStyle = color;
+      ctx.lineWidth = 1.5;
+      ctx.stroke();
+      if (points.length === 1) {
+        var p = points[0];
+        ctx.fillStyle = color;
+        ctx.fillRect(x(data.step ? p.ts + data.step / 2 : p.ts) - 2, y(data.step ? p.average : p.value) - 2, 4, 4);
+      }
+    });
+    ctx.restore();
+
+    if (this.cursor != null) {
+      ctx.strokeStyle = '#999';
+      ctx.beginPath();
+      ctx.moveTo(Math.round(this.cursor) + 0.5, l.top);
+      ctx.lineTo(Math.round(this.cursor) + 0.5, l.top + plotH);
+      ctx.stroke();
+    }
+  };
+
+  // hover shows the values of each series nearest the mouse
+  Chart.prototype.hover = function (e) {
+    var rect = this.canvas.getBoundingClientRect();
+    var mx = e.clientX - rect.left;
+    if (!this.x || mx < this.plot.left || mx > this.plot.right) {
+      return;
+    }
+    var w = this.window || currentRange();
+    var ts = w.from + (mx - this.plot.left) / (this.plot.right - this.plot.left) * (w.to - w.from);
+    var lines = [formatTime(ts, 0)];
+    var self = this;
+    this.names().forEach(function (name) {
+      var data = self.data[name];
+      if (!data || data.points.length === 0) {
+        return;
+      }
+      var p = nearest(data.points, ts - data.step / 2);
+      if (data.step) {
+        lines.push(name + ': ' + formatValue(p.average) + ' (' + formatValue(p.min) + ' – ' +
+          formatValue(p.max) + ', ' + p.count + ' stats)');
+      } else {
+        lines.push(name + ': ' + formatValue(p.value));
+      }
+    });
+    this.cursor = mx;
+    this.draw();
+    var tip = $('tooltip');
+    tip.innerHTML = '';
+    lines.forEach(function (line) {
+      var div = document.createElement('div');
+      div.textContent = line;
+      tip.appendChild(div);
+    });
+    tip.style.display = 'block';
+    tip.style.left = Math.min(e.clientX + 12, document.documentElement.clientWidth - tip.offsetWidth - 4) + 'px';
+    tip.style.top = (e.clientY + 12) + 'px';
+  };
+
+  // nearest returns the point of the sorted points nearest ts
+  function nearest(points, ts) {
+    var lo = 0, hi = points.length - 1;
+    while (lo < hi) {
+      var mid = (lo + hi) >> 1;
+      if (points[mid].ts < ts) { lo = mid + 1; } else { hi = mid; }
+    }
+    if (lo > 0 && ts - points[lo - 1].ts < points[lo].ts - ts) {
+      return points[lo - 1];
+    }
+    return points[lo];
+  }
+
+  function valueTicks(lo, hi, count) {
+    var span = (hi - lo) / count;
+    var mag = Math.pow(10, Math.floor(Math.log(span) / Math.LN10));
+    var step = [1, 2, 5, 10].map(function (m) { return m * mag; }).filter(function (s) { return s >= span; })[0];
+    var ticks = [];
+    for (var v = Math.ceil(lo / step) * step; v <= hi; v += step) {
+      ticks.push(Math.abs(v) < step / 1e6 ? 0 : v);
+    }
+    return ticks;
+  }
+
+  function timeTicks(from, to, count) {
+    var step = STEPS.filter(function (s) { return s >= (to - from) / count; })[0] || STEPS[STEPS.length - 1];
+    // align the ticks to the local day for steps of hours and days
+    var offset = step >= HOUR ? new Date(from).getTimezoneOffset() * MINUTE : 0;
+    var ticks = [];
+    for (var t = Math.ceil((from - offset) / step) * step + offset; t <= to; t += step) {
+      ticks.push(t);
+    }
+    return ticks;
+  }
+
+  function pad(n) {
+    return n < 10 ? '0' + n : String(n);
+  }
+
+  // formatTime formats a time for a range of span ms, in full if span is 0
+  function formatTime(ts, span) {
+    var d = new Date(ts);
+    var day = d.getFullYear() + '-' + pad(d.getMonth() + 1) + '-' + pad(d.getDate());
+    var time = pad(d.getHours()) + ':' + pad(d.getMinutes());
+    if (span === 0) {
+      return day + ' ' + time + ':' + pad(d.getSeconds());
+    }
+    if (span > 2 * DAY) {
+      return pad(d.getMonth() + 1) + '-' + pad(d.getDate()) + ' ' + time;
+    }
+    return span <= 15 * MINUTE ? time + ':' + pad(d.getSeconds()) : time;
+  }
+
+  function formatValue(v) {
+    var abs = Math.abs(v);
+    if (abs >= 1e9) { return (v / 1e9).toPrecision(3) + 'G'; }
+    if (abs >= 1e6) { return (v / 1e6).toPrecision(3) + 'M'; }
+    if (abs >= 1e4) { return (v / 1e3).toPrecision(3) + 'k'; }
+    return String(Number(v.toPrecision(4)));
+  }
+
+  // ---- live updates ----
+
+  function subscribe() {
+    socket.emit('unsubscribeReq', JSON.stringify({}));
+    if (!isLive()) {
+      return;
+    }
+    var all = {};
+    state.graphs.forEach(function (g) {
+      g.series.forEach(function (name) { all[name] = true; });
+    });
+    var list = Object.keys(all);
+    if (list.length > 0) {
+      socket.emit('subscribeReq', JSON.stringify({names: list, precision: 'ms'}));
+    }
+  }
+
+  var pending = false;
+  socket.on('liveStats', function (msg) {
+    var live = JSON.parse(msg);
+    charts.forEach(function (c) {
+      if (c.graph.series.indexOf(live.name) >= 0) {
+        live.stats.forEach(function (s) { c.append(live.name, s); });
+      }
+    });
+    // redraw at most once a frame
+    if (!pending) {
+      pending = true;
+      requestAnimationFrame(function () {
+        pending = false;
+        charts.forEach(function (c) {
+          c.slide();
+          c.draw();
+        });
+      });
+    }
+  });
+  socket.on('connect', subscribe);
+
+  // ---- saved dashboards ----
+
+  var saved = []; // summaries of the saved dashboards
+
+  // send makes a request of the dashboards API, resolving to its JSON
+  // response. Errors carry the HTTP status
+  function send(method, url, body) {
+    var init = {method: method};
+    if (body !== undefined) {
+      init.headers = {'Content-Type': 'application/json'};
+      init.body = typeof body === 'string' ? body : JSON.stringify(body);
+    }
+    return fetch(url, init).then(function (res) {
+      if (!res.ok) {
+        return res.text().then(function (text) {
+          var err = new Error(text.trim() || res.statusText);
+          err.status = res.status;
+          throw err;
+        });
+      }
+      return res.status === 204 ? null : res.json();
+    });
+  }
+
+  function dashboardURL(id) {
+    return '/dashboards/' + encodeURIComponent(id);
+  }
+
+  // definition returns the dashboard shown as it is saved, titled title
+  function definition(title) {
+    var d = {
+      title: title,
+      range: state.range,
+      live: state.live,
+      graphs: state.graphs.map(function (g) {
+        var graph = g.query ? {query: g.query} : {series: g.series.slice()};
+        if (g.view !== 'auto') {
+          graph.view = g.view;
+        }
+        return graph;
+      })
+    };
+    if (state.range === 'custom') {
+      d.from = new Date(state.from).toISOString();
+      d.to = new Date(state.to).toISOString();
+    }
+    if (state.refresh) {
+      d.refresh = state.refresh + 's';
+    }
+    return d;
+  }
+
+  // show shows a saved dashboard
+  function show(d) {
+    state.id = d.id;
+    state.version = d.version;
+    state.title = d.title;
+    state.range = d.range;
+    if (d.range === 'custom') {
+      state.from = Date.parse(d.from);
+      state.to = Date.parse(d.to);
+    }
+    state.refresh = d.refresh ? Math.round(parseDuration(d.refresh) / SECOND) : 0;
+    state.live = d.live;
+    state.graphs = (d.graphs || []).map(function (g) {
+      return {series: g.series || [], query: g.query || '', view: g.view || 'auto'};
+    });
+    apply();
+    listSaved();
+  }
+
+  // open shows the latest version of a saved dashboard
+  function open(id) {
+    send('GET', dashboardURL(id)).then(show, function (err) {
+      alert('Error opening dashboard ' + id + ': ' + err.message);
+      state.id = '';
+      state.version = 0;
+      state.title = '';
+      apply();
+      listSaved();
+    });
+  }
+
+  function listSaved() {
+    send('GET', '/dashboards').then(function (list) {
+      saved = list;
+      showSaved();
+    }, function (err) { $('saved').title = 'Error listing dashboards: ' + err.message; });
+  }
+
+  function showSaved() {
+    var select = $('saved');
+    select.innerHTML = '';
+    var unsaved = document.createElement('option');
+    unsaved.value = '';
+    unsaved.textContent = 'Unsaved dashboard';
+    select.appendChild(unsaved);
+    saved.forEach(function (d) {
+      var o = document.createElement('option');
+      o.value = d.id;
+      o.textContent = d.title;
+      select.appendChild(o);
+      if (d.id === state.id && !state.title) {
+        state.title = d.title;
+      }
+    });
+    select.value = state.id;
+    $('save').disabled = $('delete').disabled = $('export').disabled = !state.id;
+  }
+
+  $('saved').addEventListener('change', function () {
+    var id = $('saved').value;
+    if (id) {
+      open(id);
+    } else {
+      state.id = '';
+      state.version = 0;
+      state.title = '';
+      save();
+      showSaved();
+    }
+  });
+
+  // Save saves the dashboard shown as a new version of the saved one. If
+  // someone else has saved it since, their changes are overwritten only once
+  // confirmed
+  $('save').addEventListener('click', function () {
+    var update = function (version) {
+      var d = definition(state.title || state.id);
+      d.version = version;
+      return send('PUT', dashboardURL(state.id), d);
+    };
+    update(state.version).catch(function (err) {
+      if (err.status !== 409 || !confirm(err.message + '\n\nSave over their changes?')) {
+        throw err;
+      }
+      return send('GET', dashboardURL(state.id)).then(function (latest) { return update(latest.version); });
+    }).then(function (d) {
+      if (d) {
+        state.version = d.version;
+        save();
+        listSaved();
+      }
+    }, function (err) { alert('Error saving the dashboard: ' + err.message); });
+  });
+
+  $('save-as').addEventListener('click', function () {
+    var title = prompt('Save the dashboard as', state.title);
+    if (!title) {
+      return;
+    }
+    send('POST', '/dashboards', definition(title)).then(function (d) {
+      state.id = d.id;
+      state.version = d.version;
+      state.title = d.title;
+      save();
+      listSaved();
+    }, function (err) { alert('Error saving the dashboard: ' + err.message); });
+  });
+
+  $('delete').addEventListener('click', function () {
+    if (!confirm('Delete the dashboard ' + (state.title || state.id) + ' and every version of it?')) {
+      return;
+    }
+    send('DELETE', dashboardURL(state.id)).then(function () {
+      state.id = '';
+      state.version = 0;
+      state.title = '';
+      save();
+      listSaved();
+    }, function (err) { alert('Error deleting the dashboard: ' + err.message); });
+  });
+
+  $('export').addEventListener('click', function () {
+    location.href = dashboardURL(state.id) + '/export?version=' + state.version;
+  });
+
+  $('import').addEventListener('click', function () {
+    $('import-file').click();
+  });
+
+  $('import-file').addEventListener('change', function () {
+    var file = $('import-file').files[0];
+    $('import-file').value = '';
+    if (!file) {
+      return;
+    }
+    file.text().then(function (text) {
+      return send('POST', '/dashboards/import', text).catch(function (err) {
+        if (err.status !== 409 || !confirm(err.message + '\n\nSave it as a new version of the dashboard with its id?')) {
+          throw err;
+        }
+        return send('POST', '/dashboards/import?overwrite=true', text);
+      });
+    }).then(show, function (err) { alert('Error importing ' + file.name + ': ' + err.message); });
+  });
+
+  // ---- putting it together ----
+
+  function apply() {
+    save();
+    showRange();
+    var container = $('graphs');
+    charts.forEach(function (c) { container.removeChild(c.el); });
+    charts = state.graphs.map(function (g) {
+      var c = new Chart(g);
+      container.appendChild(c.el);
+      return c;
+    });
+    $('empty').style.display = charts.length ? 'none' : '';
+    charts.forEach(function (c) { c.fetch(); });
+    subscribe();
+    filterNames();
+
+    clearInterval(refreshTimer);
+    if (state.refresh > 0) {
+      refreshTimer = setInterval(function () {
+        charts.forEach(function (c) { c.fetch(); });
+      }, state.refresh * SECOND);
+    }
+  }
+
+  // a live graph slides with the clock even while no stats arrive
+  setInterval(function () {
+    if (isLive()) {
+      charts.forEach(function (c) {
+        c.slide();
+        c.draw();
+      });
+    }
+  }, 5 * SECOND);
+
+  window.addEventListener('resize', function () {
+    charts.forEach(function (c) { c.draw(); });
+  });
+  window.addEventListener('hashchange', function () {
+    if (load()) {
+      open(state.id);
+    } else {
+      apply();
+      showSaved();
+    }
+  });
+
+  loadNames('').then(function (list) {
+    names = list;
+    filterNames();
+  }, function (err) { showNames([], 'Error listing series: ' + err.message); });
+  if (load()) {
+    open(state.id);
+  } else {
+    apply();
+    listSaved();
+  }
+})();
@@ -0,0 +1,42 @@
+package atomicfile
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("WriteFile", func() {
+
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "gostat-atomicfile")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should replace the file, leaving no temporary file behind", func() {
+		path := filepath.Join(dir, "state.json")
+		Expect(WriteFile(path, []byte("first"))).To(Succeed())
+		Expect(WriteFile(path, []byte("second"))).To(Succeed())
+
+		data, err := ioutil.ReadFile(path)
+		Expect(err).To(BeNil())
+		Expect(string(data)).To(Equal("second"))
+
+		files, err := ioutil.ReadDir(dir)
+		Expect(err).To(BeNil())
+		Expect(files).To(HaveLen(1))
+	})
+
+	It("should fail without touching anything when the directory is missing", func() {
+		Expect(WriteFile(filepath.Join(dir, "missing", "state.json"), []byte("data"))).NotTo(Succeed())
+	})
+})
@@ -1,7 +1,10 @@
 package bucketer
 
 import (
+	"github.com/CapillarySoftware/gostat/clock"
+	"github.com/CapillarySoftware/gostat/metrics"
 	"github.com/CapillarySoftware/gostat/stat"
+	"github.com/CapillarySoftware/gostat/writemode"
 	log "github.com/cihub/seelog"
 	"time"
 )
@@ -10,6 +13,13 @@ const NaonsecondsPerMin time.Duration = 60000000000
 
 type bucketMap map[string][]*stat.Stat
 
+var (
+	statsBucketed    = metrics.NewCounter("gostat.bucketer.bucketed", "Stats placed in a bucket")
+	statsTooNew      = metrics.NewCounter("gostat.bucketer.dropped;reason=future", "Stats dropped by the Bucketer")
+	statsTooOld      = metrics.NewCounter("gostat.bucketer.dropped;reason=late", "Stats dropped by the Bucketer")
+	bucketsPublished = metrics.NewCounter("gostat.bucketer.published", "Buckets published by the Bucketer")
+)
+
 type Bucketer struct {
 	currentBucketMinTime time.Time
 	currentBuckets       bucketMap
@@ -21,13 +31,27 @@ type Bucketer struct {
 	futureBuckets       bucketMap
 
 	input    <-chan *stat.Stat   // Stats to be bucketed are read from this channel
+	batches  <-chan []*stat.Stat // batches of stats to be bucketed are read from this channel, if not nil
 	output   chan<- []*stat.Stat // 'buckets' of Stats are written to this channel
 	shutdown <-chan bool         // signals a graceful shutdown
+	clock    clock.Clock         // drives the advance from one minute to the next, and publishing
+
+	finalized func(time.Time)  // called with the time before which every bucket is final, if not nil
+	modes     *writemode.Rules // how stats with the same name and timestamp are combined when published
+
+	statusRequests chan chan Status // Status requests are answered by Run
+	flushRequests  chan chan bool   // Flush requests are answered by Run
 }
 
-// NewBucketer constructs a Bucketer
+// NewBucketer constructs a Bucketer running on the system clock
 func NewBucketer(stats <-chan *stat.Stat, bucketedStats chan<- []*stat.Stat, shutdown <-chan bool) *Bucketer {
-	startOfCurrentMin := time.Now().UTC().Truncate(time.Minute) // "now", rounded down to the current min
+	return NewBucketerWithClock(stats, bucketedStats, shutdown, clock.Real)
+}
+
+// NewBucketerWithClock constructs a Bucketer whose buckets start at the clock's
+// current minute and advance as the clock does
+func NewBucketerWithClock(stats <-chan *stat.Stat, bucketedStats chan<- []*stat.Stat, shutdown <-chan bool, c clock.Clock) *Bucketer {
+	startOfCurrentMin := c.Now().UTC().Truncate(time.Minute) // "now", rounded down to the current min
 
 	return &Bucketer{
 		currentBucketMinTime: startOfCurrentMin,
@@ -42,42 +66,97 @@ func NewBucketer(stats <-chan *stat.Stat, bucketedStats chan<- []*stat.Stat, shu
 		input:    stats,
 		output:   bucketedStats,
 		shutdown: shutdown,
+		clock:    c,
+
+		statusRequests: make(chan chan Status),
+		flushRequests:  make(chan chan bool),
 	}
 }
 
+// OnFinalized registers a function called whenever the Bucketer advances, with
+// the time before which it will no longer publish or accept any stats
+func (b *Bucketer) OnFinalized(f func(before time.Time)) {
+	b.finalized = f
+}
+
+// WriteModes sets the write modes of the series. The stats of a summed series
+// that share a timestamp are summed into one when its bucket is published, as
+// they are stored, while every stat of the other series is aggregated, whether
+// or not it is stored. It must be called before Run
+func (b *Bucketer) WriteModes(r *writemode.Rules) {
+	b.modes = r
+}
+
 // Run is a goroutine that reads stats from the input channel, placing them into
 // the appropriate bucket. Buckets are published on the output channel at the
 // specified interval
 func (b *Bucketer) Run(publishInterval time.Duration) {
-	done := false
+	ticks := make(chan tick)
+	stop := make(chan bool)
+	advanceTicker, publishTicker := newTickers(b.clock, publishInterval)
+	go sendTicks(advanceTicker, publishTicker, stop, ticks)
+	defer close(stop)
 
-	publishTickChan := time.NewTicker(publishInterval)
+	b.run(ticks)
+}
+
+// run buckets stats until shut down, advancing and publishing on the ticks
+// read from the ticks channel
+func (b *Bucketer) run(ticks <-chan tick) {
+	done := false
 
 	for !done {
 		select {
 		case stat := <-b.input:
 			log.Debugf("Bucketer got %+v", *stat)
 			b.insert(stat)
-		case done = <-b.shutdown:
-			log.Debug("Bucketer shutting down ", time.Now())
-			// TODO: drain remaining stats
-			publishTickChan.Stop()
+		case batch := <-b.batches:
+			b.insertBatch(batch)
+		case t := <-ticks:
+			b.advance(t.now)
+			if t.publish {
+				log.Debug("Bucketer publish interval elapsed ", t.now)
+				b.pub()
+			}
+		case <-b.shutdown:
+			log.Debug("Bucketer shutting down ", b.clock.Now())
+			done = true
+			b.drain()
 			b.pub()
-			break
-		case <-publishTickChan.C:
-			log.Debug("Bucketer publish interval elapsed ", time.Now())
+		case reply := <-b.statusRequests:
+			reply <- b.status()
+		case flushed := <-b.flushRequests:
+			log.Info("Bucketer flushing on request ", b.clock.Now())
 			b.pub()
-		case <-time.After(time.Second * 1):
-			log.Debug("Bucketer Run() timeout ", time.Now())
+			close(flushed)
 		}
+	}
 
-		if time.Now().UTC().After(b.futureBucketMinTime) {
-			log.Debug("Bucketer advancing ", time.Now())
-			b.next()
-		}
+	log.Info("Bucketer Run() exiting ", b.clock.Now())
+}
+
+// advance moves on to the next minute for as long as now is after the start of
+// the future bucket
+func (b *Bucketer) advance(now time.Time) {
+	for now.After(b.futureBucketMinTime) {
+		log.Debug("Bucketer advancing ", now)
+		b.next()
 	}
+}
 
-	log.Info("Bucketer Run() exiting ", time.Now())
+// drain inserts the stats already waiting on the input channel, so that stats
+// sent before a shutdown make it into the final buckets
+func (b *Bucketer) drain() {
+	for {
+		select {
+		case stat := <-b.input:
+			b.insert(stat)
+		case batch := <-b.batches:
+			b.insertBatch(batch)
+		default:
+			return
+		}
+	}
 }
 
 // pub invokes publish() on the current and previous buckets
@@ -95,23 +174,45 @@ func (b *Bucketer) publish(buckets bucketMap) {
 	for statName, bucket := range buckets {
 		log.Debugf("publishing %d stats for bucket: %v", len(bucket), statName)
 
-		clone := make([]*stat.Stat, len(bucket))
-		for i := range bucket {
-			clone[i] = bucket[i]
+		if b.modes.Mode(statName) == writemode.Sum {
+			b.output <- writemode.Collapse(writemode.Sum, bucket)
+		} else {
+			b.output <- writemode.Collapse(writemode.KeepAll, bucket) // a copy
 		}
-		b.output <- clone
+		bucketsPublished.Inc()
 	}
 }
 
 // insert places the provided stat in the appropriate current, previous, or future bucket.
 // It returns an error if the stat could not be placed in a bucket
 func (b *Bucketer) insert(s *stat.Stat) error {
+	if err := b.place(s); err != nil {
+		return err
+	}
+	statsBucketed.Inc()
+	return nil
+}
+
+// insertBatch inserts each stat of a batch, counting them once rather than per
+// stat
+func (b *Bucketer) insertBatch(stats []*stat.Stat) {
+	placed := 0
+	for _, s := range stats {
+		if b.place(s) == nil {
+			placed++
+		}
+	}
+	statsBucketed.Add(uint64(placed))
+}
+
+// place places the provided stat in the appropriate bucket, without counting it
+func (b *Bucketer) place(s *stat.Stat) error {
 	var buckets bucketMap
 
 	if s == nil {
 		return log.Errorf("dropping nil stat")
 	} else if s.Timestamp.After(b.futureBucketMinTime.Add(time.Nanosecond * (NaonsecondsPerMin - 1))) {
-		// TODO: insert a "meta stat" representing a dropped future stat
+		statsTooNew.Inc()
 		return log.Warnf("Bucketer: dropping 'future' stat that is 'after' %v: %+v", b.futureBucketMinTime.Add(time.Nanosecond*(NaonsecondsPerMin-1)), *s)
 	}
 
@@ -122,7 +223,7 @@ func (b *Bucketer) insert(s *stat.Stat) error {
 	} else if s.Timestamp.After(b.previousBucketMinTime) || s.Timestamp.Equal(b.previousBucketMinTime) {
 		buckets = b.previousBuckets
 	} else {
-		// TODO: insert a "meta stat" representing a dropped 'too old' stat
+		statsTooOld.Inc()
 		return log.Warnf("Bucketer: dropping stat older than %v: %+v", b.previousBucketMinTime, *s)
 	}
 
@@ -142,4 +243,8 @@ func (b *Bucketer) next() {
 	b.previousBuckets = b.currentBuckets
 	b.currentBuckets = b.futureBuckets
 	b.futureBuckets = make(map[string][]*stat.Stat)
+
+	if b.finalized != nil {
+		b.finalized(b.previousBucketMinTime)
+	}
 }
@@ -2,7 +2,9 @@ package bucketer
 
 import (
 	"fmt"
+	"github.com/CapillarySoftware/gostat/clock"
 	"github.com/CapillarySoftware/gostat/stat"
+	"github.com/CapillarySoftware/gostat/writemode"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"time"
@@ -414,4 +416,231 @@ var _ = Describe("Bucketer", func() {
 			x.pub()
 		})
 	})
+
+	Describe("Run", func() {
+		It("should drain the input and publish the final buckets when shut down", func(done Done) {
+			const STAT_NAME = "foo"
+			input := make(chan *stat.Stat, 2)
+			output := make(chan []*stat.Stat, 2)
+			shutdown := make(chan bool)
+			x := NewBucketerWithClock(input, output, shutdown, clock.NewFake(time.Date(2015, 1, 2, 3, 4, 30, 0, time.UTC)))
+
+			// stats still waiting on the input when the shutdown is signalled
+			s1 := stat.Stat{Name: STAT_NAME, Timestamp: x.currentBucketMinTime.Add(time.Duration(time.Second)), Value: 1}
+			s2 := stat.Stat{Name: STAT_NAME, Timestamp: x.currentBucketMinTime.Add(time.Duration(time.Second * 2)), Value: 2}
+			input <- &s1
+			input <- &s2
+			close(shutdown)
+
+			x.Run(time.Hour)
+
+			Expect(input).To(BeEmpty())
+			Expect(<-output).To(ConsistOf(&s1, &s2))
+			close(done)
+		})
+	})
+
+	Describe("OnFinalized", func() {
+		It("should report the time before which buckets are final when advancing", func() {
+			x := NewBucketer(stats, bucketedStats, shutdown)
+			var finalized time.Time
+			x.OnFinalized(func(before time.Time) { finalized = before })
+
+			current := x.currentBucketMinTime
+			x.next()
+			Expect(finalized).To(Equal(current))
+			Expect(finalized).To(Equal(x.previousBucketMinTime))
+		})
+	})
+	Describe("Status", func() {
+		It("should return an error if the Bucketer is not running", func() {
+			x := NewBucketer(stats, bucketedStats, shutdown)
+			_, err := x.Status(time.Millisecond)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Flush", func() {
+		It("should return an error if the Bucketer is not running", func() {
+			x := NewBucketer(stats, bucketedStats, shutdown)
+			Expect(x.Flush(time.Millisecond)).NotTo(Succeed())
+		})
+	})
+
+	Describe("with a fake clock", func() {
+		var (
+			fake   *clock.Fake
+			start  time.Time
+			input  chan *stat.Stat
+			output chan []*stat.Stat
+			stop   chan bool
+			ticks  chan tick
+			x      *Bucketer
+		)
+
+		BeforeEach(func() {
+			start = time.Date(2015, 1, 2, 3, 4, 30, 0, time.UTC)
+			fake = clock.NewFake(start)
+			input = make(chan *stat.Stat)
+			output = make(chan []*stat.Stat, 100)
+			stop = make(chan bool)
+			ticks = make(chan tick)
+			x = NewBucketerWithClock(input, output, stop, fake)
+		})
+
+		AfterEach(func() {
+			close(stop)
+		})
+
+		// advance advances the clock by d, and returns once the Bucketer has the
+		// tick. The ticks channel is unbuffered, so anything asked of the Bucketer
+		// afterwards is answered after the tick
+		advance := func(d time.Duration, publish bool) {
+			fake.Advance(d)
+			ticks <- tick{now: fake.Now(), publish: publish}
+		}
+
+		status := func() Status {
+			s, err := x.Status(time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			return s
+		}
+
+		It("should start its buckets at the clock's minute", func() {
+			Expect(x.currentBucketMinTime).To(Equal(start.Truncate(time.Minute)))
+		})
+
+		It("should describe its buckets", func(done Done) {
+			go x.run(ticks)
+			current := start.Truncate(time.Minute)
+
+			input <- &stat.Stat{Name: "foo", Timestamp: current.Add(time.Second), Value: 1}
+			input <- &stat.Stat{Name: "foo", Timestamp: current.Add(time.Second * 2), Value: 2}
+			input <- &stat.Stat{Name: "bar", Timestamp: current.Add(time.Second), Value: 3}
+
+			s := status()
+			Expect(s.Current).To(Equal(BucketStatus{Start: current, Series: 2, Stats: 3}))
+			Expect(s.Previous).To(Equal(BucketStatus{Start: current.Add(-time.Minute)}))
+			Expect(s.Future).To(Equal(BucketStatus{Start: current.Add(time.Minute)}))
+			close(done)
+		})
+
+		It("should publish its current buckets when flushed", func(done Done) {
+			go x.run(ticks)
+
+			s := stat.Stat{Name: "foo", Timestamp: start, Value: 1}
+			input <- &s
+			Expect(x.Flush(time.Second)).To(Succeed())
+			Expect(output).To(Receive(ConsistOf(&s)))
+			close(done)
+		})
+
+		It("should advance when the clock passes the future bucket", func(done Done) {
+			go x.run(ticks)
+			input <- &stat.Stat{Name: "foo", Timestamp: start, Value: 1}
+
+			advance(time.Second*20, false)
+			Expect(status().Current.Start).To(Equal(start.Truncate(time.Minute)))
+
+			advance(time.Second*11, false)
+			Expect(status().Current.Start).To(Equal(start.Truncate(time.Minute).Add(time.Minute)))
+			Expect(status().Previous).To(Equal(BucketStatus{Start: start.Truncate(time.Minute), Series: 1, Stats: 1}))
+			close(done)
+		})
+
+		It("should drop stats that arrive after their minute is finalized", func(done Done) {
+			go x.run(ticks)
+			advance(time.Minute*2, false)
+			Expect(status().Current.Start).To(Equal(start.Truncate(time.Minute).Add(time.Minute * 2)))
+
+			input <- &stat.Stat{Name: "foo", Timestamp: start, Value: 1}
+			Expect(status().Previous.Stats + status().Current.Stats + status().Future.Stats).To(Equal(0))
+			close(done)
+		})
+
+		It("should publish only on a publishing tick", func(done Done) {
+			go x.run(ticks)
+			input <- &stat.Stat{Name: "foo", Timestamp: start, Value: 1}
+
+			advance(time.Second, false)
+			status()
+			Expect(output).To(BeEmpty())
+
+			advance(time.Second*4, true)
+			status()
+			Expect(output).To(Receive(HaveLen(1)))
+			close(done)
+		})
+
+		It("should sum the stats of a summed series that share a timestamp, and aggregate every stat of the others", func(done Done) {
+			modes, err := writemode.ParseRules([]string{"sum.*=sum", "keep.*=keep"}, "last")
+			Expect(err).NotTo(HaveOccurred())
+			x.WriteModes(modes)
+			go x.run(ticks)
+
+			for _, name := range []string{"sum.foo", "keep.foo", "last.foo"} {
+				input <- &stat.Stat{Name: name, Timestamp: start, Value: 1}
+				input <- &stat.Stat{Name: name, Timestamp: start, Value: 2}
+				input <- &stat.Stat{Name: name, Timestamp: start.Add(time.Second), Value: 4}
+			}
+			advance(time.Second*5, true)
+			status()
+
+			published := make(map[string][]float64)
+			for i := 0; i < 3; i++ {
+				var bucket []*stat.Stat
+				Expect(output).To(Receive(&bucket))
+				for _, s := range bucket {
+					published[s.Name] = append(published[s.Name], s.Value)
+				}
+			}
+			Expect(published).To(Equal(map[string][]float64{
+				"sum.foo":  {3, 4},
+				"keep.foo": {1, 2, 4},
+				"last.foo": {1, 2, 4},
+			}))
+			close(done)
+		})
+
+		It("should tick at every advance interval, and publish at the publish interval", func(done Done) {
+			advanceTicker, publishTicker := newTickers(fake, time.Second*5)
+			go sendTicks(advanceTicker, publishTicker, stop, ticks)
+
+			fake.Advance(time.Second)
+			Expect(<-ticks).To(Equal(tick{now: start.Add(time.Second)}))
+
+			// both tickers are due, in either order
+			fake.Advance(time.Second * 4)
+			Expect([]tick{<-ticks, <-ticks}).To(ConsistOf(
+				tick{now: start.Add(time.Second * 5)},
+				tick{now: start.Add(time.Second * 5), publish: true}))
+			close(done)
+		})
+
+		It("should replay historical data at full speed", func(done Done) {
+			finalized := make(chan time.Time, 100)
+			x.OnFinalized(func(before time.Time) { finalized <- before })
+			go func() {
+				for range output {
+				}
+			}()
+			advanceTicker, publishTicker := newTickers(fake, time.Second*5)
+			go sendTicks(advanceTicker, publishTicker, stop, ticks)
+			go x.run(ticks)
+
+			// ten minutes of one stat a second, with the clock following the stats
+			for i := 0; i < 600; i++ {
+				t := start.Add(time.Second * time.Duration(i))
+				fake.Set(t)
+				input <- &stat.Stat{Name: "foo", Timestamp: t, Value: float64(i)}
+			}
+
+			// the last tick held is the last second replayed, so every minute but
+			// the last is finalized in turn
+			for i := 0; i < 10; i++ {
+				Expect(<-finalized).To(Equal(start.Truncate(time.Minute).Add(time.Minute * time.Duration(i))))
+			}
+			close(done)
+		}, 5)
+	})
 })
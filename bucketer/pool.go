@@ -0,0 +1,233 @@
+package bucketer
+
+import (
+	"fmt"
+	"github.com/CapillarySoftware/gostat/clock"
+	"github.com/CapillarySoftware/gostat/stat"
+	"github.com/CapillarySoftware/gostat/writemode"
+	log "github.com/cihub/seelog"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// batchSize is the most stats a Pool passes to a worker at once
+const batchSize = 256
+
+// Pool buckets stats with several Bucketers working in parallel. Each series is
+// bucketed by one worker, chosen by hashing its name, and every worker
+// publishes to the same output. The workers share one source of ticks, so they
+// advance from one minute to the next together
+type Pool struct {
+	workers  []*Bucketer
+	batches  []chan []*stat.Stat // stats are passed to each worker in batches
+	input    <-chan *stat.Stat   // Stats to be bucketed are read from this channel
+	shutdown <-chan bool         // signals a graceful shutdown
+
+	workerShutdown chan bool   // shuts the workers down once the input is drained
+	clock          clock.Clock // shared by the workers
+
+	mu        sync.Mutex
+	finalized []func(time.Time)
+	advanced  []time.Time // the last time each worker finalized
+	reported  time.Time   // the last time passed to finalized
+}
+
+// NewPool constructs a Pool of workers Bucketers running on the system clock
+func NewPool(workers int, stats <-chan *stat.Stat, bucketedStats chan<- []*stat.Stat, shutdown <-chan bool) *Pool {
+	return NewPoolWithClock(workers, stats, bucketedStats, shutdown, clock.Real)
+}
+
+// NewPoolWithClock constructs a Pool of workers Bucketers running on a clock
+func NewPoolWithClock(workers int, stats <-chan *stat.Stat, bucketedStats chan<- []*stat.Stat, shutdown <-chan bool, c clock.Clock) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &Pool{
+		input:          stats,
+		shutdown:       shutdown,
+		workerShutdown: make(chan bool),
+		clock:          c,
+		advanced:       make([]time.Time, workers),
+	}
+
+	for i := 0; i < workers; i++ {
+		batches := make(chan []*stat.Stat, 16)
+		b := NewBucketerWithClock(nil, bucketedStats, p.workerShutdown, c)
+		b.batches = batches
+
+		i := i
+		b.OnFinalized(func(before time.Time) { p.workerFinalized(i, before) })
+
+		p.workers = append(p.workers, b)
+		p.batches = append(p.batches, batches)
+	}
+	return p
+}
+
+// Workers returns the number of workers
+func (p *Pool) Workers() int {
+	return len(p.workers)
+}
+
+// OnFinalized registers a function called whenever every worker has advanced,
+// with the time before which none of them will publish or accept any stats.
+// Each function registered is called in turn
+func (p *Pool) OnFinalized(f func(before time.Time)) {
+	p.mu.Lock()
+	p.finalized = append(p.finalized, f)
+	p.mu.Unlock()
+}
+
+// workerFinalized records that worker i has advanced, and reports when the
+// slowest worker has
+func (p *Pool) workerFinalized(i int, before time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.advanced[i] = before
+	slowest := p.advanced[0]
+	for _, t := range p.advanced[1:] {
+		if t.Before(slowest) {
+			slowest = t
+		}
+	}
+
+	if slowest.After(p.reported) {
+		p.reported = slowest
+		for _, f := range p.finalized {
+			f(slowest)
+		}
+	}
+}
+
+// WriteModes sets the write modes of the series for every worker, as
+// Bucketer.WriteModes does. It must be called before Run
+func (p *Pool) WriteModes(r *writemode.Rules) {
+	for _, b := range p.workers {
+		b.WriteModes(r)
+	}
+}
+
+// Run is a goroutine that passes each stat read from the input channel to the
+// worker bucketing its series. Buckets are published at the specified interval.
+// When shut down it passes on the stats left on the input, then shuts the
+// workers down, waiting for them to publish their final buckets
+func (p *Pool) Run(publishInterval time.Duration) {
+	ticks := make([]chan<- tick, len(p.workers))
+	var running sync.WaitGroup
+	for i, b := range p.workers {
+		t := make(chan tick)
+		ticks[i] = t
+		running.Add(1)
+		go func(b *Bucketer) {
+			defer running.Done()
+			b.run(t)
+		}(b)
+	}
+
+	stop := make(chan bool)
+	advanceTicker, publishTicker := newTickers(p.clock, publishInterval)
+	go sendTicks(advanceTicker, publishTicker, stop, ticks...)
+
+	pending := make([][]*stat.Stat, len(p.workers))
+	done := false
+	for !done {
+		select {
+		case s := <-p.input:
+			p.dispatch(s, pending)
+			p.dispatchWaiting(pending)
+		case <-p.shutdown:
+			log.Debug("Bucketer Pool shutting down ", p.clock.Now())
+			done = true
+			p.dispatchWaiting(pending)
+		}
+	}
+
+	close(stop)
+	close(p.workerShutdown)
+	running.Wait()
+	log.Info("Bucketer Pool Run() exiting ", p.clock.Now())
+}
+
+// dispatchWaiting dispatches the stats already waiting on the input, then
+// passes every pending batch on
+func (p *Pool) dispatchWaiting(pending [][]*stat.Stat) {
+	for {
+		select {
+		case s := <-p.input:
+			p.dispatch(s, pending)
+		default:
+			for i := range pending {
+				p.send(i, pending)
+			}
+			return
+		}
+	}
+}
+
+// dispatch adds a stat to the pending batch of the worker bucketing its series,
+// passing the batch on when it is full
+func (p *Pool) dispatch(s *stat.Stat, pending [][]*stat.Stat) {
+	if s == nil {
+		return
+	}
+	i := p.worker(s.Name)
+	pending[i] = append(pending[i], s)
+	if len(pending[i]) >= batchSize {
+		p.send(i, pending)
+	}
+}
+
+func (p *Pool) send(i int, pending [][]*stat.Stat) {
+	if len(pending[i]) > 0 {
+		p.batches[i] <- pending[i]
+		pending[i] = make([]*stat.Stat, 0, batchSize)
+	}
+}
+
+// worker returns the index of the worker bucketing the series name
+func (p *Pool) worker(name string) int {
+	if len(p.workers) == 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % uint32(len(p.workers)))
+}
+
+// Status returns the combined state of the workers' buckets, or an error if
+// one does not respond within timeout
+func (p *Pool) Status(timeout time.Duration) (Status, error) {
+	var total Status
+	for i, b := range p.workers {
+		s, err := b.Status(timeout)
+		if err != nil {
+			return Status{}, fmt.Errorf("worker %d: %v", i, err)
+		}
+		total.Previous = addBucketStatus(total.Previous, s.Previous)
+		total.Current = addBucketStatus(total.Current, s.Current)
+		total.Future = addBucketStatus(total.Future, s.Future)
+	}
+	return total, nil
+}
+
+func addBucketStatus(a, b BucketStatus) BucketStatus {
+	if a.Start.IsZero() || b.Start.Before(a.Start) {
+		a.Start = b.Start
+	}
+	a.Series += b.Series
+	a.Stats += b.Stats
+	return a
+}
+
+// Flush asks every worker to publish its current and previous buckets now
+func (p *Pool) Flush(timeout time.Duration) error {
+	for i, b := range p.workers {
+		if err := b.Flush(timeout); err != nil {
+			return fmt.Errorf("worker %d: %v", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package bucketer
+
+import (
+	"fmt"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"runtime"
+	"testing"
+	"time"
+)
+
+var _ = Describe("Pool", func() {
+	var (
+		input    chan *stat.Stat
+		output   chan []*stat.Stat
+		shutdown chan bool
+		p        *Pool
+	)
+
+	BeforeEach(func() {
+		input = make(chan *stat.Stat, 100)
+		output = make(chan []*stat.Stat, 100)
+		shutdown = make(chan bool)
+		p = NewPool(4, input, output, shutdown)
+	})
+
+	// send sends stats of n series, m per series, within the current minute
+	send := func(n, m int) {
+		start := time.Now().UTC().Truncate(time.Minute)
+		for i := 0; i < m; i++ {
+			for j := 0; j < n; j++ {
+				input <- &stat.Stat{Name: fmt.Sprintf("series%d", j), Timestamp: start.Add(time.Millisecond * time.Duration(i)), Value: float64(i)}
+			}
+		}
+	}
+
+	It("should bucket each series with one worker, publishing every bucket to the output", func(done Done) {
+		go p.Run(time.Hour)
+		send(20, 3)
+		close(shutdown)
+
+		buckets := make(map[string]int)
+		for len(buckets) < 20 {
+			bucket := <-output
+			Expect(bucket).To(HaveLen(3))
+			buckets[bucket[0].Name]++
+		}
+		for _, n := range buckets {
+			Expect(n).To(Equal(1))
+		}
+		close(done)
+	})
+
+	It("should spread the series across the workers", func() {
+		used := make(map[int]bool)
+		for i := 0; i < 100; i++ {
+			used[p.worker(fmt.Sprintf("series%d", i))] = true
+		}
+		Expect(used).To(HaveLen(4))
+	})
+
+	It("should combine the status of the workers", func(done Done) {
+		go p.Run(time.Hour)
+		send(20, 3)
+
+		Eventually(func() int {
+			s, err := p.Status(time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			return s.Current.Stats
+		}).Should(Equal(60))
+
+		s, _ := p.Status(time.Second)
+		Expect(s.Current.Series).To(Equal(20))
+		Expect(s.Current.Start).To(Equal(p.workers[0].currentBucketMinTime))
+
+		close(shutdown)
+		close(done)
+	})
+
+	It("should flush every worker", func(done Done) {
+		go p.Run(time.Hour)
+		send(20, 1)
+		Eventually(func() int { s, _ := p.Status(time.Second); return s.Current.Stats }).Should(Equal(20))
+
+		Expect(p.Flush(time.Second)).To(Succeed())
+		Expect(output).To(HaveLen(20))
+
+		close(shutdown)
+		close(done)
+	})
+
+	It("should report the time finalized by the slowest worker", func() {
+		var finalized []time.Time
+		p.OnFinalized(func(before time.Time) { finalized = append(finalized, before) })
+
+		t := time.Now().UTC().Truncate(time.Minute)
+		p.workerFinalized(0, t)
+		p.workerFinalized(1, t)
+		p.workerFinalized(2, t)
+		Expect(finalized).To(BeEmpty())
+
+		p.workerFinalized(3, t)
+		Expect(finalized).To(Equal([]time.Time{t}))
+
+		p.workerFinalized(0, t.Add(time.Minute))
+		Expect(finalized).To(HaveLen(1))
+	})
+
+	It("should report the time finalized to every function registered", func() {
+		var first, second time.Time
+		p.OnFinalized(func(before time.Time) { first = before })
+		p.OnFinalized(func(before time.Time) { second = before })
+
+		t := time.Now().UTC().Truncate(time.Minute)
+		for i := 0; i < p.Workers(); i++ {
+			p.workerFinalized(i, t)
+		}
+		Expect(first).To(Equal(t))
+		Expect(second).To(Equal(t))
+	})
+})
+
+// benchmarkBucketing measures how many stats per second run buckets, with
+// producers sending stats of 10000 series concurrently
+func benchmarkBucketing(b *testing.B, run func(input <-chan *stat.Stat, output chan<- []*stat.Stat, shutdown <-chan bool)) {
+	const series = 10000
+	log.ReplaceLogger(log.Disabled)
+	defer log.ReplaceLogger(log.Default)
+
+	names := make([]string, series)
+	for i := range names {
+		names[i] = fmt.Sprintf("web.requests;host=web%d", i)
+	}
+
+	input := make(chan *stat.Stat, 10000)
+	output := make(chan []*stat.Stat, 1000)
+	shutdown := make(chan bool)
+	finished := make(chan bool)
+
+	go func() {
+		for range output {
+		}
+	}()
+	go func() {
+		run(input, output, shutdown)
+		close(finished)
+	}()
+
+	now := time.Now().UTC()
+	producers := runtime.GOMAXPROCS(0)
+	b.ResetTimer()
+	start := time.Now()
+
+	sent := make(chan bool)
+	for p := 0; p < producers; p++ {
+		go func(p int) {
+			for i := p; i < b.N; i += producers {
+				input <- &stat.Stat{Name: names[i%series], Timestamp: now, Value: float64(i)}
+			}
+			sent <- true
+		}(p)
+	}
+	for p := 0; p < producers; p++ {
+		<-sent
+	}
+	close(shutdown)
+	<-finished
+
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "stats/s")
+	close(output)
+}
+
+func BenchmarkBucketer(b *testing.B) {
+	benchmarkBucketing(b, func(input <-chan *stat.Stat, output chan<- []*stat.Stat, shutdown <-chan bool) {
+		NewBucketer(input, output, shutdown).Run(time.Hour)
+	})
+}
+
+// BenchmarkPool benchmarks Pools of 1 to GOMAXPROCS workers, e.g.
+//
+//	go test -run NONE -bench Pool -cpu 1,2,4,8 ./bucketer
+func BenchmarkPool(b *testing.B) {
+	for workers := 1; workers <= runtime.GOMAXPROCS(0); workers *= 2 {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			benchmarkBucketing(b, func(input <-chan *stat.Stat, output chan<- []*stat.Stat, shutdown <-chan bool) {
+				NewPool(workers, input, output, shutdown).Run(time.Hour)
+			})
+		})
+	}
+}
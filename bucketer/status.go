@@ -0,0 +1,78 @@
+package bucketer
+
+import (
+	"fmt"
+	"time"
+)
+
+// BucketStatus describes one of the Bucketer's buckets
+type BucketStatus struct {
+	Start  time.Time `json:"start"`
+	Series int       `json:"series"` // the number of distinct stat names in the bucket
+	Stats  int       `json:"stats"`
+}
+
+// Status describes the Bucketer's buckets
+type Status struct {
+	Previous BucketStatus `json:"previous"`
+	Current  BucketStatus `json:"current"`
+	Future   BucketStatus `json:"future"`
+}
+
+// Status returns the state of a running Bucketer, or an error if it does not
+// respond within timeout
+func (b *Bucketer) Status(timeout time.Duration) (Status, error) {
+	reply := make(chan Status, 1)
+	deadline := time.After(timeout)
+
+	select {
+	case b.statusRequests <- reply:
+	case <-deadline:
+		return Status{}, fmt.Errorf("bucketer: no response to a status request within %v", timeout)
+	}
+
+	select {
+	case s := <-reply:
+		return s, nil
+	case <-deadline:
+		return Status{}, fmt.Errorf("bucketer: no response to a status request within %v", timeout)
+	}
+}
+
+// Flush asks a running Bucketer to publish its current and previous buckets
+// now, and waits for it to finish, or returns an error if it does not finish
+// within timeout
+func (b *Bucketer) Flush(timeout time.Duration) error {
+	flushed := make(chan bool)
+	deadline := time.After(timeout)
+
+	select {
+	case b.flushRequests <- flushed:
+	case <-deadline:
+		return fmt.Errorf("bucketer: no response to a flush request within %v", timeout)
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-deadline:
+		return fmt.Errorf("bucketer: flush did not finish within %v", timeout)
+	}
+}
+
+// status describes the buckets
+func (b *Bucketer) status() Status {
+	return Status{
+		Previous: bucketStatus(b.previousBucketMinTime, b.previousBuckets),
+		Current:  bucketStatus(b.currentBucketMinTime, b.currentBuckets),
+		Future:   bucketStatus(b.futureBucketMinTime, b.futureBuckets),
+	}
+}
+
+func bucketStatus(start time.Time, buckets bucketMap) BucketStatus {
+	s := BucketStatus{Start: start, Series: len(buckets)}
+	for _, bucket := range buckets {
+		s.Stats += len(bucket)
+	}
+	return s
+}
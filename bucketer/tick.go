@@ -0,0 +1,51 @@
+package bucketer
+
+import (
+	"github.com/CapillarySoftware/gostat/clock"
+	"time"
+)
+
+// AdvanceInterval is how often a Bucketer checks whether to advance to the next
+// minute
+const AdvanceInterval = time.Second
+
+// tick tells a Bucketer the time, and whether to publish its buckets
+type tick struct {
+	now     time.Time
+	publish bool
+}
+
+// newTickers starts the tickers for sendTicks on the clock. They are started
+// before sendTicks runs, so that no time the clock passes goes unnoticed
+func newTickers(c clock.Clock, publishInterval time.Duration) (advanceTicker, publishTicker clock.Ticker) {
+	return c.NewTicker(AdvanceInterval), c.NewTicker(publishInterval)
+}
+
+// sendTicks sends a tick for each tick of the advance ticker, and a publishing
+// tick for each tick of the publish ticker, to every channel until stopped,
+// then stops the tickers. Bucketers sharing the ticks advance from one minute to
+// the next at the same time
+func sendTicks(advanceTicker, publishTicker clock.Ticker, stop <-chan bool, ticks ...chan<- tick) {
+	defer advanceTicker.Stop()
+	defer publishTicker.Stop()
+
+	for {
+		var t tick
+		select {
+		case now := <-advanceTicker.C():
+			t = tick{now: now.UTC()}
+		case now := <-publishTicker.C():
+			t = tick{now: now.UTC(), publish: true}
+		case <-stop:
+			return
+		}
+
+		for _, c := range ticks {
+			select {
+			case c <- t:
+			case <-stop:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock that only moves when told to. Timers and tickers fire as the
+// Fake is advanced past them. Like a time.Ticker, a ticker holds one tick, but
+// where a time.Ticker drops newer ticks while its reader falls behind, a Fake's
+// ticker replaces the tick it holds, so its reader always sees the latest
+// period passed, even when the Fake is advanced by several periods at once
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+// waiter is a pending timer, or a ticker if period is not zero
+type waiter struct {
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+// NewFake constructs a Fake set to now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the Fake's time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After returns a channel receiving the time once the Fake has advanced by d
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &waiter{at: f.now.Add(d), c: make(chan time.Time, 1)}
+	f.waiters = append(f.waiters, w)
+	return w.c
+}
+
+// NewTicker returns a Ticker ticking every time the Fake advances by d
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &waiter{at: f.now.Add(d), period: d, c: make(chan time.Time, 1)}
+	f.waiters = append(f.waiters, w)
+	return &fakeTicker{f, w}
+}
+
+// Waiters returns the number of pending timers and tickers, so a test can wait
+// for a component to start waiting on the Fake before advancing it
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+// Advance moves the Fake forward by d, firing the timers and tickers due
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the Fake forward to t, firing the timers and tickers due. The Fake
+// never moves backwards
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if t.Before(f.now) {
+		return
+	}
+	f.now = t
+
+	// fire in the order the waiters are due
+	sort.SliceStable(f.waiters, func(i, j int) bool { return f.waiters[i].at.Before(f.waiters[j].at) })
+
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(t) {
+			pending = append(pending, w)
+			continue
+		}
+
+		if w.period == 0 {
+			send(w.c, w.at)
+			continue
+		}
+
+		// a ticker delivers the latest period passed, and carries on from there
+		periods := t.Sub(w.at) / w.period
+		tick := w.at.Add(periods * w.period)
+		select {
+		case <-w.c:
+		default:
+		}
+		send(w.c, tick)
+		w.at = tick.Add(w.period)
+		pending = append(pending, w)
+	}
+	f.waiters = pending
+}
+
+// send sends t unless the channel already holds a time, like a time.Ticker
+func send(c chan time.Time, t time.Time) {
+	select {
+	case c <- t:
+	default:
+	}
+}
+
+func (f *Fake) stop(w *waiter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTicker struct {
+	f *Fake
+	w *waiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.c }
+func (t *fakeTicker) Stop()               { t.f.stop(t.w) }
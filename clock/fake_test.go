@@ -0,0 +1,71 @@
+package clock
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("Fake", func() {
+	var (
+		start time.Time
+		f     *Fake
+	)
+
+	BeforeEach(func() {
+		start = time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+		f = NewFake(start)
+	})
+
+	It("should only move when advanced", func() {
+		Expect(f.Now()).To(Equal(start))
+		f.Advance(time.Minute)
+		Expect(f.Now()).To(Equal(start.Add(time.Minute)))
+	})
+
+	It("should never move backwards", func() {
+		f.Set(start.Add(-time.Minute))
+		Expect(f.Now()).To(Equal(start))
+	})
+
+	It("should fire a timer once it is due", func() {
+		c := f.After(time.Second * 10)
+		Expect(f.Waiters()).To(Equal(1))
+
+		f.Advance(time.Second * 9)
+		Expect(c).NotTo(Receive())
+
+		f.Advance(time.Second)
+		Expect(c).To(Receive(Equal(start.Add(time.Second * 10))))
+		Expect(f.Waiters()).To(Equal(0))
+	})
+
+	It("should tick every period", func() {
+		t := f.NewTicker(time.Second)
+		f.Advance(time.Second)
+		Expect(t.C()).To(Receive(Equal(start.Add(time.Second))))
+		f.Advance(time.Second)
+		Expect(t.C()).To(Receive(Equal(start.Add(time.Second * 2))))
+	})
+
+	It("should deliver a single tick of the latest period passed", func() {
+		t := f.NewTicker(time.Second)
+		f.Advance(time.Millisecond * 1500)
+		f.Advance(time.Minute)
+
+		Expect(t.C()).To(Receive(Equal(start.Add(time.Second * 61))))
+		Expect(t.C()).NotTo(Receive())
+
+		f.Advance(time.Millisecond * 500)
+		Expect(t.C()).To(Receive(Equal(start.Add(time.Second * 62))))
+	})
+
+	It("should not tick once stopped", func() {
+		t := f.NewTicker(time.Second)
+		t.Stop()
+		Expect(f.Waiters()).To(Equal(0))
+
+		f.Advance(time.Second)
+		Expect(t.C()).NotTo(Receive())
+	})
+})
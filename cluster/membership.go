@@ -0,0 +1,139 @@
+package cluster
+
+import (
+	"github.com/CapillarySoftware/gostat/metrics"
+	log "github.com/cihub/seelog"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var liveNodes = metrics.NewGauge("gostat.cluster.nodes", "Nodes in the cluster that are up, including this one")
+
+// Membership tracks which of a static list of peers are up, and maintains the
+// Ring of this node and the peers that are. Peers are probed every interval, so
+// shards move to a peer when it joins and away from it when it leaves
+type Membership struct {
+	self     string   // this node's address
+	peers    []string // the addresses of every node, which may include self
+	replicas int      // the number of points of each node on the Ring
+	shutdown <-chan bool
+
+	probe   func(address string) bool // reports whether a peer is up
+	changed func(*Ring)               // called with the new Ring when nodes join or leave, if not nil
+
+	mu   sync.RWMutex
+	ring *Ring
+}
+
+// NewMembership constructs a Membership of self and peers. Until the peers are
+// first probed they are assumed to be up
+func NewMembership(self string, peers []string, replicas int, shutdown <-chan bool) *Membership {
+	m := &Membership{
+		self:     self,
+		peers:    peers,
+		replicas: replicas,
+		shutdown: shutdown,
+		probe:    probeTCP,
+	}
+	m.setRing(NewRing(replicas, append([]string{self}, peers...)...))
+	return m
+}
+
+// OnChange registers f to be called with the new Ring whenever nodes join or
+// leave
+func (m *Membership) OnChange(f func(*Ring)) {
+	m.changed = f
+}
+
+// Ring returns the Ring of the nodes that are up
+func (m *Membership) Ring() *Ring {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.ring
+}
+
+func (m *Membership) setRing(r *Ring) {
+	m.mu.Lock()
+	m.ring = r
+	m.mu.Unlock()
+	liveNodes.Set(float64(len(r.Nodes())))
+}
+
+// Run probes the peers now and every interval until shut down
+func (m *Membership) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	m.update()
+	for {
+		select {
+		case <-ticker.C:
+			m.update()
+		case <-m.shutdown:
+			return
+		}
+	}
+}
+
+// update probes the peers, replacing the Ring if any have joined or left
+func (m *Membership) update() {
+	up := []string{m.self}
+	for _, peer := range m.peers {
+		if peer != m.self && m.probe(peer) {
+			up = append(up, peer)
+		}
+	}
+
+	current := m.Ring()
+	r := NewRing(m.replicas, up...)
+	if sameNodes(current.Nodes(), r.Nodes()) {
+		return
+	}
+
+	for _, node := range r.Nodes() {
+		if !current.Has(node) {
+			log.Info("cluster: node joined: ", node)
+		}
+	}
+	for _, node := range current.Nodes() {
+		if !r.Has(node) {
+			log.Warn("cluster: node left: ", node)
+		}
+	}
+
+	m.setRing(r)
+	if m.changed != nil {
+		m.changed(r)
+	}
+}
+
+func sameNodes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// probeTCP reports whether a TCP connection can be made to a tcp:// nanomsg
+// address. Peers on other transports are assumed to be up
+func probeTCP(address string) bool {
+	u, err := url.Parse(address)
+	if err != nil || u.Scheme != "tcp" {
+		return true
+	}
+
+	conn, err := net.DialTimeout("tcp", u.Host, time.Second)
+	if err != nil {
+		log.Debug("cluster: probing ", address, ": ", err)
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,50 @@
+package cluster
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Membership", func() {
+	var (
+		m       *Membership
+		up      map[string]bool
+		changes []*Ring
+	)
+
+	BeforeEach(func() {
+		up = map[string]bool{"b": true, "c": true}
+		changes = nil
+		m = NewMembership("a", []string{"a", "b", "c"}, DefaultReplicas, make(chan bool))
+		m.probe = func(address string) bool { return up[address] }
+		m.OnChange(func(r *Ring) { changes = append(changes, r) })
+	})
+
+	It("should assume every peer is up until they are probed", func() {
+		Expect(m.Ring().Nodes()).To(Equal([]string{"a", "b", "c"}))
+	})
+
+	It("should remove peers that leave and add them back when they rejoin", func() {
+		up["b"] = false
+		m.update()
+		Expect(m.Ring().Nodes()).To(Equal([]string{"a", "c"}))
+
+		up["b"] = true
+		m.update()
+		Expect(m.Ring().Nodes()).To(Equal([]string{"a", "b", "c"}))
+		Expect(changes).To(HaveLen(2))
+	})
+
+	It("should not replace the Ring if no peers join or leave", func() {
+		r := m.Ring()
+		m.update()
+		Expect(m.Ring()).To(BeIdenticalTo(r))
+		Expect(changes).To(BeEmpty())
+	})
+
+	It("should always include itself", func() {
+		up = map[string]bool{}
+		m.update()
+		Expect(m.Ring().Nodes()).To(Equal([]string{"a"}))
+	})
+})
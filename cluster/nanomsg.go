@@ -0,0 +1,69 @@
+package cluster
+
+import (
+	"github.com/CapillarySoftware/gostat/protoStat"
+	"github.com/CapillarySoftware/gostat/push"
+	"github.com/CapillarySoftware/gostat/stat"
+	"time"
+)
+
+// SendTimeout bounds how long sending to a peer may block, e.g. while it is
+// unreachable
+const SendTimeout = time.Second
+
+// nanomsgSender pushes stats to a peer's nanomsg listener, in the format
+// producers use
+type nanomsgSender struct {
+	*push.Socket
+}
+
+// DialNanomsg returns a Sender pushing stats to the nanomsg address of a peer
+func DialNanomsg(address string) (Sender, error) {
+	socket, err := push.Dial(address, SendTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &nanomsgSender{socket}, nil
+}
+
+func (n *nanomsgSender) Send(stats []*stat.Stat) error {
+	msgs, err := Encode(stats)
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		if err := n.Socket.Send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode encodes stats as protoStat messages, one for each run of stats with
+// the same timestamp, since a message has a single timestamp
+func Encode(stats []*stat.Stat) ([][]byte, error) {
+	var msgs [][]byte
+
+	for start := 0; start < len(stats); {
+		t := stats[start].Timestamp
+		m := protoStat.ProtoStats{TimeNano: int64Ptr(t.UnixNano())}
+
+		end := start
+		for ; end < len(stats) && stats[end].Timestamp.Equal(t); end++ {
+			key, value := stats[end].Name, stats[end].Value
+			m.Stats = append(m.Stats, &protoStat.ProtoStat{Key: &key, Value: &value})
+		}
+
+		msg, err := m.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+		start = end
+	}
+	return msgs, nil
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
@@ -0,0 +1,88 @@
+// Package cluster shards stats across gostat nodes by consistent hashing, so
+// that each series is bucketed by exactly one node
+package cluster
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"github.com/CapillarySoftware/gostat/stat"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is the number of points each node has on a Ring
+const DefaultReplicas = 128
+
+// Ring maps stat names to the nodes owning them. Each node is placed at many
+// points on a ring of hashes, and a name is owned by the node at the first point
+// after the name's hash, so adding or removing a node only moves the names
+// between it and its neighbours. A Ring is immutable
+type Ring struct {
+	nodes  []string
+	points []uint64          // sorted
+	owners map[uint64]string // the node at each point
+}
+
+// NewRing constructs a Ring of nodes, each placed at replicas points
+func NewRing(replicas int, nodes ...string) *Ring {
+	r := &Ring{owners: make(map[uint64]string)}
+
+	for _, node := range nodes {
+		if r.Has(node) {
+			continue
+		}
+		r.nodes = append(r.nodes, node)
+		for i := 0; i < replicas; i++ {
+			p := hash(node + "#" + strconv.Itoa(i))
+			if _, taken := r.owners[p]; !taken {
+				r.owners[p] = node
+				r.points = append(r.points, p)
+			}
+		}
+	}
+
+	sort.Strings(r.nodes)
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Nodes returns the nodes on the ring, sorted
+func (r *Ring) Nodes() []string {
+	return r.nodes
+}
+
+// Has reports whether node is on the ring
+func (r *Ring) Has(node string) bool {
+	for _, n := range r.nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// Owner returns the node owning the stat name, or the empty string if the ring
+// is empty. Names differing only in the order of their tags have the same owner
+func (r *Ring) Owner(name string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	h := hash(Key(name))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// Key returns the name stats are sharded by: the metric name followed by its
+// tags in order
+func Key(name string) string {
+	return stat.FormatName(stat.ParseName(name))
+}
+
+func hash(s string) uint64 {
+	sum := sha1.Sum([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Ring", func() {
+	names := func(n int) []string {
+		var names []string
+		for i := 0; i < n; i++ {
+			names = append(names, fmt.Sprintf("web.requests;host=web%d", i))
+		}
+		return names
+	}
+
+	It("should have no owner when it has no nodes", func() {
+		Expect(NewRing(DefaultReplicas).Owner("foo")).To(Equal(""))
+	})
+
+	It("should list its nodes once each, sorted", func() {
+		r := NewRing(DefaultReplicas, "tcp://b:2026", "tcp://a:2026", "tcp://b:2026")
+		Expect(r.Nodes()).To(Equal([]string{"tcp://a:2026", "tcp://b:2026"}))
+		Expect(r.Has("tcp://a:2026")).To(BeTrue())
+		Expect(r.Has("tcp://c:2026")).To(BeFalse())
+	})
+
+	It("should give names the same owner on every node", func() {
+		a := NewRing(DefaultReplicas, "a", "b", "c")
+		b := NewRing(DefaultReplicas, "c", "a", "b")
+		for _, name := range names(100) {
+			Expect(a.Owner(name)).To(Equal(b.Owner(name)))
+		}
+	})
+
+	It("should give names differing only in the order of their tags the same owner", func() {
+		r := NewRing(DefaultReplicas, "a", "b", "c")
+		for i := 0; i < 100; i++ {
+			Expect(r.Owner(fmt.Sprintf("cpu;dc=east;host=h%d", i))).To(Equal(r.Owner(fmt.Sprintf("cpu;host=h%d;dc=east", i))))
+		}
+	})
+
+	It("should spread names across the nodes", func() {
+		r := NewRing(DefaultReplicas, "a", "b", "c")
+		owned := make(map[string]int)
+		for _, name := range names(3000) {
+			owned[r.Owner(name)]++
+		}
+		for _, node := range r.Nodes() {
+			Expect(owned[node]).To(BeNumerically("~", 1000, 250))
+		}
+	})
+
+	It("should only move names to a node that joins", func() {
+		before := NewRing(DefaultReplicas, "a", "b", "c")
+		after := NewRing(DefaultReplicas, "a", "b", "c", "d")
+
+		moved := 0
+		for _, name := range names(3000) {
+			if owner := after.Owner(name); owner != before.Owner(name) {
+				Expect(owner).To(Equal("d"))
+				moved++
+			}
+		}
+		Expect(moved).To(BeNumerically("~", 750, 250))
+	})
+})
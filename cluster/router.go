@@ -0,0 +1,210 @@
+package cluster
+
+import (
+	"github.com/CapillarySoftware/gostat/metrics"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"time"
+)
+
+const (
+	// maxBatch is the most stats routed, and forwarded to a peer, at once
+	maxBatch = 500
+
+	// maxHeld is the most stats held to be forwarded again
+	maxHeld = 100000
+)
+
+// RetryFor bounds how long stats that could not be forwarded are retried, while
+// the Bucketer of the node owning them still accepts them, before they are kept
+const RetryFor = time.Second * 30
+
+var (
+	routedLocal    = metrics.NewCounter("gostat.cluster.local", "Stats owned by this node")
+	forwarded      = metrics.NewCounter("gostat.cluster.forwarded", "Stats forwarded to the node owning them")
+	forwardErrors  = metrics.NewCounter("gostat.cluster.forward.errors", "Stats that could not be forwarded, and were kept by this node")
+	forwardRetries = metrics.NewCounter("gostat.cluster.forward.retries", "Stats held to be forwarded again after a forward failed")
+)
+
+// Sender sends stats to a peer
+type Sender interface {
+	Send(stats []*stat.Stat) error
+	Close() error
+}
+
+// Dialer returns a Sender to the peer at address
+type Dialer func(address string) (Sender, error)
+
+// Router passes the stats this node owns to its pipeline, and forwards the rest
+// to the nodes owning them. Stats that cannot be forwarded are held and routed
+// again every second for up to RetryFor, so that they reach their owner once it
+// is reachable again, or move with their series if it leaves the cluster.
+//
+// Stats still not forwarded after RetryFor, or when too many are held, or when
+// shutting down, are kept by this node so that they are archived rather than
+// lost. The aggregates of their series then diverge for that minute: the owner
+// and this node each aggregate part of its stats
+type Router struct {
+	self     string
+	members  *Membership
+	input    <-chan *stat.Stat // stats received by this node
+	local    chan<- *stat.Stat // stats owned by this node are output here
+	shutdown <-chan bool       // signals a graceful shutdown
+
+	dial    Dialer
+	senders map[string]Sender // by peer address
+	held    []held            // stats to be forwarded again, oldest first
+	nHeld   int               // the number of stats held
+	now     func() time.Time
+}
+
+// held are stats that could not be forwarded, retried until the time given
+type held struct {
+	stats []*stat.Stat
+	until time.Time
+}
+
+// NewRouter constructs a Router for the node at address self, forwarding over
+// nanomsg
+func NewRouter(self string, members *Membership, input <-chan *stat.Stat, local chan<- *stat.Stat, shutdown <-chan bool) *Router {
+	return &Router{
+		self:     self,
+		members:  members,
+		input:    input,
+		local:    local,
+		shutdown: shutdown,
+		dial:     DialNanomsg,
+		senders:  make(map[string]Sender),
+		now:      time.Now,
+	}
+}
+
+// Run routes stats until shut down, then routes those left on the input and
+// those held, keeping any that still cannot be forwarded
+func (r *Router) Run() {
+	defer r.closeSenders()
+	retryTicker := time.NewTicker(time.Second)
+	defer retryTicker.Stop()
+
+	done := false
+	for !done {
+		select {
+		case s := <-r.input:
+			r.route(r.batch(s), r.now().Add(RetryFor))
+		case <-retryTicker.C:
+			r.retry(r.now())
+		case <-r.shutdown:
+			done = true
+		}
+	}
+	r.drain()
+	r.retry(time.Time{})
+}
+
+// drain routes the stats left on the input without blocking for more, keeping
+// any that cannot be forwarded
+func (r *Router) drain() {
+	for {
+		select {
+		case s := <-r.input:
+			r.route(r.batch(s), time.Time{})
+		default:
+			return
+		}
+	}
+}
+
+// retry routes the held stats again, keeping those that still cannot be
+// forwarded once now is after the time they are retried until
+func (r *Router) retry(now time.Time) {
+	retried := r.held
+	r.held, r.nHeld = nil, 0
+	for _, h := range retried {
+		until := h.until
+		if now.IsZero() {
+			until = time.Time{}
+		}
+		r.route(h.stats, until)
+	}
+}
+
+// batch returns s and any stats already waiting on the input, up to maxBatch
+func (r *Router) batch(s *stat.Stat) []*stat.Stat {
+	stats := []*stat.Stat{s}
+	for len(stats) < maxBatch {
+		select {
+		case s := <-r.input:
+			stats = append(stats, s)
+		default:
+			return stats
+		}
+	}
+	return stats
+}
+
+// route outputs the stats owned by this node and forwards the rest. Stats that
+// cannot be forwarded are held until the time given, or kept if it has passed
+func (r *Router) route(stats []*stat.Stat, until time.Time) {
+	ring := r.members.Ring()
+	remote := make(map[string][]*stat.Stat)
+
+	for _, s := range stats {
+		if owner := ring.Owner(s.Name); owner != r.self && owner != "" {
+			remote[owner] = append(remote[owner], s)
+			continue
+		}
+		routedLocal.Inc()
+		r.local <- s
+	}
+
+	for owner, stats := range remote {
+		err := r.forward(owner, stats)
+		if err == nil {
+			forwarded.Add(uint64(len(stats)))
+			continue
+		}
+
+		if r.now().Before(until) && r.nHeld+len(stats) <= maxHeld {
+			log.Debugf("cluster: holding %d stats that could not be forwarded to %s: %v", len(stats), owner, err)
+			forwardRetries.Add(uint64(len(stats)))
+			r.held = append(r.held, held{stats: stats, until: until})
+			r.nHeld += len(stats)
+			continue
+		}
+
+		log.Warnf("cluster: keeping %d stats that could not be forwarded to %s: %v", len(stats), owner, err)
+		forwardErrors.Add(uint64(len(stats)))
+		for _, s := range stats {
+			r.local <- s
+		}
+	}
+}
+
+// forward sends stats to the peer at address, dialing it if need be. A Sender
+// that fails is closed and dialed again next time
+func (r *Router) forward(address string, stats []*stat.Stat) error {
+	sender, ok := r.senders[address]
+	if !ok {
+		var err error
+		if sender, err = r.dial(address); err != nil {
+			return err
+		}
+		r.senders[address] = sender
+	}
+
+	if err := sender.Send(stats); err != nil {
+		sender.Close()
+		delete(r.senders, address)
+		return err
+	}
+	return nil
+}
+
+func (r *Router) closeSenders() {
+	for address, sender := range r.senders {
+		if err := sender.Close(); err != nil {
+			log.Warn("cluster: error closing the connection to ", address, ": ", err)
+		}
+	}
+	r.senders = make(map[string]Sender)
+}
@@ -0,0 +1,189 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/protoStat"
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+// chanSender forwards stats to the input of a Router in the same process
+type chanSender struct {
+	input chan<- *stat.Stat
+	err   error
+}
+
+func (c *chanSender) Send(stats []*stat.Stat) error {
+	if c.err != nil {
+		return c.err
+	}
+	for _, s := range stats {
+		c.input <- s
+	}
+	return nil
+}
+
+func (c *chanSender) Close() error { return nil }
+
+// node is a gostat node's Router, with the stats it accepted
+type node struct {
+	router   *Router
+	input    chan *stat.Stat // stats received from producers
+	accepted chan *stat.Stat // stats owned by the node
+	sender   *chanSender     // how other nodes forward stats to it
+}
+
+var _ = Describe("Router", func() {
+	var (
+		addresses []string
+		nodes     map[string]*node
+		shutdown  chan bool
+	)
+
+	BeforeEach(func() {
+		addresses = []string{"tcp://127.0.0.1:2026", "tcp://127.0.0.1:2027", "tcp://127.0.0.1:2028"}
+		nodes = make(map[string]*node)
+		shutdown = make(chan bool)
+
+		for _, address := range addresses {
+			n := &node{input: make(chan *stat.Stat, 1000), accepted: make(chan *stat.Stat, 1000)}
+			n.sender = &chanSender{input: n.accepted}
+			members := NewMembership(address, addresses, DefaultReplicas, shutdown)
+			n.router = NewRouter(address, members, n.input, n.accepted, shutdown)
+			n.router.dial = func(address string) (Sender, error) { return nodes[address].sender, nil }
+			nodes[address] = n
+		}
+	})
+
+	AfterEach(func() {
+		close(shutdown)
+	})
+
+	// send sends stats to a node, and returns the stats each node accepted
+	send := func(to string, stats []*stat.Stat) map[string][]*stat.Stat {
+		for _, s := range stats {
+			nodes[to].input <- s
+		}
+		close(shutdown)
+		nodes[to].router.Run()
+		shutdown = make(chan bool)
+
+		accepted := make(map[string][]*stat.Stat)
+		for address, n := range nodes {
+			for len(n.accepted) > 0 {
+				accepted[address] = append(accepted[address], <-n.accepted)
+			}
+		}
+		return accepted
+	}
+
+	stats := func(n int) []*stat.Stat {
+		var stats []*stat.Stat
+		for i := 0; i < n; i++ {
+			stats = append(stats, &stat.Stat{Name: fmt.Sprintf("cpu;host=h%d", i), Timestamp: time.Now(), Value: float64(i)})
+		}
+		return stats
+	}
+
+	It("should deliver each stat to the node owning it", func() {
+		ring := NewRing(DefaultReplicas, addresses...)
+		accepted := send(addresses[0], stats(300))
+
+		total := 0
+		for address, stats := range accepted {
+			for _, s := range stats {
+				Expect(ring.Owner(s.Name)).To(Equal(address))
+			}
+			total += len(stats)
+		}
+		Expect(total).To(Equal(300))
+		Expect(accepted).To(HaveLen(3))
+	})
+
+	It("should deliver a stat to the same node whichever node receives it", func() {
+		s := stats(1)
+		var owners []string
+		for _, address := range addresses {
+			for owner := range send(address, s) {
+				owners = append(owners, owner)
+			}
+		}
+		Expect(owners).To(HaveLen(3))
+		Expect(owners[1]).To(Equal(owners[0]))
+		Expect(owners[2]).To(Equal(owners[0]))
+	})
+
+	It("should keep stats that cannot be forwarded", func() {
+		nodes[addresses[1]].sender.err = errors.New("unreachable")
+		accepted := send(addresses[0], stats(300))
+
+		Expect(accepted).NotTo(HaveKey(addresses[1]))
+		Expect(len(accepted[addresses[0]]) + len(accepted[addresses[2]])).To(Equal(300))
+	})
+
+	It("should forward stats again once their owner is reachable", func() {
+		n := nodes[addresses[0]]
+		now := time.Now()
+		n.router.now = func() time.Time { return now }
+		nodes[addresses[1]].sender.err = errors.New("unreachable")
+
+		n.router.route(stats(300), now.Add(RetryFor))
+		Expect(n.router.nHeld).To(BeNumerically(">", 0))
+		Expect(nodes[addresses[1]].accepted).To(BeEmpty())
+		held := n.router.nHeld
+
+		nodes[addresses[1]].sender.err = nil
+		n.router.retry(now.Add(time.Second))
+		Expect(n.router.nHeld).To(BeZero())
+		Expect(nodes[addresses[1]].accepted).To(HaveLen(held))
+	})
+
+	It("should keep stats that still cannot be forwarded after RetryFor", func() {
+		n := nodes[addresses[0]]
+		now := time.Now()
+		n.router.now = func() time.Time { return now }
+		nodes[addresses[1]].sender.err = errors.New("unreachable")
+
+		n.router.route(stats(300), now.Add(RetryFor))
+		held := n.router.nHeld
+		kept := len(n.accepted)
+
+		now = now.Add(RetryFor)
+		n.router.retry(now)
+		Expect(n.router.nHeld).To(BeZero())
+		Expect(n.accepted).To(HaveLen(kept + held))
+	})
+
+	It("should stop forwarding to a node that leaves", func() {
+		n := nodes[addresses[0]]
+		n.router.members.probe = func(address string) bool { return address != addresses[1] }
+		n.router.members.update()
+
+		accepted := send(addresses[0], stats(300))
+		Expect(accepted).NotTo(HaveKey(addresses[1]))
+		Expect(accepted).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("Encode", func() {
+	It("should encode a message for each run of stats with the same timestamp", func() {
+		t := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+		msgs, err := Encode([]*stat.Stat{
+			{Name: "a", Timestamp: t, Value: 1},
+			{Name: "b", Timestamp: t, Value: 2},
+			{Name: "c", Timestamp: t.Add(time.Second), Value: 3},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(msgs).To(HaveLen(2))
+
+		var m protoStat.ProtoStats
+		Expect(m.Unmarshal(msgs[0])).To(Succeed())
+		Expect(m.GetTimeNano()).To(Equal(t.UnixNano()))
+		Expect(m.GetStats()).To(HaveLen(2))
+		Expect(m.GetStats()[1].GetKey()).To(Equal("b"))
+		Expect(m.GetStats()[1].GetValue()).To(Equal(2.0))
+	})
+})
@@ -0,0 +1,316 @@
+// Package config loads gostat's settings. Each setting has a default, which is
+// overridden by the configuration file, then by an environment variable, and
+// finally by a command line flag
+package config
+
+import (
+	"fmt"
+	"github.com/CapillarySoftware/gostat/anomaly"
+	"github.com/CapillarySoftware/gostat/cluster"
+	"github.com/CapillarySoftware/gostat/migrate"
+	"github.com/CapillarySoftware/gostat/queue"
+	"github.com/CapillarySoftware/gostat/writemode"
+	"github.com/gocql/gocql"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Config holds every setting of gostat. The yaml tag of a field names it in the
+// configuration file, and the environment variable overriding it is derived
+// from the names of the field and its section, e.g. GOSTAT_HTTP_ADDRESS for
+// http.address. The flag tag names the command line flag overriding it
+type Config struct {
+	Mode string `yaml:"mode" flag:"mode" help:"standalone, or relay to send per-minute aggregates to a central gostat instead of storing stats"`
+
+	Listener struct {
+		Address string `yaml:"address" flag:"listen" help:"nanomsg address stats are received on"`
+	} `yaml:"listener"`
+
+	HTTP struct {
+		Address   string `yaml:"address" flag:"http" help:"address the HTTP and socket.io APIs are served on"`
+		Assets    string `yaml:"assets" flag:"assets" help:"directory of static files served over HTTP in place of the built-in dashboard, or empty"`
+		MaxPoints int    `yaml:"maxPoints" flag:"max-points" help:"the most raw stats a query may return, beyond which a cursor is returned or the query fails"`
+	} `yaml:"http"`
+
+	Admin struct {
+		Address string `yaml:"address" flag:"admin" help:"address the admin endpoints are served on, loopback only by default, or empty to serve none"`
+	} `yaml:"admin"`
+
+	Cassandra struct {
+		Hosts       []string      `yaml:"hosts" flag:"cassandra-hosts" help:"comma separated Cassandra hosts"`
+		Keyspace    string        `yaml:"keyspace" flag:"cassandra-keyspace" help:"Cassandra keyspace stats are stored in"`
+		Consistency string        `yaml:"consistency" flag:"cassandra-consistency" help:"Cassandra consistency level, e.g. one or quorum"`
+		Timeout     time.Duration `yaml:"timeout" flag:"cassandra-timeout" help:"timeout of Cassandra queries"`
+		Replication string        `yaml:"replication" flag:"cassandra-replication" help:"replication gostat migrate creates the keyspace with, or sets with -alter-replication: a replication factor, or comma separated datacenter=factor pairs"`
+	} `yaml:"cassandra"`
+
+	Writes struct {
+		Default string   `yaml:"default" flag:"write-mode" help:"what a series does with stats that share a timestamp: last, keep or sum"`
+		Modes   []string `yaml:"modes,omitempty" flag:"write-modes" help:"comma separated pattern=mode rules choosing the write mode of the series matching pattern"`
+	} `yaml:"writes"`
+
+	Bucketer struct {
+		PublishInterval time.Duration `yaml:"publishInterval" flag:"publish-interval" help:"how often the current and previous buckets are published"`
+		Workers         int           `yaml:"workers" flag:"bucketer-workers" help:"how many goroutines bucket stats, each bucketing a share of the series"`
+	} `yaml:"bucketer"`
+
+	Log struct {
+		Level  string `yaml:"level" flag:"log-level" help:"minimum level logged: trace, debug, info, warn, error or critical"`
+		Config string `yaml:"config" flag:"log-config" help:"seelog XML configuration file, which replaces log-level"`
+	} `yaml:"log"`
+
+	Sim bool `yaml:"sim" flag:"sim" help:"randomly generate and insert test data"`
+
+	WAL struct {
+		Dir  string        `yaml:"dir" flag:"wal-dir" help:"directory of the write-ahead log of accepted stats, or empty to disable it"`
+		Sync time.Duration `yaml:"sync" flag:"wal-sync" help:"how often the write-ahead log is synced to disk"`
+	} `yaml:"wal"`
+
+	Queues struct {
+		Size   int    `yaml:"size" flag:"queue-size" help:"how many values each queue between the stages of the pipeline holds"`
+		Policy string `yaml:"policy" flag:"queue-policy" help:"what a full queue does with a new value: block, drop-oldest or drop-newest"`
+	} `yaml:"queues"`
+
+	Metrics struct {
+		Interval time.Duration `yaml:"interval" flag:"metrics-interval" help:"how often gostat's own metrics are recorded as gostat. stats"`
+	} `yaml:"metrics"`
+
+	Cluster struct {
+		Node     string        `yaml:"node" flag:"cluster-node" help:"nanomsg address this node receives forwarded stats on, as listed in cluster.peers, or empty to run alone"`
+		Peers    []string      `yaml:"peers,omitempty" flag:"cluster-peers" help:"comma separated nanomsg addresses of every node in the cluster"`
+		Replicas int           `yaml:"replicas" flag:"cluster-replicas" help:"points of each node on the consistent hash ring"`
+		Probe    time.Duration `yaml:"probe" flag:"cluster-probe" help:"how often peers are probed to see which are up"`
+	} `yaml:"cluster"`
+
+	Relay struct {
+		Name     string        `yaml:"name" flag:"relay-name" help:"the name of this relay, unique among the relays of a central gostat"`
+		Upstream string        `yaml:"upstream" flag:"relay-upstream" help:"nanomsg address of the central gostat a relay sends aggregates to"`
+		Spool    string        `yaml:"spool" flag:"relay-spool" help:"directory of the aggregates a relay has yet to send"`
+		Interval time.Duration `yaml:"interval" flag:"relay-interval" help:"how often a relay spools final aggregates and sends them"`
+		Listen   string        `yaml:"listen" flag:"relay-listen" help:"nanomsg address aggregates from relays are received on, or empty to accept none"`
+		Seqs     string        `yaml:"seqs" flag:"relay-seqs" help:"file the sequence number of the last batch merged from each relay is saved to"`
+	} `yaml:"relay"`
+
+	Alerts struct {
+		Rules string `yaml:"rules" flag:"alert-rules" help:"JSON file of alert rules to evaluate"`
+		State string `yaml:"state" flag:"alert-state" help:"file the state of alerts is saved to"`
+	} `yaml:"alerts"`
+
+	Anomaly struct {
+		Enabled   bool          `yaml:"enabled" flag:"anomaly" help:"detect anomalies in the per-minute aggregates"`
+		Baselines string        `yaml:"baselines" flag:"anomaly-baselines" help:"file the learned anomaly baselines are saved to"`
+		Season    time.Duration `yaml:"season" flag:"anomaly-season" help:"the length of the seasonal pattern anomalies are detected against"`
+	} `yaml:"anomaly"`
+
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" flag:"shutdown-timeout" help:"how long to wait for stats to be drained and flushed when shutting down"`
+}
+
+// The modes gostat runs in
+const (
+	StandaloneMode = "standalone"
+	RelayMode      = "relay"
+)
+
+// Defaults returns the default settings
+func Defaults() *Config {
+	c := &Config{}
+	c.Mode = StandaloneMode
+	c.Listener.Address = "tcp://*:2025"
+	c.HTTP.Address = ":5000"
+	c.HTTP.MaxPoints = 100000
+	c.Admin.Address = "127.0.0.1:5050"
+	c.Cassandra.Hosts = []string{"localhost"}
+	c.Cassandra.Keyspace = "gostat"
+	c.Cassandra.Consistency = "quorum"
+	c.Cassandra.Timeout = time.Millisecond * 600
+	c.Cassandra.Replication = "1"
+	c.Writes.Default = string(writemode.LastWriteWins)
+	c.Bucketer.PublishInterval = time.Second * 5
+	c.Bucketer.Workers = 1
+	c.Log.Level = "info"
+	c.WAL.Dir = "gostat-wal"
+	c.WAL.Sync = time.Second
+	c.Queues.Size = 10000
+	c.Queues.Policy = "block"
+	c.Metrics.Interval = time.Second * 10
+	c.Cluster.Replicas = cluster.DefaultReplicas
+	c.Cluster.Probe = time.Second * 5
+	c.Relay.Name, _ = os.Hostname()
+	c.Relay.Spool = "gostat-spool"
+	c.Relay.Interval = time.Second * 10
+	c.Relay.Seqs = "gostat-relays.json"
+	c.Alerts.State = "gostat-alerts.json"
+	c.Anomaly.Baselines = "gostat-anomaly.json"
+	c.Anomaly.Season = anomaly.DefaultParams.Season
+	c.ShutdownTimeout = time.Second * 30
+	return c
+}
+
+// Load returns the default settings overridden by the YAML file at path, if
+// path is not empty, then by the environment, and then by the flags that were
+// set, if flags is not nil
+func Load(path string, flags *Flags) (*Config, error) {
+	c := Defaults()
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(data, c); err != nil {
+			return nil, fmt.Errorf("config: %s: %v", path, err)
+		}
+	}
+
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	if flags != nil {
+		if err := flags.apply(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks that the settings are usable
+func (c *Config) Validate() error {
+	switch {
+	case c.Mode != StandaloneMode && c.Mode != RelayMode:
+		return fmt.Errorf("config: unknown mode %q", c.Mode)
+	case c.Mode == RelayMode && c.Relay.Upstream == "":
+		return fmt.Errorf("config: relay.upstream is required in relay mode")
+	case c.Mode == RelayMode && c.Relay.Name == "":
+		return fmt.Errorf("config: relay.name is required in relay mode")
+	case c.Mode == RelayMode && c.Relay.Spool == "":
+		return fmt.Errorf("config: relay.spool is required in relay mode")
+	case c.Mode == RelayMode && c.Relay.Listen != "":
+		return fmt.Errorf("config: a relay cannot accept aggregates from other relays")
+	case c.Mode == RelayMode && c.Cluster.Node != "":
+		return fmt.Errorf("config: a relay cannot be part of a cluster")
+	case c.Relay.Listen != "" && c.Relay.Seqs == "":
+		return fmt.Errorf("config: relay.seqs is empty, but relay.listen accepts aggregates from relays")
+	case c.Relay.Interval <= 0:
+		return fmt.Errorf("config: relay.interval must be positive")
+	case c.Listener.Address == "":
+		return fmt.Errorf("config: listener.address is empty")
+	case c.HTTP.Address == "":
+		return fmt.Errorf("config: http.address is empty")
+	case c.HTTP.MaxPoints < 1:
+		return fmt.Errorf("config: http.maxPoints must be at least 1")
+	case c.Admin.Address != "" && c.Admin.Address == c.HTTP.Address:
+		return fmt.Errorf("config: admin.address must not be http.address, which is public")
+	case len(c.Cassandra.Hosts) == 0:
+		return fmt.Errorf("config: cassandra.hosts is empty")
+	case c.Cassandra.Keyspace == "":
+		return fmt.Errorf("config: cassandra.keyspace is empty")
+	case c.Cassandra.Timeout <= 0:
+		return fmt.Errorf("config: cassandra.timeout must be positive")
+	case c.Bucketer.PublishInterval <= 0:
+		return fmt.Errorf("config: bucketer.publishInterval must be positive")
+	case c.Bucketer.Workers < 1:
+		return fmt.Errorf("config: bucketer.workers must be at least 1")
+	case c.WAL.Sync <= 0:
+		return fmt.Errorf("config: wal.sync must be positive")
+	case c.Queues.Size < 1:
+		return fmt.Errorf("config: queues.size must be at least 1")
+	case c.Metrics.Interval <= 0:
+		return fmt.Errorf("config: metrics.interval must be positive")
+	case c.Cluster.Node != "" && !contains(c.Cluster.Peers, c.Cluster.Node):
+		return fmt.Errorf("config: cluster.node %s is not one of cluster.peers", c.Cluster.Node)
+	case c.Cluster.Node != "" && c.Cluster.Node == c.Listener.Address:
+		return fmt.Errorf("config: cluster.node must differ from listener.address")
+	case c.Cluster.Replicas < 1:
+		return fmt.Errorf("config: cluster.replicas must be at least 1")
+	case c.Cluster.Probe <= 0:
+		return fmt.Errorf("config: cluster.probe must be positive")
+	case c.Anomaly.Season < time.Minute:
+		return fmt.Errorf("config: anomaly.season must be at least a minute")
+	case c.ShutdownTimeout <= 0:
+		return fmt.Errorf("config: shutdownTimeout must be positive")
+	}
+
+	if _, err := gocql.ParseConsistencyWrapper(c.Cassandra.Consistency); err != nil {
+		return fmt.Errorf("config: cassandra.consistency: %v", err)
+	}
+	if _, err := migrate.ParseReplication(c.Cassandra.Replication); err != nil {
+		return fmt.Errorf("config: cassandra.replication: %v", err)
+	}
+	if _, err := writemode.ParseRules(c.Writes.Modes, c.Writes.Default); err != nil {
+		return fmt.Errorf("config: writes: %v", err)
+	}
+	if _, err := queue.ParsePolicy(c.Queues.Policy); err != nil {
+		return fmt.Errorf("config: queues.policy: %v", err)
+	}
+	if c.Log.Config == "" && !ValidLevel(c.Log.Level) {
+		return fmt.Errorf("config: unknown log.level %q", c.Log.Level)
+	}
+	return nil
+}
+
+// contains reports whether s is one of strings
+func contains(strings []string, s string) bool {
+	for _, t := range strings {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidLevel reports whether level is a seelog log level
+func ValidLevel(level string) bool {
+	switch level {
+	case "trace", "debug", "info", "warn", "error", "critical", "off":
+		return true
+	}
+	return false
+}
+
+// Map returns the settings as nested maps keyed by the names used in the
+// configuration file, e.g. for rendering as JSON
+func (c *Config) Map() map[string]interface{} {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil
+	}
+
+	var m map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	return stringKeys(m).(map[string]interface{})
+}
+
+// stringKeys converts the maps decoded by yaml to maps keyed by strings
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			m[fmt.Sprint(k)] = stringKeys(value)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = stringKeys(v[i])
+		}
+	}
+	return v
+}
+
+// String renders the settings as YAML
+func (c *Config) String() string {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
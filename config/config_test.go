@@ -0,0 +1,123 @@
+package config
+
+import (
+	"flag"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("Config", func() {
+
+	var dir string
+
+	write := func(yaml string) string {
+		path := filepath.Join(dir, "gostat.yaml")
+		Expect(ioutil.WriteFile(path, []byte(yaml), 0644)).To(BeNil())
+		return path
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "gostat-config")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+		os.Unsetenv("GOSTAT_HTTP_ADDRESS")
+		os.Unsetenv("GOSTAT_BUCKETER_PUBLISH_INTERVAL")
+		os.Unsetenv("GOSTAT_CASSANDRA_HOSTS")
+	})
+
+	It("should default to the settings gostat always used", func() {
+		c, err := Load("", nil)
+		Expect(err).To(BeNil())
+		Expect(c.Listener.Address).To(Equal("tcp://*:2025"))
+		Expect(c.HTTP.Address).To(Equal(":5000"))
+		Expect(c.HTTP.Assets).To(BeEmpty())
+		Expect(c.Admin.Address).To(Equal("127.0.0.1:5050"))
+		Expect(c.Cassandra.Hosts).To(Equal([]string{"localhost"}))
+		Expect(c.Bucketer.PublishInterval).To(Equal(time.Second * 5))
+	})
+
+	It("should override the defaults with the file, the environment and then the flags", func() {
+		path := write(`
+http:
+  address: ":8000"
+  assets: /srv/gostat
+bucketer:
+  publishInterval: 10s
+queues:
+  size: 100
+`)
+		os.Setenv("GOSTAT_HTTP_ADDRESS", ":9000")
+		os.Setenv("GOSTAT_BUCKETER_PUBLISH_INTERVAL", "15s")
+		os.Setenv("GOSTAT_CASSANDRA_HOSTS", "cass1, cass2")
+
+		fs := flag.NewFlagSet("gostat", flag.ContinueOnError)
+		flags := DefineFlags(fs)
+		Expect(fs.Parse([]string{"-publish-interval", "20s", "-sim"})).To(BeNil())
+
+		c, err := Load(path, flags)
+		Expect(err).To(BeNil())
+		Expect(c.HTTP.Assets).To(Equal("/srv/gostat"))
+		Expect(c.Queues.Size).To(Equal(100))
+		Expect(c.HTTP.Address).To(Equal(":9000"))
+		Expect(c.Cassandra.Hosts).To(Equal([]string{"cass1", "cass2"}))
+		Expect(c.Bucketer.PublishInterval).To(Equal(time.Second * 20))
+		Expect(c.Sim).To(BeTrue())
+	})
+
+	It("should reject unknown settings in the file", func() {
+		_, err := Load(write("htpp:\n  address: \":8000\"\n"), nil)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("should reject invalid settings", func() {
+		for _, yaml := range []string{
+			"bucketer:\n  publishInterval: 0s\n",
+			"queues:\n  policy: drop-everything\n",
+			"cassandra:\n  consistency: most\n",
+			"cassandra:\n  replication: 0\n",
+			"cassandra:\n  replication: dc1=3,dc2\n",
+			"log:\n  level: loud\n",
+			"anomaly:\n  season: 30s\n",
+			"writes:\n  default: first\n",
+			"writes:\n  modes: [web.*.requests]\n",
+			"http:\n  address: \":5000\"\nadmin:\n  address: \":5000\"\n",
+			"cluster:\n  node: tcp://127.0.0.1:3025\n  peers: [tcp://127.0.0.1:3026, tcp://127.0.0.1:3027]\n",
+		} {
+			_, err := Load(write(yaml), nil)
+			Expect(err).NotTo(BeNil(), yaml)
+		}
+	})
+
+	It("should reject malformed environment variables", func() {
+		os.Setenv("GOSTAT_BUCKETER_PUBLISH_INTERVAL", "soon")
+		_, err := Load("", nil)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("should render settings that load back unchanged", func() {
+		c := Defaults()
+		c.Cassandra.Hosts = []string{"cass1", "cass2"}
+		c.Anomaly.Season = time.Hour
+
+		loaded, err := Load(write(c.String()), nil)
+		Expect(err).To(BeNil())
+		Expect(loaded).To(Equal(c))
+	})
+
+	It("should map settings by the names used in the configuration file", func() {
+		c := Defaults()
+		c.Cassandra.Hosts = []string{"cass1", "cass2"}
+
+		m := c.Map()
+		Expect(m["cassandra"]).To(HaveKeyWithValue("hosts", []interface{}{"cass1", "cass2"}))
+		Expect(m["sim"]).To(Equal(c.Sim))
+	})
+})
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// EnvPrefix prefixes the environment variables overriding settings
+const EnvPrefix = "GOSTAT_"
+
+// setting is one field of a Config
+type setting struct {
+	path  []string // the yaml names of the field and its sections
+	flag  string
+	help  string
+	index []int // the index of the field for reflect.Value.FieldByIndex
+}
+
+// env returns the name of the environment variable overriding the setting
+func (s *setting) env() string {
+	var words []string
+	for _, name := range s.path {
+		words = append(words, snake(name))
+	}
+	return EnvPrefix + strings.ToUpper(strings.Join(words, "_"))
+}
+
+// snake converts camelCase to snake_case
+func snake(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) && i > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// settings returns every setting of a Config
+func settings() []*setting {
+	var all []*setting
+	var walk func(t reflect.Type, path []string, index []int)
+	walk = func(t reflect.Type, path []string, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			p := append(append([]string{}, path...), name)
+			idx := append(append([]int{}, index...), i)
+
+			if f.Type.Kind() == reflect.Struct {
+				walk(f.Type, p, idx)
+				continue
+			}
+			all = append(all, &setting{path: p, flag: f.Tag.Get("flag"), help: f.Tag.Get("help"), index: idx})
+		}
+	}
+	walk(reflect.TypeOf(Config{}), nil, nil)
+	return all
+}
+
+// set parses a value into the setting's field of c
+func (s *setting) set(c *Config, value string) error {
+	v := reflect.ValueOf(c).Elem().FieldByIndex(s.index)
+
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(value)
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case []string:
+		var values []string
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+		v.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported type %v", v.Type())
+	}
+	return nil
+}
+
+// get formats the setting's field of c
+func (s *setting) get(c *Config) string {
+	v := reflect.ValueOf(c).Elem().FieldByIndex(s.index).Interface()
+	if values, ok := v.([]string); ok {
+		return strings.Join(values, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// applyEnv overrides settings with the environment variables that are set
+func (c *Config) applyEnv() error {
+	for _, s := range settings() {
+		if value, ok := os.LookupEnv(s.env()); ok {
+			if err := s.set(c, value); err != nil {
+				return fmt.Errorf("config: %s: %v", s.env(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// Flags are the command line flags overriding settings
+type Flags struct {
+	values map[*setting]*flagValue
+}
+
+// flagValue records the value of a flag until it is applied to a Config
+type flagValue struct {
+	value    string
+	set      bool
+	boolFlag bool
+}
+
+func (f *flagValue) String() string     { return f.value }
+func (f *flagValue) IsBoolFlag() bool   { return f.boolFlag }
+func (f *flagValue) Set(v string) error { f.value, f.set = v, true; return nil }
+
+// DefineFlags defines a flag on fs for every setting with a flag tag
+func DefineFlags(fs *flag.FlagSet) *Flags {
+	defaults := Defaults()
+	flags := &Flags{values: make(map[*setting]*flagValue)}
+
+	for _, s := range settings() {
+		if s.flag == "" {
+			continue
+		}
+		v := &flagValue{value: s.get(defaults)}
+		_, v.boolFlag = reflect.ValueOf(defaults).Elem().FieldByIndex(s.index).Interface().(bool)
+		fs.Var(v, s.flag, fmt.Sprintf("%s (%s)", s.help, s.env()))
+		flags.values[s] = v
+	}
+	return flags
+}
+
+// apply overrides settings with the flags that were set
+func (f *Flags) apply(c *Config) error {
+	for s, v := range f.values {
+		if !v.set {
+			continue
+		}
+		if err := s.set(c, v.value); err != nil {
+			return fmt.Errorf("config: -%s: %v", s.flag, err)
+		}
+	}
+	return nil
+}
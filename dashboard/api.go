@@ -0,0 +1,78 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Request is a request of the dashboards API. Over socket.io it is sent as
+// dashboardReq and answered by a Response, as dashboardRes
+type Request struct {
+	Tracker   string          `json:"tracker"`
+	Op        string          `json:"op"` // list, get, versions, create, update, delete, import or export
+	ID        string          `json:"id,omitempty"`
+	Version   int             `json:"version,omitempty"`   // of get and export, or 0 for the latest
+	Dashboard json.RawMessage `json:"dashboard,omitempty"` // of create, update and import
+	Overwrite bool            `json:"overwrite,omitempty"` // whether import may save over a dashboard with the same id
+}
+
+// Response answers a Request
+type Response struct {
+	Tracker    string          `json:"tracker"`
+	Error      string          `json:"error,omitempty"`
+	Dashboard  *Dashboard      `json:"dashboard,omitempty"`
+	Dashboards []Summary       `json:"dashboards,omitempty"`
+	Export     json.RawMessage `json:"export,omitempty"`
+}
+
+// Handle answers a request with the dashboards in s
+func Handle(s Store, r *Request) *Response {
+	resp, err := do(s, r)
+	if err != nil {
+		return &Response{Tracker: r.Tracker, Error: err.Error()}
+	}
+	return resp
+}
+
+func do(s Store, r *Request) (*Response, error) {
+	resp := &Response{Tracker: r.Tracker}
+	var err error
+
+	switch r.Op {
+	case "list":
+		resp.Dashboards, err = List(s)
+	case "get":
+		resp.Dashboard, err = Get(s, r.ID, r.Version)
+	case "versions":
+		resp.Dashboards, err = Versions(s, r.ID)
+	case "create", "update":
+		var d Dashboard
+		if err := json.Unmarshal(r.Dashboard, &d); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		if r.ID != "" {
+			d.ID = r.ID
+		}
+		if r.Op == "create" {
+			resp.Dashboard, err = Create(s, d.Definition, d.ID)
+		} else {
+			resp.Dashboard, err = Update(s, &d)
+		}
+	case "delete":
+		err = Delete(s, r.ID)
+	case "import":
+		resp.Dashboard, err = Import(s, r.Dashboard, r.Overwrite)
+	case "export":
+		var d *Dashboard
+		if d, err = Get(s, r.ID, r.Version); err == nil {
+			resp.Export, err = Export(d)
+		}
+	default:
+		err = fmt.Errorf("%w: unknown op %q", ErrInvalid, r.Op)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,262 @@
+// Package dashboard saves dashboards, sets of graphs charted over a time range,
+// so that they can be shared by their id. Every save of a dashboard is kept as
+// a new version, and dashboards move between gostats as exported JSON files
+package dashboard
+
+import (
+	"errors"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/clock"
+	"github.com/CapillarySoftware/gostat/query"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("dashboard: not found")
+	ErrExists   = errors.New("dashboard: a dashboard with the id already exists")
+	ErrConflict = errors.New("dashboard: saved by someone else since the version being updated, reload it and try again")
+	ErrInvalid  = errors.New("dashboard: invalid dashboard")
+)
+
+// The views of a graph
+const (
+	Auto       = "auto" // raw stats for short ranges, aggregates for wide ones
+	Raw        = "raw"
+	Aggregated = "aggregated" // the average, min and max of each step
+)
+
+// Custom is the Range of a dashboard charting From to To
+const Custom = "custom"
+
+var idRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// now returns the time a dashboard is saved, to the millisecond Cassandra
+// stores
+var now = func() time.Time {
+	return clock.Real.Now().UTC().Truncate(time.Millisecond)
+}
+
+// Graph charts series named, or those returned by a query
+type Graph struct {
+	Title  string   `json:"title,omitempty"`
+	Series []string `json:"series,omitempty"`
+	Query  string   `json:"query,omitempty"`
+	View   string   `json:"view,omitempty"` // empty for Auto
+}
+
+// Definition is what a dashboard shows
+type Definition struct {
+	Title   string     `json:"title"`
+	Range   string     `json:"range"` // the latest period charted, e.g. 1h or 7d, or Custom
+	From    *time.Time `json:"from,omitempty"`
+	To      *time.Time `json:"to,omitempty"`
+	Refresh string     `json:"refresh,omitempty"` // how often the graphs are refreshed, e.g. 30s, or empty for never
+	Live    bool       `json:"live"`              // whether graphs follow their series as stats are stored
+	Graphs  []Graph    `json:"graphs"`
+}
+
+// Validate checks that the definition can be saved
+func (d *Definition) Validate() error {
+	switch {
+	case strings.TrimSpace(d.Title) == "":
+		return fmt.Errorf("%w: a title is required", ErrInvalid)
+	case len(d.Title) > 200:
+		return fmt.Errorf("%w: the title is longer than 200 bytes", ErrInvalid)
+	case d.Range == Custom && (d.From == nil || d.To == nil):
+		return fmt.Errorf("%w: a custom range needs from and to", ErrInvalid)
+	case d.Range == Custom && !d.From.Before(*d.To):
+		return fmt.Errorf("%w: from must be before to", ErrInvalid)
+	}
+	if d.Range != Custom {
+		if period, err := query.ParseDuration(d.Range); err != nil || period <= 0 {
+			return fmt.Errorf("%w: invalid range %q, use a period such as 1h or custom", ErrInvalid, d.Range)
+		}
+	}
+	if d.Refresh != "" {
+		if every, err := query.ParseDuration(d.Refresh); err != nil || every < time.Second {
+			return fmt.Errorf("%w: invalid refresh %q, use a period of at least 1s", ErrInvalid, d.Refresh)
+		}
+	}
+
+	for i, g := range d.Graphs {
+		switch {
+		case len(g.Series) == 0 && g.Query == "":
+			return fmt.Errorf("%w: graph %d charts no series or query", ErrInvalid, i+1)
+		case len(g.Series) > 0 && g.Query != "":
+			return fmt.Errorf("%w: graph %d has both series and a query", ErrInvalid, i+1)
+		case g.View != "" && g.View != Auto && g.View != Raw && g.View != Aggregated:
+			return fmt.Errorf("%w: graph %d has unknown view %q", ErrInvalid, i+1, g.View)
+		}
+		for _, name := range g.Series {
+			if name == "" {
+				return fmt.Errorf("%w: graph %d has a series without a name", ErrInvalid, i+1)
+			}
+		}
+		if g.Query != "" {
+			if _, err := query.Parse(g.Query); err != nil {
+				return fmt.Errorf("%w: graph %d: %v", ErrInvalid, i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Dashboard is a saved version of a dashboard
+type Dashboard struct {
+	ID      string    `json:"id"`
+	Version int       `json:"version"`
+	Saved   time.Time `json:"saved"`
+	Definition
+}
+
+// Summary describes a version of a dashboard, for listing
+type Summary struct {
+	ID      string    `json:"id"`
+	Version int       `json:"version"`
+	Title   string    `json:"title"`
+	Saved   time.Time `json:"saved"`
+}
+
+// Store is where dashboards are saved
+type Store interface {
+	// List summarizes the latest version of every dashboard
+	List() ([]Summary, error)
+	// Get returns a version of a dashboard, or its latest version if version
+	// is 0, or nil if there is no such version
+	Get(id string, version int) (*Dashboard, error)
+	// Versions summarizes every version of a dashboard, newest first
+	Versions(id string) ([]Summary, error)
+	// Put saves a version of a dashboard unless it has been saved already,
+	// reporting whether it was saved
+	Put(d *Dashboard) (bool, error)
+	Delete(id string) error
+}
+
+// List summarizes the latest version of every dashboard, in order of title
+func List(s Store) ([]Summary, error) {
+	dashboards, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(dashboards, func(i, j int) bool {
+		a, b := strings.ToLower(dashboards[i].Title), strings.ToLower(dashboards[j].Title)
+		return a < b || a == b && dashboards[i].ID < dashboards[j].ID
+	})
+	return dashboards, nil
+}
+
+// Get returns a version of a dashboard, or its latest version if version is 0
+func Get(s Store, id string, version int) (*Dashboard, error) {
+	d, err := s.Get(id, version)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, ErrNotFound
+	}
+	return d, nil
+}
+
+// Versions summarizes every version of a dashboard, newest first
+func Versions(s Store, id string) ([]Summary, error) {
+	versions, err := s.Versions(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+	return versions, nil
+}
+
+// Create saves the first version of a new dashboard. If id is empty, the
+// dashboard's id is made from its title
+func Create(s Store, def Definition, id string) (*Dashboard, error) {
+	if err := def.Validate(); err != nil {
+		return nil, err
+	}
+	if id != "" {
+		if !idRegexp.MatchString(id) {
+			return nil, fmt.Errorf("%w: invalid id %q, use up to 64 lowercase letters, digits, - and _", ErrInvalid, id)
+		}
+		return put(s, &Dashboard{ID: id, Version: 1, Saved: now(), Definition: def}, ErrExists)
+	}
+
+	// number the id if the title's is taken
+	base := slug(def.Title)
+	for n := 1; n <= 100; n++ {
+		id := base
+		if n > 1 {
+			id = fmt.Sprintf("%s-%d", base, n)
+		}
+		d, err := put(s, &Dashboard{ID: id, Version: 1, Saved: now(), Definition: def}, ErrExists)
+		if err != ErrExists {
+			return d, err
+		}
+	}
+	return nil, ErrExists
+}
+
+// Update saves a new version of the dashboard d.ID, with d's definition. d's
+// version is that of the dashboard it changes, so that, if the dashboard has
+// been saved again since, the update fails rather than lose those changes
+func Update(s Store, d *Dashboard) (*Dashboard, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	latest, err := Get(s, d.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+	if latest.Version != d.Version {
+		return nil, ErrConflict
+	}
+	return put(s, &Dashboard{ID: d.ID, Version: d.Version + 1, Saved: now(), Definition: d.Definition}, ErrConflict)
+}
+
+// Delete deletes every version of a dashboard
+func Delete(s Store, id string) error {
+	if _, err := Get(s, id, 0); err != nil {
+		return err
+	}
+	return s.Delete(id)
+}
+
+// put saves a version of a dashboard, failing with taken if it already exists
+func put(s Store, d *Dashboard, taken error) (*Dashboard, error) {
+	saved, err := s.Put(d)
+	if err != nil {
+		return nil, err
+	}
+	if !saved {
+		return nil, taken
+	}
+	return d, nil
+}
+
+// slug makes an id from a title, e.g. web-requests from "Web Requests"
+func slug(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+		if b.Len() >= 48 {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return "dashboard"
+	}
+	return b.String()
+}
@@ -0,0 +1,185 @@
+package dashboard
+
+import (
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+// memStore keeps the versions of each dashboard in memory, oldest first
+type memStore struct {
+	dashboards map[string][]Dashboard
+}
+
+func newMemStore() *memStore {
+	return &memStore{dashboards: make(map[string][]Dashboard)}
+}
+
+func (m *memStore) List() ([]Summary, error) {
+	var s []Summary
+	for _, versions := range m.dashboards {
+		s = append(s, summarize(versions[len(versions)-1]))
+	}
+	return s, nil
+}
+
+func (m *memStore) Get(id string, version int) (*Dashboard, error) {
+	versions := m.dashboards[id]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if version == 0 || versions[i].Version == version {
+			d := versions[i]
+			return &d, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memStore) Versions(id string) ([]Summary, error) {
+	var s []Summary
+	versions := m.dashboards[id]
+	for i := len(versions) - 1; i >= 0; i-- {
+		s = append(s, summarize(versions[i]))
+	}
+	return s, nil
+}
+
+func (m *memStore) Put(d *Dashboard) (bool, error) {
+	for _, v := range m.dashboards[d.ID] {
+		if v.Version == d.Version {
+			return false, nil
+		}
+	}
+	m.dashboards[d.ID] = append(m.dashboards[d.ID], *d)
+	return true, nil
+}
+
+func (m *memStore) Delete(id string) error {
+	delete(m.dashboards, id)
+	return nil
+}
+
+func summarize(d Dashboard) Summary {
+	return Summary{ID: d.ID, Version: d.Version, Title: d.Title, Saved: d.Saved}
+}
+
+var _ = Describe("Dashboards", func() {
+	var (
+		store *memStore
+		def   Definition
+		saved time.Time
+	)
+
+	BeforeEach(func() {
+		store = newMemStore()
+		def = Definition{
+			Title:   "Web Requests",
+			Range:   "1h",
+			Refresh: "30s",
+			Live:    true,
+			Graphs: []Graph{
+				{Series: []string{"web.host1.requests", "web.host2.requests"}},
+				{Query: "sumSeries(web.*.requests)", View: Raw},
+			},
+		}
+		saved = time.Date(2014, 10, 1, 3, 36, 0, 0, time.UTC)
+		now = func() time.Time { return saved }
+	})
+
+	It("should create a dashboard with an id made from its title", func() {
+		d, err := Create(store, def, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(d.ID).To(Equal("web-requests"))
+		Expect(d.Version).To(Equal(1))
+		Expect(d.Saved).To(Equal(saved))
+
+		d, err = Create(store, def, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(d.ID).To(Equal("web-requests-2"))
+
+		got, err := Get(store, "web-requests", 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got.Definition).To(Equal(def))
+	})
+
+	It("should create a dashboard with the id given, once", func() {
+		_, err := Create(store, def, "web")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = Create(store, def, "web")
+		Expect(err).To(Equal(ErrExists))
+		_, err = Create(store, def, "Web Requests")
+		Expect(errors.Is(err, ErrInvalid)).To(BeTrue())
+	})
+
+	It("should save each update as a new version", func() {
+		d, _ := Create(store, def, "")
+		d.Title = "Requests"
+		updated, err := Update(store, d)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.Version).To(Equal(2))
+
+		latest, _ := Get(store, d.ID, 0)
+		Expect(latest.Title).To(Equal("Requests"))
+		first, _ := Get(store, d.ID, 1)
+		Expect(first.Title).To(Equal("Web Requests"))
+
+		versions, err := Versions(store, d.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(Equal([]Summary{
+			{ID: d.ID, Version: 2, Title: "Requests", Saved: saved},
+			{ID: d.ID, Version: 1, Title: "Web Requests", Saved: saved},
+		}))
+	})
+
+	It("should not update a dashboard saved since the version updated", func() {
+		d, _ := Create(store, def, "")
+		mine, theirs := *d, *d
+		_, err := Update(store, &theirs)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = Update(store, &mine)
+		Expect(err).To(Equal(ErrConflict))
+
+		_, err = Update(store, &Dashboard{ID: "missing", Version: 1, Definition: def})
+		Expect(err).To(Equal(ErrNotFound))
+	})
+
+	It("should list dashboards by title and delete them", func() {
+		Create(store, Definition{Title: "b", Range: "1h"}, "")
+		Create(store, Definition{Title: "A", Range: "1h"}, "")
+		list, err := List(store)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(list).To(HaveLen(2))
+		Expect(list[0].Title).To(Equal("A"))
+
+		Expect(Delete(store, "a")).To(Succeed())
+		Expect(Delete(store, "a")).To(Equal(ErrNotFound))
+		_, err = Get(store, "a", 0)
+		Expect(err).To(Equal(ErrNotFound))
+	})
+
+	It("should reject invalid definitions", func() {
+		from := time.Unix(100, 0)
+		to := time.Unix(50, 0)
+		for _, d := range []Definition{
+			{Range: "1h"},
+			{Title: "t", Range: "an hour"},
+			{Title: "t", Range: "1h", Refresh: "10ms"},
+			{Title: "t", Range: Custom},
+			{Title: "t", Range: Custom, From: &from, To: &to},
+			{Title: "t", Range: "1h", Graphs: []Graph{{}}},
+			{Title: "t", Range: "1h", Graphs: []Graph{{Series: []string{"a"}, Query: "a"}}},
+			{Title: "t", Range: "1h", Graphs: []Graph{{Series: []string{"a"}, View: "pie"}}},
+			{Title: "t", Range: "1h", Graphs: []Graph{{Query: "sumSeries("}}},
+		} {
+			Expect(errors.Is(d.Validate(), ErrInvalid)).To(BeTrue(), "%+v", d)
+		}
+		Expect(def.Validate()).To(Succeed())
+	})
+
+	It("should make ids of titles", func() {
+		Expect(slug("Web Requests")).To(Equal("web-requests"))
+		Expect(slug("  API / latency (p95) ")).To(Equal("api-latency-p95"))
+		Expect(slug("!!!")).To(Equal("dashboard"))
+	})
+})
@@ -0,0 +1,47 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// FormatVersion is the version of the JSON format dashboards are exported in
+const FormatVersion = 1
+
+// exported is a dashboard as exported, its definition alongside its id and
+// the version of the format, but not the version of the dashboard, which only
+// has meaning to the gostat it was saved by
+type exported struct {
+	Format int    `json:"gostatDashboard"`
+	ID     string `json:"id,omitempty"`
+	Definition
+}
+
+// Export returns a dashboard in the JSON format Import reads
+func Export(d *Dashboard) ([]byte, error) {
+	return json.MarshalIndent(exported{Format: FormatVersion, ID: d.ID, Definition: d.Definition}, "", "  ")
+}
+
+// Import saves an exported dashboard. It keeps the dashboard's id, unless it
+// has none, and fails with ErrExists if the id is taken, unless overwrite is
+// set, in which case the dashboard is saved as a new version of the one with
+// the id
+func Import(s Store, data []byte, overwrite bool) (*Dashboard, error) {
+	var e exported
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if e.Format > FormatVersion {
+		return nil, fmt.Errorf("%w: exported in format %d, which is newer than this gostat reads", ErrInvalid, e.Format)
+	}
+
+	d, err := Create(s, e.Definition, e.ID)
+	if err != ErrExists || !overwrite {
+		return d, err
+	}
+	latest, err := Get(s, e.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+	return Update(s, &Dashboard{ID: e.ID, Version: latest.Version, Definition: e.Definition})
+}
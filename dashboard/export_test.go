@@ -0,0 +1,56 @@
+package dashboard
+
+import (
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Export", func() {
+	var (
+		store *memStore
+		d     *Dashboard
+	)
+
+	BeforeEach(func() {
+		store = newMemStore()
+		d, _ = Create(store, Definition{Title: "Web", Range: "1h", Graphs: []Graph{{Series: []string{"web.requests"}}}}, "")
+	})
+
+	It("should export a dashboard that imports unchanged", func() {
+		data, err := Export(d)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring(`"gostatDashboard": 1`))
+		Expect(string(data)).NotTo(ContainSubstring(`"version"`))
+
+		other := newMemStore()
+		imported, err := Import(other, data, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(imported.ID).To(Equal(d.ID))
+		Expect(imported.Version).To(Equal(1))
+		Expect(imported.Definition).To(Equal(d.Definition))
+	})
+
+	It("should only import over a dashboard with the same id if asked", func() {
+		data, _ := Export(d)
+		_, err := Import(store, data, false)
+		Expect(err).To(Equal(ErrExists))
+
+		imported, err := Import(store, data, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(imported.Version).To(Equal(2))
+	})
+
+	It("should import a dashboard without an id under a new one", func() {
+		imported, err := Import(store, []byte(`{"gostatDashboard": 1, "title": "Web", "range": "1h", "graphs": []}`), false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(imported.ID).To(Equal("web-2"))
+	})
+
+	It("should reject a newer format or a malformed file", func() {
+		_, err := Import(store, []byte(`{"gostatDashboard": 2, "title": "Web", "range": "1h"}`), false)
+		Expect(errors.Is(err, ErrInvalid)).To(BeTrue())
+		_, err = Import(store, []byte(`{`), false)
+		Expect(errors.Is(err, ErrInvalid)).To(BeTrue())
+	})
+})
@@ -0,0 +1,130 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxBody is the largest dashboard accepted
+const maxBody = 1 << 20
+
+// Handler serves the dashboards API:
+//
+//	GET    /dashboards                  summaries of every dashboard
+//	POST   /dashboards                  creates a dashboard, given its definition and optionally an id
+//	POST   /dashboards/import           imports an exported dashboard, over one with its id if overwrite=true
+//	GET    /dashboards/{id}             the latest version of a dashboard, or that given by version=n
+//	PUT    /dashboards/{id}             updates a dashboard, given the version it changes
+//	DELETE /dashboards/{id}             deletes every version of a dashboard
+//	GET    /dashboards/{id}/versions    summaries of every version of a dashboard
+//	GET    /dashboards/{id}/export      the dashboard as a file to import, or its version=n
+func Handler(s Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := parseRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req == nil {
+			http.Error(w, "no such dashboards API", http.StatusNotFound)
+			return
+		}
+
+		resp, err := do(s, req)
+		if err != nil {
+			writeError(w, req, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch req.Op {
+		case "list", "versions":
+			if resp.Dashboards == nil {
+				resp.Dashboards = []Summary{}
+			}
+			json.NewEncoder(w).Encode(resp.Dashboards)
+		case "create", "import":
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(resp.Dashboard)
+		case "delete":
+			w.WriteHeader(http.StatusNoContent)
+		case "export":
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", req.ID+".json"))
+			w.Write(append(resp.Export, '\n'))
+		default:
+			json.NewEncoder(w).Encode(resp.Dashboard)
+		}
+	})
+}
+
+// parseRequest returns the Request of an HTTP request, or nil if it is for no
+// API
+func parseRequest(r *http.Request) (*Request, error) {
+	req := &Request{}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/dashboards"), "/")
+	parts := strings.Split(path, "/")
+	if path == "" {
+		parts = nil
+	}
+
+	if v := r.URL.Query().Get("version"); v != "" {
+		var err error
+		if req.Version, err = strconv.Atoi(v); err != nil || req.Version < 1 {
+			return nil, fmt.Errorf("invalid version %q", v)
+		}
+	}
+
+	switch {
+	case len(parts) == 0 && r.Method == "GET":
+		req.Op = "list"
+	case len(parts) == 0 && r.Method == "POST":
+		req.Op = "create"
+	case len(parts) == 1 && parts[0] == "import" && r.Method == "POST":
+		req.Op = "import"
+		req.Overwrite = r.URL.Query().Get("overwrite") == "true"
+	case len(parts) == 1 && r.Method == "GET":
+		req.Op = "get"
+	case len(parts) == 1 && r.Method == "PUT":
+		req.Op = "update"
+	case len(parts) == 1 && r.Method == "DELETE":
+		req.Op = "delete"
+	case len(parts) == 2 && parts[1] == "versions" && r.Method == "GET":
+		req.Op = "versions"
+	case len(parts) == 2 && parts[1] == "export" && r.Method == "GET":
+		req.Op = "export"
+	default:
+		return nil, nil
+	}
+	if len(parts) > 0 && req.Op != "import" {
+		req.ID = parts[0]
+	}
+
+	if r.Method == "POST" || r.Method == "PUT" {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxBody))
+		if err != nil {
+			return nil, fmt.Errorf("error reading the dashboard: %v", err)
+		}
+		req.Dashboard = body
+	}
+	return req, nil
+}
+
+func writeError(w http.ResponseWriter, req *Request, err error) {
+	switch {
+	case err == ErrNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err == ErrExists, err == ErrConflict:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Errorf("dashboard: error serving %s %s: %v", req.Op, req.ID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
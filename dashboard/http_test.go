@@ -0,0 +1,79 @@
+package dashboard
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+var _ = Describe("Handler", func() {
+	var store *memStore
+
+	BeforeEach(func() {
+		store = newMemStore()
+	})
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, url, strings.NewReader(body))
+		Handler(store).ServeHTTP(w, r)
+		return w
+	}
+
+	It("should create, get, update, list and delete dashboards", func() {
+		w := serve("GET", "/dashboards", "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(strings.TrimSpace(w.Body.String())).To(Equal("[]"))
+
+		w = serve("POST", "/dashboards", `{"title": "Web", "range": "1h", "graphs": [{"series": ["web.requests"]}]}`)
+		Expect(w.Code).To(Equal(http.StatusCreated))
+		var d Dashboard
+		Expect(json.Unmarshal(w.Body.Bytes(), &d)).To(Succeed())
+		Expect(d.ID).To(Equal("web"))
+
+		w = serve("PUT", "/dashboards/web", `{"version": 1, "title": "Web Requests", "range": "6h", "graphs": []}`)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		w = serve("PUT", "/dashboards/web", `{"version": 1, "title": "Stale", "range": "6h", "graphs": []}`)
+		Expect(w.Code).To(Equal(http.StatusConflict))
+
+		w = serve("GET", "/dashboards/web?version=1", "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(json.Unmarshal(w.Body.Bytes(), &d)).To(Succeed())
+		Expect(d.Title).To(Equal("Web"))
+
+		w = serve("GET", "/dashboards/web/versions", "")
+		var versions []Summary
+		Expect(json.Unmarshal(w.Body.Bytes(), &versions)).To(Succeed())
+		Expect(versions).To(HaveLen(2))
+
+		w = serve("GET", "/dashboards", "")
+		var list []Summary
+		Expect(json.Unmarshal(w.Body.Bytes(), &list)).To(Succeed())
+		Expect(list).To(HaveLen(1))
+		Expect(list[0].Title).To(Equal("Web Requests"))
+
+		Expect(serve("DELETE", "/dashboards/web", "").Code).To(Equal(http.StatusNoContent))
+		Expect(serve("GET", "/dashboards/web", "").Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should export a dashboard as a file that imports", func() {
+		serve("POST", "/dashboards", `{"id": "web", "title": "Web", "range": "1h", "graphs": []}`)
+		w := serve("GET", "/dashboards/web/export", "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Disposition")).To(ContainSubstring("web.json"))
+		exported := w.Body.String()
+
+		Expect(serve("POST", "/dashboards/import", exported).Code).To(Equal(http.StatusConflict))
+		Expect(serve("POST", "/dashboards/import?overwrite=true", exported).Code).To(Equal(http.StatusCreated))
+	})
+
+	It("should reject bad requests", func() {
+		Expect(serve("POST", "/dashboards", `{"range": "1h"}`).Code).To(Equal(http.StatusBadRequest))
+		Expect(serve("GET", "/dashboards/web?version=latest", "").Code).To(Equal(http.StatusBadRequest))
+		Expect(serve("PATCH", "/dashboards/web", "").Code).To(Equal(http.StatusNotFound))
+		Expect(serve("GET", "/dashboards/web/graphs", "").Code).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,63 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/repo"
+)
+
+// repoStore saves dashboards in Cassandra, their definitions as JSON
+type repoStore struct{}
+
+// Repo is the Store of the dashboards saved in Cassandra
+var Repo Store = repoStore{}
+
+func (repoStore) List() ([]Summary, error) {
+	versions, err := repo.ListDashboards()
+	if err != nil {
+		return nil, err
+	}
+	return summaries(versions), nil
+}
+
+func (repoStore) Get(id string, version int) (*Dashboard, error) {
+	v, err := repo.GetDashboard(id, version)
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	d := &Dashboard{ID: v.ID, Version: v.Version, Saved: v.Saved.UTC()}
+	if err := json.Unmarshal([]byte(v.Body), &d.Definition); err != nil {
+		return nil, fmt.Errorf("dashboard: version %d of %s is corrupt: %v", v.Version, v.ID, err)
+	}
+	return d, nil
+}
+
+func (repoStore) Versions(id string) ([]Summary, error) {
+	versions, err := repo.GetDashboardVersions(id)
+	if err != nil {
+		return nil, err
+	}
+	return summaries(versions), nil
+}
+
+func (repoStore) Put(d *Dashboard) (bool, error) {
+	body, err := json.Marshal(d.Definition)
+	if err != nil {
+		return false, err
+	}
+	return repo.InsertDashboardVersion(repo.DashboardVersion{ID: d.ID, Version: d.Version, Title: d.Title, Body: string(body), Saved: d.Saved})
+}
+
+func (repoStore) Delete(id string) error {
+	return repo.DeleteDashboard(id)
+}
+
+// summaries summarizes versions of dashboards read without their bodies
+func summaries(versions []repo.DashboardVersion) []Summary {
+	s := make([]Summary, 0, len(versions))
+	for _, v := range versions {
+		s = append(s, Summary{ID: v.ID, Version: v.Version, Title: v.Title, Saved: v.Saved.UTC()})
+	}
+	return s
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/config"
+	"github.com/CapillarySoftware/gostat/export"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"io"
+	"os"
+)
+
+// runExport runs `gostat export`, which writes the raw stats or aggregates of a
+// series to a file or stdout, returning the exit status
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("gostat export", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("GOSTAT_CONFIG"), "YAML configuration file (GOSTAT_CONFIG)")
+	name := fs.String("name", "", "name of the stat exported")
+	start := fs.String("start", "", "start of the stats exported, RFC 3339 or seconds since the epoch")
+	end := fs.String("end", "", "end of the stats exported, which is not included, RFC 3339 or seconds since the epoch")
+	resolution := fs.String("resolution", export.Raw, "raw, or the resolution of the aggregates exported: 1m, 1h or 1d")
+	format := fs.String("format", export.CSV, "csv, jsonl or protobuf")
+	output := fs.String("output", "", "file written, or empty for stdout")
+	flags := config.DefineFlags(fs)
+	fs.Parse(args)
+
+	req := &export.Request{Name: *name, Resolution: *resolution, Format: *format}
+	var err error
+	if req.Start, err = stat.ParseTime(*start); err != nil {
+		fmt.Fprintln(os.Stderr, "export: -start:", err)
+		return 2
+	}
+	if req.End, err = stat.ParseTime(*end); err != nil {
+		fmt.Fprintln(os.Stderr, "export: -end:", err)
+		return 2
+	}
+	if err := req.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	conf, err := config.Load(*configPath, flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := configureLogging(conf); err != nil {
+		fmt.Fprintln(os.Stderr, "error configuring logging:", err)
+		return 1
+	}
+	defer log.Flush()
+	if *output == "" && conf.Log.Config == "" {
+		// keep the log out of the stats written to stdout
+		level, _ := log.LogLevelFromString(conf.Log.Level) // validated by config.Load
+		if logger, err := log.LoggerFromWriterWithMinLevel(os.Stderr, level); err == nil {
+			log.ReplaceLogger(logger)
+		}
+	}
+	configureRepo(conf)
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Error("export: ", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	buffered := bufio.NewWriter(out)
+
+	w, err := export.NewWriter(req.Format, buffered)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	n, err := export.Export(export.Repo, req, w)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		log.Error("export: ", err)
+		return 1
+	}
+	log.Infof("export: exported %d stats of %s", n, req.Name)
+	return 0
+}
@@ -0,0 +1,82 @@
+// Package export writes the raw stats or aggregates of a series in bulk, for
+// offline analysis
+package export
+
+import (
+	"fmt"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/importer"
+	"github.com/CapillarySoftware/gostat/repo"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"time"
+)
+
+// Raw is the resolution of raw stats. The other resolutions are those of the
+// aggregates computed by gostat import, and stored by gostat as it aggregates
+// the series it receives
+const Raw = "raw"
+
+// Source is where exported stats are read from
+type Source interface {
+	ScanRawStats(name string, start, end time.Time, f func(stat.Stat) error) error
+	ScanAggregates(name, resolution string, start, end time.Time, f func(aggregator.BucketAggregate) error) error
+}
+
+type repoSource struct{}
+
+func (repoSource) ScanRawStats(name string, start, end time.Time, f func(stat.Stat) error) error {
+	return repo.ScanRawStats(name, start, end, f)
+}
+
+func (repoSource) ScanAggregates(name, resolution string, start, end time.Time, f func(aggregator.BucketAggregate) error) error {
+	return repo.ScanAggregates(name, resolution, start, end, f)
+}
+
+// Repo is the Source of the stats stored in Cassandra
+var Repo Source = repoSource{}
+
+// Request describes an export
+type Request struct {
+	Name       string
+	Resolution string    // Raw, or the resolution of aggregates, e.g. 1m
+	Start, End time.Time // the stats from Start up to, but not including, End are exported
+	Format     string
+}
+
+// Validate checks that the request can be exported
+func (r *Request) Validate() error {
+	switch {
+	case r.Name == "":
+		return fmt.Errorf("export: a name is required")
+	case r.Resolution != Raw && r.Resolution != importer.Minute && r.Resolution != importer.Hour && r.Resolution != importer.Day:
+		return fmt.Errorf("export: unknown resolution %q, use raw, 1m, 1h or 1d", r.Resolution)
+	case !r.Start.Before(r.End):
+		return fmt.Errorf("export: the start must be before the end")
+	case r.Format == Protobuf && r.Resolution != Raw:
+		return ErrRawOnly
+	}
+	return nil
+}
+
+// Export writes the stats requested from src to w, a page at a time, returning
+// how many were written
+func Export(src Source, r *Request, w Writer) (n int, err error) {
+	log.Debugf("export: exporting %+v", *r)
+
+	if r.Resolution == Raw {
+		err = src.ScanRawStats(r.Name, r.Start, r.End, func(s stat.Stat) error {
+			n++
+			return w.WriteStat(s)
+		})
+	} else {
+		err = src.ScanAggregates(r.Name, r.Resolution, r.Start, r.End, func(a aggregator.BucketAggregate) error {
+			n++
+			return w.WriteAggregate(a)
+		})
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, w.Flush()
+}
@@ -0,0 +1,114 @@
+package export
+
+import (
+	"errors"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+// fakeSource serves stats and aggregates from memory, failing after failAfter
+// rows if it is positive
+type fakeSource struct {
+	stats      []stat.Stat
+	aggregates []aggregator.BucketAggregate
+	failAfter  int
+}
+
+func (f *fakeSource) ScanRawStats(name string, start, end time.Time, fn func(stat.Stat) error) error {
+	for i, s := range f.stats {
+		if f.failAfter > 0 && i == f.failAfter {
+			return errors.New("unavailable")
+		}
+		if s.Name == name && !s.Timestamp.Before(start) && s.Timestamp.Before(end) {
+			if err := fn(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *fakeSource) ScanAggregates(name, resolution string, start, end time.Time, fn func(aggregator.BucketAggregate) error) error {
+	for _, a := range f.aggregates {
+		if a.Name == name && !a.Time.Before(start) && a.Time.Before(end) {
+			if err := fn(a); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var _ = Describe("Export", func() {
+	t0 := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+	var src *fakeSource
+
+	BeforeEach(func() {
+		src = &fakeSource{
+			stats: []stat.Stat{
+				{Name: "foo", Timestamp: t0, Value: 1},
+				{Name: "bar", Timestamp: t0, Value: 2},
+				{Name: "foo", Timestamp: t0.Add(time.Minute), Value: 3},
+			},
+			aggregates: []aggregator.BucketAggregate{
+				{Name: "foo", Time: t0.Truncate(time.Minute), StatsAggregate: aggregator.StatsAggregate{Average: 1, Min: 1, Max: 1, Count: 1}},
+			},
+		}
+	})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		Handler(src).ServeHTTP(w, httptest.NewRequest("GET", "/export?"+query, nil))
+		return w
+	}
+
+	Describe("Request", func() {
+		It("should validate the request", func() {
+			valid := Request{Name: "foo", Resolution: Raw, Start: t0, End: t0.Add(time.Hour), Format: CSV}
+			Expect(valid.Validate()).To(Succeed())
+
+			r := valid
+			r.Resolution = "5m"
+			Expect(r.Validate()).NotTo(Succeed())
+
+			r = valid
+			r.End = r.Start
+			Expect(r.Validate()).NotTo(Succeed())
+
+			r = valid
+			r.Resolution, r.Format = "1m", Protobuf
+			Expect(r.Validate()).To(Equal(ErrRawOnly))
+		})
+	})
+
+	Describe("Handler", func() {
+		It("should stream the raw stats of the series in the range", func() {
+			w := get("name=foo&start=2015-01-02T03:00:00Z&end=1420168000")
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("Content-Type")).To(Equal("text/csv"))
+			Expect(w.Body.String()).To(Equal("name,timestamp,value\nfoo,2015-01-02T03:04:05Z,1\nfoo,2015-01-02T03:05:05Z,3\n"))
+		})
+
+		It("should export aggregates", func() {
+			w := get("name=foo&start=2015-01-02T03:00:00Z&end=2015-01-02T04:00:00Z&resolution=1m&format=jsonl")
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Body.String()).To(Equal(`{"name":"foo","timestamp":"2015-01-02T03:04:00Z","average":1,"min":1,"max":1,"count":1}` + "\n"))
+		})
+
+		It("should reject a bad request", func() {
+			Expect(get("start=2015-01-02T03:00:00Z&end=2015-01-02T04:00:00Z").Code).To(Equal(http.StatusBadRequest))
+			Expect(get("name=foo&start=yesterday&end=2015-01-02T04:00:00Z").Code).To(Equal(http.StatusBadRequest))
+			Expect(get("name=foo&start=2015-01-02T03:00:00Z&end=2015-01-02T04:00:00Z&format=parquet").Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should report an error before anything is sent", func() {
+			src.failAfter = 1
+			Expect(get("name=foo&start=2015-01-02T03:00:00Z&end=2015-01-02T04:00:00Z").Code).To(Equal(http.StatusInternalServerError))
+		})
+	})
+})
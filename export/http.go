@@ -0,0 +1,92 @@
+package export
+
+import (
+	"fmt"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"net/http"
+	"time"
+)
+
+// Handler serves GET /export?name=...&start=...&end=...&resolution=...&format=...,
+// streaming the stats from src as they are read. start and end are RFC 3339 or
+// seconds since the epoch, resolution defaults to raw and format to csv
+func Handler(src Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := parseRequest(r)
+		if err == nil {
+			err = req.Validate()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		out := &responseWriter{ResponseWriter: w}
+		writer, err := NewWriter(req.Format, out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType(req.Format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", req.Name+"."+req.Format))
+
+		n, err := Export(src, req, writer)
+		if err != nil {
+			log.Error("export: error exporting ", req.Name, ": ", err)
+			if !out.written {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			// part of the export has been sent, so break the connection rather
+			// than let the client take it for the whole
+			panic(http.ErrAbortHandler)
+		}
+		log.Debugf("export: exported %d stats of %s", n, req.Name)
+	})
+}
+
+// responseWriter records whether anything has been sent
+type responseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(p)
+}
+
+func parseRequest(r *http.Request) (*Request, error) {
+	req := &Request{
+		Name:       r.FormValue("name"),
+		Resolution: r.FormValue("resolution"),
+		Format:     r.FormValue("format"),
+	}
+	if req.Resolution == "" {
+		req.Resolution = Raw
+	}
+	if req.Format == "" {
+		req.Format = CSV
+	}
+
+	var err error
+	if req.Start, err = parseTime("start", r.FormValue("start")); err != nil {
+		return nil, err
+	}
+	if req.End, err = parseTime("end", r.FormValue("end")); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func parseTime(param, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("export: %s is required", param)
+	}
+	t, err := stat.ParseTime(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("export: invalid %s: %v", param, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,185 @@
+package export
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/protoStat"
+	"github.com/CapillarySoftware/gostat/stat"
+	"io"
+	"strconv"
+	"time"
+)
+
+// The formats stats are exported in. CSV, JSON lines and protobuf exports of
+// raw stats can be imported by gostat import
+const (
+	CSV       = "csv"      // name,timestamp,value records, or name,timestamp,average,min,max,count for aggregates, after a header
+	JSONLines = "jsonl"    // one JSON object per line
+	Protobuf  = "protobuf" // protoStats messages, each preceded by its length as a uvarint, of raw stats only
+	Parquet   = "parquet"  // not supported, since gostat has no Parquet encoder
+)
+
+// ErrRawOnly is returned when aggregates are written in a format that can only
+// hold raw stats
+var ErrRawOnly = errors.New("export: protobuf can only hold raw stats")
+
+// Writer writes exported stats or aggregates in a format
+type Writer interface {
+	WriteStat(s stat.Stat) error
+	WriteAggregate(a aggregator.BucketAggregate) error
+
+	// Flush writes anything buffered
+	Flush() error
+}
+
+// NewWriter returns a Writer of the format writing to w
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case CSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case JSONLines:
+		b := bufio.NewWriter(w)
+		return &jsonWriter{w: b, e: json.NewEncoder(b)}, nil
+	case Protobuf:
+		return &protoWriter{w: bufio.NewWriter(w)}, nil
+	case Parquet:
+		return nil, fmt.Errorf("export: parquet is not supported, export csv and convert it")
+	}
+	return nil, fmt.Errorf("export: unknown format %q", format)
+}
+
+// ContentType returns the MIME type of the format
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv"
+	case JSONLines:
+		return "application/x-ndjson"
+	}
+	return "application/octet-stream"
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool // whether the header has been written
+}
+
+func (c *csvWriter) WriteStat(s stat.Stat) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write([]string{"name", "timestamp", "value"}); err != nil {
+			return err
+		}
+	}
+	return c.w.Write([]string{s.Name, formatTime(s.Timestamp), formatFloat(s.Value)})
+}
+
+func (c *csvWriter) WriteAggregate(a aggregator.BucketAggregate) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write([]string{"name", "timestamp", "average", "min", "max", "count"}); err != nil {
+			return err
+		}
+	}
+	return c.w.Write([]string{a.Name, formatTime(a.Time), formatFloat(a.Average), formatFloat(a.Min), formatFloat(a.Max), strconv.Itoa(a.Count)})
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonWriter struct {
+	w *bufio.Writer
+	e *json.Encoder
+}
+
+type jsonStat struct {
+	Name      string  `json:"name"`
+	Timestamp string  `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type jsonAggregate struct {
+	Name      string  `json:"name"`
+	Timestamp string  `json:"timestamp"`
+	Average   float64 `json:"average"`
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Count     int     `json:"count"`
+}
+
+func (j *jsonWriter) WriteStat(s stat.Stat) error {
+	return j.e.Encode(jsonStat{s.Name, formatTime(s.Timestamp), s.Value})
+}
+
+func (j *jsonWriter) WriteAggregate(a aggregator.BucketAggregate) error {
+	return j.e.Encode(jsonAggregate{a.Name, formatTime(a.Time), a.Average, a.Min, a.Max, a.Count})
+}
+
+func (j *jsonWriter) Flush() error {
+	return j.w.Flush()
+}
+
+// protoWriter writes a message for each run of stats with the same timestamp,
+// since a message has a single timestamp
+type protoWriter struct {
+	w       *bufio.Writer
+	pending protoStat.ProtoStats
+}
+
+func (p *protoWriter) WriteStat(s stat.Stat) error {
+	t := s.Timestamp.UnixNano()
+	if p.pending.TimeNano != nil && *p.pending.TimeNano != t {
+		if err := p.writeMessage(); err != nil {
+			return err
+		}
+	}
+	if p.pending.TimeNano == nil {
+		p.pending.TimeNano = &t
+	}
+	name, value := s.Name, s.Value
+	p.pending.Stats = append(p.pending.Stats, &protoStat.ProtoStat{Key: &name, Value: &value})
+	return nil
+}
+
+func (p *protoWriter) WriteAggregate(a aggregator.BucketAggregate) error {
+	return ErrRawOnly
+}
+
+func (p *protoWriter) writeMessage() error {
+	msg, err := p.pending.Marshal()
+	if err != nil {
+		return err
+	}
+	p.pending = protoStat.ProtoStats{}
+
+	size := make([]byte, binary.MaxVarintLen64)
+	if _, err := p.w.Write(size[:binary.PutUvarint(size, uint64(len(msg)))]); err != nil {
+		return err
+	}
+	_, err = p.w.Write(msg)
+	return err
+}
+
+func (p *protoWriter) Flush() error {
+	if p.pending.TimeNano != nil {
+		if err := p.writeMessage(); err != nil {
+			return err
+		}
+	}
+	return p.w.Flush()
+}
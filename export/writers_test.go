@@ -0,0 +1,86 @@
+package export
+
+import (
+	"bytes"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/importer"
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io"
+	"time"
+)
+
+// reimport reads back an export with gostat import's readers
+func reimport(format string, data []byte) []*stat.Stat {
+	r, err := importer.NewReader(format, bytes.NewReader(data), 0)
+	Expect(err).NotTo(HaveOccurred())
+
+	var stats []*stat.Stat
+	for {
+		s, err := r.Next()
+		if err == io.EOF {
+			return stats
+		}
+		Expect(err).NotTo(HaveOccurred())
+		stats = append(stats, s)
+	}
+}
+
+var _ = Describe("Writers", func() {
+	t0 := time.Date(2015, 1, 2, 3, 4, 5, 250000000, time.UTC)
+	stats := []*stat.Stat{
+		{Name: "foo", Timestamp: t0, Value: 1.5},
+		{Name: "foo", Timestamp: t0, Value: 2},
+		{Name: "foo", Timestamp: t0.Add(time.Second), Value: 3},
+	}
+
+	export := func(format string) []byte {
+		var buf bytes.Buffer
+		w, err := NewWriter(format, &buf)
+		Expect(err).NotTo(HaveOccurred())
+		for _, s := range stats {
+			Expect(w.WriteStat(*s)).To(Succeed())
+		}
+		Expect(w.Flush()).To(Succeed())
+		return buf.Bytes()
+	}
+
+	It("should write raw stats as CSV", func() {
+		Expect(string(export(CSV))).To(Equal("name,timestamp,value\n" +
+			"foo,2015-01-02T03:04:05.25Z,1.5\n" +
+			"foo,2015-01-02T03:04:05.25Z,2\n" +
+			"foo,2015-01-02T03:04:06.25Z,3\n"))
+	})
+
+	It("should write raw stats as JSON lines", func() {
+		Expect(string(export(JSONLines))).To(HavePrefix(`{"name":"foo","timestamp":"2015-01-02T03:04:05.25Z","value":1.5}` + "\n"))
+	})
+
+	for _, format := range []string{CSV, JSONLines, Protobuf} {
+		format := format
+		It("should write raw stats that can be imported as "+format, func() {
+			importFormat := map[string]string{CSV: importer.CSV, JSONLines: importer.JSONLines, Protobuf: importer.ProtoStat}[format]
+			Expect(reimport(importFormat, export(format))).To(Equal(stats))
+		})
+	}
+
+	It("should write aggregates as CSV", func() {
+		var buf bytes.Buffer
+		w, _ := NewWriter(CSV, &buf)
+		Expect(w.WriteAggregate(aggregator.BucketAggregate{Name: "foo", Time: t0.Truncate(time.Minute),
+			StatsAggregate: aggregator.StatsAggregate{Average: 2, Min: 1, Max: 3, Count: 4}})).To(Succeed())
+		Expect(w.Flush()).To(Succeed())
+		Expect(buf.String()).To(Equal("name,timestamp,average,min,max,count\nfoo,2015-01-02T03:04:00Z,2,1,3,4\n"))
+	})
+
+	It("should not write aggregates as protobuf", func() {
+		w, _ := NewWriter(Protobuf, &bytes.Buffer{})
+		Expect(w.WriteAggregate(aggregator.BucketAggregate{Name: "foo"})).To(Equal(ErrRawOnly))
+	})
+
+	It("should reject parquet", func() {
+		_, err := NewWriter(Parquet, &bytes.Buffer{})
+		Expect(err).To(MatchError(ContainSubstring("not supported")))
+	})
+})
@@ -1,105 +1,678 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"github.com/CapillarySoftware/gostat/admin"
 	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/alert"
+	"github.com/CapillarySoftware/gostat/anomaly"
 	"github.com/CapillarySoftware/gostat/bucketer"
+	"github.com/CapillarySoftware/gostat/cluster"
+	"github.com/CapillarySoftware/gostat/config"
+	"github.com/CapillarySoftware/gostat/metrics"
+	"github.com/CapillarySoftware/gostat/protoStat"
+	"github.com/CapillarySoftware/gostat/queue"
+	"github.com/CapillarySoftware/gostat/relay"
 	"github.com/CapillarySoftware/gostat/repo"
 	"github.com/CapillarySoftware/gostat/socketApi"
 	"github.com/CapillarySoftware/gostat/stat"
+	"github.com/CapillarySoftware/gostat/wal"
+	"github.com/CapillarySoftware/gostat/writemode"
 	log "github.com/cihub/seelog"
+	"github.com/gocql/gocql"
 	nano "github.com/op/go-nanomsg"
 	"math/rand"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 func main() {
-	simulateData := flag.Bool("sim", false, "randomly generate and insert test data")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		}
+	}
+
+	configPath := flag.String("config", os.Getenv("GOSTAT_CONFIG"), "YAML configuration file (GOSTAT_CONFIG)")
+	checkConfig := flag.Bool("check-config", false, "check the configuration, print the resulting settings and exit")
+	flags := config.DefineFlags(flag.CommandLine)
 	flag.Parse()
 
-	go socketApi.SocketApiServer()
-
-	stats := make(chan *stat.Stat)           // stats received from producers
-	rawStats := make(chan *stat.Stat)        // raw stats to be archived
-	bucketedStats := make(chan []*stat.Stat) // raw bucketed (non-aggregated) stats are output here
-	shutdownBucketer := make(chan bool)      // used to signal the bucketer we are done
-	shutdownListener := make(chan bool)      // used to signal the socket listener we are done
-	shutdownAggregator := make(chan bool)    // used to signal the aggregator we are done
-	shutdownStatRepo := make(chan bool)      // used to signal the stat repo we are done
-
-	installCtrlCHandler(shutdownBucketer, shutdownListener, shutdownAggregator, shutdownStatRepo)
-
-	// create and start a Bucketer
-	b := bucketer.NewBucketer(stats, bucketedStats, shutdownBucketer)
-	go b.Run(time.Second * 5)
-
-	// create and start a stat repo
-	r := repo.NewStatRepo(rawStats, shutdownStatRepo)
-	go r.Run()
-
-	// TODO: replace this simulation of an Aggregator with a real one
-	go func(bucketed <-chan []*stat.Stat, shutdown <-chan bool) {
-		for {
-			select {
-			case bucket := <-bucketed:
-				log.Debugf("aggregator simulation got stats bucket with length %d", len(bucket))
-				for _, stat := range bucket {
-					log.Debugf("aggregator sim: %#v", stat)
-				}
-				a := aggregator.Aggregate(bucket)
-				log.Debugf("aggregator sim aggregate: %#v", a)
-			case <-shutdown:
-				log.Info("aggregator simulation shutting down")
-				break
+	conf, err := config.Load(*configPath, flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *checkConfig {
+		fmt.Print(conf)
+		return
+	}
+
+	if err := configureLogging(conf); err != nil {
+		fmt.Fprintln(os.Stderr, "error configuring logging:", err)
+		os.Exit(1)
+	}
+	defer log.Flush()
+
+	configureRepo(conf)
+	if conf.Mode != config.RelayMode {
+		checkWriteModes()
+	}
+
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	policy, _ := queue.ParsePolicy(conf.Queues.Policy) // validated by config.Load
+	queueSize := conf.Queues.Size
+
+	// bounded queues between the stages of the pipeline
+	accepted, acceptedStage := startQueue[*stat.Stat]("accepted", queueSize, policy)        // stats received from producers
+	stats, statsStage := startQueue[*stat.Stat]("stats", queueSize, policy)                 // stats to be bucketed
+	bucketedStats, bucketedStage := startQueue[[]*stat.Stat]("bucketed", queueSize, policy) // raw bucketed (non-aggregated) stats are output here
+
+	// the listeners, the simulator and anything else feeding stats into the
+	// pipeline stop when ingestCtx is cancelled
+	ingestCtx, stopIngest := context.WithCancel(context.Background())
+	var ingest sync.WaitGroup
+
+	// the stages of the pipeline, in the order they are shut down so that each
+	// drains into the stages after it
+	writerStage := newStage("wal Writer")
+	bucketerStage := newStage("Bucketer")
+	aggregatorStage := newStage("Aggregator")
+	stages := []*stage{acceptedStage, writerStage, statsStage}
+
+	// stats are archived in Cassandra, except by a relay, which sends per-minute
+	// aggregates upstream instead
+	relayMode := conf.Mode == config.RelayMode
+	var rawStats *queue.Queue[*stat.Stat]
+	logged := []chan<- *stat.Stat{stats.In()}
+	if !relayMode {
+		var rawStatsStage *stage
+		rawStats, rawStatsStage = startQueue[*stat.Stat]("raw", queueSize, policy) // raw stats to be archived
+		stages = append(stages, rawStatsStage)
+		logged = append(logged, rawStats.In())
+	}
+	stages = append(stages, bucketerStage, bucketedStage, aggregatorStage)
+
+	// in a cluster, stats received from producers are routed to the nodes owning
+	// them, and stats forwarded by other nodes are accepted as they are
+	received := accepted.In()
+	if conf.Cluster.Node != "" {
+		receivedQ, receivedStage := startQueue[*stat.Stat]("received", queueSize, policy)
+		routerStage, membershipStage := newStage("cluster Router"), newStage("cluster Membership")
+		stages = append([]*stage{receivedStage, routerStage}, stages...)
+		stages = append(stages, membershipStage)
+		startCluster(conf, receivedQ.Out(), accepted.In(), routerStage, membershipStage)
+		received = receivedQ.In()
+
+		ingest.Add(1)
+		go func() {
+			defer ingest.Done()
+			bindSocketListener(ingestCtx, "cluster", conf.Cluster.Node, &clusterBound, receiveStats(accepted.In()))
+		}()
+	}
+
+	// a relay does not log stats, since it spools its aggregates instead
+	var walLog *wal.Log
+	if conf.WAL.Dir != "" && !relayMode {
+		if walLog, err = wal.Open(conf.WAL.Dir); err != nil {
+			log.Critical("error opening the write-ahead log: ", err)
+			log.Flush()
+			os.Exit(1)
+		}
+	}
+
+	// create an Aggregator, feeding the alert engine if there are rules, and the
+	// anomaly detector if it is enabled
+	var aggregates []chan<- *aggregator.BucketAggregate
+	var finalized []func(before time.Time) // told the time before which the Bucketer has finalized every minute
+	var engine *alert.Engine
+	if conf.Alerts.Rules != "" {
+		alerts, alertsStage := startQueue[*aggregator.BucketAggregate]("alerts", queueSize, policy)
+		engineStage, dispatcherStage := newStage("alert Engine"), newStage("alert Dispatcher")
+		stages = append(stages, alertsStage, engineStage, dispatcherStage)
+		engine = startAlertEngine(conf.Alerts.Rules, conf.Alerts.State, alerts.Out(), engineStage, dispatcherStage)
+		finalized = append(finalized, engine.Finalized)
+		aggregates = append(aggregates, alerts.In())
+	}
+	var detector *anomaly.Detector
+	var metaStats <-chan *stat.Stat
+	var anomalyIn chan<- *aggregator.BucketAggregate
+	if conf.Anomaly.Enabled {
+		anomalies, anomaliesStage := startQueue[*aggregator.BucketAggregate]("anomaly", queueSize, policy)
+		detectorStage := newStage("anomaly Detector")
+		stages = append(stages, anomaliesStage, detectorStage)
+		params := anomaly.DefaultParams
+		params.Season = conf.Anomaly.Season
+		detector, metaStats = startAnomalyDetector(params, conf.Anomaly.Baselines, anomalies.Out(), detectorStage)
+		if engine != nil {
+			detector.OnScored(engine.Await()) // so that the alert rules see the meta stats
+		}
+		finalized = append(finalized, detector.Finalized)
+		aggregates = append(aggregates, anomalies.In())
+		anomalyIn = anomalies.In()
+	}
+
+	// the aggregates of the series are archived in Cassandra alongside their raw
+	// stats, and rolled up by the hour and day
+	if !relayMode {
+		archived, archivedStage := startQueue[*aggregator.BucketAggregate]("aggregates", queueSize, policy)
+		aggregateRepoStage := newStage("AggregateRepo")
+		stages = append(stages, archivedStage, aggregateRepoStage)
+		ar := repo.NewAggregateRepo(archived.Out(), aggregateRepoStage.shutdown)
+		aggregateRepoStage.run(ar.Run)
+		finalized = append(finalized, ar.Finalized)
+		aggregates = append(aggregates, archived.In())
+	}
+
+	// merge the aggregates received from relays, which are shut down before the
+	// stages they feed, and which alerting and anomaly detection wait for
+	var merger *relay.Merger
+	if conf.Relay.Listen != "" {
+		batches, batchesStage := startQueue[*relay.Batch]("relayed", queueSize, policy)
+		mergerStage := newStage("relay Merger")
+		stages = append([]*stage{batchesStage, mergerStage}, stages...)
+		m, err := relay.NewMerger(conf.Relay.Seqs, batches.Out(), rawStats.In(), mergerStage.shutdown, aggregates...)
+		if err != nil {
+			log.Flush()
+			os.Exit(1)
+		}
+		if engine != nil {
+			m.OnMerged(engine.Await())
+		}
+		if detector != nil {
+			m.OnMerged(detector.Await())
+		}
+		mergerStage.run(m.Run)
+		merger = m
+
+		ingest.Add(1)
+		go func() {
+			defer ingest.Done()
+			bindSocketListener(ingestCtx, "relay", conf.Relay.Listen, &relayBound, receiveBatches(batches.In()))
+		}()
+	}
+
+	// a relay sends the aggregates upstream
+	var rel *relay.Relay
+	if relayMode {
+		relayed, relayedStage := startQueue[*aggregator.BucketAggregate]("relay", queueSize, policy)
+		relayStage := newStage("Relay")
+		stages = append(stages, relayedStage, relayStage)
+		rel = startRelay(conf, relayed.Out(), relayStage)
+		aggregates = append(aggregates, relayed.In())
+	}
+
+	// the anomaly Detector's meta stats are stored, and their aggregates fed to
+	// the stages the Aggregator feeds, other than the Detector itself
+	if metaStats != nil {
+		var archive chan<- *stat.Stat
+		if rawStats != nil {
+			archive = rawStats.In()
+		}
+		var outputs []chan<- *aggregator.BucketAggregate
+		for _, out := range aggregates {
+			if out != anomalyIn {
+				outputs = append(outputs, out)
 			}
 		}
-	}(bucketedStats, shutdownAggregator)
+		ingest.Add(1)
+		go func() {
+			defer ingest.Done()
+			forwardMetaStats(ingestCtx, metaStats, archive, outputs)
+		}()
+	}
+
+	a := aggregator.NewAggregator(bucketedStats.Out(), aggregatorStage.shutdown, aggregates...)
+	aggregatorStage.run(a.Run)
+
+	// create and start a pool of Bucketers
+	b := bucketer.NewPool(conf.Bucketer.Workers, stats.Out(), bucketedStats.In(), bucketerStage.shutdown)
+	b.WriteModes(writeModes(conf))
+	switch {
+	case rel != nil:
+		finalized = append(finalized, rel.Finalized)
+	case walLog != nil:
+		finalized = append(finalized, walLog.Finalized)
+	}
+	for _, f := range finalized {
+		b.OnFinalized(f)
+	}
+	bucketerStage.run(func() { b.Run(conf.Bucketer.PublishInterval) })
+
+	// create and start a stat repo, which is shut down last since everything
+	// upstream may still be archiving stats
+	if !relayMode {
+		statRepoStage := newStage("StatRepo")
+		stages = append(stages, statRepoStage)
+
+		// the stats stored are published to the dashboards following their
+		// series through a queue dropping the oldest, so that a slow socket
+		// cannot hold up storing them
+		published, publishedStage := startQueue[*stat.Stat]("live", queueSize, queue.DropOldest)
+		publisherStage := newStage("live Publisher")
+		stages = append(stages, publishedStage, publisherStage)
+		publisherStage.run(func() { publishLive(published.Out(), publisherStage.shutdown) })
+
+		r := repo.NewStatRepo(rawStats.Out(), statRepoStage.shutdown)
+		// so that a replayed or merged stat replaces itself
+		r.Origins(func(s *stat.Stat) (string, bool) {
+			if merger != nil {
+				if origin, ok := merger.Origin(s); ok {
+					return origin, true
+				}
+			}
+			if walLog != nil {
+				return walLog.Origin(s)
+			}
+			return "", false
+		})
+		r.OnPersisted(func(s *stat.Stat) {
+			if walLog != nil {
+				walLog.Persisted(s)
+			}
+			published.In() <- s
+		})
+		statRepoStage.run(r.Run)
+	}
+
+	// create and start a wal Writer, which replays the stats left by the last run
+	// and then logs every accepted stat before it is bucketed and archived
+	w := wal.NewWriter(walLog, conf.WAL.Sync, accepted.Out(), writerStage.shutdown, logged...)
+	writerStage.run(w.Run)
+
+	// record gostat's own metrics as stats
+	metrics.RegisterRuntime(metrics.DefaultRegistry)
+	ingest.Add(1)
+	go func() {
+		defer ingest.Done()
+		reportMetrics(ingestCtx, conf.Metrics.Interval, accepted.In())
+	}()
+
+	// serve health and readiness endpoints alongside the query API, and the admin
+	// endpoints on their own address
+	startAdmin(ctx, conf, b)
+	socketApi.MaxPoints = conf.HTTP.MaxPoints
+	go socketApi.SocketApiServer(ctx, conf.HTTP.Address, conf.HTTP.Assets)
 
 	// start a socket listener
-	go bindSocketListener(stats, shutdownListener)
+	ingest.Add(1)
+	go func() {
+		defer ingest.Done()
+		bindSocketListener(ingestCtx, "nanomsg", conf.Listener.Address, &listenerBound, receiveStats(received))
+	}()
 
 	// TODO: remove this simulation of stats when the socket listener is really processing data
-	for true {
-		<-time.After(time.Second * time.Duration(rand.Intn(3))) // sleep 0-3 seconds
+	if conf.Sim {
+		ingest.Add(1)
+		go func() {
+			defer ingest.Done()
+			simulate(ingestCtx, received)
+		}()
+	}
 
-		if *simulateData {
-			// create a stat randomly named "stat1 ... stat10" with a random value between 1-100
-			stat := stat.Stat{Name: fmt.Sprintf("stat%v", (rand.Intn(9) + 1)), Timestamp: time.Now().UTC(), Value: float64(rand.Intn(99) + 1)}
-			log.Debug("Generated simulated stat: ", stat)
-			stats <- &stat    // send it to the Bucketer
-			rawStats <- &stat // for archiving
-		}
+	<-ctx.Done()
+	stopSignals() // a second signal kills the process without waiting
+	log.Infof("stopping stats collection, waiting up to %v for a clean shutdown...", conf.ShutdownTimeout)
+
+	deadline, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+
+	if err := shutdownPipeline(deadline, stopIngest, &ingest, stages); err != nil {
+		log.Critical("error shutting down: ", err)
+		log.Flush()
+		os.Exit(1)
+	}
+	if walLog != nil {
+		walLog.Drained() // nothing persisted needs replaying after a clean shutdown
 	}
+
+	log.Info("Done")
 }
 
-// installCtrlCHandler starts a goroutine that will signal the workers when it's time
-// to shut down
-func installCtrlCHandler(shutdown ...chan<- bool) {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+// configureLogging replaces the default logger with one logging at the
+// configured level, or configured by a seelog configuration file
+func configureLogging(c *config.Config) error {
+	var logger log.LoggerInterface
+	var err error
 
-	go func() {
-		for sig := range c {
-			log.Infof(" captured %v, stopping stats collection and exiting...\n", sig)
-			for _, s := range shutdown {
-				s <- true
+	if c.Log.Config != "" {
+		logger, err = log.LoggerFromConfigAsFile(c.Log.Config)
+	} else {
+		logger, err = log.LoggerFromConfigAsString(fmt.Sprintf(`<seelog minlevel="%s"></seelog>`, c.Log.Level))
+	}
+	if err != nil {
+		return err
+	}
+	return log.ReplaceLogger(logger)
+}
+
+// configureRepo points the repo at the configured Cassandra cluster
+func configureRepo(c *config.Config) {
+	consistency, _ := gocql.ParseConsistencyWrapper(c.Cassandra.Consistency) // validated by config.Load
+	repo.Configure(repo.Settings{
+		Hosts:       c.Cassandra.Hosts,
+		Keyspace:    c.Cassandra.Keyspace,
+		Consistency: consistency,
+		Timeout:     c.Cassandra.Timeout,
+		Writes:      writeModes(c),
+	})
+}
+
+// checkWriteModes exits if a series has raw stats in the table of a write mode
+// other than its own, after its mode was changed, since they would no longer be
+// read. The check is skipped if Cassandra cannot be queried
+func checkWriteModes() {
+	misplaced, err := repo.MisplacedSeries()
+	if err != nil {
+		log.Warn("could not check the tables of the series against their write modes: ", err)
+		return
+	}
+	if len(misplaced) == 0 {
+		return
+	}
+
+	shown := misplaced
+	if len(shown) > 10 {
+		shown = shown[:10]
+	}
+	log.Criticalf("%d series have raw stats in the table of another write mode, such as %s: restore their writes.modes, or move them with gostat migrate move-series",
+		len(misplaced), strings.Join(shown, ", "))
+	log.Flush()
+	os.Exit(1)
+}
+
+// writeModes returns the write mode rules of the series
+func writeModes(c *config.Config) *writemode.Rules {
+	rules, _ := writemode.ParseRules(c.Writes.Modes, c.Writes.Default) // validated by config.Load
+	return rules
+}
+
+// startAdmin registers the health and readiness endpoints, and serves the admin
+// endpoints on admin.address until ctx is done. gostat is ready when the socket
+// listeners are bound, Cassandra can be queried and the Bucketer's current
+// bucket is advancing with the clock
+func startAdmin(ctx context.Context, conf *config.Config, b *bucketer.Pool) {
+	a := &admin.Admin{
+		Bucketer: b,
+		Queues:   queues,
+		Checks: []admin.Check{
+			{Name: "listener", Check: func() error {
+				if atomic.LoadInt32(&listenerBound) == 0 {
+					return fmt.Errorf("not bound to %s", conf.Listener.Address)
+				}
+				return nil
+			}},
+			{Name: "cluster", Check: func() error {
+				if conf.Cluster.Node != "" && atomic.LoadInt32(&clusterBound) == 0 {
+					return fmt.Errorf("not bound to %s", conf.Cluster.Node)
+				}
+				return nil
+			}},
+			{Name: "relay", Check: func() error {
+				if conf.Relay.Listen != "" && atomic.LoadInt32(&relayBound) == 0 {
+					return fmt.Errorf("not bound to %s", conf.Relay.Listen)
+				}
+				return nil
+			}},
+			{Name: "cassandra", Check: func() error {
+				if conf.Mode == config.RelayMode {
+					return nil // a relay does not use Cassandra
+				}
+				return repo.Ping()
+			}},
+			{Name: "bucketer", Check: func() error {
+				status, err := b.Status(admin.Timeout)
+				if err != nil {
+					return err
+				}
+				if lag := time.Since(status.Current.Start); lag > 2*time.Minute {
+					return fmt.Errorf("current bucket started %v ago", lag.Truncate(time.Second))
+				}
+				return nil
+			}},
+		},
+		LastWrite: repo.LastWrite,
+		Config:    conf.Map(),
+		SetLogLevel: func(level string) error {
+			if !config.ValidLevel(level) {
+				return fmt.Errorf("unknown log level %q", level)
 			}
-			<-time.After(time.Second * 5) // wait for a clean shutdown, TODO: wait on signal from all routines
-			log.Info("Done")
-			os.Exit(1)
+			c := *conf
+			c.Log.Level, c.Log.Config = level, ""
+			return configureLogging(&c)
+		},
+	}
+	a.Register(http.DefaultServeMux)
+
+	if conf.Admin.Address == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	a.RegisterAdmin(mux)
+	srv := &http.Server{Addr: conf.Admin.Address, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		log.Info("admin endpoints serving at ", conf.Admin.Address)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Error("error serving the admin endpoints: ", err)
 		}
 	}()
 }
 
-func bindSocketListener(stats chan<- *stat.Stat, shutdown <-chan bool) {
+// simulate randomly generates stats until ctx is done
+func simulate(ctx context.Context, stats chan<- *stat.Stat) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second * time.Duration(rand.Intn(3))): // sleep 0-3 seconds
+		}
+
+		// create a stat randomly named "stat1 ... stat10" with a random value between 1-100
+		stat := stat.Stat{Name: fmt.Sprintf("stat%v", (rand.Intn(9) + 1)), Timestamp: time.Now().UTC(), Value: float64(rand.Intn(99) + 1)}
+		log.Debug("Generated simulated stat: ", stat)
+		stats <- &stat // send it to be bucketed and archived
+	}
+}
+
+// startAlertEngine loads the alert rules and starts an alert engine reading
+// aggregates from the given channel, and the dispatcher that notifies its alerts
+func startAlertEngine(rulesPath, statePath string, aggregates <-chan *aggregator.BucketAggregate, engineStage, dispatcherStage *stage) *alert.Engine {
+	alertConfig, err := alert.LoadConfig(rulesPath)
+	if err != nil {
+		log.Critical("error loading alert rules: ", err)
+		log.Flush()
+		os.Exit(1)
+	}
+
+	events := make(chan *alert.Event)
+	d, err := alert.NewDispatcher(alertConfig, events, dispatcherStage.shutdown)
+	if err != nil {
+		log.Critical("error creating alert notifiers: ", err)
+		log.Flush()
+		os.Exit(1)
+	}
+
+	e, err := alert.NewEngine(alertConfig.Rules, statePath, aggregates, events, engineStage.shutdown)
+	if err != nil {
+		log.Flush()
+		os.Exit(1)
+	}
+
+	log.Infof("evaluating %d alert rules from %s", len(alertConfig.Rules), rulesPath)
+	dispatcherStage.run(d.Run)
+	engineStage.run(e.Run)
+	return e
+}
+
+// startAnomalyDetector starts an anomaly detector reading aggregates from the
+// given channel, returning it and the channel of the meta stats it emits
+func startAnomalyDetector(params anomaly.Params, baselinePath string, aggregates <-chan *aggregator.BucketAggregate, detectorStage *stage) (*anomaly.Detector, <-chan *stat.Stat) {
+	// the Aggregator feeds the detector, so the detector must never block on
+	// the stages its meta stats feed: they are buffered, and dropped if the
+	// buffer fills up
+	metaStats := make(chan *stat.Stat, 1000)
+
+	d, err := anomaly.NewDetector(params, baselinePath, aggregates, metaStats, detectorStage.shutdown)
+	if err != nil {
+		log.Flush()
+		os.Exit(1)
+	}
+
+	log.Infof("detecting anomalies against a %v season", params.Season)
+	detectorStage.run(d.Run)
+	return d, metaStats
+}
+
+// forwardMetaStats sends each meta stat read from metaStats to be archived, if
+// archive is not nil, and its aggregate to each of outputs, until ctx is done.
+// The minute of a meta stat is finalized by the time it is emitted, so it is
+// not bucketed
+func forwardMetaStats(ctx context.Context, metaStats <-chan *stat.Stat, archive chan<- *stat.Stat, outputs []chan<- *aggregator.BucketAggregate) {
+	for {
+		select {
+		case s := <-metaStats:
+			if archive != nil {
+				select {
+				case archive <- s:
+				case <-ctx.Done():
+					return
+				}
+			}
+			for _, out := range outputs {
+				select {
+				case out <- anomaly.MetaAggregate(s):
+				case <-ctx.Done():
+					return
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// queues are the queues between the stages of the pipeline, reported by /admin/status
+var queues []admin.Queue
+
+// startCluster starts routing stats between the nodes of a cluster, which are
+// probed to see which are up
+func startCluster(conf *config.Config, received <-chan *stat.Stat, accepted chan<- *stat.Stat, routerStage, membershipStage *stage) {
+	members := cluster.NewMembership(conf.Cluster.Node, conf.Cluster.Peers, conf.Cluster.Replicas, membershipStage.shutdown)
+	members.OnChange(func(r *cluster.Ring) {
+		log.Infof("cluster: %d nodes up, rebalancing: %v", len(r.Nodes()), r.Nodes())
+	})
+	membershipStage.run(func() { members.Run(conf.Cluster.Probe) })
+
+	router := cluster.NewRouter(conf.Cluster.Node, members, received, accepted, routerStage.shutdown)
+	routerStage.run(router.Run)
+	log.Infof("cluster: sharding stats across %v as %s", conf.Cluster.Peers, conf.Cluster.Node)
+}
+
+// startRelay starts sending the final per-minute aggregates upstream, spooling
+// them until they are sent
+func startRelay(conf *config.Config, aggregates <-chan *aggregator.BucketAggregate, relayStage *stage) *relay.Relay {
+	spool, err := relay.OpenSpool(conf.Relay.Spool)
+	if err != nil {
+		log.Critical("error opening the relay spool: ", err)
+		log.Flush()
+		os.Exit(1)
+	}
+	if n := spool.Len(); n > 0 {
+		log.Infof("relay: %d batches spooled by the last run", n)
+	}
+
+	r := relay.NewRelay(conf.Relay.Name, conf.Relay.Upstream, spool, aggregates, relayStage.shutdown)
+	relayStage.run(func() { r.Run(conf.Relay.Interval) })
+	log.Infof("relaying aggregates to %s as %s", conf.Relay.Upstream, conf.Relay.Name)
+	return r
+}
+
+// startQueue starts a queue as a stage of the pipeline, reporting its depth and
+// drops as metrics
+func startQueue[T any](name string, size int, policy queue.Policy) (*queue.Queue[T], *stage) {
+	s := newStage(name + " queue")
+	q := queue.New[T](name, size, policy, s.shutdown)
+	s.run(q.Run)
+
+	metrics.DefaultRegistry.GaugeFunc("gostat.queue.depth;queue="+name, "Values waiting in a queue between stages of the pipeline",
+		func() float64 { return float64(q.Len()) })
+	metrics.DefaultRegistry.CounterFunc("gostat.queue.dropped;queue="+name, "Values dropped by a full queue", q.Dropped)
+	queues = append(queues, q)
+	return q, s
+}
+
+// publishLive sends each stat read from stats to the dashboards following its
+// series, until shut down
+func publishLive(stats <-chan *stat.Stat, shutdown <-chan bool) {
+	for {
+		select {
+		case s := <-stats:
+			socketApi.Publish(s)
+		case <-shutdown:
+			return
+		}
+	}
+}
+
+// reportMetrics sends gostat's own metrics as stats every interval until ctx is
+// done
+func reportMetrics(ctx context.Context, interval time.Duration, stats chan<- *stat.Stat) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	sampler := metrics.NewSampler(metrics.DefaultRegistry)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-ticker.C:
+			for _, s := range sampler.Stats(t.UTC()) {
+				select {
+				case stats <- s:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+// listenerBound, clusterBound and relayBound are 1 while the socket listeners
+// for producers, for other nodes of a cluster and for relays are bound,
+// accessed atomically
+var listenerBound, clusterBound, relayBound int32
+
+// bindSocketListener passes each message received on address to receive until
+// ctx is done, setting bound while it is bound to the address. receive returns
+// the number of stats or aggregates it decoded from the message. The listener's
+// metrics are tagged with its name
+func bindSocketListener(ctx context.Context, name, address string, bound *int32, receive func(msg []byte) (int, error)) {
 	var (
-		msg  []byte
-		err  error
-		done = false
+		msg []byte
+		err error
 	)
+	messagesReceived := metrics.DefaultRegistry.Counter("gostat.listener.received;listener="+name, "Messages received by a listener")
+	decoded := metrics.DefaultRegistry.Counter("gostat.listener.decoded;listener="+name, "Stats or aggregates decoded by a listener")
+	decodeErrors := metrics.DefaultRegistry.Counter("gostat.listener.errors;listener="+name, "Messages a listener could not decode")
 	socket, err := nano.NewPullSocket()
 
 	if nil != err {
@@ -109,29 +682,85 @@ func bindSocketListener(stats chan<- *stat.Stat, shutdown <-chan bool) {
 	socket.SetRecvTimeout(time.Second)
 
 	defer socket.Close()
-	_, err = socket.Bind("tcp://*:2025")
+	_, err = socket.Bind(address)
 	if nil != err {
 		log.Error(err)
+	} else {
+		atomic.StoreInt32(bound, 1)
+		defer atomic.StoreInt32(bound, 0)
 	}
-	log.Info("Ready to receive data")
+	log.Info("Ready to receive data on ", address)
 
-	for !done {
+	for ctx.Err() == nil {
 		msg, err = socket.Recv(0) //blocking
 		if nil != err && err.Error() != "resource temporarily unavailable" {
 			log.Error(err)
 		}
 
 		if nil != msg {
-			// TODO: new up a Stat and send it on the stats channel
 			log.Debug("Received message: ", msg)
+			messagesReceived.Inc()
+
+			n, err := receive(msg)
+			if err != nil {
+				decodeErrors.Inc()
+				log.Warn("error decoding message: ", err)
+				continue
+			}
+			decoded.Add(uint64(n))
 		}
+	}
 
-		select {
-		case done = <-shutdown:
-		default:
-			break
+	log.Info("Exiting socket listener on ", address)
+}
+
+// receiveStats returns a function decoding the stats in a message and sending
+// them to stats
+func receiveStats(stats chan<- *stat.Stat) func(msg []byte) (int, error) {
+	return func(msg []byte) (int, error) {
+		decoded, err := decodeStats(msg, time.Now().UTC())
+		if err != nil {
+			return 0, err
+		}
+		for _, s := range decoded {
+			stats <- s
+		}
+		return len(decoded), nil
+	}
+}
+
+// receiveBatches returns a function decoding the batch of aggregates sent by a
+// relay in a message and sending it to batches
+func receiveBatches(batches chan<- *relay.Batch) func(msg []byte) (int, error) {
+	return func(msg []byte) (int, error) {
+		b, err := relay.Decode(msg)
+		if err != nil {
+			return 0, err
 		}
+		batches <- b
+		return len(b.Aggregates), nil
+	}
+}
+
+// decodeStats decodes a protoStats message. Stats are timestamped with the
+// message's time, or now if the message has none
+func decodeStats(msg []byte, now time.Time) ([]*stat.Stat, error) {
+	var m protoStat.ProtoStats
+	if err := m.Unmarshal(msg); err != nil {
+		return nil, err
+	}
+
+	t := now
+	if m.TimeNano != nil {
+		t = time.Unix(0, m.GetTimeNano()).UTC()
 	}
 
-	log.Info("Exiting socket listener")
+	stats := make([]*stat.Stat, 0, len(m.Stats))
+	for _, s := range m.Stats {
+		if s.GetKey() == "" {
+			return nil, fmt.Errorf("stat without a key")
+		}
+		stats = append(stats, &stat.Stat{Name: s.GetKey(), Timestamp: t, Value: s.GetValue()})
+	}
+	return stats, nil
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/config"
+	"github.com/CapillarySoftware/gostat/importer"
+	"github.com/CapillarySoftware/gostat/repo"
+	log "github.com/cihub/seelog"
+	"os"
+)
+
+// runImport runs `gostat import`, which loads files of historical stats into
+// Cassandra and computes their aggregates, returning the exit status
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("gostat import", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gostat import [flags] file...")
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", os.Getenv("GOSTAT_CONFIG"), "YAML configuration file (GOSTAT_CONFIG)")
+	format := fs.String("format", "", "format of the files: csv, jsonl or protostat, or empty to use each file's extension")
+	progress := fs.String("progress", "gostat-import.json", "file the progress of the import is saved to, so it can be resumed")
+	rate := fs.Float64("rate", 0, "the most stats written a second, or 0 for no limit")
+	batch := fs.Int("batch", 1000, "the number of stats written between saves of the progress")
+	flags := config.DefineFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *batch < 1 {
+		fmt.Fprintln(os.Stderr, "import: -batch must be at least 1")
+		return 2
+	}
+
+	conf, err := config.Load(*configPath, flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := configureLogging(conf); err != nil {
+		fmt.Fprintln(os.Stderr, "error configuring logging:", err)
+		return 1
+	}
+	defer log.Flush()
+	configureRepo(conf)
+
+	bulk, err := repo.OpenBulk()
+	if err != nil {
+		log.Error("import: error connecting to Cassandra: ", err)
+		return 1
+	}
+	defer bulk.Close()
+
+	im, err := importer.New(bulk, *progress)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	im.BatchSize = *batch
+	im.Rate = *rate
+
+	if err := im.Import(fs.Args(), *format); err != nil {
+		log.Error(err)
+		return 1
+	}
+	log.Info("import: done")
+	return 0
+}
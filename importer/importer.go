@@ -0,0 +1,283 @@
+// Package importer loads historical stats, which are too old for the Bucketer,
+// writing their raw points and computing their 1m, 1h and 1d aggregates
+package importer
+
+import (
+	"fmt"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/clock"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// The resolutions aggregates are computed at
+const (
+	Minute = "1m"
+	Hour   = "1h"
+	Day    = "1d"
+)
+
+// Store is where the imported stats and their aggregates are stored
+type Store interface {
+	WriteRawStats(stats []*stat.Stat, origins []string) error // origins says where each stat was read from
+	ScanRawStats(name string, start, end time.Time, f func(stat.Stat) error) error
+	WriteAggregate(name, resolution string, t time.Time, a aggregator.StatsAggregate) error
+}
+
+// Importer imports files of stats in two passes. The first writes the raw
+// stats, and the second computes the aggregates of every series imported, a
+// day at a time, from the raw stats stored for that day, which include any
+// stats of the day recorded before. Progress is saved after every batch of raw
+// stats and every day aggregated. A stat written again after an interruption
+// replaces itself, since it has the same name and timestamp, or, in a series
+// that keeps or sums the stats sharing a timestamp, the same origin in its
+// file, and the aggregates are computed from scratch. A file imported again
+// under a different path, however, adds its stats to such a series again
+type Importer struct {
+	store        Store
+	progress     *Progress
+	progressPath string
+
+	BatchSize int     // the number of stats written between saves of the progress
+	Rate      float64 // the most stats written a second, or 0 for no limit
+
+	clock   clock.Clock
+	started time.Time
+	written int // stats written since started
+}
+
+// New constructs an Importer storing stats in store, and resuming from the
+// progress saved at progressPath, if it is not empty
+func New(store Store, progressPath string) (*Importer, error) {
+	p, err := LoadProgress(progressPath)
+	if err != nil {
+		return nil, fmt.Errorf("import: error loading the progress from %s: %v", progressPath, err)
+	}
+
+	return &Importer{
+		store:        store,
+		progress:     p,
+		progressPath: progressPath,
+		BatchSize:    1000,
+		clock:        clock.Real,
+	}, nil
+}
+
+// Import imports the files, in the format given or, if format is empty, the
+// format of each file's extension, then computes the aggregates
+func (im *Importer) Import(paths []string, format string) error {
+	im.started = im.clock.Now()
+	im.written = 0
+
+	for _, path := range paths {
+		if err := im.importFile(path, format); err != nil {
+			return fmt.Errorf("import: %s: %v", path, err)
+		}
+	}
+	return im.Aggregate()
+}
+
+func (im *Importer) importFile(path, format string) error {
+	progress := im.progress.file(path)
+	if progress.Done {
+		log.Info("import: already imported ", path)
+		return nil
+	}
+
+	if format == "" {
+		var err error
+		if format, err = Format(path); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if progress.Offset > 0 {
+		log.Infof("import: resuming %s at offset %d", path, progress.Offset)
+		if _, err := f.Seek(progress.Offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	r, err := NewReader(format, f, progress.Offset)
+	if err != nil {
+		return err
+	}
+
+	// a stat's origin is its position in the file, so a stat imported again
+	// replaces itself in a series that keeps or sums the stats sharing a timestamp
+	source := path
+	if abs, err := filepath.Abs(path); err == nil {
+		source = abs
+	}
+
+	batch := make([]*stat.Stat, 0, im.BatchSize)
+	origins := make([]string, 0, im.BatchSize)
+	for {
+		s, err := r.Next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if s != nil {
+			offset, index := r.Position()
+			batch = append(batch, s)
+			origins = append(origins, fmt.Sprintf("%s:%d:%d", source, offset, index))
+		}
+
+		if len(batch) == im.BatchSize || (err == io.EOF && len(batch) > 0) {
+			if err := im.write(batch, origins); err != nil {
+				return err
+			}
+			progress.Offset = r.Offset()
+			if err := im.progress.Save(im.progressPath); err != nil {
+				return err
+			}
+			batch = batch[:0]
+			origins = origins[:0]
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	progress.Done = true
+	log.Info("import: imported ", path)
+	return im.progress.Save(im.progressPath)
+}
+
+// write writes a batch of raw stats, no faster than the Rate
+func (im *Importer) write(batch []*stat.Stat, origins []string) error {
+	if err := im.store.WriteRawStats(batch, origins); err != nil {
+		return err
+	}
+	for _, s := range batch {
+		im.progress.imported(s.Name, s.Timestamp)
+	}
+
+	im.written += len(batch)
+	im.throttle()
+	return nil
+}
+
+// throttle waits until writing the stats written so far would not have exceeded
+// the Rate
+func (im *Importer) throttle() {
+	if im.Rate <= 0 {
+		return
+	}
+	due := im.started.Add(time.Duration(float64(im.written) / im.Rate * float64(time.Second)))
+	if wait := due.Sub(im.clock.Now()); wait > 0 {
+		<-im.clock.After(wait)
+	}
+}
+
+// Aggregate computes the aggregates of every series imported, for every day
+// from its first stat to its last
+func (im *Importer) Aggregate() error {
+	names := make([]string, 0, len(im.progress.Series))
+	for name := range im.progress.Series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		span := im.progress.Series[name]
+		day := span.First.UTC().Truncate(24 * time.Hour)
+		if rolledUp, ok := im.progress.RolledUp[name]; ok && rolledUp.After(day) {
+			day = rolledUp
+		}
+
+		for ; !day.After(span.Last); day = day.Add(24 * time.Hour) {
+			if err := im.aggregateDay(name, day); err != nil {
+				return fmt.Errorf("import: error aggregating %s on %s: %v", name, day.Format("2006-01-02"), err)
+			}
+			im.progress.RolledUp[name] = day.Add(24 * time.Hour)
+			if err := im.progress.Save(im.progressPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// aggregateDay computes and stores the aggregates of one day of a series
+func (im *Importer) aggregateDay(name string, day time.Time) error {
+	var d dayAggregates
+	if err := im.store.ScanRawStats(name, day, day.Add(24*time.Hour), d.add); err != nil {
+		return err
+	}
+	d.finish()
+	if len(d.minutes) == 0 {
+		return nil
+	}
+
+	for _, a := range d.minutes {
+		if err := im.store.WriteAggregate(name, Minute, a.Time, a.StatsAggregate); err != nil {
+			return err
+		}
+	}
+	for _, a := range d.hours {
+		if err := im.store.WriteAggregate(name, Hour, a.Time, a.StatsAggregate); err != nil {
+			return err
+		}
+	}
+	return im.store.WriteAggregate(name, Day, day, d.day)
+}
+
+// dayAggregates aggregates a day of stats of one series, added in order of
+// time, by the minute with Aggregate, then the minutes by the hour and the hours
+// into the whole day with AppendStatsAggregate. Only the stats of one minute are
+// held at a time
+type dayAggregates struct {
+	minutes, hours []aggregator.BucketAggregate
+	day            aggregator.StatsAggregate
+
+	minute time.Time    // the minute of the stats in bucket
+	bucket []*stat.Stat // the stats of the minute being added
+}
+
+func (d *dayAggregates) add(s stat.Stat) error {
+	minute := s.Timestamp.UTC().Truncate(time.Minute)
+	if len(d.bucket) > 0 && !minute.Equal(d.minute) {
+		d.aggregateMinute()
+	}
+	d.minute = minute
+	d.bucket = append(d.bucket, &s)
+	return nil
+}
+
+// aggregateMinute aggregates the stats of the minute in bucket, appending the
+// minute to its hour
+func (d *dayAggregates) aggregateMinute() {
+	m := aggregator.BucketAggregate{Name: d.bucket[0].Name, Time: d.minute, StatsAggregate: aggregator.Aggregate(d.bucket)}
+	d.minutes = append(d.minutes, m)
+	d.bucket = nil
+
+	hour := m.Time.Truncate(time.Hour)
+	if len(d.hours) == 0 || !d.hours[len(d.hours)-1].Time.Equal(hour) {
+		d.hours = append(d.hours, aggregator.BucketAggregate{Name: m.Name, Time: hour})
+	}
+	last := &d.hours[len(d.hours)-1]
+	last.StatsAggregate = aggregator.AppendStatsAggregate(last.StatsAggregate, m.StatsAggregate)
+}
+
+// finish aggregates the last minute added, and the hours into the day
+func (d *dayAggregates) finish() {
+	if len(d.bucket) > 0 {
+		d.aggregateMinute()
+	}
+	for _, h := range d.hours {
+		d.day = aggregator.AppendStatsAggregate(d.day, h.StatsAggregate)
+	}
+}
@@ -0,0 +1,178 @@
+package importer
+
+import (
+	"errors"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/clock"
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// memStore stores stats and aggregates in memory, failing writes once failAfter
+// stats have been written, if it is positive
+type memStore struct {
+	raw        map[string]map[time.Time]float64
+	aggregates map[string]aggregator.StatsAggregate // by name, resolution and time
+	origins    []string                             // of every stat written, in order
+	written    int
+	failAfter  int
+}
+
+func newMemStore() *memStore {
+	return &memStore{raw: make(map[string]map[time.Time]float64), aggregates: make(map[string]aggregator.StatsAggregate)}
+}
+
+func (m *memStore) WriteRawStats(stats []*stat.Stat, origins []string) error {
+	for i, s := range stats {
+		if m.failAfter > 0 && m.written >= m.failAfter {
+			return errors.New("unavailable")
+		}
+		m.origins = append(m.origins, origins[i])
+		if m.raw[s.Name] == nil {
+			m.raw[s.Name] = make(map[time.Time]float64)
+		}
+		m.raw[s.Name][s.Timestamp] = s.Value
+		m.written++
+	}
+	return nil
+}
+
+func (m *memStore) ScanRawStats(name string, start, end time.Time, f func(stat.Stat) error) error {
+	var stats []stat.Stat
+	for t, v := range m.raw[name] {
+		if !t.Before(start) && t.Before(end) {
+			stats = append(stats, stat.Stat{Name: name, Timestamp: t, Value: v})
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Timestamp.Before(stats[j].Timestamp) })
+	for _, s := range stats {
+		if err := f(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memStore) WriteAggregate(name, resolution string, t time.Time, a aggregator.StatsAggregate) error {
+	m.aggregates[name+" "+resolution+" "+t.Format(time.RFC3339)] = a
+	return nil
+}
+
+var _ = Describe("Importer", func() {
+	var (
+		dir   string
+		store *memStore
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "importer")
+		Expect(err).NotTo(HaveOccurred())
+		store = newMemStore()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	writeFile := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		Expect(ioutil.WriteFile(path, []byte(data), 0644)).To(Succeed())
+		return path
+	}
+
+	It("should write the raw stats and their 1m, 1h and 1d aggregates", func() {
+		path := writeFile("stats.csv", "name,timestamp,value\n"+
+			"foo,2015-01-02T03:04:05Z,1\n"+
+			"foo,2015-01-02T03:04:35Z,3\n"+
+			"foo,2015-01-02T03:05:00Z,8\n"+
+			"foo,2015-01-02T04:00:00Z,4\n"+
+			"foo,2015-01-03T00:00:00Z,10\n")
+		im, err := New(store, filepath.Join(dir, "progress.json"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(im.Import([]string{path}, "")).To(Succeed())
+		Expect(store.raw["foo"]).To(HaveLen(5))
+
+		day := time.Date(2015, 1, 2, 0, 0, 0, 0, time.UTC)
+		aggregate := func(resolution string, t time.Time) aggregator.StatsAggregate {
+			a, ok := store.aggregates["foo "+resolution+" "+t.Format(time.RFC3339)]
+			Expect(ok).To(BeTrue(), "no %s aggregate at %s", resolution, t)
+			return a
+		}
+		Expect(aggregate(Minute, day.Add(3*time.Hour+4*time.Minute))).To(Equal(aggregator.StatsAggregate{Average: 2, Min: 1, Max: 3, Count: 2}))
+		Expect(aggregate(Hour, day.Add(3*time.Hour))).To(Equal(aggregator.StatsAggregate{Average: 4, Min: 1, Max: 8, Count: 3}))
+		Expect(aggregate(Day, day)).To(Equal(aggregator.StatsAggregate{Average: 4, Min: 1, Max: 8, Count: 4}))
+		Expect(aggregate(Day, day.Add(24*time.Hour))).To(Equal(aggregator.StatsAggregate{Average: 10, Min: 10, Max: 10, Count: 1}))
+		Expect(store.aggregates).To(HaveLen(4 + 3 + 2))
+	})
+
+	It("should resume an interrupted import", func() {
+		path := writeFile("stats.jsonl",
+			`{"name": "foo", "timestamp": 1420167845, "value": 1}`+"\n"+
+				`{"name": "foo", "timestamp": 1420167846, "value": 2}`+"\n"+
+				`{"name": "foo", "timestamp": 1420167847, "value": 3}`+"\n"+
+				`{"name": "foo", "timestamp": 1420167848, "value": 4}`+"\n")
+		progressPath := filepath.Join(dir, "progress.json")
+
+		store.failAfter = 3
+		im, _ := New(store, progressPath)
+		im.BatchSize = 2
+		Expect(im.Import([]string{path}, "")).NotTo(Succeed())
+
+		progress, err := LoadProgress(progressPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(progress.Files[path].Done).To(BeFalse())
+		Expect(progress.Files[path].Offset).To(BeNumerically(">", 0))
+
+		store.failAfter = 0
+		store.written = 0
+		im, _ = New(store, progressPath)
+		im.BatchSize = 2
+		Expect(im.Import([]string{path}, "")).To(Succeed())
+		Expect(store.written).To(Equal(2), "the first batch should not be written again")
+		Expect(store.raw["foo"]).To(HaveLen(4))
+
+		By("giving the stat written again the same origin")
+		Expect(store.origins).To(HaveLen(5))
+		Expect(store.origins[3]).To(Equal(store.origins[2]))
+		Expect(store.origins[4]).NotTo(Equal(store.origins[3]))
+
+		minute := time.Date(2015, 1, 2, 3, 4, 0, 0, time.UTC)
+		Expect(store.aggregates["foo 1m "+minute.Format(time.RFC3339)].Count).To(Equal(4))
+
+		By("skipping files already imported")
+		store.written = 0
+		im, _ = New(store, progressPath)
+		Expect(im.Import([]string{path}, "")).To(Succeed())
+		Expect(store.written).To(BeZero())
+	})
+
+	It("should throttle writes to the rate", func() {
+		path := writeFile("stats.csv", "foo,1420167845,1\nfoo,1420167846,2\nfoo,1420167847,3\nfoo,1420167848,4\n")
+		fake := clock.NewFake(time.Date(2015, 1, 2, 0, 0, 0, 0, time.UTC))
+		im, _ := New(store, "")
+		im.clock = fake
+		im.BatchSize = 2
+		im.Rate = 1
+
+		done := make(chan error)
+		go func() { done <- im.Import([]string{path}, CSV) }()
+
+		Eventually(fake.Waiters).Should(Equal(1))
+		Expect(store.written).To(Equal(2))
+		Consistently(done).ShouldNot(Receive())
+
+		fake.Advance(2 * time.Second)
+		Eventually(fake.Waiters).Should(Equal(1))
+		Expect(store.written).To(Equal(4))
+		fake.Advance(2 * time.Second)
+		Eventually(done).Should(Receive(BeNil()))
+	})
+})
@@ -0,0 +1,99 @@
+package importer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Progress records how far an import has got, so that an interrupted import
+// resumes where it stopped
+type Progress struct {
+	Files    map[string]*FileProgress `json:"files"`    // by path
+	Series   map[string]*Span         `json:"series"`   // the span of the stats imported of each series
+	RolledUp map[string]time.Time     `json:"rolledUp"` // the day of each series before which aggregates are computed
+}
+
+// FileProgress records how much of a file has been imported
+type FileProgress struct {
+	Offset int64 `json:"offset"` // the stats before this offset have been written
+	Done   bool  `json:"done"`
+}
+
+// Span is the span of the stats imported of a series
+type Span struct {
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+func newProgress() *Progress {
+	return &Progress{
+		Files:    make(map[string]*FileProgress),
+		Series:   make(map[string]*Span),
+		RolledUp: make(map[string]time.Time),
+	}
+}
+
+// LoadProgress reads the progress saved at path, or returns a new Progress if
+// there is none
+func LoadProgress(path string) (*Progress, error) {
+	p := newProgress()
+	if path == "" {
+		return p, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Save writes the progress to path, replacing what was saved before only once
+// it is completely written
+func (p *Progress) Save(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// file returns the progress of the file at path
+func (p *Progress) file(path string) *FileProgress {
+	f, ok := p.Files[path]
+	if !ok {
+		f = &FileProgress{}
+		p.Files[path] = f
+	}
+	return f
+}
+
+// imported widens the span of the series of a stat to include it
+func (p *Progress) imported(name string, t time.Time) {
+	span, ok := p.Series[name]
+	if !ok {
+		p.Series[name] = &Span{First: t, Last: t}
+		return
+	}
+	if t.Before(span.First) {
+		span.First = t
+	}
+	if t.After(span.Last) {
+		span.Last = t
+	}
+}
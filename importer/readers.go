@@ -0,0 +1,240 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/protoStat"
+	"github.com/CapillarySoftware/gostat/stat"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The formats of files of stats
+const (
+	CSV       = "csv"       // name,timestamp,value records, with an optional header
+	JSONLines = "jsonl"     // {"name": ..., "timestamp": ..., "value": ...} objects, one per line
+	ProtoStat = "protostat" // protoStats messages, each preceded by its length as a uvarint
+)
+
+// Reader reads the stats of a file one at a time
+type Reader interface {
+	// Next returns the next stat, or io.EOF at the end of the file
+	Next() (*stat.Stat, error)
+
+	// Offset returns the offset in the file just after the last stat returned,
+	// where reading can resume. A protoStat message is resumed from its start
+	Offset() int64
+
+	// Position returns where the last stat returned is in the file, which is the
+	// same however far the file was read before resuming: an offset of its
+	// record, and its index in the record, which is 0 but in a protoStat message
+	Position() (offset int64, index int)
+}
+
+// Format returns the format of a file from its extension, or an error if it
+// has none of .csv, .jsonl, .json, .pb or .protostat
+func Format(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return CSV, nil
+	case ".jsonl", ".json":
+		return JSONLines, nil
+	case ".pb", ".protostat":
+		return ProtoStat, nil
+	}
+	return "", fmt.Errorf("import: unknown format of %s, use -format", path)
+}
+
+// NewReader returns a Reader of the format, reading r from offset
+func NewReader(format string, r io.Reader, offset int64) (Reader, error) {
+	switch format {
+	case CSV:
+		return newCSVReader(r, offset), nil
+	case JSONLines:
+		return &jsonReader{r: bufio.NewReader(r), offset: offset}, nil
+	case ProtoStat:
+		return &protoReader{r: bufio.NewReader(r), offset: offset}, nil
+	}
+	return nil, fmt.Errorf("import: unknown format %q", format)
+}
+
+type csvReader struct {
+	r      *csv.Reader
+	start  int64 // the offset the reader started at
+	header bool  // whether a header may still be skipped
+}
+
+func newCSVReader(r io.Reader, offset int64) *csvReader {
+	c := csv.NewReader(r)
+	c.FieldsPerRecord = 3
+	c.TrimLeadingSpace = true
+	c.ReuseRecord = true
+	return &csvReader{r: c, start: offset, header: offset == 0}
+}
+
+func (c *csvReader) Next() (*stat.Stat, error) {
+	for {
+		record, err := c.r.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := strconv.ParseFloat(record[2], 64)
+		if err != nil && c.header {
+			c.header = false
+			continue // a header
+		}
+		c.header = false
+		if err != nil {
+			line, _ := c.r.FieldPos(2)
+			return nil, fmt.Errorf("line %d: value %q is not a number", line, record[2])
+		}
+
+		t, err := stat.ParseTime(record[1])
+		if err != nil {
+			line, _ := c.r.FieldPos(1)
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		return &stat.Stat{Name: record[0], Timestamp: t, Value: value}, nil
+	}
+}
+
+func (c *csvReader) Offset() int64 {
+	return c.start + c.r.InputOffset()
+}
+
+// Position returns the offset just after the record of the last stat
+func (c *csvReader) Position() (int64, int) {
+	return c.Offset(), 0
+}
+
+type jsonReader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+// jsonStat is a stat of a JSON lines file. The timestamp is an RFC 3339 string,
+// or a number of seconds since the epoch
+type jsonStat struct {
+	Name      string          `json:"name"`
+	Timestamp json.RawMessage `json:"timestamp"`
+	Value     float64         `json:"value"`
+}
+
+func (j *jsonReader) Next() (*stat.Stat, error) {
+	for {
+		line, err := j.r.ReadBytes('\n')
+		if err == io.EOF && len(line) > 0 {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		j.offset += int64(len(line))
+
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		var js jsonStat
+		if err := json.Unmarshal(line, &js); err != nil {
+			return nil, fmt.Errorf("offset %d: %v", j.offset-int64(len(line)), err)
+		}
+		if js.Name == "" {
+			return nil, fmt.Errorf("offset %d: stat without a name", j.offset-int64(len(line)))
+		}
+
+		t, err := stat.ParseTime(strings.Trim(string(js.Timestamp), `"`))
+		if err != nil {
+			return nil, fmt.Errorf("offset %d: %v", j.offset-int64(len(line)), err)
+		}
+		return &stat.Stat{Name: js.Name, Timestamp: t, Value: js.Value}, nil
+	}
+}
+
+func (j *jsonReader) Offset() int64 {
+	return j.offset
+}
+
+// Position returns the offset just after the line of the last stat
+func (j *jsonReader) Position() (int64, int) {
+	return j.offset, 0
+}
+
+// MaxMessageSize is the largest protoStat message read, so that a corrupt or
+// foreign file is rejected rather than exhausting memory
+var MaxMessageSize uint64 = 16 << 20
+
+type protoReader struct {
+	r       *bufio.Reader
+	start   int64        // of the last message read
+	offset  int64        // of the message after it
+	pending []*stat.Stat // the stats of the last message not yet returned
+	index   int          // of the last stat returned in its message
+}
+
+func (p *protoReader) Next() (*stat.Stat, error) {
+	for len(p.pending) == 0 {
+		if err := p.readMessage(); err != nil {
+			return nil, err
+		}
+	}
+
+	s := p.pending[0]
+	p.pending = p.pending[1:]
+	p.index++
+	return s, nil
+}
+
+func (p *protoReader) readMessage() error {
+	size, err := binary.ReadUvarint(p.r)
+	if err != nil {
+		return err
+	}
+	if size > MaxMessageSize {
+		return fmt.Errorf("offset %d: message of %d bytes is larger than %d", p.offset, size, MaxMessageSize)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(p.r, msg); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	var m protoStat.ProtoStats
+	if err := m.Unmarshal(msg); err != nil {
+		return fmt.Errorf("offset %d: %v", p.offset, err)
+	}
+	if m.TimeNano == nil {
+		return fmt.Errorf("offset %d: message without a timeNano", p.offset)
+	}
+
+	t := time.Unix(0, m.GetTimeNano()).UTC()
+	for _, s := range m.Stats {
+		p.pending = append(p.pending, &stat.Stat{Name: s.GetKey(), Timestamp: t, Value: s.GetValue()})
+	}
+	p.start = p.offset
+	p.index = -1
+	p.offset += int64(binary.PutUvarint(make([]byte, binary.MaxVarintLen64), size)) + int64(size)
+	return nil
+}
+
+// Offset returns the start of the message after the last stat returned, or of
+// the message the stat is in if that has stats left, since reading resumes with
+// a whole message
+func (p *protoReader) Offset() int64 {
+	if len(p.pending) > 0 {
+		return p.start
+	}
+	return p.offset
+}
+
+// Position returns the start of the message of the last stat, and the stat's
+// index in it
+func (p *protoReader) Position() (int64, int) {
+	return p.start, p.index
+}
@@ -0,0 +1,169 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/CapillarySoftware/gostat/cluster"
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io"
+	"strings"
+	"time"
+)
+
+// readAll reads every stat, and the offset after each
+func readAll(r Reader) (stats []*stat.Stat, offsets []int64, err error) {
+	for {
+		s, err := r.Next()
+		if err == io.EOF {
+			return stats, offsets, nil
+		} else if err != nil {
+			return stats, offsets, err
+		}
+		stats = append(stats, s)
+		offsets = append(offsets, r.Offset())
+	}
+}
+
+// protoFile encodes stats as a protoStat file
+func protoFile(stats ...*stat.Stat) []byte {
+	msgs, err := cluster.Encode(stats)
+	Expect(err).NotTo(HaveOccurred())
+
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		size := make([]byte, binary.MaxVarintLen64)
+		buf.Write(size[:binary.PutUvarint(size, uint64(len(msg)))])
+		buf.Write(msg)
+	}
+	return buf.Bytes()
+}
+
+var _ = Describe("Readers", func() {
+	t0 := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	Describe("Format", func() {
+		It("should tell the format from the extension", func() {
+			Expect(Format("old/stats.CSV")).To(Equal(CSV))
+			Expect(Format("stats.jsonl")).To(Equal(JSONLines))
+			Expect(Format("stats.pb")).To(Equal(ProtoStat))
+			_, err := Format("stats.txt")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("CSV", func() {
+		It("should read stats, skipping a header", func() {
+			data := "name,timestamp,value\nfoo,1420167845,1.5\nbar,2015-01-02T03:04:05.25Z,2\n"
+			r, _ := NewReader(CSV, strings.NewReader(data), 0)
+			stats, _, err := readAll(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats).To(Equal([]*stat.Stat{
+				{Name: "foo", Timestamp: t0, Value: 1.5},
+				{Name: "bar", Timestamp: t0.Add(250 * time.Millisecond), Value: 2},
+			}))
+		})
+
+		It("should resume from an offset", func() {
+			data := "foo,1420167845,1\nfoo,1420167846,2\nfoo,1420167847,3\n"
+			r, _ := NewReader(CSV, strings.NewReader(data), 0)
+			_, offsets, err := readAll(r)
+			Expect(err).NotTo(HaveOccurred())
+
+			r, _ = NewReader(CSV, strings.NewReader(data[offsets[0]:]), offsets[0])
+			stats, resumed, err := readAll(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats).To(HaveLen(2))
+			Expect(stats[0].Value).To(Equal(2.0))
+			Expect(resumed).To(Equal(offsets[1:]))
+		})
+
+		It("should report the line of a bad value", func() {
+			r, _ := NewReader(CSV, strings.NewReader("foo,1420167845,1\nfoo,1420167846,x\n"), 0)
+			_, _, err := readAll(r)
+			Expect(err).To(MatchError(ContainSubstring("line 2")))
+		})
+	})
+
+	Describe("JSON lines", func() {
+		It("should read stats with either kind of timestamp, skipping blank lines", func() {
+			data := `{"name": "foo", "timestamp": 1420167845, "value": 1}` + "\n\n" +
+				`{"name": "bar", "timestamp": "2015-01-02T03:04:05Z", "value": 2}`
+			r, _ := NewReader(JSONLines, strings.NewReader(data), 0)
+			stats, offsets, err := readAll(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats).To(Equal([]*stat.Stat{
+				{Name: "foo", Timestamp: t0, Value: 1},
+				{Name: "bar", Timestamp: t0, Value: 2},
+			}))
+			Expect(offsets[1]).To(Equal(int64(len(data))))
+		})
+
+		It("should reject a stat without a name", func() {
+			r, _ := NewReader(JSONLines, strings.NewReader(`{"timestamp": 1420167845, "value": 1}`), 0)
+			_, _, err := readAll(r)
+			Expect(err).To(MatchError(ContainSubstring("without a name")))
+		})
+	})
+
+	Describe("protoStat", func() {
+		It("should read every stat of every message", func() {
+			data := protoFile(
+				&stat.Stat{Name: "foo", Timestamp: t0, Value: 1},
+				&stat.Stat{Name: "bar", Timestamp: t0, Value: 2},
+				&stat.Stat{Name: "foo", Timestamp: t0.Add(time.Second), Value: 3},
+			)
+			r, _ := NewReader(ProtoStat, bytes.NewReader(data), 0)
+			stats, offsets, err := readAll(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats).To(HaveLen(3))
+			Expect(stats[2]).To(Equal(&stat.Stat{Name: "foo", Timestamp: t0.Add(time.Second), Value: 3}))
+
+			By("resuming from the start of a message with stats left")
+			Expect(offsets[0]).To(Equal(int64(0)))
+			Expect(offsets[2]).To(Equal(int64(len(data))))
+			r, _ = NewReader(ProtoStat, bytes.NewReader(data[offsets[1]:]), offsets[1])
+			resumed, _, err := readAll(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resumed).To(Equal(stats[2:]))
+		})
+
+		It("should give each stat a position that is the same on resuming", func() {
+			data := protoFile(
+				&stat.Stat{Name: "foo", Timestamp: t0, Value: 1},
+				&stat.Stat{Name: "bar", Timestamp: t0, Value: 2},
+			)
+			position := func(r Reader) [2]int64 {
+				_, err := r.Next()
+				Expect(err).NotTo(HaveOccurred())
+				offset, index := r.Position()
+				return [2]int64{offset, int64(index)}
+			}
+
+			r, _ := NewReader(ProtoStat, bytes.NewReader(data), 0)
+			first := position(r)
+			second := position(r)
+			Expect(second).NotTo(Equal(first))
+
+			r, _ = NewReader(ProtoStat, bytes.NewReader(data), 0)
+			Expect(position(r)).To(Equal(first))
+			Expect(position(r)).To(Equal(second))
+		})
+
+		It("should report a truncated message", func() {
+			data := protoFile(&stat.Stat{Name: "foo", Timestamp: t0, Value: 1})
+			r, _ := NewReader(ProtoStat, bytes.NewReader(data[:len(data)-1]), 0)
+			_, _, err := readAll(r)
+			Expect(err).To(Equal(io.ErrUnexpectedEOF))
+		})
+
+		It("should reject a message larger than MaxMessageSize without reading it", func() {
+			size := make([]byte, binary.MaxVarintLen64)
+			data := size[:binary.PutUvarint(size, MaxMessageSize+1)]
+			r, _ := NewReader(ProtoStat, bytes.NewReader(data), 0)
+			_, _, err := readAll(r)
+			Expect(err).To(MatchError(ContainSubstring("is larger than")))
+		})
+	})
+})
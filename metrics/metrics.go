@@ -0,0 +1,239 @@
+// Package metrics instruments gostat itself. Counters, gauges and histograms
+// are registered by name, with any labels as tags in the name, e.g.
+// gostat.listener.received;listener=nanomsg. They are reported as stats fed
+// back into gostat's own pipeline, and in the Prometheus text format
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Kind is the kind of a metric
+type Kind int
+
+const (
+	CounterKind Kind = iota
+	GaugeKind
+	HistogramKind
+)
+
+func (k Kind) String() string {
+	switch k {
+	case GaugeKind:
+		return "gauge"
+	case HistogramKind:
+		return "histogram"
+	}
+	return "counter"
+}
+
+// Metric is anything a Registry can report
+type Metric interface {
+	Name() string
+	Help() string
+	Kind() Kind
+}
+
+// Counter counts events. Its value only ever increases
+type Counter struct {
+	name, help string
+	value      uint64 // accessed atomically
+	f          func() uint64
+}
+
+func (c *Counter) Name() string { return c.name }
+func (c *Counter) Help() string { return c.help }
+func (c *Counter) Kind() Kind   { return CounterKind }
+
+// Inc adds one to the counter
+func (c *Counter) Inc() { atomic.AddUint64(&c.value, 1) }
+
+// Add adds n to the counter
+func (c *Counter) Add(n uint64) { atomic.AddUint64(&c.value, n) }
+
+// Value returns the count
+func (c *Counter) Value() uint64 {
+	if c.f != nil {
+		return c.f()
+	}
+	return atomic.LoadUint64(&c.value)
+}
+
+// Gauge is a value that goes up and down
+type Gauge struct {
+	name, help string
+	bits       uint64 // the float64 value, accessed atomically
+	f          func() float64
+}
+
+func (g *Gauge) Name() string { return g.name }
+func (g *Gauge) Help() string { return g.help }
+func (g *Gauge) Kind() Kind   { return GaugeKind }
+
+// Set sets the gauge's value
+func (g *Gauge) Set(v float64) { atomic.StoreUint64(&g.bits, math.Float64bits(v)) }
+
+// Add adds d, which may be negative, to the gauge's value
+func (g *Gauge) Add(d float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		if atomic.CompareAndSwapUint64(&g.bits, old, math.Float64bits(math.Float64frombits(old)+d)) {
+			return
+		}
+	}
+}
+
+// Value returns the gauge's value
+func (g *Gauge) Value() float64 {
+	if g.f != nil {
+		return g.f()
+	}
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// Histogram counts observations, such as latencies in seconds, into buckets
+type Histogram struct {
+	name, help string
+	bounds     []float64 // the upper bound of each bucket, ascending
+
+	mu     sync.Mutex
+	counts []uint64 // the number of observations in each bucket, and a last bucket of the rest
+	count  uint64
+	sum    float64
+}
+
+// DefaultBuckets suit latencies in seconds
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+func (h *Histogram) Name() string { return h.name }
+func (h *Histogram) Help() string { return h.help }
+func (h *Histogram) Kind() Kind   { return HistogramKind }
+
+// Observe records an observation
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+
+	h.mu.Lock()
+	h.counts[i]++
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// Snapshot returns the cumulative count of observations up to each bound, the
+// total count and the sum of the observations
+func (h *Histogram) Snapshot() (cumulative []uint64, count uint64, sum float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cumulative = make([]uint64, len(h.bounds))
+	var n uint64
+	for i := range h.bounds {
+		n += h.counts[i]
+		cumulative[i] = n
+	}
+	return cumulative, h.count, h.sum
+}
+
+// Registry holds metrics by name
+type Registry struct {
+	mu         sync.Mutex
+	metrics    map[string]Metric
+	collectors []func()
+}
+
+// NewRegistry constructs a Registry
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]Metric)}
+}
+
+// DefaultRegistry holds the metrics registered by the package level functions
+var DefaultRegistry = NewRegistry()
+
+// register returns the metric already registered under m's name, if its kind
+// matches, or registers m
+func (r *Registry) register(m Metric) Metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.metrics[m.Name()]; ok {
+		if existing.Kind() != m.Kind() {
+			panic("metrics: " + m.Name() + " is already registered as a " + existing.Kind().String())
+		}
+		return existing
+	}
+	r.metrics[m.Name()] = m
+	return m
+}
+
+// Counter returns the counter registered under name, registering it if need be
+func (r *Registry) Counter(name, help string) *Counter {
+	return r.register(&Counter{name: name, help: help}).(*Counter)
+}
+
+// CounterFunc registers a counter whose value is returned by f
+func (r *Registry) CounterFunc(name, help string, f func() uint64) *Counter {
+	return r.register(&Counter{name: name, help: help, f: f}).(*Counter)
+}
+
+// Gauge returns the gauge registered under name, registering it if need be
+func (r *Registry) Gauge(name, help string) *Gauge {
+	return r.register(&Gauge{name: name, help: help}).(*Gauge)
+}
+
+// GaugeFunc registers a gauge whose value is returned by f
+func (r *Registry) GaugeFunc(name, help string, f func() float64) *Gauge {
+	return r.register(&Gauge{name: name, help: help, f: f}).(*Gauge)
+}
+
+// Histogram returns the histogram registered under name, registering it with
+// the given bucket bounds if need be
+func (r *Registry) Histogram(name, help string, bounds []float64) *Histogram {
+	h := &Histogram{name: name, help: help, bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+	return r.register(h).(*Histogram)
+}
+
+// OnCollect registers a function called before the metrics are reported, to
+// update gauges that are sampled rather than set as things happen
+func (r *Registry) OnCollect(f func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, f)
+}
+
+// collect runs the collectors and returns the metrics ordered by name
+func (r *Registry) collect() []Metric {
+	r.mu.Lock()
+	collectors := r.collectors
+	metrics := make([]Metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+
+	for _, f := range collectors {
+		f()
+	}
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name() < metrics[j].Name() })
+	return metrics
+}
+
+// NewCounter registers a counter on the DefaultRegistry
+func NewCounter(name, help string) *Counter {
+	return DefaultRegistry.Counter(name, help)
+}
+
+// NewGauge registers a gauge on the DefaultRegistry
+func NewGauge(name, help string) *Gauge {
+	return DefaultRegistry.Gauge(name, help)
+}
+
+// NewHistogram registers a histogram with the DefaultBuckets on the
+// DefaultRegistry
+func NewHistogram(name, help string) *Histogram {
+	return DefaultRegistry.Histogram(name, help, DefaultBuckets)
+}
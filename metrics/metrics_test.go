@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+var _ = Describe("Registry", func() {
+
+	var r *Registry
+
+	BeforeEach(func() {
+		r = NewRegistry()
+	})
+
+	It("should return the metric already registered under a name", func() {
+		c := r.Counter("gostat.test", "help")
+		c.Inc()
+		Expect(r.Counter("gostat.test", "help")).To(BeIdenticalTo(c))
+		Expect(func() { r.Gauge("gostat.test", "help") }).To(Panic())
+	})
+
+	It("should count, gauge and bucket", func() {
+		c := r.Counter("gostat.test.count", "")
+		c.Inc()
+		c.Add(2)
+		Expect(c.Value()).To(Equal(uint64(3)))
+
+		g := r.Gauge("gostat.test.gauge", "")
+		g.Set(5)
+		g.Add(-1.5)
+		Expect(g.Value()).To(Equal(3.5))
+
+		h := r.Histogram("gostat.test.latency", "", []float64{1, 2})
+		h.Observe(0.5)
+		h.Observe(1)
+		h.Observe(3)
+		cumulative, count, sum := h.Snapshot()
+		Expect(cumulative).To(Equal([]uint64{2, 2}))
+		Expect(count).To(Equal(uint64(3)))
+		Expect(sum).To(Equal(4.5))
+	})
+
+	It("should write the Prometheus text format", func() {
+		r.Counter("gostat.listener.received;listener=nanomsg", "Messages received").Add(7)
+		r.Counter("gostat.listener.received;listener=http", "Messages received").Add(2)
+		r.Counter("gostat.listener.received.bytes", "Bytes received").Add(100)
+		r.Gauge("gostat.api.connections", "Open connections").Set(3)
+		h := r.Histogram("gostat.repo.write.latency", "Write latency", []float64{0.1, 1})
+		h.Observe(0.05)
+		h.Observe(0.5)
+
+		var out bytes.Buffer
+		Expect(r.WritePrometheus(&out)).To(BeNil())
+		Expect(out.String()).To(Equal(`# HELP gostat_api_connections Open connections
+# TYPE gostat_api_connections gauge
+gostat_api_connections 3
+# HELP gostat_listener_received_bytes_total Bytes received
+# TYPE gostat_listener_received_bytes_total counter
+gostat_listener_received_bytes_total 100
+# HELP gostat_listener_received_total Messages received
+# TYPE gostat_listener_received_total counter
+gostat_listener_received_total{listener="http"} 2
+gostat_listener_received_total{listener="nanomsg"} 7
+# HELP gostat_repo_write_latency Write latency
+# TYPE gostat_repo_write_latency histogram
+gostat_repo_write_latency_bucket{le="0.1"} 1
+gostat_repo_write_latency_bucket{le="1"} 2
+gostat_repo_write_latency_bucket{le="+Inf"} 2
+gostat_repo_write_latency_sum 0.55
+gostat_repo_write_latency_count 2
+`))
+	})
+
+	It("should report counters as their increase since the previous report", func() {
+		t := time.Now().UTC()
+		c := r.Counter("gostat.test.count;host=a", "")
+		g := r.Gauge("gostat.test.gauge", "")
+		h := r.Histogram("gostat.test.latency;host=a", "", DefaultBuckets)
+		s := NewSampler(r)
+
+		c.Add(5)
+		g.Set(2)
+		h.Observe(1)
+		h.Observe(3)
+		Expect(s.Stats(t)).To(ConsistOf(
+			&stat.Stat{Name: "gostat.test.count;host=a", Timestamp: t, Value: 5},
+			&stat.Stat{Name: "gostat.test.gauge", Timestamp: t, Value: 2},
+			&stat.Stat{Name: "gostat.test.latency.count;host=a", Timestamp: t, Value: 2},
+			&stat.Stat{Name: "gostat.test.latency.mean;host=a", Timestamp: t, Value: 2},
+		))
+
+		c.Inc()
+		Expect(s.Stats(t)).To(ConsistOf(
+			&stat.Stat{Name: "gostat.test.count;host=a", Timestamp: t, Value: 1},
+			&stat.Stat{Name: "gostat.test.gauge", Timestamp: t, Value: 2},
+			&stat.Stat{Name: "gostat.test.latency.count;host=a", Timestamp: t, Value: 0},
+		))
+	})
+
+	It("should collect runtime metrics", func() {
+		RegisterRuntime(r)
+		var out bytes.Buffer
+		Expect(r.WritePrometheus(&out)).To(BeNil())
+		Expect(out.String()).To(ContainSubstring("gostat_runtime_goroutines "))
+		Expect(out.String()).To(ContainSubstring("gostat_runtime_gc_total "))
+	})
+
+	It("should collect runtime metrics from several goroutines at once", func() {
+		RegisterRuntime(r)
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					NewSampler(r).Stats(time.Now())
+					r.WritePrometheus(ioutil.Discard)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+})
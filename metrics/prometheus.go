@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/stat"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Handler serves the metrics of a Registry in the Prometheus text format
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WritePrometheus(w)
+	})
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format. Dots in
+// names become underscores, counters get a _total suffix, and tags become
+// labels
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	type series struct {
+		name   string
+		tags   map[string]string
+		metric Metric
+	}
+
+	// the series of a metric must be written together, under one description
+	var all []series
+	for _, m := range r.collect() {
+		metric, tags := stat.ParseName(m.Name())
+		name := prometheusName(metric)
+		if m.Kind() == CounterKind && !strings.HasSuffix(name, "_total") {
+			name += "_total"
+		}
+		all = append(all, series{name, tags, m})
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].name < all[j].name })
+
+	out := bufio.NewWriter(w)
+	described := make(map[string]bool)
+
+	for _, s := range all {
+		name, tags, m := s.name, s.tags, s.metric
+		if !described[name] {
+			described[name] = true
+			fmt.Fprintf(out, "# HELP %s %s\n", name, escapeHelp(m.Help()))
+			fmt.Fprintf(out, "# TYPE %s %s\n", name, m.Kind())
+		}
+
+		switch m := m.(type) {
+		case *Counter:
+			fmt.Fprintf(out, "%s%s %d\n", name, labels(tags, "", ""), m.Value())
+		case *Gauge:
+			fmt.Fprintf(out, "%s%s %s\n", name, labels(tags, "", ""), formatFloat(m.Value()))
+		case *Histogram:
+			cumulative, count, sum := m.Snapshot()
+			for i, bound := range m.bounds {
+				fmt.Fprintf(out, "%s_bucket%s %d\n", name, labels(tags, "le", formatFloat(bound)), cumulative[i])
+			}
+			fmt.Fprintf(out, "%s_bucket%s %d\n", name, labels(tags, "le", "+Inf"), count)
+			fmt.Fprintf(out, "%s_sum%s %s\n", name, labels(tags, "", ""), formatFloat(sum))
+			fmt.Fprintf(out, "%s_count%s %d\n", name, labels(tags, "", ""), count)
+		}
+	}
+	return out.Flush()
+}
+
+// prometheusName replaces the characters Prometheus does not allow in a name
+func prometheusName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// labels formats tags, and an extra label if name is not empty, as Prometheus
+// labels
+func labels(tags map[string]string, name, value string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, prometheusName(k)+"="+strconv.Quote(tags[k]))
+	}
+	if name != "" {
+		pairs = append(pairs, name+"="+strconv.Quote(value))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"github.com/CapillarySoftware/gostat/stat"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Sampler turns the metrics of a Registry into stats. Counters are reported as
+// their increase since the previous report, gauges as their value, and
+// histograms as <name>.count, the number of observations since the previous
+// report, and <name>.mean, their mean
+type Sampler struct {
+	registry *Registry
+	counts   map[string]uint64  // the value of each counter, or histogram count, at the previous report
+	sums     map[string]float64 // the sum of each histogram at the previous report
+}
+
+// NewSampler constructs a Sampler
+func NewSampler(r *Registry) *Sampler {
+	return &Sampler{
+		registry: r,
+		counts:   make(map[string]uint64),
+		sums:     make(map[string]float64),
+	}
+}
+
+// Stats returns the stats of every metric at time t
+func (s *Sampler) Stats(t time.Time) []*stat.Stat {
+	var stats []*stat.Stat
+	add := func(name string, v float64) {
+		stats = append(stats, &stat.Stat{Name: name, Timestamp: t, Value: v})
+	}
+
+	for _, m := range s.registry.collect() {
+		switch m := m.(type) {
+		case *Counter:
+			v := m.Value()
+			add(m.Name(), float64(v-s.counts[m.Name()]))
+			s.counts[m.Name()] = v
+		case *Gauge:
+			add(m.Name(), m.Value())
+		case *Histogram:
+			_, count, sum := m.Snapshot()
+			n, total := count-s.counts[m.Name()], sum-s.sums[m.Name()]
+			s.counts[m.Name()], s.sums[m.Name()] = count, sum
+
+			metric, tags := stat.ParseName(m.Name())
+			add(stat.FormatName(metric+".count", tags), float64(n))
+			if n > 0 {
+				add(stat.FormatName(metric+".mean", tags), total/float64(n))
+			}
+		}
+	}
+	return stats
+}
+
+// memStats holds the runtime.MemStats read by the latest collection, which
+// the runtime gauges of another collection may be reading at the same time
+type memStats struct {
+	mu  sync.Mutex
+	mem runtime.MemStats
+}
+
+// read reads the runtime's memory statistics
+func (m *memStats) read() {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	m.mu.Lock()
+	m.mem = mem
+	m.mu.Unlock()
+}
+
+// get returns f of the statistics last read
+func (m *memStats) get(f func(mem *runtime.MemStats) float64) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return f(&m.mem)
+}
+
+// RegisterRuntime registers gauges of the Go runtime: goroutines, memory and
+// garbage collection
+func RegisterRuntime(r *Registry) {
+	mem := &memStats{}
+	r.OnCollect(mem.read)
+
+	r.GaugeFunc("gostat.runtime.goroutines", "Number of goroutines", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	r.GaugeFunc("gostat.runtime.heap.alloc", "Bytes of allocated heap objects", func() float64 {
+		return mem.get(func(m *runtime.MemStats) float64 { return float64(m.HeapAlloc) })
+	})
+	r.GaugeFunc("gostat.runtime.heap.objects", "Number of allocated heap objects", func() float64 {
+		return mem.get(func(m *runtime.MemStats) float64 { return float64(m.HeapObjects) })
+	})
+	r.GaugeFunc("gostat.runtime.sys", "Bytes of memory obtained from the OS", func() float64 {
+		return mem.get(func(m *runtime.MemStats) float64 { return float64(m.Sys) })
+	})
+	r.CounterFunc("gostat.runtime.gc", "Number of completed garbage collections", func() uint64 {
+		return uint64(mem.get(func(m *runtime.MemStats) float64 { return float64(m.NumGC) }))
+	})
+	r.GaugeFunc("gostat.runtime.gc.pause", "Seconds of the most recent garbage collection pause", func() float64 {
+		return mem.get(func(m *runtime.MemStats) float64 { return float64(m.PauseNs[(m.NumGC+255)%256]) / float64(time.Second) })
+	})
+}
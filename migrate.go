@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/config"
+	"github.com/CapillarySoftware/gostat/migrate"
+	"github.com/CapillarySoftware/gostat/repo"
+	log "github.com/cihub/seelog"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// runMigrate runs `gostat migrate up|down|status`, which applies, rolls back or
+// lists the migrations of the Cassandra schema, `gostat migrate move-series`,
+// which moves the raw stats of the series whose write mode changed, or `gostat
+// migrate index-series`, which adds the series stored to the series table,
+// returning the exit status
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("gostat migrate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gostat migrate [flags] up|down|status|move-series|index-series")
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", os.Getenv("GOSTAT_CONFIG"), "YAML configuration file (GOSTAT_CONFIG)")
+	newestFirst := fs.Bool("newest-first", false, "create the raw stats tables clustered newest first, which speeds up reading the latest stats")
+	steps := fs.Int("steps", 1, "the number of migrations rolled back by down")
+	alterReplication := fs.Bool("alter-replication", false, "change the replication of an existing keyspace to cassandra.replication on up")
+	flags := config.DefineFlags(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	command := fs.Arg(0)
+	if command != "up" && command != "down" && command != "status" && command != "move-series" && command != "index-series" {
+		fs.Usage()
+		return 2
+	}
+	if *steps < 1 {
+		fmt.Fprintln(os.Stderr, "migrate: -steps must be at least 1")
+		return 2
+	}
+
+	conf, err := config.Load(*configPath, flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := configureLogging(conf); err != nil {
+		fmt.Fprintln(os.Stderr, "error configuring logging:", err)
+		return 1
+	}
+	defer log.Flush()
+	configureRepo(conf)
+
+	switch command {
+	case "move-series":
+		return moveSeries()
+	case "index-series":
+		return indexSeries()
+	}
+
+	migrations, err := migrate.Migrations()
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	schema, err := repo.OpenSchema()
+	if err != nil {
+		log.Error("migrate: error connecting to Cassandra: ", err)
+		return 1
+	}
+	defer schema.Close()
+
+	replication, _ := migrate.ParseReplication(conf.Cassandra.Replication) // validated by config.Load
+	options := migrate.Options{Keyspace: conf.Cassandra.Keyspace, NewestFirst: *newestFirst, AlterReplication: *alterReplication}
+	m, err := migrate.New(schema, migrations, options, replication)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+
+	switch command {
+	case "up":
+		done, err := m.Up()
+		if err != nil {
+			log.Error(err)
+			return 1
+		}
+		log.Infof("migrate: %d migrations applied", len(done))
+		for _, mig := range done {
+			if mig.Name == "series" {
+				return indexSeries() // the series stored before the table was created
+			}
+		}
+	case "down":
+		done, err := m.Down(*steps)
+		if err != nil {
+			log.Error(err)
+			return 1
+		}
+		log.Infof("migrate: %d migrations rolled back", len(done))
+	case "status":
+		status, err := m.Status()
+		if err != nil {
+			log.Error(err)
+			return 1
+		}
+		printStatus(status)
+	}
+	return 0
+}
+
+// moveSeries moves the raw stats of each series stored in the table of another
+// write mode into the table of its own
+func moveSeries() int {
+	misplaced, err := repo.MisplacedSeries()
+	if err != nil {
+		log.Error("migrate: error finding the series to move: ", err)
+		return 1
+	}
+	for _, name := range misplaced {
+		if err := repo.MoveSeries(name); err != nil {
+			log.Errorf("migrate: error moving %s: %v", name, err)
+			return 1
+		}
+		log.Info("migrate: moved ", name)
+	}
+	log.Infof("migrate: %d series moved", len(misplaced))
+	return 0
+}
+
+// indexSeries adds every series stored to the series table
+func indexSeries() int {
+	n, err := repo.IndexSeries()
+	if err != nil {
+		log.Error("migrate: error indexing the series: ", err)
+		return 1
+	}
+	log.Infof("migrate: %d series indexed", n)
+	return 0
+}
+
+// printStatus prints the state of each version of the schema
+func printStatus(status []migrate.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, s := range status {
+		name, applied := s.Name, "pending"
+		if name == "" {
+			name = "(unknown to this gostat)"
+		}
+		if !s.Applied.IsZero() {
+			applied = s.Applied.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, name, applied)
+	}
+	w.Flush()
+}
@@ -0,0 +1,311 @@
+// Package migrate versions gostat's Cassandra schema. The migrations are CQL
+// files embedded in the binary, applied in order of version and recorded in the
+// schema_migrations table of the keyspace
+package migrate
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// files holds the migrations, named <version>_<name>.up.cql and
+// <version>_<name>.down.cql. Since Cassandra cannot change its schema in a
+// transaction, every statement must be safe to repeat, e.g. CREATE TABLE IF NOT
+// EXISTS, so that a migration interrupted part way can be applied again
+//
+//go:embed migrations/*.cql
+var files embed.FS
+
+var (
+	fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.cql$`)
+	keyspace = regexp.MustCompile(`^\w{1,48}$`)
+)
+
+// Options are the choices made in rendering the migrations
+type Options struct {
+	Keyspace         string
+	NewestFirst      bool // cluster the raw stats tables newest first, which can only be chosen when they are created
+	AlterReplication bool // change the replication of an existing keyspace that differs from the one given
+}
+
+// Migration is one version of the schema
+type Migration struct {
+	Version  int
+	Name     string
+	up, down *template.Template
+}
+
+// Up returns the statements applying the migration
+func (m *Migration) Up(o Options) ([]string, error) {
+	return render(m.up, o)
+}
+
+// Down returns the statements rolling the migration back
+func (m *Migration) Down(o Options) ([]string, error) {
+	return render(m.down, o)
+}
+
+func (m *Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Migrations returns the migrations embedded in gostat, in order of version
+func Migrations() ([]*Migration, error) {
+	return load(files, "migrations")
+}
+
+// load returns the migrations in dir, in order of version
+func load(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		match := fileName.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrate: %s is not named <version>_<name>.up.cql or .down.cql", e.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrate: version %d is named both %s and %s", version, m.Name, match[2])
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		t, err := template.New(e.Name()).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("migrate: %v", err)
+		}
+		if match[3] == "up" {
+			m.up = t
+		} else {
+			m.down = t
+		}
+	}
+
+	var migrations []*Migration
+	for _, m := range byVersion {
+		if m.up == nil || m.down == nil {
+			return nil, fmt.Errorf("migrate: %s has no up or no down migration", m)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// render executes the template of a migration, returning its statements
+func render(t *template.Template, o Options) ([]string, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, o); err != nil {
+		return nil, fmt.Errorf("migrate: %v", err)
+	}
+	return statements(b.String()), nil
+}
+
+// statements splits CQL into its statements, dropping comments
+func statements(cql string) []string {
+	var lines []string
+	for _, line := range strings.Split(cql, "\n") {
+		if i := strings.Index(line, "--"); i >= 0 {
+			line = line[:i]
+		}
+		lines = append(lines, strings.TrimRight(line, " \t\r"))
+	}
+
+	var stmts []string
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+// Store is the keyspace migrated
+type Store interface {
+	// Replication returns the replication of the keyspace, or nil if it does
+	// not exist
+	Replication() (Replication, error)
+	// CreateKeyspace creates the keyspace and its schema_migrations table, if
+	// they do not exist
+	CreateKeyspace(r Replication) error
+	AlterKeyspace(r Replication) error
+	// Applied returns when each version recorded was applied
+	Applied() (map[int]time.Time, error)
+	Exec(stmt string) error
+	Record(version int, name string, at time.Time) error
+	Forget(version int) error
+}
+
+// Status is the state of one version of the schema
+type Status struct {
+	Version int
+	Name    string    // empty if the version is unknown to this gostat
+	Applied time.Time // the zero time if the version is pending
+}
+
+// Migrator applies and rolls back the migrations of a keyspace
+type Migrator struct {
+	store       Store
+	migrations  []*Migration
+	options     Options
+	replication Replication
+}
+
+// New constructs a Migrator of the keyspace in store, which is replicated as
+// given when it is created or migrated up
+func New(store Store, migrations []*Migration, o Options, r Replication) (*Migrator, error) {
+	if !keyspace.MatchString(o.Keyspace) {
+		return nil, fmt.Errorf("migrate: invalid keyspace name %q", o.Keyspace)
+	}
+	return &Migrator{store: store, migrations: migrations, options: o, replication: r}, nil
+}
+
+// Up creates the keyspace if needed and applies every migration not yet
+// applied, returning those applied. The replication of an existing keyspace is
+// only changed if AlterReplication is set
+func (m *Migrator) Up() ([]*Migration, error) {
+	current, err := m.store.Replication()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.store.CreateKeyspace(m.replication); err != nil {
+		return nil, fmt.Errorf("migrate: error creating keyspace %s: %v", m.options.Keyspace, err)
+	}
+	switch {
+	case current == nil || current.Equal(m.replication):
+	case !m.options.AlterReplication:
+		log.Warnf("migrate: keyspace %s is replicated %v rather than %v; run migrate -alter-replication up to change it",
+			m.options.Keyspace, current, m.replication)
+	default:
+		log.Warnf("migrate: changing the replication of keyspace %s from %v to %v; run nodetool repair on every node",
+			m.options.Keyspace, current, m.replication)
+		if err := m.store.AlterKeyspace(m.replication); err != nil {
+			return nil, fmt.Errorf("migrate: error altering keyspace %s: %v", m.options.Keyspace, err)
+		}
+	}
+
+	applied, err := m.store.Applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []*Migration
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		stmts, err := mig.Up(m.options)
+		if err != nil {
+			return done, err
+		}
+		if err := m.exec(stmts); err != nil {
+			return done, fmt.Errorf("migrate: error applying %s: %v", mig, err)
+		}
+		if err := m.store.Record(mig.Version, mig.Name, time.Now().UTC()); err != nil {
+			return done, fmt.Errorf("migrate: error recording %s: %v", mig, err)
+		}
+		log.Infof("migrate: applied %s", mig)
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// Down rolls back the latest steps migrations applied, returning those rolled
+// back
+func (m *Migrator) Down(steps int) ([]*Migration, error) {
+	applied, err := m.store.Applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []int
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	if steps < len(versions) {
+		versions = versions[:steps]
+	}
+
+	var done []*Migration
+	for _, v := range versions {
+		mig := m.migration(v)
+		if mig == nil {
+			return done, fmt.Errorf("migrate: version %d was applied by a newer gostat, which must roll it back", v)
+		}
+		stmts, err := mig.Down(m.options)
+		if err != nil {
+			return done, err
+		}
+		if err := m.exec(stmts); err != nil {
+			return done, fmt.Errorf("migrate: error rolling back %s: %v", mig, err)
+		}
+		if err := m.store.Forget(v); err != nil {
+			return done, fmt.Errorf("migrate: error recording the roll back of %s: %v", mig, err)
+		}
+		log.Infof("migrate: rolled back %s", mig)
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// Status returns the state of every migration, and of any version applied by
+// a newer gostat, in order of version
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.store.Applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var status []Status
+	for _, mig := range m.migrations {
+		status = append(status, Status{Version: mig.Version, Name: mig.Name, Applied: applied[mig.Version]})
+	}
+	for v, at := range applied {
+		if m.migration(v) == nil {
+			status = append(status, Status{Version: v, Applied: at})
+		}
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Version < status[j].Version })
+	return status, nil
+}
+
+// migration returns the migration of a version, or nil if there is none
+func (m *Migrator) migration(version int) *Migration {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return mig
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) exec(stmts []string) error {
+	for _, stmt := range stmts {
+		if err := m.store.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package migrate
+
+import (
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"strings"
+	"testing/fstest"
+	"time"
+)
+
+// memStore records the statements executed against a keyspace, failing those
+// containing failOn, if it is not empty
+type memStore struct {
+	replication Replication
+	applied     map[int]time.Time
+	executed    []string
+	failOn      string
+}
+
+func newMemStore() *memStore {
+	return &memStore{applied: make(map[int]time.Time)}
+}
+
+func (m *memStore) Replication() (Replication, error) {
+	return m.replication, nil
+}
+
+func (m *memStore) CreateKeyspace(r Replication) error {
+	if m.replication == nil {
+		m.replication = r
+	}
+	return nil
+}
+
+func (m *memStore) AlterKeyspace(r Replication) error {
+	m.replication = r
+	return nil
+}
+
+func (m *memStore) Applied() (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+	for v, at := range m.applied {
+		applied[v] = at
+	}
+	return applied, nil
+}
+
+func (m *memStore) Exec(stmt string) error {
+	if m.failOn != "" && strings.Contains(stmt, m.failOn) {
+		return errors.New("unavailable")
+	}
+	m.executed = append(m.executed, stmt)
+	return nil
+}
+
+func (m *memStore) Record(version int, name string, at time.Time) error {
+	m.applied[version] = at
+	return nil
+}
+
+func (m *memStore) Forget(version int) error {
+	delete(m.applied, version)
+	return nil
+}
+
+var _ = Describe("Migrate", func() {
+	var (
+		store      *memStore
+		migrations []*Migration
+		options    Options
+		single     Replication
+	)
+
+	BeforeEach(func() {
+		store = newMemStore()
+		var err error
+		migrations, err = load(fstest.MapFS{
+			"m/0001_a.up.cql":   {Data: []byte("CREATE TABLE IF NOT EXISTS {{.Keyspace}}.a (k int PRIMARY KEY); -- the first\n")},
+			"m/0001_a.down.cql": {Data: []byte("DROP TABLE IF EXISTS {{.Keyspace}}.a;")},
+			"m/0002_b.up.cql":   {Data: []byte("CREATE TABLE IF NOT EXISTS {{.Keyspace}}.b (k int PRIMARY KEY);\nCREATE TABLE IF NOT EXISTS {{.Keyspace}}.c (k int PRIMARY KEY);")},
+			"m/0002_b.down.cql": {Data: []byte("DROP TABLE IF EXISTS {{.Keyspace}}.c;\nDROP TABLE IF EXISTS {{.Keyspace}}.b;")},
+		}, "m")
+		Expect(err).To(BeNil())
+		options = Options{Keyspace: "gostat"}
+		single, _ = ParseReplication("1")
+	})
+
+	It("should load the embedded migrations, numbered from 1 without gaps", func() {
+		embedded, err := Migrations()
+		Expect(err).To(BeNil())
+		Expect(embedded).NotTo(BeEmpty())
+		for i, m := range embedded {
+			Expect(m.Version).To(Equal(i + 1))
+			for _, o := range []Options{{Keyspace: "gostat"}, {Keyspace: "gostat", NewestFirst: true}} {
+				up, err := m.Up(o)
+				Expect(err).To(BeNil())
+				Expect(up).NotTo(BeEmpty())
+				down, err := m.Down(o)
+				Expect(err).To(BeNil())
+				Expect(down).NotTo(BeEmpty())
+			}
+		}
+	})
+
+	It("should cluster the raw stats tables newest first when asked", func() {
+		embedded, _ := Migrations()
+		up, _ := embedded[0].Up(Options{Keyspace: "gostat", NewestFirst: true})
+		Expect(up[0]).To(HavePrefix("CREATE TABLE IF NOT EXISTS gostat.raw_stats ("))
+		Expect(up[0]).To(HaveSuffix("WITH CLUSTERING ORDER BY (ts DESC)"))
+
+		up, _ = embedded[0].Up(Options{Keyspace: "gostat"})
+		Expect(up[0]).NotTo(ContainSubstring("CLUSTERING ORDER"))
+	})
+
+	It("should split statements and drop comments", func() {
+		Expect(statements("-- a table\nCREATE TABLE t (\n  k int, -- the key\n  PRIMARY KEY (k)\n);\n\nDROP TABLE u;\n")).To(Equal([]string{
+			"CREATE TABLE t (\n  k int,\n  PRIMARY KEY (k)\n)",
+			"DROP TABLE u",
+		}))
+	})
+
+	It("should reject migrations without a down migration or misnamed", func() {
+		_, err := load(fstest.MapFS{"m/0001_a.up.cql": {Data: []byte("")}}, "m")
+		Expect(err).NotTo(BeNil())
+		_, err = load(fstest.MapFS{"m/a.cql": {Data: []byte("")}}, "m")
+		Expect(err).NotTo(BeNil())
+		_, err = load(fstest.MapFS{
+			"m/0001_a.up.cql":   {Data: []byte("")},
+			"m/0001_b.down.cql": {Data: []byte("")},
+		}, "m")
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("should reject an invalid keyspace name", func() {
+		_, err := New(store, migrations, Options{Keyspace: "gostat; DROP"}, single)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("should create the keyspace and apply every migration once", func() {
+		m, _ := New(store, migrations, options, single)
+		done, err := m.Up()
+		Expect(err).To(BeNil())
+		Expect(done).To(HaveLen(2))
+		Expect(store.replication).To(Equal(single))
+		Expect(store.executed).To(Equal([]string{
+			"CREATE TABLE IF NOT EXISTS gostat.a (k int PRIMARY KEY)",
+			"CREATE TABLE IF NOT EXISTS gostat.b (k int PRIMARY KEY)",
+			"CREATE TABLE IF NOT EXISTS gostat.c (k int PRIMARY KEY)",
+		}))
+		Expect(store.applied).To(HaveLen(2))
+
+		done, err = m.Up()
+		Expect(err).To(BeNil())
+		Expect(done).To(BeEmpty())
+		Expect(store.executed).To(HaveLen(3))
+	})
+
+	It("should leave the replication of an existing keyspace unless asked to change it", func() {
+		r, _ := ParseReplication("dc1=3")
+		store.replication = r
+		m, _ := New(store, migrations, options, single)
+		_, err := m.Up()
+		Expect(err).To(BeNil())
+		Expect(store.replication).To(Equal(r))
+	})
+
+	It("should change the replication of an existing keyspace when asked", func() {
+		store.replication = single
+		r, _ := ParseReplication("dc1=3")
+		options.AlterReplication = true
+		m, _ := New(store, migrations, options, r)
+		_, err := m.Up()
+		Expect(err).To(BeNil())
+		Expect(store.replication).To(Equal(r))
+	})
+
+	It("should resume a migration that failed part way", func() {
+		store.failOn = ".c "
+		m, _ := New(store, migrations, options, single)
+		done, err := m.Up()
+		Expect(err).NotTo(BeNil())
+		Expect(done).To(HaveLen(1))
+		Expect(store.applied).To(HaveKey(1))
+		Expect(store.applied).NotTo(HaveKey(2))
+
+		store.failOn = ""
+		done, err = m.Up()
+		Expect(err).To(BeNil())
+		Expect(done).To(HaveLen(1))
+		Expect(done[0].Version).To(Equal(2))
+		Expect(store.executed[len(store.executed)-2:]).To(Equal([]string{
+			"CREATE TABLE IF NOT EXISTS gostat.b (k int PRIMARY KEY)",
+			"CREATE TABLE IF NOT EXISTS gostat.c (k int PRIMARY KEY)",
+		}))
+	})
+
+	It("should roll back the latest migrations", func() {
+		m, _ := New(store, migrations, options, single)
+		m.Up()
+		store.executed = nil
+
+		done, err := m.Down(1)
+		Expect(err).To(BeNil())
+		Expect(done).To(HaveLen(1))
+		Expect(done[0].Version).To(Equal(2))
+		Expect(store.executed).To(Equal([]string{"DROP TABLE IF EXISTS gostat.c", "DROP TABLE IF EXISTS gostat.b"}))
+		Expect(store.applied).To(HaveLen(1))
+
+		done, err = m.Down(5)
+		Expect(err).To(BeNil())
+		Expect(done).To(HaveLen(1))
+		Expect(store.applied).To(BeEmpty())
+	})
+
+	It("should not roll back a version it does not know", func() {
+		m, _ := New(store, migrations, options, single)
+		m.Up()
+		store.applied[3] = time.Now()
+
+		_, err := m.Down(1)
+		Expect(err).NotTo(BeNil())
+		Expect(store.applied).To(HaveLen(3))
+	})
+
+	It("should report which migrations are applied and pending", func() {
+		applied := time.Date(2014, 9, 9, 19, 0, 0, 0, time.UTC)
+		store.applied[1] = applied
+		store.applied[7] = applied
+
+		m, _ := New(store, migrations, options, single)
+		status, err := m.Status()
+		Expect(err).To(BeNil())
+		Expect(status).To(Equal([]Status{
+			{Version: 1, Name: "a", Applied: applied},
+			{Version: 2, Name: "b"},
+			{Version: 7, Applied: applied},
+		}))
+	})
+})
@@ -0,0 +1,89 @@
+package migrate
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The replication strategies of a keyspace
+const (
+	SimpleStrategy          = "SimpleStrategy"
+	NetworkTopologyStrategy = "NetworkTopologyStrategy"
+)
+
+// Replication is the replication map of a keyspace, naming its strategy as
+// "class" alongside the strategy's options
+type Replication map[string]string
+
+// ParseReplication parses a replication factor, which replicates a keyspace
+// with SimpleStrategy, or comma separated datacenter=factor pairs, which
+// replicate it with NetworkTopologyStrategy
+func ParseReplication(s string) (Replication, error) {
+	if !strings.Contains(s, "=") {
+		if err := checkFactor(s); err != nil {
+			return nil, err
+		}
+		return Replication{"class": SimpleStrategy, "replication_factor": strings.TrimSpace(s)}, nil
+	}
+
+	r := Replication{"class": NetworkTopologyStrategy}
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.Index(pair, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%q is not datacenter=factor", pair)
+		}
+		dc, factor := strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:])
+		if dc == "" || dc == "class" {
+			return nil, fmt.Errorf("%q does not name a datacenter", pair)
+		}
+		if err := checkFactor(factor); err != nil {
+			return nil, err
+		}
+		r[dc] = factor
+	}
+	return r, nil
+}
+
+func checkFactor(s string) error {
+	if n, err := strconv.Atoi(strings.TrimSpace(s)); err != nil || n < 1 {
+		return fmt.Errorf("replication factor %q is not a positive integer", s)
+	}
+	return nil
+}
+
+// Equal reports whether two replication maps are the same
+func (r Replication) Equal(o Replication) bool {
+	if len(r) != len(o) {
+		return false
+	}
+	for k, v := range r {
+		if w, ok := o[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the replication as a CQL map literal
+func (r Replication) String() string {
+	var keys []string
+	for k := range r {
+		if k != "class" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	entries := []string{fmt.Sprintf("'class': '%s'", quote(r["class"]))}
+	for _, k := range keys {
+		entries = append(entries, fmt.Sprintf("'%s': '%s'", quote(k), quote(r[k])))
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+// quote escapes a CQL string
+func quote(s string) string {
+	return strings.Replace(s, "'", "''", -1)
+}
@@ -0,0 +1,36 @@
+package migrate
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replication", func() {
+	It("should parse a replication factor as SimpleStrategy", func() {
+		r, err := ParseReplication("3")
+		Expect(err).To(BeNil())
+		Expect(r).To(Equal(Replication{"class": SimpleStrategy, "replication_factor": "3"}))
+		Expect(r.String()).To(Equal("{'class': 'SimpleStrategy', 'replication_factor': '3'}"))
+	})
+
+	It("should parse datacenter factors as NetworkTopologyStrategy", func() {
+		r, err := ParseReplication("dc2=2, dc1=3")
+		Expect(err).To(BeNil())
+		Expect(r).To(Equal(Replication{"class": NetworkTopologyStrategy, "dc1": "3", "dc2": "2"}))
+		Expect(r.String()).To(Equal("{'class': 'NetworkTopologyStrategy', 'dc1': '3', 'dc2': '2'}"))
+	})
+
+	It("should reject invalid replication", func() {
+		for _, s := range []string{"", "0", "three", "dc1=3,dc2", "dc1=0", "=3", "class=3"} {
+			_, err := ParseReplication(s)
+			Expect(err).NotTo(BeNil(), s)
+		}
+	})
+
+	It("should compare replication maps", func() {
+		r, _ := ParseReplication("dc1=3,dc2=2")
+		Expect(r.Equal(Replication{"class": NetworkTopologyStrategy, "dc2": "2", "dc1": "3"})).To(BeTrue())
+		Expect(r.Equal(Replication{"class": NetworkTopologyStrategy, "dc1": "3"})).To(BeFalse())
+		Expect(r.Equal(Replication{"class": SimpleStrategy, "replication_factor": "3"})).To(BeFalse())
+	})
+})
@@ -0,0 +1,43 @@
+// Package push sends messages over a nanomsg push socket, as producers send
+// stats to gostat, nodes forward stats to each other and relays send batches
+// upstream
+package push
+
+import (
+	nano "github.com/op/go-nanomsg"
+	"time"
+)
+
+// Socket is a push socket connected to one address
+type Socket struct {
+	socket *nano.PushSocket
+}
+
+// Dial returns a Socket pushing to a nanomsg address. A send blocks for at
+// most sendTimeout, e.g. while nothing is listening at the address
+func Dial(address string, sendTimeout time.Duration) (*Socket, error) {
+	socket, err := nano.NewPushSocket()
+	if err != nil {
+		return nil, err
+	}
+	if err := socket.SetSendTimeout(sendTimeout); err != nil {
+		socket.Close()
+		return nil, err
+	}
+	if _, err := socket.Connect(address); err != nil {
+		socket.Close()
+		return nil, err
+	}
+	return &Socket{socket}, nil
+}
+
+// Send sends one message
+func (s *Socket) Send(msg []byte) error {
+	_, err := s.socket.Send(msg, 0)
+	return err
+}
+
+// Close closes the socket
+func (s *Socket) Close() error {
+	return s.socket.Close()
+}
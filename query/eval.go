@@ -0,0 +1,99 @@
+package query
+
+import (
+	"fmt"
+	"github.com/CapillarySoftware/gostat/stat"
+	"sort"
+	"time"
+)
+
+// DefaultStep is the interval series are aligned to when they are combined
+const DefaultStep = time.Minute
+
+// Fetcher provides the raw stats a query is evaluated against
+type Fetcher interface {
+	// Names returns the names of every known series
+	Names() ([]string, error)
+
+	// Fetch returns the raw stats recorded for name between start and end, in
+	// time order
+	Fetch(name string, start, end time.Time) ([]stat.Stat, error)
+}
+
+// Evaluator evaluates expressions over a time range of the stats provided by a
+// Fetcher
+type Evaluator struct {
+	Start, End time.Time
+	Step       time.Duration // alignment interval for cross-series functions
+
+	fetcher Fetcher
+}
+
+// NewEvaluator constructs an Evaluator over the range [start, end]
+func NewEvaluator(fetcher Fetcher, start, end time.Time) *Evaluator {
+	return &Evaluator{Start: start, End: end, Step: DefaultStep, fetcher: fetcher}
+}
+
+// Run parses and evaluates a query over the range [start, end]
+func Run(fetcher Fetcher, query string, start, end time.Time) ([]*Series, error) {
+	expr, err := Parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEvaluator(fetcher, start, end).Eval(expr)
+}
+
+// Eval evaluates an expression to a list of series
+func (e *Evaluator) Eval(expr Expr) ([]*Series, error) {
+	switch x := expr.(type) {
+	case *SeriesRef:
+		return e.fetch(x.Pattern)
+	case *Call:
+		f, ok := functions[x.Name]
+		if !ok {
+			return nil, fmt.Errorf("query: unknown function %s", x.Name)
+		}
+		return f(e, x)
+	default:
+		return nil, fmt.Errorf("query: expected a series expression, got %s", expr)
+	}
+}
+
+// shift returns a copy of the evaluator moved back in time by d
+func (e *Evaluator) shift(d time.Duration) *Evaluator {
+	shifted := *e
+	shifted.Start = e.Start.Add(-d)
+	shifted.End = e.End.Add(-d)
+	return &shifted
+}
+
+// fetch loads every series matching pattern
+func (e *Evaluator) fetch(pattern string) ([]*Series, error) {
+	names := []string{pattern}
+
+	if isPattern(pattern) {
+		all, err := e.fetcher.Names()
+		if err != nil {
+			return nil, err
+		}
+
+		names = names[:0]
+		for _, name := range all {
+			if MatchName(pattern, name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	}
+
+	series := make([]*Series, 0, len(names))
+	for _, name := range names {
+		stats, err := e.fetcher.Fetch(name, e.Start, e.End)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, newSeries(name, stats))
+	}
+	return series, nil
+}
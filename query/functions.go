@@ -0,0 +1,489 @@
+package query
+
+import (
+	"fmt"
+	"github.com/CapillarySoftware/gostat/stat"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// function evaluates a call to a query function
+type function func(e *Evaluator, call *Call) ([]*Series, error)
+
+var functions map[string]function
+
+func init() {
+	functions = map[string]function{
+		"sumSeries":          sumSeries,
+		"averageSeries":      averageSeries,
+		"scale":              scale,
+		"derivative":         derivative,
+		"rate":               rate,
+		"integral":           integral,
+		"movingAverage":      movingAverage,
+		"percentileOfSeries": percentileOfSeries,
+		"asPercent":          asPercent,
+		"timeShift":          timeShift,
+		"topK":               topK,
+		"groupByNodes":       groupByNodes,
+		"groupByTags":        groupByTags,
+		"alias":              alias,
+		"aliasByNode":        aliasByNode,
+	}
+}
+
+// sumSeries adds the series together, step by step
+func sumSeries(e *Evaluator, call *Call) ([]*Series, error) {
+	return combine(e, call, func(values []float64) float64 {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	})
+}
+
+// averageSeries averages the series together, step by step
+func averageSeries(e *Evaluator, call *Call) ([]*Series, error) {
+	return combine(e, call, func(values []float64) float64 {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	})
+}
+
+// percentileOfSeries computes the nth percentile across the series at each step
+func percentileOfSeries(e *Evaluator, call *Call) ([]*Series, error) {
+	if err := checkArgs(call, 2, 2); err != nil {
+		return nil, err
+	}
+
+	n, err := numberArg(call, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := e.Eval(call.Args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return []*Series{combineSeries(call.String(), in, e.Step, func(values []float64) float64 {
+		return Percentile(values, n)
+	})}, nil
+}
+
+// scale multiplies every value by a constant factor
+func scale(e *Evaluator, call *Call) ([]*Series, error) {
+	if err := checkArgs(call, 2, 2); err != nil {
+		return nil, err
+	}
+
+	factor, err := numberArg(call, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return transform(e, call, func(stats []stat.Stat) []stat.Stat {
+		for i := range stats {
+			stats[i].Value *= factor
+		}
+		return stats
+	})
+}
+
+// derivative replaces each value with its difference from the previous value
+func derivative(e *Evaluator, call *Call) ([]*Series, error) {
+	if err := checkArgs(call, 1, 1); err != nil {
+		return nil, err
+	}
+
+	return transform(e, call, func(stats []stat.Stat) []stat.Stat {
+		out := make([]stat.Stat, 0, len(stats))
+		for i := 1; i < len(stats); i++ {
+			out = append(out, stat.Stat{Timestamp: stats[i].Timestamp, Value: stats[i].Value - stats[i-1].Value})
+		}
+		return out
+	})
+}
+
+// rate is the derivative of the series per second
+func rate(e *Evaluator, call *Call) ([]*Series, error) {
+	if err := checkArgs(call, 1, 1); err != nil {
+		return nil, err
+	}
+
+	return transform(e, call, func(stats []stat.Stat) []stat.Stat {
+		out := make([]stat.Stat, 0, len(stats))
+		for i := 1; i < len(stats); i++ {
+			elapsed := stats[i].Timestamp.Sub(stats[i-1].Timestamp).Seconds()
+			if elapsed <= 0 {
+				continue
+			}
+			out = append(out, stat.Stat{Timestamp: stats[i].Timestamp, Value: (stats[i].Value - stats[i-1].Value) / elapsed})
+		}
+		return out
+	})
+}
+
+// integral replaces each value with the running total of the series
+func integral(e *Evaluator, call *Call) ([]*Series, error) {
+	if err := checkArgs(call, 1, 1); err != nil {
+		return nil, err
+	}
+
+	return transform(e, call, func(stats []stat.Stat) []stat.Stat {
+		total := 0.0
+		for i := range stats {
+			total += stats[i].Value
+			stats[i].Value = total
+		}
+		return stats
+	})
+}
+
+// movingAverage averages each value with those preceding it, over either a
+// number of points, e.g. movingAverage(foo, 10), or a duration, e.g.
+// movingAverage(foo, 5m)
+func movingAverage(e *Evaluator, call *Call) ([]*Series, error) {
+	if err := checkArgs(call, 2, 2); err != nil {
+		return nil, err
+	}
+
+	var points int
+	var window time.Duration
+
+	switch arg := call.Args[1].(type) {
+	case *Number:
+		points = int(arg.Value)
+	case *Duration:
+		window = arg.Value
+	case *String:
+		var err error
+		if window, err = ParseDuration(arg.Value); err != nil {
+			return nil, err
+		}
+	}
+	if points <= 0 && window <= 0 {
+		return nil, fmt.Errorf("query: %s expects a positive window", call.Name)
+	}
+
+	return transform(e, call, func(stats []stat.Stat) []stat.Stat {
+		out := make([]stat.Stat, len(stats))
+		sum := 0.0
+		first := 0
+		for i := range stats {
+			sum += stats[i].Value
+			for (points > 0 && i-first >= points) ||
+				(window > 0 && !stats[first].Timestamp.After(stats[i].Timestamp.Add(-window))) {
+				sum -= stats[first].Value
+				first++
+			}
+			out[i] = stat.Stat{Timestamp: stats[i].Timestamp, Value: sum / float64(i-first+1)}
+		}
+		return out
+	})
+}
+
+// asPercent expresses each series as a percentage of a total. The total is
+// either a number, a series expression (summed if it yields several series),
+// or, when omitted, the sum of the series themselves
+func asPercent(e *Evaluator, call *Call) ([]*Series, error) {
+	if err := checkArgs(call, 1, 2); err != nil {
+		return nil, err
+	}
+
+	in, err := e.Eval(call.Args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var constant float64
+	var totals map[time.Time]float64
+
+	if len(call.Args) == 2 {
+		if n, ok := call.Args[1].(*Number); ok {
+			constant = n.Value
+		} else {
+			total, err := e.Eval(call.Args[1])
+			if err != nil {
+				return nil, err
+			}
+			totals = sum(total, e.Step).consolidate(e.Step)
+		}
+	} else {
+		totals = sum(in, e.Step).consolidate(e.Step)
+	}
+
+	out := make([]*Series, 0, len(in))
+	for _, s := range in {
+		var stats []stat.Stat
+		for t, v := range s.consolidate(e.Step) {
+			total := constant
+			if totals != nil {
+				total = totals[t]
+			}
+			if total == 0 {
+				continue
+			}
+			stats = append(stats, stat.Stat{Timestamp: t, Value: v / total * 100})
+		}
+		sort.Sort(byTimestamp(stats))
+		out = append(out, newSeries(fmt.Sprintf("asPercent(%s)", s.Name), stats))
+	}
+	return out, nil
+}
+
+// timeShift draws the series as it was a duration ago, e.g. timeShift(foo, 1d)
+func timeShift(e *Evaluator, call *Call) ([]*Series, error) {
+	if err := checkArgs(call, 2, 2); err != nil {
+		return nil, err
+	}
+
+	shift, err := durationArg(call, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := e.shift(shift).Eval(call.Args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	for i, s := range in {
+		for j := range s.Stats {
+			s.Stats[j].Timestamp = s.Stats[j].Timestamp.Add(shift)
+		}
+		in[i] = newSeries(fmt.Sprintf("timeShift(%s,%s)", s.Name, call.Args[1]), s.Stats)
+	}
+	return in, nil
+}
+
+// topK keeps the k series with the highest average value
+func topK(e *Evaluator, call *Call) ([]*Series, error) {
+	if err := checkArgs(call, 2, 2); err != nil {
+		return nil, err
+	}
+
+	k, err := countArg(call, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := e.Eval(call.Args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Stable(byAverageDesc(in))
+	if k < len(in) {
+		in = in[:k]
+	}
+	return in, nil
+}
+
+// alias renames every series, e.g. alias(sumSeries(web.*.requests), "requests")
+func alias(e *Evaluator, call *Call) ([]*Series, error) {
+	if err := checkArgs(call, 2, 2); err != nil {
+		return nil, err
+	}
+
+	name, ok := call.Args[1].(*String)
+	if !ok {
+		return nil, fmt.Errorf("query: argument 2 of %s must be a name", call.Name)
+	}
+
+	in, err := e.Eval(call.Args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	for i, s := range in {
+		in[i] = newSeries(name.Value, s.Stats)
+	}
+	return in, nil
+}
+
+// aliasByNode renames each series after one or more zero based segments of its
+// metric name, counting from the end if negative, e.g. aliasByNode(web.*.requests, 1)
+// names web.host1.requests host1
+func aliasByNode(e *Evaluator, call *Call) ([]*Series, error) {
+	if err := checkArgs(call, 2, -1); err != nil {
+		return nil, err
+	}
+
+	var nodes []int
+	for i := 1; i < len(call.Args); i++ {
+		n, err := numberArg(call, i)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, int(n))
+	}
+
+	in, err := e.Eval(call.Args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	for i, s := range in {
+		metric, _ := stat.ParseName(s.Name)
+		segments := strings.Split(metric, ".")
+
+		name := make([]string, 0, len(nodes))
+		for _, n := range nodes {
+			if n < 0 {
+				n += len(segments)
+			}
+			if n >= 0 && n < len(segments) {
+				name = append(name, segments[n])
+			}
+		}
+		in[i] = newSeries(strings.Join(name, "."), s.Stats)
+	}
+	return in, nil
+}
+
+// transform evaluates the first argument of a call and applies f to each
+// resulting series, naming the results after the call
+func transform(e *Evaluator, call *Call, f func([]stat.Stat) []stat.Stat) ([]*Series, error) {
+	in, err := e.Eval(call.Args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, len(call.Args))
+	for _, a := range call.Args[1:] {
+		args = append(args, a.String())
+	}
+
+	out := make([]*Series, len(in))
+	for i, s := range in {
+		name := call.Name + "(" + strings.Join(append([]string{s.Name}, args...), ",") + ")"
+		out[i] = newSeries(name, f(s.Stats))
+	}
+	return out, nil
+}
+
+// combine evaluates every argument of a call and merges all of the resulting
+// series into one with f
+func combine(e *Evaluator, call *Call, f func([]float64) float64) ([]*Series, error) {
+	if err := checkArgs(call, 1, -1); err != nil {
+		return nil, err
+	}
+
+	var in []*Series
+	for _, arg := range call.Args {
+		series, err := e.Eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		in = append(in, series...)
+	}
+
+	return []*Series{combineSeries(call.String(), in, e.Step, f)}, nil
+}
+
+// combineSeries aligns the series to step and reduces the values present at
+// each step to one with f
+func combineSeries(name string, in []*Series, step time.Duration, f func([]float64) float64) *Series {
+	consolidated := make([]map[time.Time]float64, len(in))
+	for i, s := range in {
+		consolidated[i] = s.consolidate(step)
+	}
+
+	var stats []stat.Stat
+	for _, t := range steps(consolidated) {
+		var values []float64
+		for _, c := range consolidated {
+			if v, ok := c[t]; ok {
+				values = append(values, v)
+			}
+		}
+		stats = append(stats, stat.Stat{Timestamp: t, Value: f(values)})
+	}
+	return newSeries(name, stats)
+}
+
+// sum adds series together, step by step
+func sum(in []*Series, step time.Duration) *Series {
+	return combineSeries("sum", in, step, func(values []float64) float64 {
+		total := 0.0
+		for _, v := range values {
+			total += v
+		}
+		return total
+	})
+}
+
+// Percentile returns the nth percentile of values using the nearest rank
+// method, or NaN if values is empty
+func Percentile(values []float64, n float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	rank := int(math.Ceil(n / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	} else if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+func checkArgs(call *Call, min, max int) error {
+	if len(call.Args) < min || (max >= 0 && len(call.Args) > max) {
+		return fmt.Errorf("query: wrong number of arguments to %s", call.Name)
+	}
+	return nil
+}
+
+func numberArg(call *Call, i int) (float64, error) {
+	if n, ok := call.Args[i].(*Number); ok {
+		return n.Value, nil
+	}
+	return 0, fmt.Errorf("query: argument %d of %s must be a number", i+1, call.Name)
+}
+
+// countArg returns an argument that must be a whole number of at least zero
+func countArg(call *Call, i int) (int, error) {
+	n, err := numberArg(call, i)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n != math.Trunc(n) || n > math.MaxInt32 {
+		return 0, fmt.Errorf("query: argument %d of %s must be a whole number of at least 0", i+1, call.Name)
+	}
+	return int(n), nil
+}
+
+func durationArg(call *Call, i int) (time.Duration, error) {
+	switch arg := call.Args[i].(type) {
+	case *Duration:
+		return arg.Value, nil
+	case *String:
+		return ParseDuration(arg.Value)
+	}
+	return 0, fmt.Errorf("query: argument %d of %s must be a duration", i+1, call.Name)
+}
+
+type byTimestamp []stat.Stat
+
+func (s byTimestamp) Len() int           { return len(s) }
+func (s byTimestamp) Less(i, j int) bool { return s[i].Timestamp.Before(s[j].Timestamp) }
+func (s byTimestamp) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type byAverageDesc []*Series
+
+func (s byAverageDesc) Len() int           { return len(s) }
+func (s byAverageDesc) Less(i, j int) bool { return s[i].Average() > s[j].Average() }
+func (s byAverageDesc) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,193 @@
+package query
+
+import (
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+// fakeFetcher serves stats from memory, ignoring the requested range
+type fakeFetcher map[string][]stat.Stat
+
+func (f fakeFetcher) Names() ([]string, error) {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (f fakeFetcher) Fetch(name string, start, end time.Time) ([]stat.Stat, error) {
+	var stats []stat.Stat
+	for _, s := range f[name] {
+		if !s.Timestamp.Before(start) && !s.Timestamp.After(end) {
+			stats = append(stats, s)
+		}
+	}
+	return stats, nil
+}
+
+// values returns just the values of a series
+func values(s *Series) []float64 {
+	v := make([]float64, len(s.Stats))
+	for i := range s.Stats {
+		v[i] = s.Stats[i].Value
+	}
+	return v
+}
+
+var _ = Describe("Functions", func() {
+
+	var start time.Time
+	var fetcher fakeFetcher
+
+	// at returns a stat n minutes after start
+	at := func(n int, v float64) stat.Stat {
+		return stat.Stat{Timestamp: start.Add(time.Minute * time.Duration(n)), Value: v}
+	}
+
+	run := func(q string) []*Series {
+		results, err := Run(fetcher, q, start, start.Add(time.Hour))
+		Expect(err).To(BeNil())
+		return results
+	}
+
+	BeforeEach(func() {
+		start = time.Date(2014, 10, 1, 12, 0, 0, 0, time.UTC)
+		fetcher = fakeFetcher{
+			"web.host1.requests": {at(0, 10), at(1, 20), at(2, 40), at(3, 40)},
+			"web.host2.requests": {at(0, 1), at(1, 2), at(2, 3), at(3, 4)},
+			"web.host3.requests": {at(0, 100), at(1, 100), at(2, 100), at(3, 100)},
+			"db.latency":         {at(0, 5), at(1, 7)},
+		}
+	})
+
+	It("should return every series matching a pattern, sorted by name", func() {
+		results := run("web.*.requests")
+		Expect(results).To(HaveLen(3))
+		Expect(results[0].Name).To(Equal("web.host1.requests"))
+		Expect(results[2].Name).To(Equal("web.host3.requests"))
+	})
+
+	It("should return an error for an unknown function", func() {
+		_, err := Run(fetcher, "nope(db.latency)", start, start.Add(time.Hour))
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("sumSeries should add the series together step by step", func() {
+		results := run("sumSeries(web.*.requests)")
+		Expect(results).To(HaveLen(1))
+		Expect(results[0].Name).To(Equal("sumSeries(web.*.requests)"))
+		Expect(values(results[0])).To(Equal([]float64{111, 122, 143, 144}))
+	})
+
+	It("scale should multiply every value", func() {
+		results := run("scale(db.latency, 2)")
+		Expect(results[0].Name).To(Equal("scale(db.latency,2)"))
+		Expect(values(results[0])).To(Equal([]float64{10, 14}))
+	})
+
+	It("derivative should return the difference between consecutive values", func() {
+		results := run("derivative(web.host1.requests)")
+		Expect(values(results[0])).To(Equal([]float64{10, 20, 0}))
+	})
+
+	It("rate should return the per second derivative", func() {
+		results := run("rate(web.host1.requests)")
+		Expect(values(results[0])).To(Equal([]float64{10.0 / 60, 20.0 / 60, 0}))
+	})
+
+	It("integral should return the running total", func() {
+		results := run("integral(web.host2.requests)")
+		Expect(values(results[0])).To(Equal([]float64{1, 3, 6, 10}))
+	})
+
+	It("movingAverage should average over a number of points", func() {
+		results := run("movingAverage(web.host1.requests, 2)")
+		Expect(values(results[0])).To(Equal([]float64{10, 15, 30, 40}))
+	})
+
+	It("movingAverage should average over a duration", func() {
+		results := run("movingAverage(web.host1.requests, 3m)")
+		Expect(values(results[0])).To(Equal([]float64{10, 15, 70.0 / 3, 100.0 / 3}))
+	})
+
+	It("should compose functions", func() {
+		results := run("movingAverage(rate(web.*.requests), 5m)")
+		Expect(results).To(HaveLen(3))
+		Expect(results[0].Name).To(Equal("movingAverage(rate(web.host1.requests),5m)"))
+	})
+
+	It("percentileOfSeries should return the nth percentile across series", func() {
+		results := run("percentileOfSeries(web.*.requests, 50)")
+		Expect(values(results[0])).To(Equal([]float64{10, 20, 40, 40}))
+	})
+
+	It("asPercent should express series as a percentage of a constant", func() {
+		results := run("asPercent(web.host3.requests, 200)")
+		Expect(values(results[0])).To(Equal([]float64{50, 50, 50, 50}))
+	})
+
+	It("asPercent should default to a percentage of the sum of the series", func() {
+		results := run("asPercent(web.{host2,host3}.requests)")
+		Expect(results).To(HaveLen(2))
+		Expect(values(results[0])[0]).To(BeNumerically("~", 100.0/101, 1e-9))
+		Expect(values(results[1])[0]).To(BeNumerically("~", 10000.0/101, 1e-9))
+	})
+
+	It("timeShift should return the series as it was in the past", func() {
+		fetcher["db.latency"] = append(fetcher["db.latency"], stat.Stat{Timestamp: start.Add(-time.Hour * 24), Value: 99})
+
+		results := run("timeShift(db.latency, 1d)")
+		Expect(results[0].Name).To(Equal("timeShift(db.latency,1d)"))
+		Expect(results[0].Stats).To(HaveLen(1))
+		Expect(results[0].Stats[0].Timestamp).To(Equal(start))
+		Expect(results[0].Stats[0].Value).To(Equal(99.0))
+	})
+
+	It("topK should keep the series with the highest averages", func() {
+		results := run("topK(web.*.requests, 2)")
+		Expect(results).To(HaveLen(2))
+		Expect(results[0].Name).To(Equal("web.host3.requests"))
+		Expect(results[1].Name).To(Equal("web.host1.requests"))
+	})
+
+	It("alias should rename every series", func() {
+		results := run("alias(sumSeries(web.*.requests), 'requests')")
+		Expect(results[0].Name).To(Equal("requests"))
+		Expect(results[0].Stats[0].Name).To(Equal("requests"))
+	})
+
+	It("aliasByNode should rename series after segments of their names", func() {
+		fetcher["web.host1.requests;dc=east"] = []stat.Stat{at(0, 1)}
+
+		results := run("aliasByNode(web.*.requests;dc=east, 1, -1)")
+		Expect(results).To(HaveLen(1))
+		Expect(results[0].Name).To(Equal("host1.requests"))
+	})
+
+	It("topK should reject a negative or fractional k", func() {
+		_, err := Run(fetcher, "topK(web.*.requests, -1)", start, start.Add(time.Hour))
+		Expect(err).To(HaveOccurred())
+
+		_, err = Run(fetcher, "topK(web.*.requests, 1.5)", start, start.Add(time.Hour))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should return an error when a function receives the wrong arguments", func() {
+		_, err := Run(fetcher, "scale(db.latency)", start, start.Add(time.Hour))
+		Expect(err).NotTo(BeNil())
+
+		_, err = Run(fetcher, "scale(db.latency, web.host1.requests)", start, start.Add(time.Hour))
+		Expect(err).NotTo(BeNil())
+	})
+
+	Describe("Percentile", func() {
+		It("should use the nearest rank", func() {
+			Expect(Percentile([]float64{15, 20, 35, 40, 50}, 30)).To(Equal(20.0))
+			Expect(Percentile([]float64{15, 20, 35, 40, 50}, 100)).To(Equal(50.0))
+			Expect(Percentile([]float64{15, 20, 35, 40, 50}, 0)).To(Equal(15.0))
+		})
+	})
+})
@@ -0,0 +1,182 @@
+package query
+
+import (
+	"fmt"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/stat"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// groupAggregate reduces the values a group of series has at a step to one
+type groupAggregate func(a aggregator.StatsAggregate, values []float64) float64
+
+// parseGroupAggregate parses the name of a group aggregate: sum, avg, min, max,
+// count, or a percentile such as p95 or p99.9
+func parseGroupAggregate(name string) (groupAggregate, error) {
+	switch name {
+	case "sum":
+		return func(a aggregator.StatsAggregate, _ []float64) float64 { return a.Average * float64(a.Count) }, nil
+	case "avg", "average":
+		return func(a aggregator.StatsAggregate, _ []float64) float64 { return a.Average }, nil
+	case "min":
+		return func(a aggregator.StatsAggregate, _ []float64) float64 { return a.Min }, nil
+	case "max":
+		return func(a aggregator.StatsAggregate, _ []float64) float64 { return a.Max }, nil
+	case "count":
+		return func(a aggregator.StatsAggregate, _ []float64) float64 { return float64(a.Count) }, nil
+	}
+
+	if strings.HasPrefix(name, "p") {
+		if n, err := strconv.ParseFloat(name[1:], 64); err == nil && n >= 0 && n <= 100 {
+			return func(_ aggregator.StatsAggregate, values []float64) float64 { return Percentile(values, n) }, nil
+		}
+	}
+
+	return nil, fmt.Errorf("query: unknown group aggregate %q", name)
+}
+
+// groupByNodes groups series by one or more zero based segments of their
+// metric names and aggregates each group, e.g. groupByNodes(web.*.*, "sum", 2)
+// sums web.host1.requests and web.host2.requests into a series named requests.
+// A negative node counts from the end, as in aliasByNode, and a node a name
+// does not have is an error
+func groupByNodes(e *Evaluator, call *Call) ([]*Series, error) {
+	if err := checkArgs(call, 3, -1); err != nil {
+		return nil, err
+	}
+
+	var nodes []int
+	for i := 2; i < len(call.Args); i++ {
+		n, err := numberArg(call, i)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, int(n))
+	}
+
+	return group(e, call, func(name string) (string, error) {
+		metric, _ := stat.ParseName(name)
+		segments := strings.Split(metric, ".")
+
+		key := make([]string, 0, len(nodes))
+		for _, n := range nodes {
+			i := n
+			if i < 0 {
+				i += len(segments)
+			}
+			if i < 0 || i >= len(segments) {
+				return "", fmt.Errorf("query: node %d of %s is not in %s", n, call.Name, metric)
+			}
+			key = append(key, segments[i])
+		}
+		return strings.Join(key, "."), nil
+	})
+}
+
+// groupByTags groups series by their metric name and the values of one or more
+// tags, and aggregates each group, e.g. groupByTags(web.requests;*, "avg", "dc")
+// averages web.requests;dc=east;host=web1 and web.requests;dc=east;host=web2
+// into a series named web.requests;dc=east
+func groupByTags(e *Evaluator, call *Call) ([]*Series, error) {
+	if err := checkArgs(call, 3, -1); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for i := 2; i < len(call.Args); i++ {
+		s, ok := call.Args[i].(*String)
+		if !ok {
+			return nil, fmt.Errorf("query: argument %d of %s must be a tag name", i+1, call.Name)
+		}
+		keys = append(keys, s.Value)
+	}
+
+	return group(e, call, func(name string) (string, error) {
+		metric, tags := stat.ParseName(name)
+
+		grouped := make(map[string]string)
+		for _, k := range keys {
+			if v, ok := tags[k]; ok {
+				grouped[k] = v
+			}
+		}
+		return stat.FormatName(metric, grouped), nil
+	})
+}
+
+// group evaluates the first argument of a call, partitions the resulting series
+// by key, and aggregates each partition step by step with the aggregate named
+// by the second argument. The stats of each series are aggregated per step with
+// aggregator.Aggregate, and the series of a group are then combined with
+// aggregator.AppendStatsAggregate, so that e.g. an average is weighted by the
+// number of stats behind it rather than being an average of averages
+func group(e *Evaluator, call *Call, key func(name string) (string, error)) ([]*Series, error) {
+	name, ok := call.Args[1].(*String)
+	if !ok {
+		return nil, fmt.Errorf("query: argument 2 of %s must be an aggregate name", call.Name)
+	}
+
+	f, err := parseGroupAggregate(name.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := e.Eval(call.Args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]*Series)
+	var keys []string
+	for _, s := range in {
+		k, err := key(s.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], s)
+	}
+	sort.Strings(keys)
+
+	out := make([]*Series, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, aggregateGroup(k, groups[k], e.Step, f))
+	}
+	return out, nil
+}
+
+// aggregateGroup combines a group of series into one, step by step
+func aggregateGroup(name string, group []*Series, step time.Duration, f groupAggregate) *Series {
+	aggregates := make(map[time.Time]aggregator.StatsAggregate)
+	values := make(map[time.Time][]float64)
+
+	for _, s := range group {
+		buckets := make(map[time.Time][]*stat.Stat)
+		for i := range s.Stats {
+			t := s.Stats[i].Timestamp.Truncate(step)
+			buckets[t] = append(buckets[t], &s.Stats[i])
+			values[t] = append(values[t], s.Stats[i].Value)
+		}
+
+		for t, bucket := range buckets {
+			aggregates[t] = aggregator.AppendStatsAggregate(aggregates[t], aggregator.Aggregate(bucket))
+		}
+	}
+
+	times := make([]time.Time, 0, len(aggregates))
+	for t := range aggregates {
+		times = append(times, t)
+	}
+	sort.Sort(byTime(times))
+
+	stats := make([]stat.Stat, len(times))
+	for i, t := range times {
+		stats[i] = stat.Stat{Timestamp: t, Value: f(aggregates[t], values[t])}
+	}
+	return newSeries(name, stats)
+}
@@ -0,0 +1,112 @@
+package query
+
+import (
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("Group", func() {
+
+	var start time.Time
+	var fetcher fakeFetcher
+
+	at := func(seconds int, v float64) stat.Stat {
+		return stat.Stat{Timestamp: start.Add(time.Second * time.Duration(seconds)), Value: v}
+	}
+
+	run := func(q string) []*Series {
+		results, err := Run(fetcher, q, start, start.Add(time.Hour))
+		Expect(err).To(BeNil())
+		return results
+	}
+
+	BeforeEach(func() {
+		start = time.Date(2014, 10, 1, 12, 0, 0, 0, time.UTC)
+		fetcher = fakeFetcher{
+			// host1 reports three times in the first minute, host2 once
+			"web.requests;dc=east;host=web1": {at(0, 1), at(10, 2), at(20, 3), at(60, 10)},
+			"web.requests;dc=east;host=web2": {at(0, 10), at(60, 20)},
+			"web.requests;dc=west;host=web3": {at(0, 100)},
+			"web.latency;dc=east;host=web1":  {at(0, 7)},
+		}
+	})
+
+	Describe("groupByTags", func() {
+		It("should group series by metric name and tag values", func() {
+			results := run(`groupByTags(web.requests;*, "sum", "dc")`)
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Name).To(Equal("web.requests;dc=east"))
+			Expect(values(results[0])).To(Equal([]float64{16, 30}))
+			Expect(results[1].Name).To(Equal("web.requests;dc=west"))
+			Expect(values(results[1])).To(Equal([]float64{100}))
+		})
+
+		It("should weight averages by the number of stats rather than averaging averages", func() {
+			results := run(`groupByTags(web.requests;dc=east, "avg", "dc")`)
+			Expect(results).To(HaveLen(1))
+
+			// (1 + 2 + 3 + 10) / 4, not ((1 + 2 + 3) / 3 + 10) / 2
+			Expect(values(results[0])).To(Equal([]float64{4, 15}))
+		})
+
+		It("should support min, max, count and percentiles", func() {
+			Expect(values(run(`groupByTags(web.requests;dc=east, "min", "dc")`)[0])).To(Equal([]float64{1, 10}))
+			Expect(values(run(`groupByTags(web.requests;dc=east, "max", "dc")`)[0])).To(Equal([]float64{10, 20}))
+			Expect(values(run(`groupByTags(web.requests;dc=east, "count", "dc")`)[0])).To(Equal([]float64{4, 2}))
+			Expect(values(run(`groupByTags(web.requests;dc=east, "p50", "dc")`)[0])).To(Equal([]float64{2, 10}))
+		})
+
+		It("should keep different metrics in different groups", func() {
+			results := run(`groupByTags(web.*;dc=east, "sum", "dc")`)
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Name).To(Equal("web.latency;dc=east"))
+			Expect(results[1].Name).To(Equal("web.requests;dc=east"))
+		})
+
+		It("should return an error for an unknown aggregate", func() {
+			_, err := Run(fetcher, `groupByTags(web.requests;*, "median", "dc")`, start, start.Add(time.Hour))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("groupByNodes", func() {
+		It("should group series by name segments", func() {
+			results := run(`groupByNodes(web.*, "sum", 1)`)
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Name).To(Equal("latency"))
+			Expect(values(results[0])).To(Equal([]float64{7}))
+			Expect(results[1].Name).To(Equal("requests"))
+			Expect(values(results[1])).To(Equal([]float64{116, 30}))
+		})
+
+		It("should join several segments with '.'", func() {
+			results := run(`groupByNodes(web.*, "count", 0, 1)`)
+			Expect(results).To(HaveLen(2))
+			Expect(results[1].Name).To(Equal("web.requests"))
+			Expect(values(results[1])).To(Equal([]float64{5, 2}))
+		})
+
+		It("should count a negative node from the end, and reject a node a name does not have", func() {
+			results := run(`groupByNodes(web.*, "sum", -1)`)
+			Expect(results).To(HaveLen(2))
+			Expect(results[1].Name).To(Equal("requests"))
+			Expect(values(results[1])).To(Equal([]float64{116, 30}))
+
+			_, err := Run(fetcher, `groupByNodes(web.*, "sum", 2)`, start, start.Add(time.Hour))
+			Expect(err).To(MatchError(ContainSubstring("node 2 of groupByNodes is not in web.")))
+			_, err = Run(fetcher, `groupByNodes(web.*, "sum", -3)`, start, start.Add(time.Hour))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("MatchName", func() {
+		It("should match tag filters against tag values", func() {
+			Expect(MatchName("web.requests;dc=east", "web.requests;dc=east;host=web1")).To(BeTrue())
+			Expect(MatchName("web.requests;dc=e*", "web.requests;dc=east;host=web1")).To(BeTrue())
+			Expect(MatchName("web.requests;dc=west", "web.requests;dc=east;host=web1")).To(BeFalse())
+			Expect(MatchName("web.requests;rack=1", "web.requests;dc=east;host=web1")).To(BeFalse())
+		})
+	})
+})
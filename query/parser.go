@@ -0,0 +1,237 @@
+// Package query parses and evaluates expressions over series of statistics,
+// e.g. movingAverage(rate(web.*.requests), 5m)
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expr is a node of a parsed query expression
+type Expr interface {
+	String() string
+}
+
+// Call is a function applied to a list of arguments, e.g. scale(foo, 10)
+type Call struct {
+	Name string
+	Args []Expr
+}
+
+// SeriesRef selects every series whose name matches Pattern
+type SeriesRef struct {
+	Pattern string
+}
+
+// Number is a numeric literal
+type Number struct {
+	Value float64
+}
+
+// Duration is a duration literal such as 30s, 5m, 1h, 1d or 1w
+type Duration struct {
+	Value time.Duration
+	text  string
+}
+
+// String is a quoted string literal
+type String struct {
+	Value string
+}
+
+func (c *Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		args[i] = a.String()
+	}
+	return c.Name + "(" + strings.Join(args, ",") + ")"
+}
+
+func (s *SeriesRef) String() string { return s.Pattern }
+func (n *Number) String() string    { return strconv.FormatFloat(n.Value, 'f', -1, 64) }
+func (d *Duration) String() string  { return d.text }
+func (s *String) String() string    { return strconv.Quote(s.Value) }
+
+var (
+	numberRegexp   = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+	durationRegexp = regexp.MustCompile(`^([0-9]+)(s|min|m|h|d|w)$`)
+)
+
+// Parse parses a query expression
+func Parse(query string) (Expr, error) {
+	p := &parser{input: query}
+
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+
+	return expr, nil
+}
+
+// ParseDuration parses a query duration such as 30s, 5m, 1h, 1d or 1w
+func ParseDuration(s string) (time.Duration, error) {
+	m := durationRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	n, _ := strconv.Atoi(m[1])
+	var unit time.Duration
+	switch m[2] {
+	case "s":
+		unit = time.Second
+	case "m", "min":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	case "d":
+		unit = time.Hour * 24
+	case "w":
+		unit = time.Hour * 24 * 7
+	}
+
+	return time.Duration(n) * unit, nil
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("query: position %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t' || p.input[p.pos] == '\n') {
+		p.pos++
+	}
+}
+
+func (p *parser) peek() byte {
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+// parseExpr parses a call, series pattern, number, duration or string
+func (p *parser) parseExpr() (Expr, error) {
+	p.skipSpace()
+
+	switch c := p.peek(); {
+	case c == 0:
+		return nil, p.errorf("unexpected end of query")
+	case c == '"' || c == '\'':
+		return p.parseString()
+	}
+
+	word := p.scanWord()
+	if word == "" {
+		return nil, p.errorf("unexpected %q", string(p.peek()))
+	}
+
+	p.skipSpace()
+	if p.peek() == '(' {
+		return p.parseCall(word)
+	}
+
+	if numberRegexp.MatchString(word) {
+		v, err := strconv.ParseFloat(word, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", word)
+		}
+		return &Number{Value: v}, nil
+	}
+
+	if durationRegexp.MatchString(word) {
+		d, _ := ParseDuration(word)
+		return &Duration{Value: d, text: word}, nil
+	}
+
+	return &SeriesRef{Pattern: word}, nil
+}
+
+// scanWord consumes an identifier, number, duration or series pattern. Commas
+// are part of the word when they appear inside a {a,b} alternation
+func (p *parser) scanWord() string {
+	start := p.pos
+	braces := 0
+
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		switch {
+		case c == '{':
+			braces++
+		case c == '}' && braces > 0:
+			braces--
+		case c == ',' && braces > 0:
+		case isWordChar(c):
+		default:
+			return p.input[start:p.pos]
+		}
+		p.pos++
+	}
+
+	return p.input[start:p.pos]
+}
+
+func isWordChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		strings.IndexByte("._-*?[]:;=", c) >= 0
+}
+
+func (p *parser) parseCall(name string) (Expr, error) {
+	call := &Call{Name: name}
+	p.pos++ // '('
+
+	p.skipSpace()
+	if p.peek() == ')' {
+		p.pos++
+		return call, nil
+	}
+
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return call, nil
+		case 0:
+			return nil, p.errorf("missing ')' in call to %s", name)
+		default:
+			return nil, p.errorf("unexpected %q in call to %s", string(p.peek()), name)
+		}
+	}
+}
+
+func (p *parser) parseString() (Expr, error) {
+	quote := p.input[p.pos]
+	p.pos++
+
+	end := strings.IndexByte(p.input[p.pos:], quote)
+	if end < 0 {
+		return nil, p.errorf("unterminated string")
+	}
+
+	s := &String{Value: p.input[p.pos : p.pos+end]}
+	p.pos += end + 1
+	return s, nil
+}
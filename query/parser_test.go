@@ -0,0 +1,102 @@
+package query
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("Parser", func() {
+
+	Describe("Parse", func() {
+		It("should parse a bare series name", func() {
+			expr, err := Parse("web.host1.requests")
+			Expect(err).To(BeNil())
+			Expect(expr).To(Equal(&SeriesRef{Pattern: "web.host1.requests"}))
+		})
+
+		It("should parse nested calls with number and duration arguments", func() {
+			expr, err := Parse("movingAverage(rate(web.*.requests), 5m)")
+			Expect(err).To(BeNil())
+			Expect(expr).To(Equal(&Call{Name: "movingAverage", Args: []Expr{
+				&Call{Name: "rate", Args: []Expr{&SeriesRef{Pattern: "web.*.requests"}}},
+				&Duration{Value: time.Minute * 5, text: "5m"},
+			}}))
+		})
+
+		It("should parse numbers and strings", func() {
+			expr, err := Parse(`scale(foo, -1.5)`)
+			Expect(err).To(BeNil())
+			Expect(expr.(*Call).Args[1]).To(Equal(&Number{Value: -1.5}))
+
+			expr, err = Parse(`timeShift(foo, "1d")`)
+			Expect(err).To(BeNil())
+			Expect(expr.(*Call).Args[1]).To(Equal(&String{Value: "1d"}))
+		})
+
+		It("should keep commas inside {a,b} alternations in series patterns", func() {
+			expr, err := Parse("sumSeries(web.{host1,host2}.requests, other)")
+			Expect(err).To(BeNil())
+			Expect(expr.(*Call).Args).To(Equal([]Expr{
+				&SeriesRef{Pattern: "web.{host1,host2}.requests"},
+				&SeriesRef{Pattern: "other"},
+			}))
+		})
+
+		It("should return an error for unbalanced parentheses", func() {
+			_, err := Parse("rate(foo")
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("should return an error for trailing input", func() {
+			_, err := Parse("rate(foo))")
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("should return an error for an empty query", func() {
+			_, err := Parse("  ")
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("should print an expression the way it was written, without spaces", func() {
+			expr, err := Parse("movingAverage( rate(foo) , 10)")
+			Expect(err).To(BeNil())
+			Expect(expr.String()).To(Equal("movingAverage(rate(foo),10)"))
+		})
+	})
+
+	Describe("ParseDuration", func() {
+		It("should parse each of the supported units", func() {
+			for text, expected := range map[string]time.Duration{
+				"30s":  time.Second * 30,
+				"5m":   time.Minute * 5,
+				"5min": time.Minute * 5,
+				"2h":   time.Hour * 2,
+				"1d":   time.Hour * 24,
+				"1w":   time.Hour * 24 * 7,
+			} {
+				d, err := ParseDuration(text)
+				Expect(err).To(BeNil())
+				Expect(d).To(Equal(expected))
+			}
+		})
+
+		It("should reject an unknown unit", func() {
+			_, err := ParseDuration("5y")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Describe("MatchName", func() {
+		It("should not let wildcards match across a '.'", func() {
+			Expect(MatchName("web.*.requests", "web.host1.requests")).To(BeTrue())
+			Expect(MatchName("web.*.requests", "web.host1.nginx.requests")).To(BeFalse())
+			Expect(MatchName("web.host?.requests", "web.host2.requests")).To(BeTrue())
+		})
+
+		It("should expand {a,b} alternations", func() {
+			Expect(MatchName("web.{host1,host2}.requests", "web.host2.requests")).To(BeTrue())
+			Expect(MatchName("web.{host1,host2}.requests", "web.host3.requests")).To(BeFalse())
+		})
+	})
+})
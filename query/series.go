@@ -0,0 +1,137 @@
+package query
+
+import (
+	"github.com/CapillarySoftware/gostat/stat"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Series is a named, time ordered sequence of stats
+type Series struct {
+	Name  string
+	Stats []stat.Stat
+}
+
+// newSeries constructs a Series, renaming every stat to the series name
+func newSeries(name string, stats []stat.Stat) *Series {
+	for i := range stats {
+		stats[i].Name = name
+	}
+	return &Series{Name: name, Stats: stats}
+}
+
+// Average returns the average value of the series, or 0 if it is empty
+func (s *Series) Average() float64 {
+	if len(s.Stats) == 0 {
+		return 0
+	}
+
+	sum := 0.0
+	for _, st := range s.Stats {
+		sum += st.Value
+	}
+	return sum / float64(len(s.Stats))
+}
+
+// consolidate averages the series' values into fixed steps aligned on step
+// boundaries, returning a map from the start of each step to its value
+func (s *Series) consolidate(step time.Duration) map[time.Time]float64 {
+	sums := make(map[time.Time]float64)
+	counts := make(map[time.Time]int)
+
+	for _, st := range s.Stats {
+		t := st.Timestamp.Truncate(step)
+		sums[t] += st.Value
+		counts[t]++
+	}
+
+	for t, sum := range sums {
+		sums[t] = sum / float64(counts[t])
+	}
+	return sums
+}
+
+// steps returns the sorted union of the keys of the provided maps
+func steps(values []map[time.Time]float64) []time.Time {
+	seen := make(map[time.Time]bool)
+	var times []time.Time
+
+	for _, v := range values {
+		for t := range v {
+			if !seen[t] {
+				seen[t] = true
+				times = append(times, t)
+			}
+		}
+	}
+
+	sort.Sort(byTime(times))
+	return times
+}
+
+type byTime []time.Time
+
+func (t byTime) Len() int           { return len(t) }
+func (t byTime) Less(i, j int) bool { return t[i].Before(t[j]) }
+func (t byTime) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+// isPattern reports whether a series name contains glob characters or tag
+// filters
+func isPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[{;")
+}
+
+// MatchName reports whether a stat name matches a glob pattern. The metric
+// names are matched segment by segment, and wildcards never match across a '.',
+// so web.*.requests matches web.host1.requests but not web.host1.nginx.requests.
+// Every tag in the pattern must be present in the name with a matching value,
+// e.g. web.requests;dc=east matches web.requests;dc=east;host=web1, while a
+// pattern without tags matches regardless of the name's tags
+func MatchName(pattern, name string) bool {
+	patternMetric, patternTags := stat.ParseName(pattern)
+	metric, tags := stat.ParseName(name)
+
+	patterns := strings.Split(patternMetric, ".")
+	names := strings.Split(metric, ".")
+	if len(patterns) != len(names) {
+		return false
+	}
+
+	for i := range patterns {
+		if !matchSegment(patterns[i], names[i]) {
+			return false
+		}
+	}
+
+	for k, v := range patternTags {
+		value, ok := tags[k]
+		if !ok || !matchSegment(v, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchSegment matches a single name segment, expanding {a,b} alternations
+func matchSegment(pattern, segment string) bool {
+	open := strings.IndexByte(pattern, '{')
+	if open < 0 {
+		matched, err := path.Match(pattern, segment)
+		return err == nil && matched
+	}
+
+	end := strings.IndexByte(pattern[open:], '}')
+	if end < 0 {
+		return false
+	}
+	end += open
+
+	for _, alt := range strings.Split(pattern[open+1:end], ",") {
+		if matchSegment(pattern[:open]+alt+pattern[end+1:], segment) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+// Package queue provides bounded queues between the stages of the stats
+// pipeline, so that one slow stage does not stall the stages before it
+package queue
+
+import (
+	"fmt"
+	log "github.com/cihub/seelog"
+	"sync/atomic"
+	"time"
+)
+
+// Policy decides what happens to a value put on a full queue
+type Policy int
+
+const (
+	Block      Policy = iota // wait until the queue has room
+	DropOldest               // discard the value at the head of the queue to make room
+	DropNewest               // discard the value being put
+)
+
+// ParsePolicy parses block, drop-oldest or drop-newest
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "block":
+		return Block, nil
+	case "drop-oldest":
+		return DropOldest, nil
+	case "drop-newest":
+		return DropNewest, nil
+	}
+	return Block, fmt.Errorf("queue: unknown overflow policy %q, expected block, drop-oldest or drop-newest", s)
+}
+
+func (p Policy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	}
+	return "block"
+}
+
+// Queue holds up to size values written to In until they are read from Out,
+// applying its Policy when it is full
+type Queue[T any] struct {
+	name   string
+	policy Policy
+	buf    *ring[T]
+
+	in       chan T      // values are put on the queue through this channel
+	out      chan T      // values are taken from the queue through this channel
+	shutdown <-chan bool // signals a graceful shutdown
+
+	depth   int64  // accessed atomically
+	dropped uint64 // accessed atomically
+}
+
+// New constructs a Queue
+func New[T any](name string, size int, policy Policy, shutdown <-chan bool) *Queue[T] {
+	if size < 1 {
+		size = 1
+	}
+	return &Queue[T]{
+		name:     name,
+		policy:   policy,
+		buf:      newRing[T](size),
+		in:       make(chan T),
+		out:      make(chan T),
+		shutdown: shutdown,
+	}
+}
+
+// In returns the channel values are put on the queue through
+func (q *Queue[T]) In() chan<- T {
+	return q.in
+}
+
+// Out returns the channel values are taken from the queue through
+func (q *Queue[T]) Out() <-chan T {
+	return q.out
+}
+
+// Name returns the name of the queue
+func (q *Queue[T]) Name() string { return q.name }
+
+// Len returns the number of values waiting in the queue
+func (q *Queue[T]) Len() int { return int(atomic.LoadInt64(&q.depth)) }
+
+// Dropped returns the number of values dropped since the queue was constructed
+func (q *Queue[T]) Dropped() uint64 { return atomic.LoadUint64(&q.dropped) }
+
+// Run is a goroutine that moves values from In to Out. When shut down it
+// delivers the values still queued before exiting, so it must be shut down
+// after the stage writing to In and before the stage reading from Out
+func (q *Queue[T]) Run() {
+	done := false
+
+	for !done {
+		// a full blocking queue stops accepting values, which blocks the writer
+		in := q.in
+		if q.buf.full() && q.policy == Block {
+			in = nil
+		}
+
+		var out chan T
+		var head T
+		if !q.buf.empty() {
+			out = q.out
+			head = q.buf.peek()
+		}
+
+		select {
+		case v := <-in:
+			q.put(v)
+		case out <- head:
+			q.buf.pop()
+		case <-q.shutdown:
+			done = true
+			q.flush()
+		}
+		atomic.StoreInt64(&q.depth, int64(q.buf.len()))
+	}
+
+	log.Info("queue ", q.name, " Run() exiting ", time.Now())
+}
+
+// put adds a value, applying the policy if the queue is full
+func (q *Queue[T]) put(v T) {
+	if q.buf.full() {
+		atomic.AddUint64(&q.dropped, 1)
+		if q.policy == DropNewest {
+			return
+		}
+		q.buf.pop()
+	}
+	q.buf.push(v)
+}
+
+// flush delivers the values still queued, and any still waiting to be put
+func (q *Queue[T]) flush() {
+	for {
+		if !q.buf.full() || q.policy != Block {
+			select {
+			case v := <-q.in:
+				q.put(v)
+				continue
+			default:
+			}
+		}
+
+		if q.buf.empty() {
+			return
+		}
+		q.out <- q.buf.peek()
+		q.buf.pop()
+		atomic.StoreInt64(&q.depth, int64(q.buf.len()))
+	}
+}
@@ -0,0 +1,111 @@
+package queue
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("Queue", func() {
+
+	var shutdown chan bool
+
+	// fill puts the values on a queue, waiting until each has been accepted
+	fill := func(q *Queue[int], values ...int) {
+		for _, v := range values {
+			q.In() <- v
+		}
+	}
+
+	// take reads n values from a queue
+	take := func(q *Queue[int], n int) []int {
+		var values []int
+		for i := 0; i < n; i++ {
+			values = append(values, <-q.Out())
+		}
+		return values
+	}
+
+	BeforeEach(func() {
+		shutdown = make(chan bool)
+	})
+
+	AfterEach(func() {
+		close(shutdown)
+	})
+
+	It("should pass values on in order", func() {
+		q := New[int]("test", 10, Block, shutdown)
+		go q.Run()
+
+		fill(q, 1, 2, 3)
+		Eventually(q.Len).Should(Equal(3))
+		Expect(take(q, 3)).To(Equal([]int{1, 2, 3}))
+		Eventually(q.Len).Should(Equal(0))
+	})
+
+	It("should block the writer when full with the block policy", func() {
+		q := New[int]("test", 2, Block, shutdown)
+		go q.Run()
+
+		fill(q, 1, 2)
+		Eventually(q.Len).Should(Equal(2))
+		select {
+		case q.In() <- 3:
+			Fail("a full queue accepted a value")
+		case <-time.After(time.Millisecond * 50):
+		}
+
+		Expect(take(q, 1)).To(Equal([]int{1}))
+		fill(q, 3)
+		Expect(take(q, 2)).To(Equal([]int{2, 3}))
+		Expect(q.Dropped()).To(BeZero())
+	})
+
+	It("should discard the oldest value when full with the drop-oldest policy", func() {
+		q := New[int]("test", 2, DropOldest, shutdown)
+		go q.Run()
+
+		fill(q, 1, 2, 3, 4)
+		Eventually(q.Dropped).Should(Equal(uint64(2)))
+		Expect(take(q, 2)).To(Equal([]int{3, 4}))
+	})
+
+	It("should discard the new value when full with the drop-newest policy", func() {
+		q := New[int]("test", 2, DropNewest, shutdown)
+		go q.Run()
+
+		fill(q, 1, 2, 3, 4)
+		Eventually(q.Dropped).Should(Equal(uint64(2)))
+		Expect(take(q, 2)).To(Equal([]int{1, 2}))
+	})
+
+	It("should deliver the values still queued when shut down", func(done Done) {
+		stop := make(chan bool)
+		q := New[int]("test", 3, Block, stop)
+		exited := make(chan bool)
+		go func() {
+			q.Run()
+			close(exited)
+		}()
+
+		fill(q, 1, 2, 3)
+		close(stop)
+		Expect(take(q, 3)).To(Equal([]int{1, 2, 3}))
+		<-exited
+		close(done)
+	})
+
+	Describe("ParsePolicy", func() {
+		It("should parse each policy", func() {
+			for _, p := range []Policy{Block, DropOldest, DropNewest} {
+				parsed, err := ParsePolicy(p.String())
+				Expect(err).To(BeNil())
+				Expect(parsed).To(Equal(p))
+			}
+
+			_, err := ParsePolicy("drop-everything")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,35 @@
+package queue
+
+// ring is a fixed size circular buffer
+type ring[T any] struct {
+	items []T
+	head  int
+	n     int
+}
+
+func newRing[T any](size int) *ring[T] {
+	return &ring[T]{items: make([]T, size)}
+}
+
+func (r *ring[T]) len() int    { return r.n }
+func (r *ring[T]) empty() bool { return r.n == 0 }
+func (r *ring[T]) full() bool  { return r.n == len(r.items) }
+
+// push adds a value at the tail of a buffer that is not full
+func (r *ring[T]) push(v T) {
+	r.items[(r.head+r.n)%len(r.items)] = v
+	r.n++
+}
+
+// peek returns the value at the head
+func (r *ring[T]) peek() T {
+	return r.items[r.head]
+}
+
+// pop removes the value at the head
+func (r *ring[T]) pop() {
+	var zero T
+	r.items[r.head] = zero
+	r.head = (r.head + 1) % len(r.items)
+	r.n--
+}
@@ -0,0 +1,53 @@
+// Package relay forwards per-minute aggregates from gostat relays at remote
+// sites to a central gostat, which merges them. A relay buckets and aggregates
+// stats locally and only sends each series' final aggregate for each minute
+package relay
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/gob"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"io"
+	"time"
+)
+
+// Batch is a message from a relay: the aggregates of one or more minutes,
+// numbered so that the central gostat can ignore a batch it already has
+type Batch struct {
+	Relay      string    // the name of the relay
+	Seq        uint64    // increases with each batch from the relay
+	Before     time.Time // the relay has sent the final aggregates of every minute before this time
+	Aggregates []aggregator.BucketAggregate
+}
+
+// Encode encodes a batch as compressed gob
+func Encode(b *Batch) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if err := gob.NewEncoder(w).Encode(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a batch encoded by Encode
+func Decode(data []byte) (*Batch, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+
+	b := &Batch{}
+	if err := gob.NewDecoder(r).Decode(b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return b, nil
+}
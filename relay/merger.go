@@ -0,0 +1,259 @@
+package relay
+
+import (
+	"encoding/json"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/atomicfile"
+	"github.com/CapillarySoftware/gostat/metrics"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MergeWindow is how long before the newest minute merged a Merger keeps
+// merging aggregates. Older minutes are forgotten, and an aggregate for one
+// replaces whatever was merged before
+const MergeWindow = time.Hour
+
+// StallTimeout is how long a relay may send nothing before the stages awaiting
+// the Merger stop waiting for its minutes
+const StallTimeout = time.Minute * 5
+
+var (
+	batchesReceived  = metrics.NewCounter("gostat.relay.received", "Batches of aggregates received from relays")
+	batchesDuplicate = metrics.NewCounter("gostat.relay.duplicates", "Batches received from relays more than once, which are ignored")
+)
+
+// Merger merges the aggregates sent by relays into one aggregate of each series
+// and minute, using aggregator.AppendStatsAggregate, so a series may be
+// aggregated by several relays. Each merged aggregate is output as a
+// BucketAggregate, superseding earlier ones of the same series and minute like
+// those of an Aggregator, and recorded as a stat of the minute's average. The
+// stat's Origin is its series and minute, so that it replaces the stat merged
+// before it even in a series that keeps or sums stats.
+//
+// A relay sends a minute well after the central gostat's Bucketer has
+// finalized it, so the stages fed merged aggregates, such as alerting, learn
+// the time before which every relay heard from in the last StallTimeout has
+// sent its minutes from OnMerged.
+//
+// The sequence number of the last batch merged from each relay is saved after
+// every batch, so that a batch sent again after a restart is not merged twice
+type Merger struct {
+	input    <-chan *Batch                        // batches are read from this channel
+	outputs  []chan<- *aggregator.BucketAggregate // merged aggregates are written to each of these channels
+	stats    chan<- *stat.Stat                    // the average of each merged aggregate is written to this channel
+	shutdown <-chan bool                          // signals a graceful shutdown
+
+	last     map[string]uint64 // the sequence number of the last batch from each relay
+	lastPath string            // the file last is saved to
+	merged   map[key]aggregator.StatsAggregate
+	newest   time.Time
+
+	relays     map[string]progress      // how far each relay has sent its minutes
+	onMerged   []func(before time.Time) // told the time before which the relays have sent every minute
+	mu         sync.Mutex
+	originated map[*stat.Stat]key // the series and minute of each stat output and not yet stored
+}
+
+// progress is how far a relay has sent its minutes
+type progress struct {
+	before time.Time // the relay has sent every minute before this time
+	heard  time.Time // when the relay last sent a batch
+}
+
+// NewMerger constructs a Merger, restoring the sequence numbers saved at
+// seqPath
+func NewMerger(seqPath string, batches <-chan *Batch, stats chan<- *stat.Stat, shutdown <-chan bool, outputs ...chan<- *aggregator.BucketAggregate) (*Merger, error) {
+	last, err := loadSeqs(seqPath)
+	if err != nil {
+		return nil, log.Errorf("error loading relay sequence numbers from %s: %v", seqPath, err)
+	}
+
+	return &Merger{
+		input:    batches,
+		outputs:  outputs,
+		stats:    stats,
+		shutdown: shutdown,
+		last:     last,
+		lastPath: seqPath,
+		merged:   make(map[key]aggregator.StatsAggregate),
+
+		relays:     make(map[string]progress),
+		originated: make(map[*stat.Stat]key),
+	}, nil
+}
+
+// OnMerged registers a function told every few seconds the time before which
+// every relay heard from in the last StallTimeout has sent all its minutes
+func (m *Merger) OnMerged(f func(before time.Time)) {
+	m.onMerged = append(m.onMerged, f)
+}
+
+// Origin returns the series and minute of a stat output by the Merger, which is
+// the same for every stat merged into that minute, or false if the Merger did
+// not output it. It is passed to StatRepo.Origins
+func (m *Merger) Origin(s *stat.Stat) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, ok := m.originated[s]
+	if !ok {
+		return "", false
+	}
+	delete(m.originated, s)
+	return "relay/" + k.name + "/" + strconv.FormatInt(k.time.Unix(), 10), true
+}
+
+// mergedBefore returns the time before which every relay heard from in the last
+// StallTimeout before now has sent all its minutes. Without such a relay
+// nothing is awaited, so it is an hour after now
+func (m *Merger) mergedBefore(now time.Time) time.Time {
+	before := now.Add(time.Hour)
+	for _, p := range m.relays {
+		if now.Sub(p.heard) < StallTimeout && p.before.Before(before) {
+			before = p.before
+		}
+	}
+	return before
+}
+
+// Run is a goroutine that merges each batch read from the input channel, and
+// every few seconds tells the functions registered with OnMerged how far the
+// relays have sent their minutes
+func (m *Merger) Run() {
+	done := false
+	mergedTicker := time.NewTicker(time.Second * 10)
+	defer mergedTicker.Stop()
+
+	for !done {
+		select {
+		case b := <-m.input:
+			m.merge(b)
+		case <-mergedTicker.C:
+			before := m.mergedBefore(time.Now())
+			for _, f := range m.onMerged {
+				f(before)
+			}
+		case <-m.shutdown:
+			log.Info("Merger shutting down ", time.Now())
+			done = true
+			m.drain()
+		}
+	}
+
+	log.Info("Merger Run() exiting ", time.Now())
+}
+
+// drain merges the batches already waiting on the input channel
+func (m *Merger) drain() {
+	for {
+		select {
+		case b := <-m.input:
+			m.merge(b)
+		default:
+			return
+		}
+	}
+}
+
+// merge merges a batch, unless it has already been merged
+func (m *Merger) merge(b *Batch) {
+	batchesReceived.Inc()
+	if b.Seq <= m.last[b.Relay] {
+		log.Warnf("relay: ignoring batch %d from %s, which has already been merged", b.Seq, b.Relay)
+		batchesDuplicate.Inc()
+		return
+	}
+	m.last[b.Relay] = b.Seq
+	if !b.Before.IsZero() { // unless the relay is too old to say
+		p := m.relays[b.Relay]
+		p.heard = time.Now()
+		if b.Before.After(p.before) {
+			p.before = b.Before
+		}
+		m.relays[b.Relay] = p
+	}
+
+	newest := m.newest
+	for i := range b.Aggregates {
+		m.mergeAggregate(&b.Aggregates[i])
+	}
+	if m.newest.After(newest) {
+		m.forget()
+	}
+
+	if err := saveSeqs(m.lastPath, m.last); err != nil {
+		log.Error("error saving relay sequence numbers to ", m.lastPath, ": ", err)
+	}
+}
+
+func (m *Merger) mergeAggregate(a *aggregator.BucketAggregate) {
+	k := key{a.Name, a.Time.UTC()}
+	merged := aggregator.AppendStatsAggregate(m.merged[k], a.StatsAggregate)
+	m.merged[k] = merged
+	if k.time.After(m.newest) {
+		m.newest = k.time
+	}
+
+	out := &aggregator.BucketAggregate{Name: k.name, Time: k.time, StatsAggregate: merged}
+	for _, output := range m.outputs {
+		output <- out
+	}
+
+	s := &stat.Stat{Name: k.name, Timestamp: k.time, Value: merged.Average}
+	m.mu.Lock()
+	m.originated[s] = k
+	m.mu.Unlock()
+	m.stats <- s
+}
+
+// forget forgets the minutes more than the MergeWindow before the newest
+func (m *Merger) forget() {
+	cutoff := m.newest.Add(-MergeWindow)
+	for k := range m.merged {
+		if k.time.Before(cutoff) {
+			delete(m.merged, k)
+		}
+	}
+
+	// the stats never stored, e.g. dropped by a full queue
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for s, k := range m.originated {
+		if k.time.Before(cutoff) {
+			delete(m.originated, s)
+		}
+	}
+}
+
+// loadSeqs reads the sequence numbers saved at path. A missing file is not an
+// error, since nothing has been merged yet on the first run
+func loadSeqs(path string) (map[string]uint64, error) {
+	seqs := make(map[string]uint64)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return seqs, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &seqs); err != nil {
+		return nil, err
+	}
+	return seqs, nil
+}
+
+// saveSeqs writes the sequence numbers to path, replacing the file atomically
+func saveSeqs(path string, seqs map[string]uint64) error {
+	data, err := json.Marshal(seqs)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, data)
+}
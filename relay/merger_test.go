@@ -0,0 +1,121 @@
+package relay
+
+import (
+	"fmt"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("Merger", func() {
+	var (
+		dir, seqPath string
+		output       chan *aggregator.BucketAggregate
+		stats        chan *stat.Stat
+		m            *Merger
+	)
+
+	newMerger := func() *Merger {
+		m, err := NewMerger(seqPath, make(chan *Batch), stats, make(chan bool), output)
+		Expect(err).To(BeNil())
+		return m
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "gostat-merger")
+		Expect(err).To(BeNil())
+		seqPath = filepath.Join(dir, "relays.json")
+
+		output = make(chan *aggregator.BucketAggregate, 10)
+		stats = make(chan *stat.Stat, 10)
+		m = newMerger()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	batch := func(relay string, seq uint64, aggregates ...*aggregator.BucketAggregate) *Batch {
+		b := &Batch{Relay: relay, Seq: seq}
+		for _, a := range aggregates {
+			b.Aggregates = append(b.Aggregates, *a)
+		}
+		return b
+	}
+
+	It("should merge the aggregates of a series from several relays", func() {
+		m.merge(batch("edge1", 1, bucketAggregate("cpu", minute, 1, 2)))
+		Expect(<-output).To(Equal(bucketAggregate("cpu", minute, 1, 2)))
+		Expect(<-stats).To(Equal(&stat.Stat{Name: "cpu", Timestamp: minute, Value: 1.5}))
+
+		m.merge(batch("edge2", 1, bucketAggregate("cpu", minute, 6)))
+		Expect(<-output).To(Equal(bucketAggregate("cpu", minute, 1, 2, 6)))
+		Expect(<-stats).To(Equal(&stat.Stat{Name: "cpu", Timestamp: minute, Value: 3}))
+	})
+
+	It("should ignore a batch it has already merged", func() {
+		m.merge(batch("edge1", 2, bucketAggregate("cpu", minute, 1)))
+		m.merge(batch("edge1", 2, bucketAggregate("cpu", minute, 1)))
+		m.merge(batch("edge1", 1, bucketAggregate("cpu", minute, 1)))
+		Expect(output).To(HaveLen(1))
+	})
+
+	It("should ignore a batch merged before a restart", func() {
+		m.merge(batch("edge1", 2, bucketAggregate("cpu", minute, 1)))
+		Expect(output).To(HaveLen(1))
+
+		restarted := newMerger()
+		restarted.merge(batch("edge1", 2, bucketAggregate("cpu", minute, 1)))
+		Expect(output).To(HaveLen(1))
+
+		restarted.merge(batch("edge1", 3, bucketAggregate("cpu", minute, 1)))
+		Expect(output).To(HaveLen(2))
+	})
+
+	It("should forget minutes more than the MergeWindow before the newest", func() {
+		m.merge(batch("edge1", 1, bucketAggregate("cpu", minute, 1)))
+		m.merge(batch("edge1", 2, bucketAggregate("cpu", minute.Add(MergeWindow+time.Minute), 1)))
+		Expect(m.merged).To(HaveLen(1))
+	})
+
+	It("should store one stat for a minute of a summed series merged from several relays", func() {
+		// a summed series stores a row for each origin, and sums the rows read
+		rows := make(map[string]float64)
+		store := func() {
+			s := <-stats
+			origin, ok := m.Origin(s)
+			Expect(ok).To(BeTrue())
+			rows[origin] = s.Value
+		}
+
+		m.merge(batch("edge1", 1, bucketAggregate("web.requests", minute, 10)))
+		store()
+		m.merge(batch("edge2", 1, bucketAggregate("web.requests", minute, 20)))
+		store()
+
+		Expect(rows).To(Equal(map[string]float64{fmt.Sprintf("relay/web.requests/%d", minute.Unix()): 15}))
+		_, ok := m.Origin(&stat.Stat{Name: "web.requests", Timestamp: minute, Value: 15})
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should wait for the minutes of every relay heard from recently", func() {
+		now := minute.Add(time.Hour)
+		Expect(m.mergedBefore(now)).To(Equal(now.Add(time.Hour)))
+
+		edge1, edge2 := batch("edge1", 1), batch("edge2", 1)
+		edge1.Before, edge2.Before = minute.Add(time.Minute*2), minute.Add(time.Minute)
+		m.merge(edge1)
+		m.merge(edge2)
+		Expect(m.mergedBefore(time.Now())).To(Equal(minute.Add(time.Minute)))
+
+		// a relay that has stopped sending is no longer waited for
+		stalled := time.Now().Add(StallTimeout)
+		Expect(m.mergedBefore(stalled)).To(Equal(stalled.Add(time.Hour)))
+	})
+})
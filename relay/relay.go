@@ -0,0 +1,242 @@
+package relay
+
+import (
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/metrics"
+	"github.com/CapillarySoftware/gostat/push"
+	log "github.com/cihub/seelog"
+	"sync"
+	"time"
+)
+
+// SendTimeout bounds how long sending a batch upstream may block, e.g. while the
+// upstream is unreachable
+const SendTimeout = time.Second * 5
+
+var (
+	aggregatesSpooled = metrics.NewCounter("gostat.relay.spooled", "Final aggregates written to the relay's spool")
+	aggregatesLate    = metrics.NewCounter("gostat.relay.late", "Aggregates of minutes the relay had already spooled, which are dropped")
+	batchesSent       = metrics.NewCounter("gostat.relay.sent", "Batches of aggregates sent upstream")
+	sendErrors        = metrics.NewCounter("gostat.relay.send.errors", "Attempts to send a batch upstream that failed")
+)
+
+// Sender sends encoded batches upstream
+type Sender interface {
+	Send(data []byte) error
+	Close() error
+}
+
+// Dialer returns a Sender to the upstream at address
+type Dialer func(address string) (Sender, error)
+
+type key struct {
+	name string
+	time time.Time
+}
+
+// Relay collects the aggregates of a relay's Aggregator, spools the final
+// aggregate of each series and minute, and sends the spooled batches upstream.
+//
+// An Aggregator outputs a BucketAggregate each time a bucket is published, each
+// superseding the last, so the Relay keeps only the latest of each series and
+// minute. Once the Bucketer has finalized a minute and the Aggregator has had
+// an interval to catch up, the Relay spools that minute's aggregates
+type Relay struct {
+	name     string
+	upstream string
+	spool    *Spool
+	input    <-chan *aggregator.BucketAggregate // aggregates are read from this channel
+	shutdown <-chan bool                        // signals a graceful shutdown
+
+	dial   Dialer
+	sender Sender
+
+	aggregates map[key]*aggregator.BucketAggregate // the latest aggregate of each series and minute not yet spooled
+	spooled    time.Time                           // aggregates before this time have been spooled
+
+	mu        sync.Mutex
+	finalized time.Time // the time before which the Bucketer has finalized every minute
+}
+
+// NewRelay constructs a Relay named name, sending to the nanomsg address of a
+// central gostat
+func NewRelay(name, upstream string, spool *Spool, aggregates <-chan *aggregator.BucketAggregate, shutdown <-chan bool) *Relay {
+	return &Relay{
+		name:       name,
+		upstream:   upstream,
+		spool:      spool,
+		input:      aggregates,
+		shutdown:   shutdown,
+		dial:       DialNanomsg,
+		aggregates: make(map[key]*aggregator.BucketAggregate),
+	}
+}
+
+// Finalized records the time before which the Bucketer will no longer publish
+// any stats. It is passed to Bucketer.OnFinalized
+func (r *Relay) Finalized(before time.Time) {
+	r.mu.Lock()
+	r.finalized = before
+	r.mu.Unlock()
+}
+
+func (r *Relay) finalizedBefore() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.finalized
+}
+
+// Run is a goroutine that collects aggregates, and every interval spools the
+// minutes finalized at least an interval ago and sends the spool upstream. When
+// shut down it spools every aggregate it holds, final or not, and tries to send
+// them
+func (r *Relay) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer r.closeSender()
+
+	var settled time.Time // the finalized time as of the last tick
+	r.send()
+
+	done := false
+	for !done {
+		select {
+		case a := <-r.input:
+			r.collect(a)
+		case <-ticker.C:
+			r.spoolBefore(settled)
+			settled = r.finalizedBefore()
+			r.send()
+		case <-r.shutdown:
+			log.Info("Relay shutting down ", time.Now())
+			done = true
+			r.drain()
+			r.spoolBefore(time.Now().UTC().Add(time.Hour))
+			r.send()
+		}
+	}
+
+	log.Info("Relay Run() exiting ", time.Now())
+}
+
+// drain collects the aggregates already waiting on the input channel
+func (r *Relay) drain() {
+	for {
+		select {
+		case a := <-r.input:
+			r.collect(a)
+		default:
+			return
+		}
+	}
+}
+
+// collect keeps an aggregate, replacing any earlier one of the same bucket
+func (r *Relay) collect(a *aggregator.BucketAggregate) {
+	if a.Time.Before(r.spooled) {
+		log.Warnf("relay: dropping an aggregate of %s for %v, which has already been spooled", a.Name, a.Time)
+		aggregatesLate.Inc()
+		return
+	}
+	r.aggregates[key{a.Name, a.Time}] = a
+}
+
+// spoolBefore writes the aggregates of the minutes before t to the spool as one
+// batch. A batch is spooled even without any aggregates, so that the central
+// gostat learns the minutes before t are final
+func (r *Relay) spoolBefore(t time.Time) {
+	if !t.After(r.spooled) {
+		return
+	}
+
+	// when shut down the relay spools minutes that are not final yet
+	b := &Batch{Relay: r.name, Before: t}
+	if finalized := r.finalizedBefore(); finalized.Before(t) {
+		b.Before = finalized
+	}
+	for k, a := range r.aggregates {
+		if k.time.Before(t) {
+			b.Aggregates = append(b.Aggregates, *a)
+		}
+	}
+	if err := r.spool.Put(b); err != nil {
+		// keep the aggregates and try again next time
+		log.Error("relay: error spooling aggregates: ", err)
+		return
+	}
+	aggregatesSpooled.Add(uint64(len(b.Aggregates)))
+	log.Debugf("relay: spooled %d aggregates before %v", len(b.Aggregates), t)
+
+	for k := range r.aggregates {
+		if k.time.Before(t) {
+			delete(r.aggregates, k)
+		}
+	}
+	r.spooled = t
+}
+
+// send sends the spooled batches upstream, oldest first, until the spool is
+// empty or sending fails
+func (r *Relay) send() {
+	for {
+		seq, data, ok, err := r.spool.Oldest()
+		if err != nil {
+			log.Error(err, ", discarding it")
+			if err := r.spool.Remove(seq); err != nil {
+				log.Error("relay: error removing a batch from the spool: ", err)
+				return
+			}
+			continue
+		}
+		if !ok {
+			return
+		}
+
+		if err := r.sendBatch(data); err != nil {
+			log.Warnf("relay: error sending to %s, %d batches spooled: %v", r.upstream, r.spool.Len(), err)
+			sendErrors.Inc()
+			return
+		}
+		batchesSent.Inc()
+
+		if err := r.spool.Remove(seq); err != nil {
+			log.Error("relay: error removing a sent batch from the spool: ", err)
+			return
+		}
+	}
+}
+
+// sendBatch sends an encoded batch upstream, dialing the upstream if the relay
+// is not connected. A failed send drops the connection, so that the next batch
+// is sent over a fresh one
+func (r *Relay) sendBatch(data []byte) error {
+	if r.sender == nil {
+		sender, err := r.dial(r.upstream)
+		if err != nil {
+			return err
+		}
+		r.sender = sender
+	}
+
+	if err := r.sender.Send(data); err != nil {
+		r.closeSender()
+		return err
+	}
+	return nil
+}
+
+func (r *Relay) closeSender() {
+	if r.sender != nil {
+		r.sender.Close()
+		r.sender = nil
+	}
+}
+
+// DialNanomsg returns a Sender pushing batches to a nanomsg address
+func DialNanomsg(address string) (Sender, error) {
+	socket, err := push.Dial(address, SendTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return socket, nil
+}
@@ -0,0 +1,155 @@
+package relay
+
+import (
+	"errors"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// fakeSender records the batches sent upstream, or fails if err is set
+type fakeSender struct {
+	batches []*Batch
+	err     error
+}
+
+func (f *fakeSender) Send(data []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	b, err := Decode(data)
+	if err != nil {
+		return err
+	}
+	f.batches = append(f.batches, b)
+	return nil
+}
+
+func (f *fakeSender) Close() error { return nil }
+
+var minute = time.Date(2015, 1, 2, 3, 4, 0, 0, time.UTC)
+
+func bucketAggregate(name string, t time.Time, values ...float64) *aggregator.BucketAggregate {
+	a := aggregator.StatsAggregate{Min: values[0], Max: values[0], Count: len(values)}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+		if v < a.Min {
+			a.Min = v
+		}
+		if v > a.Max {
+			a.Max = v
+		}
+	}
+	a.Average = sum / float64(len(values))
+	return &aggregator.BucketAggregate{Name: name, Time: t, StatsAggregate: a}
+}
+
+var _ = Describe("Batch", func() {
+	It("should decode what it encodes", func() {
+		b := &Batch{Relay: "edge1", Seq: 7, Aggregates: []aggregator.BucketAggregate{*bucketAggregate("cpu", minute, 1, 2, 3)}}
+		data, err := Encode(b)
+		Expect(err).NotTo(HaveOccurred())
+
+		decoded, err := Decode(data)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decoded).To(Equal(b))
+	})
+
+	It("should fail to decode garbage", func() {
+		_, err := Decode([]byte("garbage"))
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Relay", func() {
+	var (
+		dir      string
+		spool    *Spool
+		input    chan *aggregator.BucketAggregate
+		shutdown chan bool
+		sender   *fakeSender
+		r        *Relay
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "gostat-spool")
+		Expect(err).NotTo(HaveOccurred())
+		spool, err = OpenSpool(dir)
+		Expect(err).NotTo(HaveOccurred())
+
+		input = make(chan *aggregator.BucketAggregate, 10)
+		shutdown = make(chan bool)
+		sender = &fakeSender{}
+		r = NewRelay("edge1", "tcp://central:2030", spool, input, shutdown)
+		r.dial = func(address string) (Sender, error) { return sender, nil }
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should only send the latest aggregate of each finalized minute", func() {
+		r.collect(bucketAggregate("cpu", minute, 1))
+		r.collect(bucketAggregate("cpu", minute, 1, 2))
+		r.collect(bucketAggregate("cpu", minute.Add(time.Minute), 5))
+
+		r.spoolBefore(minute.Add(time.Minute))
+		r.send()
+
+		Expect(sender.batches).To(HaveLen(1))
+		Expect(sender.batches[0].Relay).To(Equal("edge1"))
+		Expect(sender.batches[0].Aggregates).To(Equal([]aggregator.BucketAggregate{*bucketAggregate("cpu", minute, 1, 2)}))
+		Expect(r.aggregates).To(HaveLen(1))
+		Expect(spool.Len()).To(Equal(0))
+	})
+
+	It("should tell the central gostat which minutes it has sent, even without aggregates", func() {
+		r.Finalized(minute.Add(time.Minute * 2))
+		r.spoolBefore(minute.Add(time.Minute))
+		r.spoolBefore(minute.Add(time.Hour)) // as when shut down
+		r.send()
+
+		Expect(sender.batches).To(HaveLen(2))
+		Expect(sender.batches[0].Before).To(Equal(minute.Add(time.Minute)))
+		Expect(sender.batches[0].Aggregates).To(BeEmpty())
+		Expect(sender.batches[1].Before).To(Equal(minute.Add(time.Minute * 2)))
+	})
+
+	It("should drop aggregates of minutes already spooled", func() {
+		r.spoolBefore(minute.Add(time.Minute))
+		r.collect(bucketAggregate("cpu", minute, 1))
+		Expect(r.aggregates).To(BeEmpty())
+	})
+
+	It("should keep batches spooled while the upstream is unreachable", func() {
+		sender.err = errors.New("unreachable")
+		r.collect(bucketAggregate("cpu", minute, 1))
+		r.spoolBefore(minute.Add(time.Minute))
+		r.collect(bucketAggregate("cpu", minute.Add(time.Minute), 2))
+		r.spoolBefore(minute.Add(time.Minute * 2))
+		r.send()
+		Expect(spool.Len()).To(Equal(2))
+
+		sender.err = nil
+		r.send()
+		Expect(spool.Len()).To(Equal(0))
+		Expect(sender.batches).To(HaveLen(2))
+		Expect(sender.batches[0].Aggregates[0].Time).To(Equal(minute))
+		Expect(sender.batches[1].Aggregates[0].Time).To(Equal(minute.Add(time.Minute)))
+	})
+
+	It("should spool and send every aggregate it holds when shut down", func(done Done) {
+		input <- bucketAggregate("cpu", time.Now().UTC().Truncate(time.Minute), 1)
+		close(shutdown)
+		r.Run(time.Hour)
+
+		Expect(sender.batches).To(HaveLen(1))
+		Expect(sender.batches[0].Aggregates).To(HaveLen(1))
+		close(done)
+	})
+})
@@ -0,0 +1,129 @@
+package relay
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spool holds the batches a relay has yet to send upstream, one file each, so
+// that they survive the upstream being unreachable and the relay restarting
+type Spool struct {
+	dir  string
+	seqs []uint64 // of the batches in the spool, oldest first
+	last uint64   // the last sequence number assigned
+	now  func() time.Time
+}
+
+// OpenSpool opens the spool in dir, creating the directory if need be
+func OpenSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{dir: dir, now: time.Now}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".batch") {
+			continue
+		}
+		if seq, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), ".batch"), 10, 64); err == nil {
+			s.seqs = append(s.seqs, seq)
+		}
+	}
+	sort.Slice(s.seqs, func(i, j int) bool { return s.seqs[i] < s.seqs[j] })
+	if len(s.seqs) > 0 {
+		s.last = s.seqs[len(s.seqs)-1]
+	}
+	return s, nil
+}
+
+// Len returns the number of batches in the spool
+func (s *Spool) Len() int {
+	return len(s.seqs)
+}
+
+// nextSeq returns a sequence number greater than any assigned before, even by
+// an earlier run whose batches have all been sent
+func (s *Spool) nextSeq() uint64 {
+	seq := uint64(s.now().UnixNano())
+	if seq <= s.last {
+		seq = s.last + 1
+	}
+	s.last = seq
+	return seq
+}
+
+// Put numbers a batch and writes it to the spool
+func (s *Spool) Put(b *Batch) error {
+	b.Seq = s.nextSeq()
+	data, err := Encode(b)
+	if err != nil {
+		return err
+	}
+
+	// write a temporary file and rename it, so a crash never leaves a partial batch
+	tmp := s.path(b.Seq) + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, s.path(b.Seq)); err != nil {
+		return err
+	}
+
+	s.seqs = append(s.seqs, b.Seq)
+	return nil
+}
+
+// Oldest returns the sequence number and encoding of the oldest batch, if the
+// spool is not empty
+func (s *Spool) Oldest() (seq uint64, data []byte, ok bool, err error) {
+	if len(s.seqs) == 0 {
+		return 0, nil, false, nil
+	}
+	seq = s.seqs[0]
+	if data, err = ioutil.ReadFile(s.path(seq)); err != nil {
+		return seq, nil, false, fmt.Errorf("relay: error reading spooled batch %d: %v", seq, err)
+	}
+	return seq, data, true, nil
+}
+
+// Remove removes the oldest batch, once it has been sent
+func (s *Spool) Remove(seq uint64) error {
+	if len(s.seqs) == 0 || s.seqs[0] != seq {
+		return fmt.Errorf("relay: batch %d is not the oldest in the spool", seq)
+	}
+	if err := os.Remove(s.path(seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.seqs = s.seqs[1:]
+	return nil
+}
+
+func (s *Spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d.batch", seq))
+}
@@ -0,0 +1,63 @@
+package relay
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+var _ = Describe("Spool", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "gostat-spool")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should return batches oldest first until they are removed", func() {
+		s, err := OpenSpool(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Put(&Batch{Relay: "edge1"})).To(Succeed())
+		Expect(s.Put(&Batch{Relay: "edge2"})).To(Succeed())
+		Expect(s.Len()).To(Equal(2))
+
+		seq, data, ok, err := s.Oldest()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		b, _ := Decode(data)
+		Expect(b.Relay).To(Equal("edge1"))
+		Expect(b.Seq).To(Equal(seq))
+
+		Expect(s.Remove(seq)).To(Succeed())
+		_, data, _, _ = s.Oldest()
+		b, _ = Decode(data)
+		Expect(b.Relay).To(Equal("edge2"))
+		Expect(b.Seq).To(BeNumerically(">", seq))
+	})
+
+	It("should keep batches across restarts, and keep numbering them in order", func() {
+		s, _ := OpenSpool(dir)
+		now := time.Now()
+		s.now = func() time.Time { return now }
+		Expect(s.Put(&Batch{Relay: "edge1"})).To(Succeed())
+		first, _, _, _ := s.Oldest()
+
+		s, err := OpenSpool(dir)
+		Expect(err).NotTo(HaveOccurred())
+		s.now = func() time.Time { return now.Add(-time.Hour) } // the clock went back
+		Expect(s.Len()).To(Equal(1))
+		Expect(s.Put(&Batch{Relay: "edge1"})).To(Succeed())
+
+		Expect(s.Remove(first)).To(Succeed())
+		second, _, ok, _ := s.Oldest()
+		Expect(ok).To(BeTrue())
+		Expect(second).To(BeNumerically(">", first))
+	})
+})
@@ -0,0 +1,210 @@
+package repo
+
+import (
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/metrics"
+	log "github.com/cihub/seelog"
+	"sync"
+	"time"
+)
+
+// The resolutions aggregates are stored at, the same as those the importer
+// computes
+const (
+	minuteResolution = "1m"
+	hourResolution   = "1h"
+	dayResolution    = "1d"
+)
+
+var (
+	aggregateWrites      = metrics.NewCounter("gostat.repo.aggregate.writes", "Aggregates written to Cassandra")
+	aggregateWriteErrors = metrics.NewCounter("gostat.repo.aggregate.write.errors", "Aggregates that could not be written to Cassandra")
+)
+
+// insertAggregate is the statement inserting an aggregate, taking its name,
+// resolution, time, average, min, max and count
+const insertAggregate = `INSERT INTO aggregate_stats (name, resolution, ts, average, min, max, count) VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+// WriteAggregate stores the aggregate of name over the period of the resolution
+// (1m, 1h or 1d) starting at t, replacing any stored before
+func WriteAggregate(name, resolution string, t time.Time, a aggregator.StatsAggregate) error {
+	session, err := createSession()
+	if err != nil {
+		log.Error("error connecting to Cassandra to insert an aggregate: ", err)
+		return err
+	}
+	defer closeSession(session)
+
+	return session.Query(insertAggregate, name, resolution, t, a.Average, a.Min, a.Max, a.Count).Exec()
+}
+
+// AggregateStore is where an AggregateRepo reads and writes aggregates
+type AggregateStore interface {
+	WriteAggregate(name, resolution string, t time.Time, a aggregator.StatsAggregate) error
+	ScanAggregates(name, resolution string, start, end time.Time, f func(aggregator.BucketAggregate) error) error
+}
+
+type sessionAggregates struct{}
+
+func (sessionAggregates) WriteAggregate(name, resolution string, t time.Time, a aggregator.StatsAggregate) error {
+	return WriteAggregate(name, resolution, t, a)
+}
+
+func (sessionAggregates) ScanAggregates(name, resolution string, start, end time.Time, f func(aggregator.BucketAggregate) error) error {
+	return ScanAggregates(name, resolution, start, end, f)
+}
+
+// period is an hour or day of a series
+type period struct {
+	name  string
+	start time.Time
+}
+
+// AggregateRepo stores the aggregates of the live series at the resolutions the
+// importer computes for historical ones. Each minute's aggregate is written as
+// it arrives, replacing the one before. Once the Bucketer has finalized an
+// hour, the hour is aggregated from its minutes and its day, so far, from its
+// hours. An hour whose minutes change after it was aggregated, e.g. by an
+// aggregate merged late from a relay, is aggregated again. The hours waiting to
+// be aggregated are held in memory, so those of a series reporting nothing
+// after a restart are left unaggregated
+type AggregateRepo struct {
+	input    <-chan *aggregator.BucketAggregate // aggregates to be stored are read from this channel
+	shutdown <-chan bool                        // signals a graceful shutdown
+	store    AggregateStore
+
+	hours map[period]bool // the hours with minutes written since they were last aggregated
+	days  map[period]bool // the days with hours aggregated since they were last aggregated
+
+	mu        sync.Mutex
+	finalized time.Time // the time before which the Bucketer has finalized every minute
+}
+
+// NewAggregateRepo constructs an AggregateRepo storing aggregates in Cassandra
+func NewAggregateRepo(aggregates <-chan *aggregator.BucketAggregate, shutdown <-chan bool) *AggregateRepo {
+	return NewAggregateRepoWithStore(aggregates, shutdown, sessionAggregates{})
+}
+
+// NewAggregateRepoWithStore constructs an AggregateRepo storing aggregates in
+// store
+func NewAggregateRepoWithStore(aggregates <-chan *aggregator.BucketAggregate, shutdown <-chan bool, store AggregateStore) *AggregateRepo {
+	return &AggregateRepo{
+		input:    aggregates,
+		shutdown: shutdown,
+		store:    store,
+		hours:    make(map[period]bool),
+		days:     make(map[period]bool),
+	}
+}
+
+// Finalized records the time before which the Bucketer will no longer publish
+// any stats. It is passed to Bucketer.OnFinalized
+func (r *AggregateRepo) Finalized(before time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finalized = before
+}
+
+func (r *AggregateRepo) finalizedBefore() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.finalized
+}
+
+// Run is a goroutine that writes the aggregates read from the input channel,
+// and aggregates the hours finalized
+func (r *AggregateRepo) Run() {
+	done := false
+	rollupTicker := time.NewTicker(time.Second * 10)
+
+	var settled time.Time // the finalized time as of the last tick
+
+	for !done {
+		select {
+		case a := <-r.input:
+			r.write(a)
+		case <-r.shutdown:
+			log.Info("AggregateRepo shutting down ", time.Now())
+			done = true
+			rollupTicker.Stop()
+			r.drain()
+			r.rollupBefore(r.finalizedBefore())
+		case <-rollupTicker.C:
+			r.rollupBefore(settled)
+			settled = r.finalizedBefore()
+		}
+	}
+
+	log.Info("AggregateRepo Run() exiting ", time.Now())
+}
+
+// drain writes the aggregates already waiting on the input channel
+func (r *AggregateRepo) drain() {
+	for {
+		select {
+		case a := <-r.input:
+			r.write(a)
+		default:
+			return
+		}
+	}
+}
+
+// write stores the aggregate of a minute, marking its hour to be aggregated
+func (r *AggregateRepo) write(a *aggregator.BucketAggregate) {
+	if err := r.store.WriteAggregate(a.Name, minuteResolution, a.Time, a.StatsAggregate); err != nil {
+		log.Error("error inserting aggregate: ", err)
+		aggregateWriteErrors.Inc()
+		return
+	}
+	aggregateWrites.Inc()
+	r.hours[period{a.Name, a.Time.UTC().Truncate(time.Hour)}] = true
+}
+
+// rollupBefore aggregates the pending hours ending by before, and their days.
+// An hour or day that cannot be aggregated stays pending
+func (r *AggregateRepo) rollupBefore(before time.Time) {
+	for k := range r.hours {
+		if k.start.Add(time.Hour).After(before) {
+			continue
+		}
+		if err := r.rollup(k.name, hourResolution, minuteResolution, k.start, time.Hour); err != nil {
+			log.Errorf("error aggregating the hour of %s at %v: %v", k.name, k.start, err)
+			continue
+		}
+		delete(r.hours, k)
+		r.days[period{k.name, k.start.Truncate(24 * time.Hour)}] = true
+	}
+
+	for k := range r.days {
+		if err := r.rollup(k.name, dayResolution, hourResolution, k.start, 24*time.Hour); err != nil {
+			log.Errorf("error aggregating the day of %s at %v: %v", k.name, k.start, err)
+			continue
+		}
+		delete(r.days, k)
+	}
+}
+
+// rollup stores the aggregate at resolution of the period of name starting at
+// t and lasting length, appending the aggregates of the finer resolution within
+// it
+func (r *AggregateRepo) rollup(name, resolution, finer string, t time.Time, length time.Duration) error {
+	var whole aggregator.StatsAggregate
+	err := r.store.ScanAggregates(name, finer, t, t.Add(length), func(a aggregator.BucketAggregate) error {
+		whole = aggregator.AppendStatsAggregate(whole, a.StatsAggregate)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if whole.Count == 0 {
+		return nil
+	}
+
+	if err := r.store.WriteAggregate(name, resolution, t, whole); err != nil {
+		aggregateWriteErrors.Inc()
+		return err
+	}
+	aggregateWrites.Inc()
+	return nil
+}
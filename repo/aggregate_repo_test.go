@@ -0,0 +1,107 @@
+package repo
+
+import (
+	"fmt"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sort"
+	"time"
+)
+
+// memoryAggregates is an AggregateStore in memory
+type memoryAggregates struct {
+	stored map[string]map[time.Time]aggregator.StatsAggregate // by name and resolution
+	err    error                                              // returned by every call, if not nil
+}
+
+func (m *memoryAggregates) WriteAggregate(name, resolution string, t time.Time, a aggregator.StatsAggregate) error {
+	if m.err != nil {
+		return m.err
+	}
+	k := name + "/" + resolution
+	if m.stored[k] == nil {
+		m.stored[k] = make(map[time.Time]aggregator.StatsAggregate)
+	}
+	m.stored[k][t] = a
+	return nil
+}
+
+func (m *memoryAggregates) ScanAggregates(name, resolution string, start, end time.Time, f func(aggregator.BucketAggregate) error) error {
+	if m.err != nil {
+		return m.err
+	}
+	var times []time.Time
+	for t := range m.stored[name+"/"+resolution] {
+		if !t.Before(start) && t.Before(end) {
+			times = append(times, t)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	for _, t := range times {
+		if err := f(aggregator.BucketAggregate{Name: name, Time: t, StatsAggregate: m.stored[name+"/"+resolution][t]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ = Describe("AggregateRepo", func() {
+	var (
+		day   time.Time
+		store *memoryAggregates
+		r     *AggregateRepo
+	)
+
+	BeforeEach(func() {
+		day = time.Date(2015, 1, 2, 0, 0, 0, 0, time.UTC)
+		store = &memoryAggregates{stored: make(map[string]map[time.Time]aggregator.StatsAggregate)}
+		r = NewAggregateRepoWithStore(nil, nil, store)
+	})
+
+	write := func(t time.Time, value float64) {
+		r.write(&aggregator.BucketAggregate{Name: "foo", Time: t, StatsAggregate: aggregator.StatsAggregate{Average: value, Min: value, Max: value, Count: 1}})
+	}
+
+	It("should store the latest aggregate of each minute", func() {
+		write(day, 1)
+		write(day, 3)
+		Expect(store.stored["foo/1m"]).To(Equal(map[time.Time]aggregator.StatsAggregate{day: {Average: 3, Min: 3, Max: 3, Count: 1}}))
+	})
+
+	It("should aggregate an hour once it is finalized, and its day", func() {
+		write(day, 1)
+		write(day.Add(time.Minute*59), 3)
+
+		r.rollupBefore(day.Add(time.Minute * 59))
+		Expect(store.stored["foo/1h"]).To(BeEmpty())
+
+		r.rollupBefore(day.Add(time.Hour))
+		Expect(store.stored["foo/1h"]).To(Equal(map[time.Time]aggregator.StatsAggregate{day: {Average: 2, Min: 1, Max: 3, Count: 2}}))
+		Expect(store.stored["foo/1d"]).To(Equal(map[time.Time]aggregator.StatsAggregate{day: {Average: 2, Min: 1, Max: 3, Count: 2}}))
+
+		write(day.Add(time.Hour), 5)
+		r.rollupBefore(day.Add(time.Hour * 2))
+		Expect(store.stored["foo/1d"]).To(Equal(map[time.Time]aggregator.StatsAggregate{day: {Average: 3, Min: 1, Max: 5, Count: 3}}))
+	})
+
+	It("should aggregate an hour again when a minute of it changes", func() {
+		write(day, 1)
+		r.rollupBefore(day.Add(time.Hour))
+
+		write(day, 7)
+		r.rollupBefore(day.Add(time.Hour))
+		Expect(store.stored["foo/1h"][day]).To(Equal(aggregator.StatsAggregate{Average: 7, Min: 7, Max: 7, Count: 1}))
+	})
+
+	It("should keep an hour pending until it can be aggregated", func() {
+		write(day, 1)
+		store.err = fmt.Errorf("unavailable")
+		r.rollupBefore(day.Add(time.Hour))
+
+		store.err = nil
+		r.rollupBefore(day.Add(time.Hour))
+		Expect(store.stored["foo/1h"]).To(HaveKey(day))
+		Expect(store.stored["foo/1d"]).To(HaveKey(day))
+	})
+})
@@ -0,0 +1,58 @@
+package repo
+
+import (
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/stat"
+	"github.com/gocql/gocql"
+	"time"
+)
+
+// Bulk reads and writes stats and aggregates over a single session, for loading
+// large amounts of data such as an import of historical stats
+type Bulk struct {
+	session *gocql.Session
+}
+
+// OpenBulk connects to Cassandra
+func OpenBulk() (*Bulk, error) {
+	session, err := createSession()
+	if err != nil {
+		return nil, err
+	}
+	return &Bulk{session}, nil
+}
+
+// Close closes the session
+func (b *Bulk) Close() {
+	closeSession(b.session)
+}
+
+// WriteRawStats inserts raw stats, each read from the origin of the same
+// index. A stat with the same name and timestamp as one already stored replaces
+// it, unless the series keeps or sums such stats, when only a stat with the same
+// origin is replaced
+func (b *Bulk) WriteRawStats(stats []*stat.Stat, origins []string) error {
+	for i, s := range stats {
+		if err := indexSeries(b.session, s.Name); err != nil {
+			return err
+		}
+		cql, args := insert(s, origins[i])
+		if err := b.session.Query(cql, args...).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScanRawStats calls f with each raw stat of name from start up to, but not
+// including, end, in order of time, fetching them a page at a time like the
+// package's ScanRawStats
+func (b *Bulk) ScanRawStats(name string, start, end time.Time, f func(stat.Stat) error) error {
+	return scanRawStats(b.session, name, start, end, f)
+}
+
+// WriteAggregate stores the aggregate of name over the period of the resolution
+// (1m, 1h or 1d) starting at t, replacing any stored before
+func (b *Bulk) WriteAggregate(name, resolution string, t time.Time, a aggregator.StatsAggregate) error {
+	return b.session.Query(insertAggregate, name, resolution, t, a.Average, a.Min, a.Max, a.Count).Exec()
+}
@@ -0,0 +1,122 @@
+package repo
+
+import (
+	log "github.com/cihub/seelog"
+	"github.com/gocql/gocql"
+	"time"
+)
+
+// DashboardVersion is one saved version of a dashboard, its definition kept as
+// JSON
+type DashboardVersion struct {
+	ID      string
+	Version int
+	Title   string
+	Body    string
+	Saved   time.Time
+}
+
+// GetDashboard returns a version of a dashboard, or its latest version if
+// version is 0, or nil if there is no such version
+func GetDashboard(id string, version int) (*DashboardVersion, error) {
+	session, err := createSession()
+	if err != nil {
+		log.Error("failed to connect to Cassandra to get a dashboard: ", err)
+		return nil, err
+	}
+	defer closeSession(session)
+
+	q := session.Query(`SELECT id, version, title, body, saved FROM dashboards WHERE id = ? LIMIT 1`, id)
+	if version != 0 {
+		q = session.Query(`SELECT id, version, title, body, saved FROM dashboards WHERE id = ? AND version = ?`, id, version)
+	}
+	var d DashboardVersion
+	if err := q.Scan(&d.ID, &d.Version, &d.Title, &d.Body, &d.Saved); err == gocql.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// GetDashboardVersions returns every version of a dashboard, newest first and
+// without their bodies
+func GetDashboardVersions(id string) ([]DashboardVersion, error) {
+	session, err := createSession()
+	if err != nil {
+		log.Error("failed to connect to Cassandra to list dashboard versions: ", err)
+		return nil, err
+	}
+	defer closeSession(session)
+
+	var versions []DashboardVersion
+	iter := session.Query(`SELECT id, version, title, saved FROM dashboards WHERE id = ?`, id).Iter()
+	var d DashboardVersion
+	for iter.Scan(&d.ID, &d.Version, &d.Title, &d.Saved) {
+		versions = append(versions, d)
+	}
+	return versions, iter.Close()
+}
+
+// ListDashboards returns the latest version of every dashboard, without their
+// bodies
+func ListDashboards() ([]DashboardVersion, error) {
+	session, err := createSession()
+	if err != nil {
+		log.Error("failed to connect to Cassandra to list dashboards: ", err)
+		return nil, err
+	}
+	defer closeSession(session)
+
+	var ids []string
+	iter := session.Query(`SELECT DISTINCT id FROM dashboards`).Iter()
+	var id string
+	for iter.Scan(&id) {
+		ids = append(ids, id)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	var latest []DashboardVersion
+	for _, id := range ids {
+		var d DashboardVersion
+		err := session.Query(`SELECT id, version, title, saved FROM dashboards WHERE id = ? LIMIT 1`, id).
+			Scan(&d.ID, &d.Version, &d.Title, &d.Saved)
+		if err == gocql.ErrNotFound {
+			continue // deleted since it was listed
+		} else if err != nil {
+			return nil, err
+		}
+		latest = append(latest, d)
+	}
+	return latest, nil
+}
+
+// InsertDashboardVersion saves a version of a dashboard unless the version has
+// been saved already, reporting whether it was saved. The check is a
+// lightweight transaction, so of two clients saving the same version only one
+// succeeds
+func InsertDashboardVersion(d DashboardVersion) (bool, error) {
+	session, err := createSession()
+	if err != nil {
+		log.Error("failed to connect to Cassandra to save a dashboard: ", err)
+		return false, err
+	}
+	defer closeSession(session)
+
+	return session.Query(`INSERT INTO dashboards (id, version, title, body, saved) VALUES (?, ?, ?, ?, ?) IF NOT EXISTS`,
+		d.ID, d.Version, d.Title, d.Body, d.Saved).MapScanCAS(make(map[string]interface{}))
+}
+
+// DeleteDashboard deletes every version of a dashboard
+func DeleteDashboard(id string) error {
+	session, err := createSession()
+	if err != nil {
+		log.Error("failed to connect to Cassandra to delete a dashboard: ", err)
+		return err
+	}
+	defer closeSession(session)
+
+	return session.Query(`DELETE FROM dashboards WHERE id = ?`, id).Exec()
+}
@@ -0,0 +1,98 @@
+package repo
+
+import (
+	"errors"
+	"github.com/CapillarySoftware/gostat/stat"
+	"github.com/CapillarySoftware/gostat/writemode"
+	log "github.com/cihub/seelog"
+	"time"
+)
+
+// GetLastNRawStats returns the last stats of name, at most last of them, in
+// order of time
+func GetLastNRawStats(name string, last int) ([]stat.Stat, error) {
+	return getLastN(name, last, nil)
+}
+
+// GetLastNRawStatsBefore returns the last stats of name before, but not at, the
+// time before, at most last of them, in order of time. Passing the time of the
+// first stat returned gets the stats before those, to scroll back through a
+// series
+func GetLastNRawStatsBefore(name string, before time.Time, last int) ([]stat.Stat, error) {
+	return getLastN(name, last, &before)
+}
+
+// errEnough stops reading once the last n stats have been read
+var errEnough = errors.New("enough stats")
+
+func getLastN(name string, last int, before *time.Time) ([]stat.Stat, error) {
+	if last <= 0 {
+		return make([]stat.Stat, 0), nil
+	}
+
+	session, err := createSession()
+	if err != nil {
+		log.Error("failed to connect to Cassandra to query last n raw stats: ", err)
+		return make([]stat.Stat, 0), err
+	}
+	defer closeSession(session)
+
+	cql := `SELECT ts, value FROM ` + table(name) + ` WHERE name = ?`
+	args := []interface{}{name}
+	if before != nil {
+		cql += ` AND ts < ?`
+		args = append(args, *before)
+	}
+	cql += ` ORDER BY ts DESC`
+	if mode(name) != writemode.Sum {
+		// every row is a stat, whereas the rows of a summed series are read
+		// until a stat beyond the last n starts
+		cql += ` LIMIT ?`
+		args = append(args, last)
+	}
+
+	iter := session.Query(cql, args...).PageSize(ScanPageSize).Iter()
+	rawStats := collectLast(name, last, iter.Scan)
+	if err := iter.Close(); err != nil {
+		log.Error("error transforming last n raw stats query results: ", err)
+		return make([]stat.Stat, 0), err
+	}
+	return rawStats, nil
+}
+
+// collectLast reads the rows of a series newest first with scan, until it has
+// the last stats, and returns them in order of time
+func collectLast(name string, last int, scan func(dest ...interface{}) bool) []stat.Stat {
+	size := last
+	if size > ScanPageSize {
+		size = ScanPageSize
+	}
+	rawStats := make([]stat.Stat, 0, size)
+	p := newPoints(name, func(s stat.Stat) error {
+		if len(rawStats) == last {
+			return errEnough
+		}
+		rawStats = append(rawStats, s)
+		return nil
+	})
+
+	var ts time.Time
+	var value float64
+	for scan(&ts, &value) {
+		if p.add(ts, value) != nil {
+			break
+		}
+	}
+	p.flush()
+
+	reverse(rawStats)
+	return rawStats
+}
+
+// reverse reverses stats in place, turning the newest first order they are read
+// in into order of time
+func reverse(stats []stat.Stat) {
+	for i, j := 0, len(stats)-1; i < j; i, j = i+1, j-1 {
+		stats[i], stats[j] = stats[j], stats[i]
+	}
+}
@@ -0,0 +1,108 @@
+package repo
+
+import (
+	"github.com/CapillarySoftware/gostat/stat"
+	"github.com/CapillarySoftware/gostat/writemode"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+	"time"
+)
+
+// scanner scans rows like a gocql.Iter, newest first
+func scanner(rows []stat.Stat) func(dest ...interface{}) bool {
+	i := 0
+	return func(dest ...interface{}) bool {
+		if i == len(rows) {
+			return false
+		}
+		*dest[0].(*time.Time) = rows[i].Timestamp
+		*dest[1].(*float64) = rows[i].Value
+		i++
+		return true
+	}
+}
+
+// newestFirst returns n rows of a stat a second, newest first
+func newestFirst(name string, n int) []stat.Stat {
+	t0 := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+	rows := make([]stat.Stat, n)
+	for i := range rows {
+		rows[i] = stat.Stat{Name: name, Timestamp: t0.Add(time.Duration(n-i) * time.Second), Value: float64(n - i)}
+	}
+	return rows
+}
+
+var _ = Describe("collectLast", func() {
+	AfterEach(func() {
+		settings.Writes = nil
+	})
+
+	It("should return the last stats in order of time", func() {
+		stats := collectLast("foo", 3, scanner(newestFirst("foo", 5)))
+		Expect(stats).To(HaveLen(3))
+		Expect(stats[0].Value).To(Equal(3.0))
+		Expect(stats[2].Value).To(Equal(5.0))
+	})
+
+	It("should return fewer stats if the series has fewer", func() {
+		Expect(collectLast("foo", 10, scanner(newestFirst("foo", 2)))).To(HaveLen(2))
+		Expect(collectLast("foo", 10, scanner(nil))).To(BeEmpty())
+	})
+
+	It("should sum the rows of a summed series that share a timestamp", func() {
+		settings.Writes, _ = writemode.ParseRules([]string{"foo=sum"}, "last")
+		t0 := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+		rows := []stat.Stat{
+			{Timestamp: t0.Add(2 * time.Second), Value: 1},
+			{Timestamp: t0.Add(2 * time.Second), Value: 2},
+			{Timestamp: t0.Add(time.Second), Value: 4},
+			{Timestamp: t0.Add(time.Second), Value: 8},
+			{Timestamp: t0, Value: 16},
+		}
+		Expect(collectLast("foo", 2, scanner(rows))).To(Equal([]stat.Stat{
+			{Name: "foo", Timestamp: t0.Add(time.Second), Value: 12},
+			{Name: "foo", Timestamp: t0.Add(2 * time.Second), Value: 3},
+		}))
+	})
+})
+
+// prependLast is how the last stats used to be collected, prepending each row
+func prependLast(name string, scan func(dest ...interface{}) bool) []stat.Stat {
+	rawStats := make([]stat.Stat, 0)
+	tmp := make([]stat.Stat, 1)
+	var ts time.Time
+	var value float64
+	for scan(&ts, &value) {
+		tmp[0] = stat.Stat{Name: name, Timestamp: ts, Value: value}
+		rawStats = append(tmp, rawStats...)
+	}
+	return rawStats
+}
+
+func benchmarkLast(b *testing.B, n int, collect func(rows []stat.Stat) []stat.Stat) {
+	rows := newestFirst("foo", n)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(collect(rows)) != n {
+			b.Fatal("wrong number of stats")
+		}
+	}
+}
+
+func BenchmarkCollectLast1000(b *testing.B) {
+	benchmarkLast(b, 1000, func(rows []stat.Stat) []stat.Stat { return collectLast("foo", len(rows), scanner(rows)) })
+}
+
+func BenchmarkCollectLast10000(b *testing.B) {
+	benchmarkLast(b, 10000, func(rows []stat.Stat) []stat.Stat { return collectLast("foo", len(rows), scanner(rows)) })
+}
+
+func BenchmarkPrependLast1000(b *testing.B) {
+	benchmarkLast(b, 1000, func(rows []stat.Stat) []stat.Stat { return prependLast("foo", scanner(rows)) })
+}
+
+func BenchmarkPrependLast10000(b *testing.B) {
+	benchmarkLast(b, 10000, func(rows []stat.Stat) []stat.Stat { return prependLast("foo", scanner(rows)) })
+}
@@ -0,0 +1,99 @@
+package repo
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/CapillarySoftware/gostat/stat"
+	"github.com/CapillarySoftware/gostat/writemode"
+	log "github.com/cihub/seelog"
+	"math"
+	"time"
+)
+
+// noSkip is the timestamp of the cursor of a summed series that skips no rows
+const noSkip = math.MinInt64
+
+// GetRawStatsPage returns at most limit raw stats of name between start and
+// end, resuming from the cursor returned with the previous page, or from start
+// if cursor is nil. It also returns the cursor of the next page, which is nil
+// once the range is exhausted
+func GetRawStatsPage(name string, start, end time.Time, limit int, cursor []byte) ([]stat.Stat, []byte, error) {
+	session, err := createSession()
+	if err != nil {
+		log.Error("failed to connect to Cassandra to query a page of raw stats: ", err)
+		return nil, nil, err
+	}
+	defer closeSession(session)
+
+	// a summed series may have more rows with the last timestamp of a page on
+	// the next, so its cursor also holds that timestamp, whose rows are skipped
+	sum := mode(name) == writemode.Sum
+	var skip *time.Time
+	if sum && cursor != nil {
+		if len(cursor) < 8 {
+			return nil, nil, errors.New("invalid cursor")
+		}
+		if n := int64(binary.BigEndian.Uint64(cursor)); n != noSkip {
+			t := time.Unix(0, n)
+			skip = &t
+		}
+		cursor = cursor[8:]
+	}
+
+	// setting the page state, even to nil, turns off fetching the following
+	// pages automatically
+	iter := session.Query(`SELECT ts, value FROM `+table(name)+` WHERE name = ? AND ts >= ? AND ts <= ? ORDER BY ts`, name, start, end).
+		PageSize(limit).PageState(cursor).Iter()
+	next := iter.PageState()
+
+	stats := make([]stat.Stat, 0, iter.NumRows())
+	p := newPoints(name, func(s stat.Stat) error {
+		stats = append(stats, s)
+		return nil
+	})
+	rows := 0
+	var ts time.Time
+	var value float64
+	for rows < limit && iter.Scan(&ts, &value) {
+		rows++
+		if skip != nil && ts.Equal(*skip) {
+			continue
+		}
+		p.add(ts, value)
+	}
+
+	if err := iter.Close(); err != nil {
+		log.Error("error transforming raw stats page query results: ", err)
+		return nil, nil, err
+	}
+	if len(next) == 0 {
+		next = nil
+	}
+
+	if sum && next != nil && p.has {
+		// sum the rows of the last timestamp on the pages that follow too
+		last := p.pending.Timestamp
+		p.pending.Value = 0
+		iter := session.Query(`SELECT value FROM raw_stats_seq WHERE name = ? AND ts = ?`, name, last).Iter()
+		for iter.Scan(&value) {
+			p.pending.Value += value
+		}
+		if err := iter.Close(); err != nil {
+			log.Error("error summing raw stats for a page: ", err)
+			return nil, nil, err
+		}
+		skip = &last
+	}
+	p.flush()
+
+	if sum && next != nil {
+		skipped := make([]byte, 8, 8+len(next))
+		n := int64(noSkip)
+		if skip != nil {
+			n = skip.UnixNano()
+		}
+		binary.BigEndian.PutUint64(skipped, uint64(n))
+		next = append(skipped, next...)
+	}
+	return stats, next, nil
+}
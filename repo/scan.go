@@ -0,0 +1,69 @@
+package repo
+
+import (
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"github.com/gocql/gocql"
+	"time"
+)
+
+// ScanPageSize is the number of rows fetched from Cassandra at a time by the
+// Scan functions
+var ScanPageSize = 1000
+
+// ScanRawStats calls f with each raw stat of name from start up to, but not
+// including, end, in order of time. Rows are fetched a page at a time, so the
+// range can be far larger than fits in memory. Scanning stops at the first
+// error returned by f, which ScanRawStats returns
+func ScanRawStats(name string, start, end time.Time, f func(stat.Stat) error) error {
+	session, err := createSession()
+	if err != nil {
+		log.Error("failed to connect to Cassandra to scan raw stats: ", err)
+		return err
+	}
+	defer closeSession(session)
+
+	return scanRawStats(session, name, start, end, f)
+}
+
+// scanRawStats is ScanRawStats over session
+func scanRawStats(session *gocql.Session, name string, start, end time.Time, f func(stat.Stat) error) error {
+	iter := session.Query(`SELECT ts, value FROM `+table(name)+` WHERE name = ? AND ts >= ? AND ts < ? ORDER BY ts`, name, start, end).
+		PageSize(ScanPageSize).Iter()
+	p := newPoints(name, f)
+	var ts time.Time
+	var value float64
+	for iter.Scan(&ts, &value) {
+		if err := p.add(ts, value); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	return p.flush()
+}
+
+// ScanAggregates calls f with each aggregate of name at the resolution (1m, 1h
+// or 1d) from start up to, but not including, end, like ScanRawStats
+func ScanAggregates(name, resolution string, start, end time.Time, f func(aggregator.BucketAggregate) error) error {
+	session, err := createSession()
+	if err != nil {
+		log.Error("failed to connect to Cassandra to scan aggregates: ", err)
+		return err
+	}
+	defer closeSession(session)
+
+	iter := session.Query(`SELECT ts, average, min, max, count FROM aggregate_stats WHERE name = ? AND resolution = ? AND ts >= ? AND ts < ?`,
+		name, resolution, start, end).PageSize(ScanPageSize).Iter()
+	a := aggregator.BucketAggregate{Name: name}
+	for iter.Scan(&a.Time, &a.Average, &a.Min, &a.Max, &a.Count) {
+		if err := f(a); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
@@ -0,0 +1,124 @@
+package repo
+
+import (
+	"fmt"
+	"github.com/CapillarySoftware/gostat/migrate"
+	"github.com/gocql/gocql"
+	"strings"
+	"time"
+)
+
+// SchemaTimeout is the least timeout of the statements changing the schema,
+// which take longer than queries
+var SchemaTimeout = time.Second * 10
+
+// migrationsTable records the versions of the schema applied
+const migrationsTable = "schema_migrations"
+
+// Schema changes the schema of the keyspace, connecting to no keyspace so that
+// it can create it
+type Schema struct {
+	session *gocql.Session
+}
+
+// OpenSchema connects to Cassandra
+func OpenSchema() (*Schema, error) {
+	cluster := newCluster()
+	if cluster.Timeout < SchemaTimeout {
+		cluster.Timeout = SchemaTimeout
+	}
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{session}, nil
+}
+
+// Close closes the session
+func (s *Schema) Close() {
+	closeSession(s.session)
+}
+
+// Replication returns the replication of the keyspace, or nil if it does not
+// exist
+func (s *Schema) Replication() (migrate.Replication, error) {
+	ks, err := s.session.KeyspaceMetadata(settings.Keyspace)
+	if err == gocql.ErrKeyspaceDoesNotExist {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	class := ks.StrategyClass
+	if i := strings.LastIndex(class, "."); i >= 0 {
+		class = class[i+1:]
+	}
+	r := migrate.Replication{"class": class}
+	for k, v := range ks.StrategyOptions {
+		if k != "class" {
+			r[k] = fmt.Sprint(v)
+		}
+	}
+	return r, nil
+}
+
+// CreateKeyspace creates the keyspace and its schema_migrations table, if they
+// do not exist
+func (s *Schema) CreateKeyspace(r migrate.Replication) error {
+	if err := s.session.Query(`CREATE KEYSPACE IF NOT EXISTS ` + settings.Keyspace + ` WITH REPLICATION = ` + r.String()).Exec(); err != nil {
+		return err
+	}
+	return s.session.Query(`CREATE TABLE IF NOT EXISTS ` + s.migrations() + ` (
+		version int PRIMARY KEY,
+		name    varchar,
+		applied timestamp
+	)`).Exec()
+}
+
+// AlterKeyspace changes the replication of the keyspace
+func (s *Schema) AlterKeyspace(r migrate.Replication) error {
+	return s.session.Query(`ALTER KEYSPACE ` + settings.Keyspace + ` WITH REPLICATION = ` + r.String()).Exec()
+}
+
+// Applied returns when each version of the schema recorded was applied, which
+// is none if the keyspace or its schema_migrations table does not exist
+func (s *Schema) Applied() (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+	ks, err := s.session.KeyspaceMetadata(settings.Keyspace)
+	if err == gocql.ErrKeyspaceDoesNotExist {
+		return applied, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if _, ok := ks.Tables[migrationsTable]; !ok {
+		return applied, nil
+	}
+
+	iter := s.session.Query(`SELECT version, applied FROM ` + s.migrations()).Iter()
+	var version int
+	var at time.Time
+	for iter.Scan(&version, &at) {
+		applied[version] = at
+	}
+	return applied, iter.Close()
+}
+
+// Exec executes a statement changing the schema
+func (s *Schema) Exec(stmt string) error {
+	return s.session.Query(stmt).Exec()
+}
+
+// Record records that a version of the schema was applied
+func (s *Schema) Record(version int, name string, at time.Time) error {
+	return s.session.Query(`INSERT INTO `+s.migrations()+` (version, name, applied) VALUES (?, ?, ?)`, version, name, at).Exec()
+}
+
+// Forget records that a version of the schema was rolled back
+func (s *Schema) Forget(version int) error {
+	return s.session.Query(`DELETE FROM `+s.migrations()+` WHERE version = ?`, version).Exec()
+}
+
+// migrations returns the qualified name of the schema_migrations table
+func (s *Schema) migrations() string {
+	return settings.Keyspace + "." + migrationsTable
+}
@@ -0,0 +1,75 @@
+package repo
+
+import (
+	log "github.com/cihub/seelog"
+	"github.com/gocql/gocql"
+	"sync"
+)
+
+// indexed holds the series this process has added to the series table, so
+// that each is added on its first write only
+var indexed sync.Map
+
+// indexSeries adds name to the series table, unless this process already has
+func indexSeries(session *gocql.Session, name string) error {
+	if _, ok := indexed.Load(name); ok {
+		return nil
+	}
+	if err := session.Query(`INSERT INTO series (shard, name) VALUES (0, ?)`, name).Exec(); err != nil {
+		return err
+	}
+	indexed.Store(name, true)
+	return nil
+}
+
+// GetStatNames returns the name of every stat that has been recorded, in order,
+// from the series table
+func GetStatNames() ([]string, error) {
+	session, err := createSession()
+	if err != nil {
+		log.Error("failed to connect to Cassandra to query stat names: ", err)
+		return make([]string, 0), err
+	}
+	defer closeSession(session)
+
+	names := make([]string, 0)
+	iter := session.Query(`SELECT name FROM series WHERE shard = 0`).PageSize(ScanPageSize).Iter()
+	var name string
+	for iter.Scan(&name) {
+		names = append(names, name)
+	}
+	if err := iter.Close(); err != nil {
+		log.Error("error transforming stat names query results: ", err)
+		return make([]string, 0), err
+	}
+	return names, nil
+}
+
+// IndexSeries adds every series with raw stats to the series table, for those
+// stored before it was created. It scans the partitions of both raw stats
+// tables, so it is run once, by gostat migrate
+func IndexSeries() (int, error) {
+	session, err := createSession()
+	if err != nil {
+		log.Error("failed to connect to Cassandra to index the series: ", err)
+		return 0, err
+	}
+	defer closeSession(session)
+
+	n := 0
+	for _, t := range []string{rawTable, seqTable} {
+		iter := session.Query(`SELECT DISTINCT name FROM ` + t).PageSize(ScanPageSize).Iter()
+		var name string
+		for iter.Scan(&name) {
+			if err := indexSeries(session, name); err != nil {
+				iter.Close()
+				return n, err
+			}
+			n++
+		}
+		if err := iter.Close(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
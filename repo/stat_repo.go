@@ -1,25 +1,81 @@
 package repo
 
 import (
+	"github.com/CapillarySoftware/gostat/clock"
+	"github.com/CapillarySoftware/gostat/metrics"
 	"github.com/CapillarySoftware/gostat/stat"
+	"github.com/CapillarySoftware/gostat/writemode"
 	log "github.com/cihub/seelog"
 	"github.com/gocql/gocql"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	writes       = metrics.NewCounter("gostat.repo.writes", "Raw stats written to Cassandra")
+	writeErrors  = metrics.NewCounter("gostat.repo.write.errors", "Raw stats that could not be written to Cassandra")
+	writeLatency = metrics.NewHistogram("gostat.repo.write.latency", "Seconds taken to write a raw stat to Cassandra")
+
+	lastWrite int64 // the UnixNano time of the last successful write, accessed atomically
+)
+
+// LastWrite returns when a raw stat was last written to Cassandra, or the zero
+// time if none has been
+func LastWrite() time.Time {
+	if t := atomic.LoadInt64(&lastWrite); t != 0 {
+		return time.Unix(0, t).UTC()
+	}
+	return time.Time{}
+}
+
+// Ping checks that Cassandra can be queried
+func Ping() error {
+	session, err := createSession()
+	if err != nil {
+		return err
+	}
+	defer closeSession(session)
+
+	var key string
+	return session.Query(`SELECT key FROM system.local`).Scan(&key)
+}
+
 type StatRepo struct {
 	rawStats <-chan *stat.Stat // Stats to be persisted are read from this channel
 	shutdown <-chan bool       // signals a graceful shutdown
+	clock    clock.Clock       // times writes
+
+	persisted func(*stat.Stat)                // called with each stat once it is persisted, if not nil
+	origin    func(*stat.Stat) (string, bool) // tells where a stat was read from, if not nil
 }
 
-// NewStatRepo constructs a StatRepo
+// NewStatRepo constructs a StatRepo timing writes with the system clock
 func NewStatRepo(rawStats <-chan *stat.Stat, shutdown <-chan bool) *StatRepo {
+	return NewStatRepoWithClock(rawStats, shutdown, clock.Real)
+}
+
+// NewStatRepoWithClock constructs a StatRepo timing writes with a clock
+func NewStatRepoWithClock(rawStats <-chan *stat.Stat, shutdown <-chan bool, c clock.Clock) *StatRepo {
 	return &StatRepo{
 		rawStats: rawStats,
 		shutdown: shutdown,
+		clock:    c,
 	}
 }
 
+// OnPersisted registers a function called with each stat once it has been
+// written to Cassandra
+func (s *StatRepo) OnPersisted(f func(*stat.Stat)) {
+	s.persisted = f
+}
+
+// Origins registers a function telling where a stat was read from, such as its
+// position in the write-ahead log, which is the same when the stat is written
+// again, e.g. when the log is replayed
+func (s *StatRepo) Origins(f func(*stat.Stat) (string, bool)) {
+	s.origin = f
+}
+
 // Run is a goroutine that writes stats from the input channel, placing them into
 // the appropriate bucket. Buckets are published on the output channel at the
 // specified interval
@@ -30,39 +86,107 @@ func (s *StatRepo) Run() {
 		select {
 		case stat := <-s.rawStats:
 			log.Debugf("StatRepo got %+v", *stat)
-			s.insertRawStat(stat)
-		case done = <-s.shutdown:
+			s.write(stat)
+		case <-s.shutdown:
 			log.Debug("StatRepo shutting down ", time.Now())
-		case <-time.After(time.Second * 1):
-			log.Debug("StatRepo Run() timeout ", time.Now())
+			done = true
+			s.drain()
 		}
 	}
 
 	log.Info("StatRepo InsertRawStats() exiting ", time.Now())
 }
 
-func createSession() (session *gocql.Session, err error) {
-	cluster := gocql.NewCluster("localhost")
-	cluster.Keyspace = "gostat"
-	cluster.Consistency = gocql.Quorum
+// drain writes the stats already waiting on the input channel
+func (s *StatRepo) drain() {
+	for {
+		select {
+		case stat := <-s.rawStats:
+			s.write(stat)
+		default:
+			return
+		}
+	}
+}
+
+// write inserts a stat, reporting it as persisted if the insert succeeds
+func (s *StatRepo) write(stat *stat.Stat) {
+	start := s.clock.Now()
+	var origin string
+	if s.origin != nil {
+		origin, _ = s.origin(stat)
+	}
+	err := s.insertRawStat(stat, origin)
+	writeLatency.Observe(s.clock.Now().Sub(start).Seconds())
+
+	if err != nil {
+		writeErrors.Inc()
+		return
+	}
+	writes.Inc()
+	atomic.StoreInt64(&lastWrite, s.clock.Now().UnixNano())
+	if s.persisted != nil {
+		s.persisted(stat)
+	}
+}
 
+// Settings locate the Cassandra cluster stats are stored in, and choose how
+// each series stores stats that share a timestamp
+type Settings struct {
+	Hosts       []string
+	Keyspace    string
+	Consistency gocql.Consistency
+	Timeout     time.Duration
+	Writes      *writemode.Rules // nil for last-write-wins throughout
+}
+
+// settings are used by every query, and default to a local cluster
+var settings = Settings{
+	Hosts:       []string{"localhost"},
+	Keyspace:    "gostat",
+	Consistency: gocql.Quorum,
+	Timeout:     time.Millisecond * 600,
+}
+
+// Configure sets the Cassandra cluster used by the repo. It must be called
+// before the repo is used
+func Configure(s Settings) {
+	settings = s
+}
+
+func createSession() (session *gocql.Session, err error) {
+	cluster := newCluster()
+	cluster.Keyspace = settings.Keyspace
 	return cluster.CreateSession()
 }
 
-func (s *StatRepo) insertRawStat(stat *stat.Stat) {
+// newCluster returns the configuration of the cluster, connecting to no keyspace
+func newCluster() *gocql.ClusterConfig {
+	cluster := gocql.NewCluster(settings.Hosts...)
+	cluster.Consistency = settings.Consistency
+	cluster.Timeout = settings.Timeout
+	return cluster
+}
+
+func (s *StatRepo) insertRawStat(stat *stat.Stat, origin string) error {
 	var session *gocql.Session
 	var err error
 
 	if session, err = createSession(); err != nil {
 		log.Error("error connecting to Cassandra to insert raw stat: ", err)
-		return
+		return err
 	}
 	defer closeSession(session)
 
-	if err := session.Query(`INSERT INTO raw_stats (name, ts, value) VALUES (?, ?, ?)`,
-		stat.Name, stat.Timestamp, stat.Value).Exec(); err != nil {
+	if err = indexSeries(session, stat.Name); err != nil {
+		log.Error("error indexing the series of a raw stat: ", err)
+		return err
+	}
+	cql, args := insert(stat, origin)
+	if err = session.Query(cql, args...).Exec(); err != nil {
 		log.Error("error inserting raw stat: ", err)
 	}
+	return err
 }
 
 func closeSession(session *gocql.Session) {
@@ -82,13 +206,17 @@ func GetRawStats(name string, start, end time.Time) ([]stat.Stat, error) {
 	}
 	defer closeSession(session)
 
-	iter := session.Query(`SELECT ts, value FROM raw_stats WHERE name = ? AND ts >= ? AND ts <= ?`, name, start, end).Iter()
+	iter := session.Query(`SELECT ts, value FROM `+table(name)+` WHERE name = ? AND ts >= ? AND ts <= ? ORDER BY ts`, name, start, end).Iter()
+	p := newPoints(name, func(s stat.Stat) error {
+		rawStats = append(rawStats, s)
+		return nil
+	})
 	var ts time.Time
 	var value float64
 	for iter.Scan(&ts, &value) {
-		stat := stat.Stat{Name: name, Timestamp: ts, Value: value}
-		rawStats = append(rawStats, stat)
+		p.add(ts, value)
 	}
+	p.flush()
 
 	if err := iter.Close(); err != nil {
 		log.Error("error transforming raw stats query results: ", err)
@@ -97,32 +225,3 @@ func GetRawStats(name string, start, end time.Time) ([]stat.Stat, error) {
 
 	return rawStats, nil
 }
-
-func GetLastNRawStats(name string, last int) ([]stat.Stat, error) {
-	var session *gocql.Session
-	var err error
-	rawStats := make([]stat.Stat, 0)
-	tmp := make([]stat.Stat, 1)
-
-	if session, err = createSession(); err != nil {
-		log.Error("failed to connect to Cassandra to query last n raw stats: ", err)
-		return make([]stat.Stat, 0), err
-	}
-	defer session.Close()
-
-	iter := session.Query(`SELECT ts, value FROM raw_stats WHERE name = ? ORDER BY ts DESC LIMIT ?`, name, last).Iter()
-	var ts time.Time
-	var value float64
-	for iter.Scan(&ts, &value) {
-		stat := stat.Stat{Name: name, Timestamp: ts, Value: value}
-		tmp[0] = stat
-		rawStats = append(tmp, rawStats...)
-	}
-
-	if err := iter.Close(); err != nil {
-		log.Error("error transforming last n raw stats query results: ", err)
-		return make([]stat.Stat, 0), err
-	}
-
-	return rawStats, nil
-}
@@ -8,5 +8,7 @@ import (
 )
 
 var _ = Describe("StatRepo", func() {
-
+	It("should return no stats without querying for none", func() {
+		Expect(GetLastNRawStats("foo", 0)).To(BeEmpty())
+	})
 })
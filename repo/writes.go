@@ -0,0 +1,163 @@
+package repo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/stat"
+	"github.com/CapillarySoftware/gostat/writemode"
+	log "github.com/cihub/seelog"
+	"github.com/gocql/gocql"
+	"hash/fnv"
+	"time"
+)
+
+// The tables raw stats are stored in. raw_stats holds one stat per name and
+// timestamp, so a later write replaces an earlier one. raw_stats_seq holds every
+// stat, each with a unique seq, for series that keep or sum the stats sharing a
+// timestamp
+const (
+	rawTable = "raw_stats"
+	seqTable = "raw_stats_seq"
+)
+
+// mode returns the write mode of the series name
+func mode(name string) writemode.Mode {
+	return settings.Writes.Mode(name)
+}
+
+// table returns the table the raw stats of the series name are stored in
+func table(name string) string {
+	if mode(name) == writemode.LastWriteWins {
+		return rawTable
+	}
+	return seqTable
+}
+
+// insert returns the statement inserting a raw stat, and its arguments. A
+// stat of a series that keeps or sums the stats sharing a timestamp is given a
+// seq derived from its origin, where it was read from, if it has one, so that
+// writing it again replaces it rather than adding to it. Without an origin the
+// seq is new
+func insert(s *stat.Stat, origin string) (string, []interface{}) {
+	if table(s.Name) == rawTable {
+		return `INSERT INTO raw_stats (name, ts, value) VALUES (?, ?, ?)`, []interface{}{s.Name, s.Timestamp, s.Value}
+	}
+
+	seq := gocql.TimeUUID()
+	if origin != "" {
+		seq = originSeq(s.Timestamp, origin)
+	}
+	return `INSERT INTO raw_stats_seq (name, ts, seq, value) VALUES (?, ?, ?, ?)`, []interface{}{s.Name, s.Timestamp, seq, s.Value}
+}
+
+// originSeq returns a time UUID of the time t whose clock sequence and node are
+// a hash of origin, so that it is the same for the same stat
+func originSeq(t time.Time, origin string) gocql.UUID {
+	seq := gocql.UUIDFromTime(t)
+	h := fnv.New64a()
+	h.Write([]byte(origin))
+	binary.BigEndian.PutUint64(seq[8:], h.Sum64())
+	seq[8] = seq[8]&0x3f | 0x80 // the RFC 4122 variant
+	return seq
+}
+
+// points turns the rows of a series, ordered by time, into its stats, summing
+// the adjacent rows that share a timestamp if the series is summed
+type points struct {
+	name    string
+	sum     bool
+	pending stat.Stat // the last stat, which more rows may be summed into
+	has     bool      // whether there is a pending stat
+	emit    func(stat.Stat) error
+}
+
+func newPoints(name string, emit func(stat.Stat) error) *points {
+	return &points{name: name, sum: mode(name) == writemode.Sum, emit: emit}
+}
+
+func (p *points) add(ts time.Time, value float64) error {
+	if p.has {
+		if p.sum && p.pending.Timestamp.Equal(ts) {
+			p.pending.Value += value
+			return nil
+		}
+		if err := p.emit(p.pending); err != nil {
+			return err
+		}
+	}
+	p.pending, p.has = stat.Stat{Name: p.name, Timestamp: ts, Value: value}, true
+	return nil
+}
+
+// flush emits the last stat, which may have been waiting for more rows with its
+// timestamp
+func (p *points) flush() error {
+	if !p.has {
+		return nil
+	}
+	p.has = false
+	return p.emit(p.pending)
+}
+
+// MisplacedSeries returns the series with raw stats in the table of a write
+// mode other than their own, after their mode was changed, since their stats in
+// that table are no longer read. MoveSeries moves them
+func MisplacedSeries() ([]string, error) {
+	session, err := createSession()
+	if err != nil {
+		log.Error("failed to connect to Cassandra to check the tables of the series: ", err)
+		return nil, err
+	}
+	defer closeSession(session)
+
+	var misplaced []string
+	for _, t := range []string{rawTable, seqTable} {
+		iter := session.Query(`SELECT DISTINCT name FROM ` + t).PageSize(ScanPageSize).Iter()
+		var name string
+		for iter.Scan(&name) {
+			if table(name) != t {
+				misplaced = append(misplaced, name)
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return misplaced, nil
+}
+
+// MoveSeries moves the raw stats of name stored in the table of another write
+// mode into the table of its own, and deletes them from the other. Stats moved
+// into raw_stats keep only the last of those sharing a timestamp. The stats are
+// given the same seq whenever they are moved into raw_stats_seq, so a move
+// interrupted part way can be run again
+func MoveSeries(name string) error {
+	session, err := createSession()
+	if err != nil {
+		log.Error("failed to connect to Cassandra to move a series: ", err)
+		return err
+	}
+	defer closeSession(session)
+
+	from := seqTable
+	if table(name) == seqTable {
+		from = rawTable
+	}
+
+	iter := session.Query(`SELECT ts, value FROM `+from+` WHERE name = ?`, name).PageSize(ScanPageSize).Iter()
+	var ts time.Time
+	var value float64
+	for n := 0; iter.Scan(&ts, &value); n++ {
+		s := &stat.Stat{Name: name, Timestamp: ts, Value: value}
+		cql, args := insert(s, fmt.Sprintf("%s/%d", from, n))
+		if err := session.Query(cql, args...).Exec(); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	return session.Query(`DELETE FROM `+from+` WHERE name = ?`, name).Exec()
+}
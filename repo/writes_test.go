@@ -0,0 +1,52 @@
+package repo
+
+import (
+	"github.com/CapillarySoftware/gostat/stat"
+	"github.com/CapillarySoftware/gostat/writemode"
+	"github.com/gocql/gocql"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("insert", func() {
+	t := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	BeforeEach(func() {
+		modes, err := writemode.ParseRules([]string{"keep.*=keep"}, "last")
+		Expect(err).NotTo(HaveOccurred())
+		settings.Writes = modes
+	})
+
+	AfterEach(func() {
+		settings.Writes = nil
+	})
+
+	It("should insert the stats of a last-write-wins series into raw_stats", func() {
+		cql, args := insert(&stat.Stat{Name: "foo", Timestamp: t, Value: 1}, "wal/1/0")
+		Expect(cql).To(HavePrefix("INSERT INTO raw_stats "))
+		Expect(args).To(Equal([]interface{}{"foo", t, 1.0}))
+	})
+
+	It("should give a stat with an origin the same seq each time", func() {
+		s := &stat.Stat{Name: "keep.foo", Timestamp: t, Value: 1}
+		cql, first := insert(s, "wal/1/0")
+		Expect(cql).To(HavePrefix("INSERT INTO raw_stats_seq "))
+		_, again := insert(s, "wal/1/0")
+		_, other := insert(s, "wal/1/30")
+		Expect(again).To(Equal(first))
+		Expect(other[2]).NotTo(Equal(first[2]))
+
+		seq := first[2].(gocql.UUID)
+		Expect(seq.Time()).To(Equal(t))
+		Expect(seq.Version()).To(Equal(1))
+		Expect(seq.Variant()).To(Equal(gocql.VariantIETF))
+	})
+
+	It("should give a stat without an origin a new seq", func() {
+		s := &stat.Stat{Name: "keep.foo", Timestamp: t, Value: 1}
+		_, first := insert(s, "")
+		_, second := insert(s, "")
+		Expect(second[2]).NotTo(Equal(first[2]))
+	})
+})
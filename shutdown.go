@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// stage is a goroutine of the stats pipeline. Closing its shutdown channel
+// tells it to drain its input, flush whatever it holds, and exit
+type stage struct {
+	name     string
+	shutdown chan bool
+	running  sync.WaitGroup
+}
+
+// newStage constructs a stage
+func newStage(name string) *stage {
+	return &stage{name: name, shutdown: make(chan bool)}
+}
+
+// run starts the stage's goroutine
+func (s *stage) run(f func()) {
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		f()
+	}()
+}
+
+// stop signals the stage to shut down and waits for it to exit
+func (s *stage) stop(ctx context.Context) error {
+	close(s.shutdown)
+	return wait(ctx, &s.running)
+}
+
+// shutdownPipeline stops ingesting stats, then stops each stage in turn so that
+// the stats already ingested are drained through the rest of the pipeline. It
+// gives up if ctx is done first
+func shutdownPipeline(ctx context.Context, stopIngest context.CancelFunc, ingest *sync.WaitGroup, stages []*stage) error {
+	stopIngest()
+	if err := wait(ctx, ingest); err != nil {
+		return fmt.Errorf("waiting for the listeners to stop: %v", err)
+	}
+
+	for _, s := range stages {
+		if err := s.stop(ctx); err != nil {
+			return fmt.Errorf("waiting for the %s to stop: %v", s.name, err)
+		}
+	}
+	return nil
+}
+
+// wait waits for wg, or until ctx is done
+func wait(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
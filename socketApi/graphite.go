@@ -0,0 +1,326 @@
+package socketApi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/query"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Graphite render and find APIs, so that Grafana's Graphite data source can
+// chart gostat's series without a plugin
+
+// now returns the time relative Graphite times are from, and up to which rollups
+// read stored aggregates, replaced in tests
+var now = time.Now
+
+// graphiteSeries is a series rendered as Graphite's JSON format
+type graphiteSeries struct {
+	Target     string      `json:"target"`
+	Datapoints []datapoint `json:"datapoints"`
+}
+
+// datapoint is a [value, timestamp] pair of a rendered series, the timestamp in
+// UNIX epoch seconds and the value null if it isn't a number
+type datapoint struct {
+	Value float64
+	Ts    int64
+}
+
+func (d datapoint) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(d.Value) || math.IsInf(d.Value, 0) {
+		return []byte(fmt.Sprintf("[null,%d]", d.Ts)), nil
+	}
+	return []byte(fmt.Sprintf("[%s,%d]", strconv.FormatFloat(d.Value, 'g', -1, 64), d.Ts)), nil
+}
+
+// metricNode is a node of the tree of metric names, as returned by
+// /metrics/find. A leaf is a series, and a branch has series below it; a node
+// may be both
+type metricNode struct {
+	Text          string `json:"text"`
+	ID            string `json:"id"`
+	Leaf          int    `json:"leaf"`
+	Expandable    int    `json:"expandable"`
+	AllowChildren int    `json:"allowChildren"`
+}
+
+var (
+	relativeTimeRegexp = regexp.MustCompile(`^(?:now)?-(\d+)(s|sec|secs|seconds?|min|mins|minutes?|h|hours?|d|days?|w|weeks?|mon|months?|y|years?)$`)
+	epochRegexp        = regexp.MustCompile(`^\d{1,18}$`)
+)
+
+// parseGraphiteTime parses the from or until parameter of a render request: now,
+// a time before now such as -6h or now-7d, UNIX epoch seconds, or an absolute
+// time such as 14:30_20141001 or 20141001, in UTC
+func parseGraphiteTime(s string, now time.Time) (time.Time, error) {
+	if s == "now" {
+		return now, nil
+	}
+	if m := relativeTimeRegexp.FindStringSubmatch(s); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		var unit time.Duration
+		switch {
+		case strings.HasPrefix(m[2], "s"):
+			unit = time.Second
+		case strings.HasPrefix(m[2], "mi"):
+			unit = time.Minute
+		case strings.HasPrefix(m[2], "h"):
+			unit = time.Hour
+		case strings.HasPrefix(m[2], "d"):
+			unit = time.Hour * 24
+		case strings.HasPrefix(m[2], "w"):
+			unit = time.Hour * 24 * 7
+		case strings.HasPrefix(m[2], "mo"):
+			unit = time.Hour * 24 * 30
+		case strings.HasPrefix(m[2], "y"):
+			unit = time.Hour * 24 * 365
+		}
+		return now.Add(-time.Duration(n) * unit), nil
+	}
+	if epochRegexp.MatchString(s) && len(s) != 8 {
+		n, _ := strconv.ParseInt(s, 10, 64)
+		return time.Unix(n, 0), nil
+	}
+	for _, layout := range []string{"15:04_20060102", "20060102"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use now, a time before it like -6h, epoch seconds or HH:MM_YYYYMMDD", s)
+}
+
+// renderHandler serves /render?target=...&from=...&until=...&format=json, the
+// series of each target, a query, between from and until. Given maxDataPoints,
+// each series is averaged into at most that many steps. A target naming series
+// is then rolled up from the raw stats however many there are, while other
+// queries are limited to MaxPoints raw stats as /query is
+func renderHandler(w http.ResponseWriter, r *http.Request) {
+	queries("render").Inc()
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if format := r.Form.Get("format"); format != "" && format != "json" {
+		http.Error(w, fmt.Sprintf("unsupported format %q, only json is rendered", format), http.StatusBadRequest)
+		return
+	}
+
+	t := now()
+	from, until := "-24h", "now"
+	if s := r.Form.Get("from"); s != "" {
+		from = s
+	}
+	if s := r.Form.Get("until"); s != "" {
+		until = s
+	}
+	start, err := parseGraphiteTime(from, t)
+	if err != nil {
+		http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	end, err := parseGraphiteTime(until, t)
+	if err != nil {
+		http.Error(w, "invalid until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !start.Before(end) {
+		http.Error(w, "from must be before until", http.StatusBadRequest)
+		return
+	}
+
+	maxDataPoints := 0
+	if s := r.Form.Get("maxDataPoints"); s != "" {
+		if maxDataPoints, err = strconv.Atoi(s); err != nil || maxDataPoints < 1 {
+			http.Error(w, "invalid maxDataPoints", http.StatusBadRequest)
+			return
+		}
+		if maxDataPoints > MaxPoints {
+			maxDataPoints = MaxPoints
+		}
+	}
+
+	rendered := make([]graphiteSeries, 0)
+	for _, target := range r.Form["target"] {
+		if strings.TrimSpace(target) == "" {
+			continue
+		}
+		series, err := render(target, start, end, maxDataPoints)
+		if err != nil {
+			log.Errorf("error rendering %q for /render: %v", target, err)
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		rendered = append(rendered, series...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rendered)
+}
+
+// render returns the series of a target between start and end, averaged into
+// at most maxDataPoints steps unless it is 0
+func render(target string, start, end time.Time, maxDataPoints int) ([]graphiteSeries, error) {
+	var step time.Duration
+	if maxDataPoints > 0 {
+		// whole seconds, as Graphite's timestamps are
+		step = (end.Sub(start) + time.Duration(maxDataPoints) - 1) / time.Duration(maxDataPoints)
+		step = (step + time.Second - 1) / time.Second * time.Second
+	}
+
+	expr, err := query.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if ref, ok := expr.(*query.SeriesRef); ok && step > 0 {
+		return renderRollups(ref.Pattern, start, end, step)
+	}
+
+	results, err := runQuery(target, start, end)
+	if err != nil {
+		return nil, err
+	}
+	rendered := make([]graphiteSeries, 0, len(results))
+	for _, s := range results {
+		stats := s.Stats
+		if step > 0 && len(stats) > maxDataPoints {
+			stats = consolidate(stats, step)
+		}
+		points := make([]datapoint, 0, len(stats))
+		for _, st := range stats {
+			points = append(points, datapoint{Value: st.Value, Ts: st.Timestamp.Unix()})
+		}
+		rendered = append(rendered, graphiteSeries{Target: s.Name, Datapoints: points})
+	}
+	return rendered, nil
+}
+
+// renderRollups renders the series matching a pattern, or named by it, as the
+// averages of their raw stats in steps of step
+func renderRollups(pattern string, start, end time.Time, step time.Duration) ([]graphiteSeries, error) {
+	names, err := matchingNames(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := make([]graphiteSeries, 0, len(names))
+	for _, name := range names {
+		aggregates, err := rollup(name, start, end, step)
+		if err != nil {
+			return nil, repoError{err}
+		}
+		points := make([]datapoint, 0, len(aggregates))
+		for _, a := range aggregates {
+			points = append(points, datapoint{Value: a.Average, Ts: a.Time.Unix()})
+		}
+		rendered = append(rendered, graphiteSeries{Target: name, Datapoints: points})
+	}
+	return rendered, nil
+}
+
+// matchingNames returns the sorted names of the series matching a pattern, or
+// just the name if it isn't a pattern, as a query would fetch
+func matchingNames(pattern string) ([]string, error) {
+	if !strings.ContainsAny(pattern, "*?[{;") {
+		return []string{pattern}, nil
+	}
+	all, err := getNames()
+	if err != nil {
+		return nil, repoError{err}
+	}
+	var names []string
+	for _, name := range all {
+		if query.MatchName(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// consolidate averages time ordered stats into steps of step since the UNIX
+// epoch, skipping values that aren't numbers
+func consolidate(stats []stat.Stat, step time.Duration) []stat.Stat {
+	var out []stat.Stat
+	var count int
+	for _, s := range stats {
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+		t := floor(s.Timestamp, step)
+		if n := len(out); n > 0 && out[n-1].Timestamp.Equal(t) {
+			count++
+			out[n-1].Value += (s.Value - out[n-1].Value) / float64(count)
+			continue
+		}
+		out = append(out, stat.Stat{Name: s.Name, Timestamp: t, Value: s.Value})
+		count = 1
+	}
+	return out
+}
+
+// findHandler serves /metrics/find?query=..., the nodes of the tree of metric
+// names matching a pattern such as web.* segment for segment. A series with
+// tags is a leaf named after its last segment and its tags
+func findHandler(w http.ResponseWriter, r *http.Request) {
+	queries("find").Inc()
+	names, err := getNames()
+	if err != nil {
+		log.Error("repo error listing series for /metrics/find: ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(findMetrics(r.FormValue("query"), names))
+}
+
+// findMetrics returns the nodes of the tree of names matching pattern, sorted
+func findMetrics(pattern string, names []string) []metricNode {
+	if pattern == "" {
+		pattern = "*"
+	}
+	depth := len(strings.Split(pattern, "."))
+
+	found := make(map[string]*metricNode)
+	for _, name := range names {
+		metric, _ := stat.ParseName(name)
+		segments := strings.Split(metric, ".")
+		if len(segments) < depth {
+			continue
+		}
+		prefix := strings.Join(segments[:depth], ".")
+		if !query.MatchName(pattern, prefix) {
+			continue
+		}
+
+		if len(segments) == depth {
+			if node, ok := found[name]; ok {
+				node.Leaf = 1
+				continue
+			}
+			found[name] = &metricNode{Text: segments[depth-1] + name[len(metric):], ID: name, Leaf: 1}
+			continue
+		}
+		if node, ok := found[prefix]; ok {
+			node.Expandable, node.AllowChildren = 1, 1
+			continue
+		}
+		found[prefix] = &metricNode{Text: segments[depth-1], ID: prefix, Expandable: 1, AllowChildren: 1}
+	}
+
+	nodes := make([]metricNode, 0, len(found))
+	for _, node := range found {
+		nodes = append(nodes, *node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
@@ -0,0 +1,222 @@
+package socketApi
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/repo"
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var _ = Describe("Graphite", func() {
+	var stats map[string][]stat.Stat
+	t := time.Unix(1412164800, 0)
+
+	BeforeEach(func() {
+		stats = map[string][]stat.Stat{
+			"web.host1.requests":         {{Timestamp: t.Add(-90 * time.Second), Value: 1}, {Timestamp: t.Add(-80 * time.Second), Value: 3}, {Timestamp: t.Add(-30 * time.Second), Value: 8}},
+			"web.host2.requests":         {{Timestamp: t.Add(-90 * time.Second), Value: 10}},
+			"web.host2.requests;dc=east": {{Timestamp: t.Add(-90 * time.Second), Value: 100}},
+			"db.reads":                   {{Timestamp: t.Add(-90 * time.Second), Value: 5}},
+		}
+		getNames = func() ([]string, error) {
+			names := make([]string, 0, len(stats))
+			for name := range stats {
+				names = append(names, name)
+			}
+			return names, nil
+		}
+		scanRawStats = func(name string, start, end time.Time, f func(stat.Stat) error) error {
+			for _, s := range stats[name] {
+				if err := f(s); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		scanAggregates = func(name, resolution string, start, end time.Time, f func(aggregator.BucketAggregate) error) error {
+			return nil // none stored, so rollups scan the raw stats
+		}
+		getPage = func(name string, start, end time.Time, size int, cursor []byte) ([]stat.Stat, []byte, error) {
+			return stats[name], nil, nil
+		}
+		now = func() time.Time { return t }
+	})
+
+	AfterEach(func() {
+		getNames, scanRawStats, scanAggregates, getPage = repo.GetStatNames, repo.ScanRawStats, repo.ScanAggregates, repo.GetRawStatsPage
+		now = time.Now
+	})
+
+	serve := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		var r *http.Request
+		if method == "POST" {
+			r, _ = http.NewRequest(method, path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			r, _ = http.NewRequest(method, path+"?"+form.Encode(), nil)
+		}
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/render":
+				renderHandler(w, r)
+			case "/metrics/find":
+				findHandler(w, r)
+			}
+		}).ServeHTTP(w, r)
+		return w
+	}
+
+	rendered := func(w *httptest.ResponseRecorder) []map[string]interface{} {
+		Expect(w.Code).To(Equal(http.StatusOK), w.Body.String())
+		var series []map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &series)).To(Succeed())
+		return series
+	}
+
+	Describe("parseGraphiteTime", func() {
+		It("should parse times relative to now", func() {
+			for s, ago := range map[string]time.Duration{
+				"now":     0,
+				"-30s":    30 * time.Second,
+				"-5min":   5 * time.Minute,
+				"now-6h":  6 * time.Hour,
+				"-7d":     7 * 24 * time.Hour,
+				"-2weeks": 14 * 24 * time.Hour,
+				"-1mon":   30 * 24 * time.Hour,
+				"-1y":     365 * 24 * time.Hour,
+			} {
+				parsed, err := parseGraphiteTime(s, t)
+				Expect(err).NotTo(HaveOccurred(), s)
+				Expect(parsed).To(Equal(t.Add(-ago)), s)
+			}
+		})
+
+		It("should parse epoch seconds and absolute times", func() {
+			parsed, err := parseGraphiteTime("1412134560", t)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed.Unix()).To(Equal(int64(1412134560)))
+
+			parsed, err = parseGraphiteTime("14:30_20141001", t)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed).To(Equal(time.Date(2014, 10, 1, 14, 30, 0, 0, time.UTC)))
+
+			parsed, err = parseGraphiteTime("20141001", t)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed).To(Equal(time.Date(2014, 10, 1, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("should reject anything else", func() {
+			_, err := parseGraphiteTime("yesterday", t)
+			Expect(err).To(HaveOccurred())
+			_, err = parseGraphiteTime("-5", t)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("/render", func() {
+		It("should render the raw stats of a query as [value, timestamp] pairs", func() {
+			series := rendered(serve("GET", "/render", url.Values{"target": {"scale(db.reads, 2)"}, "from": {"-5min"}, "format": {"json"}}))
+			Expect(series).To(HaveLen(1))
+			Expect(series[0]["target"]).To(Equal("scale(db.reads,2)"))
+			Expect(series[0]["datapoints"]).To(Equal([]interface{}{[]interface{}{10.0, float64(t.Unix() - 90)}}))
+		})
+
+		It("should render every target, posted as a form", func() {
+			series := rendered(serve("POST", "/render", url.Values{"target": {"db.reads", "web.*.requests"}, "from": {"-5min"}, "until": {"now"}}))
+			Expect(series).To(HaveLen(4))
+			Expect(series[0]["target"]).To(Equal("db.reads"))
+			Expect(series[1]["target"]).To(Equal("web.host1.requests"))
+			Expect(series[3]["target"]).To(Equal("web.host2.requests;dc=east"))
+		})
+
+		It("should roll a series up into at most maxDataPoints steps", func() {
+			series := rendered(serve("GET", "/render", url.Values{"target": {"web.host1.requests"}, "from": {"-4min"}, "maxDataPoints": {"4"}}))
+			Expect(series[0]["datapoints"]).To(Equal([]interface{}{
+				[]interface{}{2.0, float64(t.Unix() - 120)},
+				[]interface{}{8.0, float64(t.Unix() - 60)},
+			}))
+		})
+
+		It("should average the results of a query into at most maxDataPoints steps", func() {
+			series := rendered(serve("GET", "/render", url.Values{"target": {"alias(web.host1.requests, 'requests')"}, "from": {"-4min"}, "maxDataPoints": {"2"}}))
+			Expect(series[0]["target"]).To(Equal("requests"))
+			Expect(series[0]["datapoints"]).To(Equal([]interface{}{[]interface{}{4.0, float64(t.Unix() - 120)}}))
+		})
+
+		It("should render a value that isn't a number as null", func() {
+			data, err := json.Marshal([]datapoint{{Value: math.NaN(), Ts: 5}, {Value: 1.5, Ts: 6}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("[[null,5],[1.5,6]]"))
+		})
+
+		It("should reject an invalid target, time or format", func() {
+			Expect(serve("GET", "/render", url.Values{"target": {"nope(db.reads)"}}).Code).To(Equal(http.StatusBadRequest))
+			Expect(serve("GET", "/render", url.Values{"target": {"db.reads"}, "from": {"yesterday"}}).Code).To(Equal(http.StatusBadRequest))
+			Expect(serve("GET", "/render", url.Values{"target": {"db.reads"}, "from": {"now"}, "until": {"-1h"}}).Code).To(Equal(http.StatusBadRequest))
+			Expect(serve("GET", "/render", url.Values{"target": {"db.reads"}, "format": {"png"}}).Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should answer 500 when the repo fails", func() {
+			failed := errors.New("unavailable")
+			getPage = func(name string, start, end time.Time, size int, cursor []byte) ([]stat.Stat, []byte, error) {
+				return nil, nil, failed
+			}
+			scanRawStats = func(name string, start, end time.Time, f func(stat.Stat) error) error {
+				return failed
+			}
+			Expect(serve("GET", "/render", url.Values{"target": {"scale(db.reads, 2)"}}).Code).To(Equal(http.StatusInternalServerError))
+			Expect(serve("GET", "/render", url.Values{"target": {"db.reads"}, "maxDataPoints": {"4"}}).Code).To(Equal(http.StatusInternalServerError))
+
+			getNames = func() ([]string, error) { return nil, failed }
+			Expect(serve("GET", "/render", url.Values{"target": {"web.*.requests"}, "maxDataPoints": {"4"}}).Code).To(Equal(http.StatusInternalServerError))
+		})
+	})
+
+	Describe("/metrics/find", func() {
+		find := func(q string) []metricNode {
+			w := serve("GET", "/metrics/find", url.Values{"query": {q}})
+			Expect(w.Code).To(Equal(http.StatusOK))
+			var nodes []metricNode
+			Expect(json.Unmarshal(w.Body.Bytes(), &nodes)).To(Succeed())
+			return nodes
+		}
+
+		It("should list the top of the tree", func() {
+			Expect(find("*")).To(Equal([]metricNode{
+				{Text: "db", ID: "db", Expandable: 1, AllowChildren: 1},
+				{Text: "web", ID: "web", Expandable: 1, AllowChildren: 1},
+			}))
+		})
+
+		It("should list the nodes matching a pattern segment for segment", func() {
+			Expect(find("web.host*")).To(Equal([]metricNode{
+				{Text: "host1", ID: "web.host1", Expandable: 1, AllowChildren: 1},
+				{Text: "host2", ID: "web.host2", Expandable: 1, AllowChildren: 1},
+			}))
+		})
+
+		It("should list series as leaves, with their tags", func() {
+			Expect(find("web.host2.*")).To(Equal([]metricNode{
+				{Text: "requests", ID: "web.host2.requests", Leaf: 1},
+				{Text: "requests;dc=east", ID: "web.host2.requests;dc=east", Leaf: 1},
+			}))
+		})
+
+		It("should mark a series with series below it as both", func() {
+			stats["db.reads.p95"] = nil
+			Expect(find("db.*")).To(Equal([]metricNode{
+				{Text: "reads", ID: "db.reads", Leaf: 1, Expandable: 1, AllowChildren: 1},
+			}))
+		})
+	})
+})
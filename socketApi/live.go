@@ -0,0 +1,130 @@
+package socketApi
+
+import (
+	"encoding/json"
+	"github.com/CapillarySoftware/gostat/metrics"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"github.com/googollee/go-socket.io"
+	"sync"
+)
+
+var subscriptions = metrics.NewGauge("gostat.api.subscriptions", "Series subscribed to by socket.io connections")
+
+// subscribeRequest subscribes a socket to the live stats of series, which are
+// sent as liveStats events with timestamps in the precision asked for.
+// unsubscribeReq takes the same request, and without names unsubscribes from
+// every series
+type subscribeRequest struct {
+	Names     []string `json:"names"`
+	Precision string   `json:"precision,omitempty"`
+}
+
+// liveStats carries stats of a series as they are stored
+type liveStats struct {
+	Name  string    `json:"name"`
+	Stats []rawStat `json:"stats"`
+}
+
+// subscriber is a socket subscribed to a series
+type subscriber struct {
+	so socketio.Socket
+	p  precision
+}
+
+// hub holds the subscribers of each series, by socket id
+type hub struct {
+	mu     sync.Mutex
+	series map[string]map[string]subscriber
+}
+
+var live = newHub()
+
+func newHub() *hub {
+	return &hub{series: make(map[string]map[string]subscriber)}
+}
+
+// Publish sends a stat to the sockets subscribed to its series. It is called
+// with each stat once it is stored, so that a client sees it as soon as it can
+// query it
+func Publish(s *stat.Stat) {
+	live.publish(s)
+}
+
+func (h *hub) publish(s *stat.Stat) {
+	h.mu.Lock()
+	subscribers := make([]subscriber, 0, len(h.series[s.Name]))
+	for _, sub := range h.series[s.Name] {
+		subscribers = append(subscribers, sub)
+	}
+	h.mu.Unlock()
+
+	for _, sub := range subscribers {
+		data, _ := json.Marshal(liveStats{Name: s.Name, Stats: []rawStat{{Ts: sub.p.format(s.Timestamp), Value: s.Value}}})
+		sub.so.Emit("liveStats", string(data))
+	}
+}
+
+func (h *hub) subscribe(so socketio.Socket, names []string, p precision) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, name := range names {
+		if h.series[name] == nil {
+			h.series[name] = make(map[string]subscriber)
+		}
+		if _, ok := h.series[name][so.Id()]; !ok {
+			subscriptions.Add(1)
+		}
+		h.series[name][so.Id()] = subscriber{so, p}
+	}
+}
+
+// unsubscribe unsubscribes a socket from the series named, or from every series
+// if names is empty
+func (h *hub) unsubscribe(so socketio.Socket, names []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(names) == 0 {
+		for name := range h.series {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		if _, ok := h.series[name][so.Id()]; ok {
+			subscriptions.Add(-1)
+			delete(h.series[name], so.Id())
+		}
+		if len(h.series[name]) == 0 {
+			delete(h.series, name)
+		}
+	}
+}
+
+func handleSubscribeReq(msg string, so socketio.Socket) {
+	log.Debug("subscribeReq: ", msg)
+	queries("subscribeReq").Inc()
+
+	var request subscribeRequest
+	if err := json.Unmarshal([]byte(msg), &request); err != nil {
+		log.Error("error parsing subscribe request (", msg, "): ", err)
+		return
+	}
+	p, err := parsePrecision(request.Precision)
+	if err != nil {
+		log.Error("error parsing subscribe request (", msg, "): ", err)
+		return
+	}
+	live.subscribe(so, request.Names, p)
+}
+
+func handleUnsubscribeReq(msg string, so socketio.Socket) {
+	log.Debug("unsubscribeReq: ", msg)
+	queries("unsubscribeReq").Inc()
+
+	var request subscribeRequest
+	if err := json.Unmarshal([]byte(msg), &request); err != nil {
+		log.Error("error parsing unsubscribe request (", msg, "): ", err)
+		return
+	}
+	live.unsubscribe(so, request.Names)
+}
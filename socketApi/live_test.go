@@ -0,0 +1,73 @@
+package socketApi
+
+import (
+	"github.com/CapillarySoftware/gostat/stat"
+	"github.com/googollee/go-socket.io"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+// fakeSocket records the events emitted to it
+type fakeSocket struct {
+	socketio.Socket
+	id      string
+	emitted []string
+}
+
+func (f *fakeSocket) Id() string {
+	return f.id
+}
+
+func (f *fakeSocket) Emit(message string, args ...interface{}) error {
+	f.emitted = append(f.emitted, message+" "+args[0].(string))
+	return nil
+}
+
+var _ = Describe("live stats", func() {
+	var (
+		a, b *fakeSocket
+		t    time.Time
+	)
+
+	BeforeEach(func() {
+		live = newHub()
+		a, b = &fakeSocket{id: "a"}, &fakeSocket{id: "b"}
+		t = time.Unix(1412134560, 0)
+	})
+
+	It("should send a stat to the sockets subscribed to its series, in their precision", func() {
+		handleSubscribeReq(`{"names": ["foo", "bar"]}`, a)
+		handleSubscribeReq(`{"names": ["foo"], "precision": "ms"}`, b)
+
+		Publish(&stat.Stat{Name: "foo", Timestamp: t, Value: 1})
+		Publish(&stat.Stat{Name: "bar", Timestamp: t, Value: 2})
+		Publish(&stat.Stat{Name: "baz", Timestamp: t, Value: 3})
+
+		Expect(a.emitted).To(Equal([]string{
+			`liveStats {"name":"foo","stats":[{"ts":1412134560,"value":1}]}`,
+			`liveStats {"name":"bar","stats":[{"ts":1412134560,"value":2}]}`,
+		}))
+		Expect(b.emitted).To(Equal([]string{`liveStats {"name":"foo","stats":[{"ts":1412134560000,"value":1}]}`}))
+	})
+
+	It("should stop sending the series unsubscribed from", func() {
+		handleSubscribeReq(`{"names": ["foo", "bar"]}`, a)
+		handleUnsubscribeReq(`{"names": ["foo"]}`, a)
+		Publish(&stat.Stat{Name: "foo", Timestamp: t, Value: 1})
+		Publish(&stat.Stat{Name: "bar", Timestamp: t, Value: 2})
+		Expect(a.emitted).To(HaveLen(1))
+
+		handleUnsubscribeReq(`{}`, a)
+		Publish(&stat.Stat{Name: "bar", Timestamp: t, Value: 2})
+		Expect(a.emitted).To(HaveLen(1))
+		Expect(live.series).To(BeEmpty())
+	})
+
+	It("should ignore a bad request", func() {
+		handleSubscribeReq(`{"names": ["foo"], "precision": "fortnights"}`, a)
+		handleSubscribeReq(`not json`, a)
+		Publish(&stat.Stat{Name: "foo", Timestamp: t, Value: 1})
+		Expect(a.emitted).To(BeEmpty())
+	})
+})
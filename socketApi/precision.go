@@ -0,0 +1,123 @@
+package socketApi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// precision is the form of the timestamps of a request and its response: a
+// number of seconds, milliseconds, microseconds or nanoseconds since the UNIX
+// epoch, or an RFC 3339 string
+type precision string
+
+const (
+	seconds      precision = "s"
+	milliseconds precision = "ms"
+	microseconds precision = "us"
+	nanoseconds  precision = "ns"
+	rfc3339      precision = "rfc3339"
+)
+
+// parsePrecision parses the precision field of a request, which defaults to
+// seconds
+func parsePrecision(s string) (precision, error) {
+	switch p := precision(s); p {
+	case "":
+		return seconds, nil
+	case seconds, milliseconds, microseconds, nanoseconds, rfc3339:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown precision %q, use s, ms, us, ns or rfc3339", s)
+}
+
+// unit is the duration of one unit of an epoch timestamp, or the resolution of
+// an RFC 3339 timestamp
+func (p precision) unit() time.Duration {
+	switch p {
+	case milliseconds:
+		return time.Millisecond
+	case microseconds:
+		return time.Microsecond
+	case nanoseconds, rfc3339:
+		return time.Nanosecond
+	}
+	return time.Second
+}
+
+// parse parses a timestamp. An RFC 3339 string is accepted whatever the
+// precision
+func (p precision) parse(s string) (time.Time, error) {
+	if p != rfc3339 {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			perSecond := int64(time.Second / p.unit())
+			return time.Unix(n/perSecond, n%perSecond*int64(p.unit())), nil
+		}
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q for precision %s", s, p)
+	}
+	return t, nil
+}
+
+// parseJSON parses a timestamp of a JSON request, a number or a string. A
+// missing timestamp is the UNIX epoch
+func (p precision) parseJSON(raw json.RawMessage) (time.Time, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return time.Unix(0, 0), nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		s = string(raw)
+	}
+	return p.parse(s)
+}
+
+// format returns a timestamp of a response, a number or an RFC 3339 string
+func (p precision) format(t time.Time) interface{} {
+	switch p {
+	case rfc3339:
+		return t.UTC().Format(time.RFC3339Nano)
+	case seconds:
+		return t.Unix()
+	}
+	return t.UnixNano() / int64(p.unit())
+}
+
+// timeRange is the time range of a socket.io request, in the precision it asks
+// for, which is also that of the timestamps of the response
+type timeRange struct {
+	StartDate json.RawMessage `json:"startDate"`
+	EndDate   json.RawMessage `json:"endDate"`
+	Precision string          `json:"precision,omitempty"`
+}
+
+func (r *timeRange) parse() (p precision, start, end time.Time, err error) {
+	if p, err = parsePrecision(r.Precision); err != nil {
+		return
+	}
+	if start, err = p.parseJSON(r.StartDate); err != nil {
+		return
+	}
+	end, err = p.parseJSON(r.EndDate)
+	return
+}
+
+// parseHTTPRange parses the precision, startDate and endDate parameters of an
+// HTTP request
+func parseHTTPRange(r *http.Request) (p precision, start, end time.Time, err error) {
+	if p, err = parsePrecision(r.FormValue("precision")); err != nil {
+		return
+	}
+	if start, err = p.parse(r.FormValue("startDate")); err != nil {
+		err = fmt.Errorf("invalid startDate: %v", err)
+		return
+	}
+	if end, err = p.parse(r.FormValue("endDate")); err != nil {
+		err = fmt.Errorf("invalid endDate: %v", err)
+	}
+	return
+}
@@ -0,0 +1,55 @@
+package socketApi
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("precision", func() {
+	t := time.Date(2015, 1, 2, 3, 4, 5, 123456789, time.UTC)
+
+	It("should default to seconds", func() {
+		Expect(parsePrecision("")).To(Equal(seconds))
+		_, err := parsePrecision("minutes")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should format timestamps in the precision", func() {
+		Expect(seconds.format(t)).To(Equal(int64(1420167845)))
+		Expect(milliseconds.format(t)).To(Equal(int64(1420167845123)))
+		Expect(microseconds.format(t)).To(Equal(int64(1420167845123456)))
+		Expect(nanoseconds.format(t)).To(Equal(int64(1420167845123456789)))
+		Expect(rfc3339.format(t)).To(Equal("2015-01-02T03:04:05.123456789Z"))
+	})
+
+	It("should parse the timestamps it formats", func() {
+		for _, p := range []precision{seconds, milliseconds, microseconds, nanoseconds, rfc3339} {
+			data, _ := json.Marshal(p.format(t))
+			parsed, err := p.parseJSON(data)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed.Equal(t.Truncate(p.unit()))).To(BeTrue(), "%s: %s", p, parsed)
+		}
+	})
+
+	It("should parse a negative epoch timestamp", func() {
+		Expect(milliseconds.parse("-1500")).To(BeTemporally("==", time.Unix(-2, 500000000)))
+	})
+
+	It("should accept an RFC 3339 timestamp in any precision", func() {
+		Expect(milliseconds.parse("2015-01-02T03:04:05.123456789Z")).To(BeTemporally("==", t))
+		_, err := rfc3339.parse("1420167845")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should parse the time range of a request", func() {
+		var r rawStatsRequest
+		Expect(json.Unmarshal([]byte(`{"name": "foo", "startDate": 1420167845123, "endDate": "2015-01-02T03:05:00Z", "precision": "ms"}`), &r)).To(Succeed())
+		p, start, end, err := r.parse()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).To(Equal(milliseconds))
+		Expect(start).To(BeTemporally("==", t.Truncate(time.Millisecond)))
+		Expect(end).To(BeTemporally("==", time.Date(2015, 1, 2, 3, 5, 0, 0, time.UTC)))
+	})
+})
@@ -0,0 +1,161 @@
+package socketApi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/query"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"github.com/googollee/go-socket.io"
+	"net/http"
+	"time"
+)
+
+type series struct {
+	Name  string    `json:"name"`
+	Stats []rawStat `json:"stats"`
+}
+
+type queryRequest struct {
+	Tracker string `json:"tracker"`
+	Query   string `json:"query"`
+	timeRange
+}
+
+// queryError answers a request that failed, as queryErr, or lastNRawStatsErr for
+// a lastNRawStatsReq
+type queryError struct {
+	Tracker string `json:"tracker"`
+	Error   string `json:"error"`
+}
+
+// repoError is a failure of the repo to provide the stats a request needs,
+// rather than a fault of the request
+type repoError struct {
+	err error
+}
+
+func (e repoError) Error() string { return e.err.Error() }
+
+func (e repoError) Unwrap() error { return e.err }
+
+// errorStatus returns the HTTP status answering a request that failed with
+// err: 500 for a repo error, and 400 for any other
+func errorStatus(err error) int {
+	var r repoError
+	if errors.As(err, &r) {
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// repoFetcher evaluates queries against the stats in the repo, failing a query
+// that fetches more than MaxPoints stats in all
+type repoFetcher struct {
+	fetched int
+}
+
+func (f *repoFetcher) Names() ([]string, error) {
+	names, err := getNames()
+	if err != nil {
+		return nil, repoError{err}
+	}
+	return names, nil
+}
+
+func (f *repoFetcher) Fetch(name string, start, end time.Time) ([]stat.Stat, error) {
+	var stats []stat.Stat
+	// fetch one more than allowed, to tell whether there are too many
+	_, _, err := streamRawStats(name, start, end, nil, MaxPoints-f.fetched+1, func(chunk []stat.Stat) error {
+		stats = append(stats, chunk...)
+		return nil
+	})
+	if err != nil {
+		return nil, repoError{err}
+	}
+	if f.fetched += len(stats); f.fetched > MaxPoints {
+		return nil, fmt.Errorf("query: more than %d points in the range, narrow it", MaxPoints)
+	}
+	return stats, nil
+}
+
+func handleQueryReq(msg string, so socketio.Socket) {
+	log.Debug("queryReq: ", msg)
+	queries("queryReq").Inc()
+	so.Emit("echo", msg)
+
+	var request queryRequest
+	if err := json.Unmarshal([]byte(msg), &request); err != nil {
+		log.Error("error parsing query request (", msg, "): ", err)
+		emitError(so, "queryErr", request.Tracker, err)
+		return
+	}
+
+	p, start, end, err := request.parse()
+	if err != nil {
+		log.Error("error parsing query request (", msg, "): ", err)
+		emitError(so, "queryErr", request.Tracker, err)
+		return
+	}
+
+	results, err := runQuery(request.Query, start, end)
+	if err != nil {
+		log.Error("error running query request (", msg, "): ", err)
+		emitError(so, "queryErr", request.Tracker, err)
+		return
+	}
+
+	so.Emit("queryRes", seriesToJson(results, p))
+}
+
+// emitError answers a request that failed with a queryError as event
+func emitError(so socketio.Socket, event, tracker string, err error) {
+	data, _ := json.Marshal(queryError{Tracker: tracker, Error: err.Error()})
+	so.Emit(event, string(data))
+}
+
+// queryHandler serves GET /query?query=...&startDate=...&endDate=..., where the
+// dates are UNIX epoch seconds, or in the precision given by the precision
+// parameter
+func queryHandler(w http.ResponseWriter, r *http.Request) {
+	queries("query").Inc()
+	p, start, end, err := parseHTTPRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, err := runQuery(r.FormValue("query"), start, end)
+	if err != nil {
+		if errorStatus(err) == http.StatusInternalServerError {
+			log.Error("repo error running query for /query: ", err)
+		}
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(seriesToJson(results, p)))
+}
+
+func runQuery(q string, start, end time.Time) ([]*query.Series, error) {
+	log.Debugf("running query %q (start date: %s, end date: %s)", q, start, end)
+	return query.Run(&repoFetcher{}, q, start, end)
+}
+
+func seriesToJson(results []*query.Series, p precision) string {
+	converted := make([]series, 0)
+
+	for _, s := range results {
+		stats := make([]rawStat, 0)
+		for _, stat := range s.Stats {
+			stats = append(stats, rawStat{Ts: p.format(stat.Timestamp), Value: stat.Value})
+		}
+		converted = append(converted, series{Name: s.Name, Stats: stats})
+	}
+
+	convertedJson, _ := json.Marshal(converted)
+
+	return string(convertedJson)
+}
@@ -0,0 +1,223 @@
+package socketApi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/query"
+	"github.com/CapillarySoftware/gostat/repo"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// The repo functions scanning raw stats and aggregates and listing series,
+// replaced in tests
+var (
+	scanRawStats   = repo.ScanRawStats
+	scanAggregates = repo.ScanAggregates
+	getNames       = repo.GetStatNames
+)
+
+// resolution is one the aggregates of the series are stored at
+type resolution struct {
+	name   string
+	length time.Duration
+}
+
+// resolutions are those the AggregateRepo and the importer store aggregates at,
+// coarsest first
+var resolutions = []resolution{{"1d", 24 * time.Hour}, {"1h", time.Hour}, {"1m", time.Minute}}
+
+// rolledUp is the aggregate of the raw stats of one step of a rollup
+type rolledUp struct {
+	Ts      interface{} `json:"ts"` // the start of the step
+	Average float64     `json:"average"`
+	Min     float64     `json:"min"`
+	Max     float64     `json:"max"`
+	Count   int         `json:"count"`
+}
+
+// checkRollup returns an error if a rollup from start up to end in steps of
+// step is not allowed
+func checkRollup(start, end time.Time, step time.Duration) error {
+	if step <= 0 {
+		return fmt.Errorf("step must be positive")
+	}
+	if steps := end.Sub(start) / step; steps > time.Duration(MaxPoints) {
+		return fmt.Errorf("the range spans more than %d steps of %v, widen the step", MaxPoints, step)
+	}
+	return nil
+}
+
+// rollup aggregates the stats of name from start up to end in steps of step,
+// which start at multiples of step since the UNIX epoch. Steps without stats
+// are left out. Each step is rolled up from the stored aggregates of the
+// coarsest resolution that divides step, where they cover it, and otherwise
+// from finer ones or the raw stats, which are scanned rather than fetched, so
+// the range may hold any number of them. It may span at most MaxPoints steps
+func rollup(name string, start, end time.Time, step time.Duration) ([]aggregator.BucketAggregate, error) {
+	if err := checkRollup(start, end, step); err != nil {
+		return nil, err
+	}
+
+	var usable []resolution
+	for _, r := range resolutions {
+		if step%r.length == 0 {
+			usable = append(usable, r)
+		}
+	}
+
+	steps := make(map[int64]*aggregator.BucketAggregate)
+	add := func(t time.Time, a aggregator.StatsAggregate) {
+		t = floor(t, step)
+		if b, ok := steps[t.UnixNano()]; ok {
+			b.StatsAggregate = aggregator.AppendStatsAggregate(b.StatsAggregate, a)
+			return
+		}
+		steps[t.UnixNano()] = &aggregator.BucketAggregate{Name: name, Time: t, StatsAggregate: a}
+	}
+	if err := scanRange(name, start, end, usable, add); err != nil {
+		return nil, err
+	}
+
+	aggregates := make([]aggregator.BucketAggregate, 0, len(steps))
+	for _, b := range steps {
+		aggregates = append(aggregates, *b)
+	}
+	sort.Slice(aggregates, func(i, j int) bool { return aggregates[i].Time.Before(aggregates[j].Time) })
+	return aggregates, nil
+}
+
+// span is a range of time from start up to end
+type span struct {
+	start, end time.Time
+}
+
+// scanRange calls add with the aggregates of name from start up to end. The
+// periods of the first of resolutions that lie within the range and have ended
+// are read from their stored aggregates, and the rest of the range, including
+// the periods without one, from the finer resolutions, or the raw stats once
+// there are none
+func scanRange(name string, start, end time.Time, resolutions []resolution, add func(time.Time, aggregator.StatsAggregate)) error {
+	if !start.Before(end) {
+		return nil
+	}
+	if len(resolutions) == 0 {
+		return scanRawStats(name, start, end, func(s stat.Stat) error {
+			add(s.Timestamp, aggregator.StatsAggregate{Average: s.Value, Min: s.Value, Max: s.Value, Count: 1})
+			return nil
+		})
+	}
+
+	r, finer := resolutions[0], resolutions[1:]
+	first, last := ceil(start, r.length), floor(end, r.length)
+	if ended := floor(now(), r.length); ended.Before(last) {
+		last = ended
+	}
+	if !first.Before(last) {
+		return scanRange(name, start, end, finer, add)
+	}
+
+	gaps := []span{{start, first}}
+	covered := first
+	err := scanAggregates(name, r.name, first, last, func(a aggregator.BucketAggregate) error {
+		if a.Time.After(covered) {
+			gaps = append(gaps, span{covered, a.Time})
+		}
+		add(a.Time, a.StatsAggregate)
+		covered = a.Time.Add(r.length)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	gaps = append(gaps, span{covered, end})
+
+	for _, g := range gaps {
+		if err := scanRange(name, g.start, g.end, finer, add); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// floor returns the start of the step t falls in
+func floor(t time.Time, step time.Duration) time.Time {
+	n := t.UnixNano()
+	r := n % int64(step)
+	if r < 0 {
+		r += int64(step)
+	}
+	return time.Unix(0, n-r).UTC()
+}
+
+// ceil returns the start of the first step starting at or after t
+func ceil(t time.Time, step time.Duration) time.Time {
+	if f := floor(t, step); f.Before(t) {
+		return f.Add(step)
+	}
+	return floor(t, step)
+}
+
+// aggregatesHandler serves GET /aggregates?name=...&step=...&startDate=...&endDate=...,
+// the stats of a series aggregated in steps of step, e.g. 1m, to chart
+// ranges too wide for their raw stats
+func aggregatesHandler(w http.ResponseWriter, r *http.Request) {
+	queries("aggregates").Inc()
+	p, start, end, err := parseHTTPRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	step, err := time.ParseDuration(r.FormValue("step"))
+	if err != nil {
+		http.Error(w, "invalid step", http.StatusBadRequest)
+		return
+	}
+
+	if err := checkRollup(start, end, step); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	aggregates, err := rollup(r.FormValue("name"), start, end, step)
+	if err != nil {
+		log.Error("repo error rolling up stats for /aggregates: ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	converted := make([]rolledUp, 0, len(aggregates))
+	for _, a := range aggregates {
+		converted = append(converted, rolledUp{Ts: p.format(a.Time), Average: a.Average, Min: a.Min, Max: a.Max, Count: a.Count})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(converted)
+}
+
+// seriesHandler serves GET /series?match=..., the sorted names of the series
+// matching the glob pattern match, or of every series if it is empty
+func seriesHandler(w http.ResponseWriter, r *http.Request) {
+	queries("series").Inc()
+	names, err := getNames()
+	if err != nil {
+		log.Error("repo error listing series for /series: ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pattern := r.FormValue("match")
+	matched := make([]string, 0, len(names))
+	for _, name := range names {
+		if pattern == "" || query.MatchName(pattern, name) {
+			matched = append(matched, name)
+		}
+	}
+	sort.Strings(matched)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matched)
+}
@@ -0,0 +1,152 @@
+package socketApi
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/CapillarySoftware/gostat/aggregator"
+	"github.com/CapillarySoftware/gostat/repo"
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var _ = Describe("Rollups", func() {
+	var (
+		stats   []stat.Stat
+		stored  map[string][]aggregator.BucketAggregate // by resolution
+		scanned [][2]time.Time                          // the ranges of raw stats scanned
+	)
+
+	BeforeEach(func() {
+		stats, stored, scanned = nil, make(map[string][]aggregator.BucketAggregate), nil
+		for i, v := range []float64{1, 3, 2, 10, 20} {
+			stats = append(stats, stat.Stat{Name: "foo", Timestamp: time.Unix(int64(i*30+15), 0), Value: v})
+		}
+		scanRawStats = func(name string, start, end time.Time, f func(stat.Stat) error) error {
+			scanned = append(scanned, [2]time.Time{start, end})
+			for _, s := range stats {
+				if s.Timestamp.Before(start) || !s.Timestamp.Before(end) {
+					continue
+				}
+				if err := f(s); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		scanAggregates = func(name, resolution string, start, end time.Time, f func(aggregator.BucketAggregate) error) error {
+			for _, a := range stored[resolution] {
+				if a.Time.Before(start) || !a.Time.Before(end) {
+					continue
+				}
+				if err := f(a); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		getNames = func() ([]string, error) {
+			return []string{"web.host2.requests", "db.reads", "web.host1.requests"}, nil
+		}
+	})
+
+	AfterEach(func() {
+		scanRawStats, scanAggregates, getNames = repo.ScanRawStats, repo.ScanAggregates, repo.GetStatNames
+		now = time.Now
+	})
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", url, nil)
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/aggregates":
+				aggregatesHandler(w, r)
+			case "/series":
+				seriesHandler(w, r)
+			}
+		}).ServeHTTP(w, r)
+		return w
+	}
+
+	It("should aggregate raw stats in steps since the epoch", func() {
+		aggregates, err := rollup("foo", time.Unix(0, 0), time.Unix(150, 0), time.Minute)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(aggregates).To(HaveLen(3))
+		Expect(aggregates[0].Time).To(Equal(time.Unix(0, 0).UTC()))
+		Expect(aggregates[0].StatsAggregate.Count).To(Equal(2))
+		Expect(aggregates[0].Average).To(Equal(2.0))
+		Expect(aggregates[1].Min).To(Equal(2.0))
+		Expect(aggregates[1].Max).To(Equal(10.0))
+		Expect(aggregates[2].Time).To(Equal(time.Unix(120, 0).UTC()))
+	})
+
+	It("should roll up the stored aggregates, and read the periods without one from finer ones or the raw stats", func() {
+		at := func(d time.Duration) time.Time { return time.Unix(0, 0).Add(d).UTC() }
+		one := func(d time.Duration, v float64) aggregator.BucketAggregate {
+			return aggregator.BucketAggregate{Name: "foo", Time: at(d), StatsAggregate: aggregator.StatsAggregate{Average: v, Min: v, Max: v, Count: 1}}
+		}
+		stored["1h"] = []aggregator.BucketAggregate{one(0, 1), one(2*time.Hour, 3), one(3*time.Hour, 4)}
+		stored["1m"] = []aggregator.BucketAggregate{one(time.Hour+5*time.Minute, 2)}
+		stats = []stat.Stat{
+			{Name: "foo", Timestamp: at(10 * time.Minute), Value: 100}, // in an hour stored
+			{Name: "foo", Timestamp: at(3*time.Hour + 10*time.Minute), Value: 5},
+			{Name: "foo", Timestamp: at(4*time.Hour + 10*time.Minute), Value: 6},
+		}
+		now = func() time.Time { return at(3*time.Hour + 30*time.Minute) }
+
+		aggregates, err := rollup("foo", at(0), at(5*time.Hour), time.Hour)
+		Expect(err).NotTo(HaveOccurred())
+		var averages []float64
+		for _, a := range aggregates {
+			averages = append(averages, a.Average)
+		}
+		Expect(averages).To(Equal([]float64{1, 2, 3, 5, 6}), "the current hour should be read from the raw stats")
+		Expect(aggregates[1].Time).To(Equal(at(time.Hour)))
+		for _, r := range scanned {
+			Expect(r[1].After(at(10*time.Minute))).To(BeTrue(), "an hour stored should not be scanned")
+		}
+	})
+
+	It("should round a time before the epoch down to its step", func() {
+		Expect(floor(time.Unix(-30, 0), time.Minute)).To(Equal(time.Unix(-60, 0).UTC()))
+		Expect(floor(time.Unix(-60, 0), time.Minute)).To(Equal(time.Unix(-60, 0).UTC()))
+	})
+
+	It("should reject a range of more than MaxPoints steps", func() {
+		_, err := rollup("foo", time.Unix(0, 0), time.Unix(int64(MaxPoints+1), 0), time.Second)
+		Expect(err).To(HaveOccurred())
+		_, err = rollup("foo", time.Unix(0, 0), time.Unix(60, 0), 0)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should serve the aggregates in the precision requested", func() {
+		w := get("/aggregates?name=foo&startDate=0&endDate=150000&precision=ms&step=1m")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var aggregates []rolledUp
+		Expect(json.Unmarshal(w.Body.Bytes(), &aggregates)).To(Succeed())
+		Expect(aggregates).To(HaveLen(3))
+		Expect(aggregates[1]).To(Equal(rolledUp{Ts: 60000.0, Average: 6, Min: 2, Max: 10, Count: 2}))
+
+		Expect(get("/aggregates?name=foo&startDate=0&endDate=150").Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should report a repo error as a server error", func() {
+		scanRawStats = func(name string, start, end time.Time, f func(stat.Stat) error) error {
+			return errors.New("unavailable")
+		}
+		Expect(get("/aggregates?name=foo&startDate=0&endDate=150&step=1m").Code).To(Equal(http.StatusInternalServerError))
+	})
+
+	It("should list the series matching a pattern", func() {
+		var names []string
+		Expect(json.Unmarshal(get("/series").Body.Bytes(), &names)).To(Succeed())
+		Expect(names).To(Equal([]string{"db.reads", "web.host1.requests", "web.host2.requests"}))
+
+		Expect(json.Unmarshal(get("/series?match=web.*.requests").Body.Bytes(), &names)).To(Succeed())
+		Expect(names).To(Equal([]string{"web.host1.requests", "web.host2.requests"}))
+	})
+})
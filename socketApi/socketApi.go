@@ -1,54 +1,96 @@
 package socketApi
 
 import (
+	"context"
 	"encoding/json"
-	"github.com/CapillarySoftware/gostat/repo"
+	"github.com/CapillarySoftware/gostat/asset"
+	"github.com/CapillarySoftware/gostat/dashboard"
+	"github.com/CapillarySoftware/gostat/export"
+	"github.com/CapillarySoftware/gostat/metrics"
 	"github.com/CapillarySoftware/gostat/stat"
 	log "github.com/cihub/seelog"
 	"github.com/googollee/go-socket.io"
 	"net/http"
-	"strings"
 	"time"
 )
 
+var connections = metrics.NewGauge("gostat.api.connections", "Open socket.io connections")
+
+// queries counts the requests of each type
+func queries(reqType string) *metrics.Counter {
+	return metrics.NewCounter("gostat.api.queries;type="+reqType, "Queries received by the API")
+}
+
 type rawStat struct {
-	// UNIX EPOCH Timestamp specifies the moment in time the statistic is applicable to
-	Ts int64 `json:"ts"`
+	// Ts specifies the moment in time the statistic is applicable to, in the
+	// precision of the request: a number since the UNIX epoch or an RFC 3339 string
+	Ts interface{} `json:"ts"`
 
 	// Value is the numeric representation of the statistic
 	Value float64 `json:"value"`
 }
 
 type rawStatsRequest struct {
-	Tracker   string `json:"tracker"`
-	Name      string `json:"name"`
-	StartDate int64  `json:"startDate"`
-	EndDate   int64  `json:"endDate"`
+	Tracker string `json:"tracker"`
+	Name    string `json:"name"`
+	timeRange
+	Limit  int    `json:"limit,omitempty"`  // the most stats returned, up to MaxPoints
+	Cursor string `json:"cursor,omitempty"` // from the rawStatsEnd of a capped response, to continue it
 }
 
 type lastNRawStatsRequest struct {
-	Tracker string `json:"tracker"`
-	Name    string `json:"name"`
-	Last    int    `json:"last"`
+	Tracker   string          `json:"tracker"`
+	Name      string          `json:"name"`
+	Last      int             `json:"last"`
+	Before    json.RawMessage `json:"before,omitempty"` // if set, only stats before it are returned, to scroll back
+	Precision string          `json:"precision,omitempty"`
 }
 
-func handleRawStatsReq(reqType, msg string, so socketio.Socket) {
-	log.Debug(reqType, ": ", msg)
+func handleLastNRawStatsReq(msg string, so socketio.Socket) {
+	log.Debug("lastNRawStatsReq: ", msg)
+	queries("lastNRawStatsReq").Inc()
 	so.Emit("echo", msg)
 
-	rawStats, err := runRawLogQuery(reqType, msg)
+	rawStats, p, err := runLastNRawStatsQuery(msg)
 	if err != nil {
-		log.Error("error running ", reqType, " query: ", err)
+		log.Error("error running lastNRawStatsReq query: ", err)
+		var request lastNRawStatsRequest
+		json.Unmarshal([]byte(msg), &request) // for the tracker, if the request has one
+		emitError(so, "lastNRawStatsErr", request.Tracker, err)
+		return
 	}
 
 	log.Debug(rawStats)
-	resType := strings.TrimSuffix(reqType, "Req") + "Res"
-	if so != nil {
-		so.Emit(resType, toJson(rawStats))
+	// send at least one, possibly empty, chunk
+	for start := 0; start == 0 || start < len(rawStats); start += ChunkSize {
+		end := start + ChunkSize
+		if end > len(rawStats) {
+			end = len(rawStats)
+		}
+		so.Emit("lastNRawStatsRes", toJson(rawStats[start:end], p))
 	}
 }
 
-func SocketApiServer() {
+// dashboards are where dashboardReq finds dashboards, replaced in tests
+var dashboards = dashboard.Repo
+
+func handleDashboardReq(msg string, so socketio.Socket) {
+	log.Debug("dashboardReq: ", msg)
+	queries("dashboardReq").Inc()
+
+	var request dashboard.Request
+	if err := json.Unmarshal([]byte(msg), &request); err != nil {
+		log.Error("error parsing dashboard request (", msg, "): ", err)
+		return
+	}
+	data, _ := json.Marshal(dashboard.Handle(dashboards, &request))
+	so.Emit("dashboardRes", string(data))
+}
+
+// SocketApiServer serves the socket.io and HTTP APIs, and the dashboard, on addr
+// until ctx is done. The dashboard is built into gostat, unless assetDir names
+// a directory of static files to serve instead
+func SocketApiServer(ctx context.Context, addr, assetDir string) {
 	server, err := socketio.NewServer(nil)
 	if err != nil {
 		log.Error(err)
@@ -56,14 +98,29 @@ func SocketApiServer() {
 
 	server.On("connection", func(so socketio.Socket) {
 		log.Debug("on connection (socketApi)")
+		connections.Add(1)
 		so.On("rawStatsReq", func(msg string) {
-			handleRawStatsReq("rawStatsReq", msg, so)
+			handleRawStatsReq(msg, so)
 		})
 		so.On("lastNRawStatsReq", func(msg string) {
-			handleRawStatsReq("lastNRawStatsReq", msg, so)
+			handleLastNRawStatsReq(msg, so)
+		})
+		so.On("queryReq", func(msg string) {
+			handleQueryReq(msg, so)
+		})
+		so.On("dashboardReq", func(msg string) {
+			handleDashboardReq(msg, so)
+		})
+		so.On("subscribeReq", func(msg string) {
+			handleSubscribeReq(msg, so)
+		})
+		so.On("unsubscribeReq", func(msg string) {
+			handleUnsubscribeReq(msg, so)
 		})
 		so.On("disconnection", func() {
 			log.Debug("on disconnect (rawStats)")
+			live.unsubscribe(so, nil)
+			connections.Add(-1)
 		})
 	})
 	server.On("error", func(so socketio.Socket, err error) {
@@ -71,37 +128,71 @@ func SocketApiServer() {
 	})
 
 	http.Handle("/socket.io/", server)
-	http.Handle("/", http.FileServer(http.Dir("./asset")))
-	log.Debug("socket.io API serving at localhost:5000...")
-	log.Error(http.ListenAndServe(":5000", nil))
+	http.HandleFunc("/query", queryHandler)
+	http.HandleFunc("/rawStats", rawStatsHandler)
+	http.HandleFunc("/aggregates", aggregatesHandler)
+	http.HandleFunc("/series", seriesHandler)
+	http.HandleFunc("/render", renderHandler)
+	http.HandleFunc("/metrics/find", findHandler)
+	http.Handle("/dashboards", dashboard.Handler(dashboard.Repo))
+	http.Handle("/dashboards/", dashboard.Handler(dashboard.Repo))
+	http.Handle("/export", export.Handler(export.Repo))
+	http.Handle("/metrics", metrics.Handler(metrics.DefaultRegistry))
+	http.Handle("/", assets(assetDir))
+	srv := &http.Server{Addr: addr}
+	go func() {
+		<-ctx.Done()
+		// give in-flight requests a moment, but never hold up the shutdown for long
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Debug("socket.io API serving at ", addr, "...")
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Error(err)
+	}
 }
 
-func runRawLogQuery(reqType, req string) (rawStats []stat.Stat, err error) {
-	switch reqType {
-	case "rawStatsReq":
-		request, err := unmarshalRawStatsReq(req)
-		if err != nil {
-			return nil, err
-		}
-		log.Debugf("parsed rawStatsReq request: %#v (start date: %s, end date: %s)", request, time.Unix(request.StartDate, 0), time.Unix(request.EndDate, 0))
-		if rawStats, err = repo.GetRawStats(request.Name, time.Unix(request.StartDate, 0), time.Unix(request.EndDate, 0)); err != nil {
-			log.Error("repo error retrieving raw stats for rawStatsReq request (", req, "): ", err)
-			return nil, err
-		}
-	case "lastNRawStatsReq":
-		request, err := unmarshalLastNRawStatsReq(req)
-		if err != nil {
-			return nil, err
-		}
+// assets serves the static files in dir, or the embedded dashboard if dir is
+// empty
+func assets(dir string) http.Handler {
+	if dir == "" {
+		return http.FileServer(http.FS(asset.Files))
+	}
+	return http.FileServer(http.Dir(dir))
+}
 
-		log.Debugf("parsed lastNRawStatsReq request: %#v", request)
-		if rawStats, err = repo.GetLastNRawStats(request.Name, request.Last); err != nil {
-			log.Error("repo error retrieving last n raw stats for lastNRawStatsReq request (", req, "): ", err)
-			return nil, err
-		}
+// runLastNRawStatsQuery returns the last stats requested, at most MaxPoints,
+// and the precision of their timestamps
+func runLastNRawStatsQuery(req string) (rawStats []stat.Stat, p precision, err error) {
+	request, err := unmarshalLastNRawStatsReq(req)
+	if err != nil {
+		return nil, seconds, err
+	}
+	if p, err = parsePrecision(request.Precision); err != nil {
+		return nil, seconds, err
 	}
 
-	return rawStats, nil
+	log.Debugf("parsed lastNRawStatsReq request: %#v", request)
+	last := request.Last
+	if last > MaxPoints {
+		last = MaxPoints
+	}
+	if len(request.Before) > 0 && string(request.Before) != "null" {
+		var before time.Time
+		if before, err = p.parseJSON(request.Before); err != nil {
+			return nil, p, err
+		}
+		rawStats, err = getLastNBefore(request.Name, before, last)
+	} else {
+		rawStats, err = getLastN(request.Name, last)
+	}
+	if err != nil {
+		log.Error("repo error retrieving last n raw stats for lastNRawStatsReq request (", req, "): ", err)
+		return nil, p, err
+	}
+	return rawStats, p, nil
 }
 
 func unmarshalRawStatsReq(req string) (request *rawStatsRequest, err error) {
@@ -120,11 +211,11 @@ func unmarshalLastNRawStatsReq(req string) (request *lastNRawStatsRequest, err e
 	return request, nil
 }
 
-func toJson(stats []stat.Stat) string {
+func toJson(stats []stat.Stat, p precision) string {
 	converted := make([]rawStat, 0)
 
 	for _, stat := range stats {
-		c := rawStat{Ts: stat.Timestamp.Unix(), Value: stat.Value}
+		c := rawStat{Ts: p.format(stat.Timestamp), Value: stat.Value}
 		converted = append(converted, c)
 	}
 
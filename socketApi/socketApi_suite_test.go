@@ -0,0 +1,13 @@
+package socketApi
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestSocketApi(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SocketApi Suite")
+}
@@ -0,0 +1,97 @@
+package socketApi
+
+import (
+	"errors"
+	"github.com/CapillarySoftware/gostat/repo"
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var _ = Describe("lastNRawStatsReq", func() {
+	var (
+		lastN  int
+		before *time.Time
+	)
+
+	BeforeEach(func() {
+		lastN, before = 0, nil
+		getLastN = func(name string, last int) ([]stat.Stat, error) {
+			lastN = last
+			return nil, nil
+		}
+		getLastNBefore = func(name string, t time.Time, last int) ([]stat.Stat, error) {
+			lastN, before = last, &t
+			return nil, nil
+		}
+	})
+
+	AfterEach(func() {
+		getLastN, getLastNBefore = repo.GetLastNRawStats, repo.GetLastNRawStatsBefore
+	})
+
+	It("should return the last stats", func() {
+		_, p, err := runLastNRawStatsQuery(`{"name": "foo", "last": 10}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).To(Equal(seconds))
+		Expect(lastN).To(Equal(10))
+		Expect(before).To(BeNil())
+	})
+
+	It("should scroll back from before a time in the precision requested", func() {
+		_, _, err := runLastNRawStatsQuery(`{"name": "foo", "last": 10, "before": 1420167845123, "precision": "ms"}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*before).To(BeTemporally("==", time.Date(2015, 1, 2, 3, 4, 5, 123000000, time.UTC)))
+	})
+
+	It("should answer a request the repo fails with a lastNRawStatsErr carrying the tracker", func() {
+		getLastN = func(name string, last int) ([]stat.Stat, error) {
+			return nil, errors.New("unavailable")
+		}
+		so := &fakeSocket{id: "a"}
+		handleLastNRawStatsReq(`{"tracker": "t1", "name": "foo", "last": 10}`, so)
+		Expect(so.emitted).To(Equal([]string{`echo {"tracker": "t1", "name": "foo", "last": 10}`,
+			`lastNRawStatsErr {"tracker":"t1","error":"unavailable"}`}))
+	})
+
+	It("should cap the stats at MaxPoints", func() {
+		_, _, err := runLastNRawStatsQuery(`{"name": "foo", "last": 1000000000}`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lastN).To(Equal(MaxPoints))
+	})
+})
+
+var _ = Describe("assets", func() {
+	It("should serve the dashboard built into gostat", func() {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/", nil)
+		assets("").ServeHTTP(w, r)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring("<title>gostat</title>"))
+
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest("GET", "/dashboard.js", nil)
+		assets("").ServeHTTP(w, r)
+		Expect(w.Code).To(Equal(http.StatusOK))
+	})
+})
+
+var _ = Describe("dashboardReq", func() {
+	It("should answer with a dashboardRes carrying the tracker", func() {
+		so := &fakeSocket{id: "a"}
+		handleDashboardReq(`{"tracker": "t1", "op": "rename"}`, so)
+		Expect(so.emitted).To(Equal([]string{`dashboardRes {"tracker":"t1","error":"dashboard: invalid dashboard: unknown op \"rename\""}`}))
+	})
+})
+
+var _ = Describe("queryReq", func() {
+	It("should answer a query that fails with a queryErr carrying the tracker", func() {
+		so := &fakeSocket{id: "a"}
+		handleQueryReq(`{"tracker": "t1", "query": "topK(foo, -1)", "startDate": 0, "endDate": 60}`, so)
+		Expect(so.emitted).To(HaveLen(2))
+		Expect(so.emitted[1]).To(HavePrefix(`queryErr {"tracker":"t1","error":"query: argument 2 of topK`))
+	})
+})
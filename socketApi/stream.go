@@ -0,0 +1,203 @@
+package socketApi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/repo"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"github.com/googollee/go-socket.io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// MaxPoints caps the raw stats returned by a request. A request for more
+	// gets the first MaxPoints and a cursor to request the rest with
+	MaxPoints = 100000
+
+	// ChunkSize is the number of raw stats fetched from Cassandra and sent to
+	// the client at a time
+	ChunkSize = 1000
+)
+
+// The repo functions fetching raw stats, replaced in tests
+var (
+	getPage        = repo.GetRawStatsPage
+	getLastN       = repo.GetLastNRawStats
+	getLastNBefore = repo.GetLastNRawStatsBefore
+)
+
+// rawStatsEnd follows the chunks of raw stats sent in response to a request,
+// with the cursor to request the stats after them, if they were capped, or the
+// error that ended the request early
+type rawStatsEnd struct {
+	Tracker string `json:"tracker"`
+	Count   int    `json:"count"`
+	Cursor  string `json:"cursor,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// limit returns the number of points a request asking for requested, or 0 for
+// no particular number, may have
+func limit(requested int) int {
+	if requested <= 0 || requested > MaxPoints {
+		return MaxPoints
+	}
+	return requested
+}
+
+func encodeCursor(cursor []byte) string {
+	return base64.RawURLEncoding.EncodeToString(cursor)
+}
+
+func decodeCursor(cursor string) ([]byte, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return b, nil
+}
+
+// streamRawStats fetches the raw stats of name between start and end, from the
+// cursor, a chunk at a time, passing each chunk to send until limit stats have
+// been sent. It returns how many were sent and the cursor of the stats after
+// them, which is nil if there are none
+func streamRawStats(name string, start, end time.Time, cursor []byte, limit int, send func([]stat.Stat) error) (count int, next []byte, err error) {
+	for {
+		size := ChunkSize
+		if limit-count < size {
+			size = limit - count
+		}
+
+		stats, next, err := getPage(name, start, end, size, cursor)
+		if err != nil {
+			return count, cursor, err
+		}
+		if len(stats) > 0 {
+			if err := send(stats); err != nil {
+				return count, cursor, err
+			}
+			count += len(stats)
+		}
+
+		if next == nil || count >= limit {
+			return count, next, nil
+		}
+		cursor = next
+	}
+}
+
+// handleRawStatsReq streams the raw stats requested as rawStatsRes events of
+// at most ChunkSize stats, followed by a rawStatsEnd event, which carries the
+// error if the request failed
+func handleRawStatsReq(msg string, so socketio.Socket) {
+	log.Debug("rawStatsReq: ", msg)
+	queries("rawStatsReq").Inc()
+	so.Emit("echo", msg)
+
+	end := func(e rawStatsEnd) {
+		done, _ := json.Marshal(e)
+		so.Emit("rawStatsEnd", string(done))
+	}
+
+	request, err := unmarshalRawStatsReq(msg)
+	if err != nil {
+		end(rawStatsEnd{Error: err.Error()})
+		return
+	}
+	p, start, stop, err := request.parse()
+	var cursor []byte
+	if err == nil {
+		cursor, err = decodeCursor(request.Cursor)
+	}
+	if err != nil {
+		log.Error("error parsing raw stats request (", msg, "): ", err)
+		end(rawStatsEnd{Tracker: request.Tracker, Error: err.Error()})
+		return
+	}
+
+	count, next, err := streamRawStats(request.Name, start, stop, cursor, limit(request.Limit),
+		func(stats []stat.Stat) error {
+			return so.Emit("rawStatsRes", toJson(stats, p))
+		})
+	if err != nil {
+		log.Error("repo error retrieving raw stats for rawStatsReq request (", msg, "): ", err)
+		// the cursor requests the stats from the chunk that failed
+		end(rawStatsEnd{Tracker: request.Tracker, Count: count, Cursor: encodeCursor(next), Error: err.Error()})
+		return
+	}
+
+	end(rawStatsEnd{Tracker: request.Tracker, Count: count, Cursor: encodeCursor(next)})
+}
+
+// rawStatsHandler serves GET /rawStats?name=...&startDate=...&endDate=..., where
+// the dates are UNIX epoch seconds, or in the precision given by the precision
+// parameter, and optionally limit and the cursor returned with a capped
+// response. The stats are streamed a chunk at a time as
+// {"stats": [...], "count": n, "cursor": "..."}
+func rawStatsHandler(w http.ResponseWriter, r *http.Request) {
+	queries("rawStats").Inc()
+	p, start, end, err := parseHTTPRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	requested := 0
+	if l := r.FormValue("limit"); l != "" {
+		if requested, err = strconv.Atoi(l); err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	cursor, err := decodeCursor(r.FormValue("cursor"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	flusher, _ := w.(http.Flusher)
+	sent := false
+	count, next, err := streamRawStats(r.FormValue("name"), start, end, cursor, limit(requested),
+		func(stats []stat.Stat) error {
+			for _, s := range stats {
+				prefix := ","
+				if !sent {
+					prefix, sent = `{"stats":[`, true
+				}
+				data, _ := json.Marshal(rawStat{Ts: p.format(s.Timestamp), Value: s.Value})
+				if _, err := w.Write(append([]byte(prefix), data...)); err != nil {
+					return err
+				}
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		})
+	if err != nil {
+		log.Error("repo error retrieving raw stats for /rawStats: ", err)
+		if !sent {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// the stats sent so far cannot be taken back, so break the connection
+		// rather than end the response as if it were whole
+		panic(http.ErrAbortHandler)
+	}
+
+	if !sent {
+		w.Write([]byte(`{"stats":[`))
+	}
+	fmt.Fprintf(w, `],"count":%d`, count)
+	if next != nil {
+		fmt.Fprintf(w, `,"cursor":%q`, encodeCursor(next))
+	}
+	w.Write([]byte("}\n"))
+}
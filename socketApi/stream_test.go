@@ -0,0 +1,202 @@
+package socketApi
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/CapillarySoftware/gostat/repo"
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+)
+
+// fakePages pages through stats, with cursors holding the index of the next
+// stat, and fails on the page at failAt if it is positive
+type fakePages struct {
+	stats  []stat.Stat
+	pages  int
+	failAt int
+}
+
+func (f *fakePages) getPage(name string, start, end time.Time, limit int, cursor []byte) ([]stat.Stat, []byte, error) {
+	f.pages++
+	if f.pages == f.failAt {
+		return nil, nil, errors.New("unavailable")
+	}
+
+	from := 0
+	if cursor != nil {
+		from, _ = strconv.Atoi(string(cursor))
+	}
+	to := from + limit
+	if to >= len(f.stats) {
+		return f.stats[from:], nil, nil
+	}
+	return f.stats[from:to], []byte(strconv.Itoa(to)), nil
+}
+
+var _ = Describe("Streaming", func() {
+	var (
+		pages                *fakePages
+		maxPoints, chunkSize int
+	)
+
+	BeforeEach(func() {
+		pages = &fakePages{}
+		for i := 0; i < 10; i++ {
+			pages.stats = append(pages.stats, stat.Stat{Name: "foo", Timestamp: time.Unix(int64(i), 0), Value: float64(i)})
+		}
+		getPage = pages.getPage
+		maxPoints, chunkSize = MaxPoints, ChunkSize
+		MaxPoints, ChunkSize = 6, 4
+	})
+
+	AfterEach(func() {
+		MaxPoints, ChunkSize = maxPoints, chunkSize
+		getPage = repo.GetRawStatsPage
+	})
+
+	stream := func(cursor []byte, limit int) (chunks [][]stat.Stat, next []byte, err error) {
+		_, next, err = streamRawStats("foo", time.Unix(0, 0), time.Unix(10, 0), cursor, limit, func(stats []stat.Stat) error {
+			chunks = append(chunks, stats)
+			return nil
+		})
+		return
+	}
+
+	Describe("streamRawStats", func() {
+		It("should send the stats a chunk at a time", func() {
+			chunks, next, err := stream(nil, 100)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(chunks).To(HaveLen(3))
+			Expect(chunks[0]).To(HaveLen(4))
+			Expect(chunks[2]).To(HaveLen(2))
+			Expect(next).To(BeNil())
+		})
+
+		It("should stop at the limit with a cursor to resume from", func() {
+			chunks, next, err := stream(nil, 6)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(chunks).To(HaveLen(2))
+			Expect(chunks[1]).To(HaveLen(2))
+			Expect(next).NotTo(BeNil())
+
+			chunks, next, err = stream(next, 6)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(chunks).To(HaveLen(1))
+			Expect(chunks[0][0].Value).To(Equal(6.0))
+			Expect(next).To(BeNil())
+		})
+	})
+
+	Describe("rawStatsReq", func() {
+		It("should end a request it cannot parse with the error", func() {
+			so := &fakeSocket{id: "a"}
+			handleRawStatsReq(`{"tracker": "t1", "name": "foo", "startDate": 0, "endDate": 10, "cursor": "!"}`, so)
+			Expect(so.emitted).To(HaveLen(2))
+			Expect(so.emitted[1]).To(Equal(`rawStatsEnd {"tracker":"t1","count":0,"error":"invalid cursor"}`))
+		})
+
+		It("should end a request the repo fails part way through with the error", func() {
+			pages.failAt = 2
+			so := &fakeSocket{id: "a"}
+			handleRawStatsReq(`{"tracker": "t1", "name": "foo", "startDate": 0, "endDate": 10}`, so)
+			Expect(so.emitted).To(HaveLen(3))
+			Expect(so.emitted[1]).To(HavePrefix("rawStatsRes "))
+			Expect(so.emitted[2]).To(MatchRegexp(`^rawStatsEnd {"tracker":"t1","count":4,"cursor":"[^"]+","error":"unavailable"}$`))
+		})
+	})
+
+	Describe("/rawStats", func() {
+		get := func(query string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			rawStatsHandler(w, httptest.NewRequest("GET", "/rawStats?"+query, nil))
+			return w
+		}
+
+		type response struct {
+			Stats  []rawStat `json:"stats"`
+			Count  int       `json:"count"`
+			Cursor string    `json:"cursor"`
+		}
+
+		It("should cap the stats returned at MaxPoints and continue from the cursor", func() {
+			w := get("name=foo&startDate=0&endDate=10")
+			Expect(w.Code).To(Equal(http.StatusOK))
+			var first response
+			Expect(json.Unmarshal(w.Body.Bytes(), &first)).To(Succeed())
+			Expect(first.Stats).To(HaveLen(6))
+			Expect(first.Count).To(Equal(6))
+			Expect(first.Cursor).NotTo(BeEmpty())
+
+			w = get("name=foo&startDate=0&endDate=10&cursor=" + first.Cursor)
+			var rest response
+			Expect(json.Unmarshal(w.Body.Bytes(), &rest)).To(Succeed())
+			Expect(rest.Stats).To(Equal([]rawStat{{6.0, 6.0}, {7.0, 7.0}, {8.0, 8.0}, {9.0, 9.0}}))
+			Expect(rest.Cursor).To(BeEmpty())
+		})
+
+		It("should honour a smaller limit", func() {
+			var r response
+			Expect(json.Unmarshal(get("name=foo&startDate=0&endDate=10&limit=3").Body.Bytes(), &r)).To(Succeed())
+			Expect(r.Stats).To(HaveLen(3))
+		})
+
+		It("should take and return timestamps in the precision requested", func() {
+			var r response
+			pages.stats[0].Timestamp = time.Unix(0, 1500*int64(time.Microsecond))
+			Expect(json.Unmarshal(get("name=foo&startDate=0&endDate=10000&precision=ms&limit=2").Body.Bytes(), &r)).To(Succeed())
+			Expect(r.Stats).To(Equal([]rawStat{{1.0, 0.0}, {1000.0, 1.0}}))
+
+			Expect(json.Unmarshal(get("name=foo&startDate=1970-01-01T00:00:00Z&endDate=1970-01-01T00:00:10Z&precision=rfc3339&limit=1").Body.Bytes(), &r)).To(Succeed())
+			Expect(r.Stats).To(Equal([]rawStat{{"1970-01-01T00:00:00.0015Z", 0.0}}))
+		})
+
+		It("should return an empty result", func() {
+			pages.stats = nil
+			Expect(get("name=foo&startDate=0&endDate=10").Body.String()).To(Equal(`{"stats":[],"count":0}` + "\n"))
+		})
+
+		It("should reject a bad request", func() {
+			Expect(get("name=foo&startDate=x&endDate=10").Code).To(Equal(http.StatusBadRequest))
+			Expect(get("name=foo&startDate=0&endDate=10&cursor=!").Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should report an error before anything is sent", func() {
+			pages.failAt = 1
+			Expect(get("name=foo&startDate=0&endDate=10").Code).To(Equal(http.StatusInternalServerError))
+		})
+	})
+
+	Describe("repoFetcher", func() {
+		It("should fail a query fetching more than MaxPoints in all", func() {
+			f := &repoFetcher{}
+			pages.stats = pages.stats[:4]
+			stats, err := f.Fetch("foo", time.Unix(0, 0), time.Unix(10, 0))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats).To(HaveLen(4))
+
+			_, err = f.Fetch("foo", time.Unix(0, 0), time.Unix(10, 0))
+			Expect(err).To(MatchError(ContainSubstring("more than 6 points")))
+		})
+	})
+
+	Describe("/query", func() {
+		get := func(query string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			queryHandler(w, httptest.NewRequest("GET", "/query?"+query, nil))
+			return w
+		}
+
+		It("should answer a query that cannot be evaluated with 400, and one the repo fails with 500", func() {
+			Expect(get("query=topK(foo,-1)&startDate=0&endDate=10").Code).To(Equal(http.StatusBadRequest))
+			Expect(get("query=foo(&startDate=0&endDate=10").Code).To(Equal(http.StatusBadRequest))
+
+			pages.failAt = 1
+			Expect(get("query=foo&startDate=0&endDate=10").Code).To(Equal(http.StatusInternalServerError))
+		})
+	})
+})
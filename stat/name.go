@@ -0,0 +1,37 @@
+package stat
+
+import (
+	"sort"
+	"strings"
+)
+
+// ParseName splits a stat name into its metric name and tags. Tags follow the
+// metric name, separated by semicolons, e.g. web.requests;host=web1;dc=east
+func ParseName(name string) (metric string, tags map[string]string) {
+	parts := strings.Split(name, ";")
+	metric = parts[0]
+	tags = make(map[string]string)
+
+	for _, tag := range parts[1:] {
+		if i := strings.IndexByte(tag, '='); i > 0 {
+			tags[tag[:i]] = tag[i+1:]
+		}
+	}
+	return
+}
+
+// FormatName joins a metric name and tags into a stat name, ordering the tags
+// by key so that equal sets of tags always produce the same name
+func FormatName(metric string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	name := metric
+	for _, k := range keys {
+		name += ";" + k + "=" + tags[k]
+	}
+	return name
+}
@@ -0,0 +1,21 @@
+package stat
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// ParseTime parses an RFC 3339 time, or a number of seconds since the epoch
+func ParseTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		whole, frac := math.Modf(secs)
+		return time.Unix(int64(whole), int64(frac*1e9)).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("timestamp %q is neither RFC 3339 nor seconds since the epoch", s)
+	}
+	return t.UTC(), nil
+}
@@ -0,0 +1,394 @@
+// Package wal is a write-ahead log of accepted stats, so that the stats held in
+// memory by the Bucketer and queued for the StatRepo survive a crash
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentSuffix = ".wal"
+
+	// SegmentAge and SegmentSize bound the active segment, after which a new
+	// segment is started. Small segments are truncated sooner
+	SegmentAge  = time.Minute
+	SegmentSize = 64 << 20
+)
+
+// segment is one file of the log
+type segment struct {
+	id      uint64
+	path    string
+	created time.Time
+	size    int64
+	newest  time.Time // the latest timestamp of the stats in the segment
+	pending int       // the number of stats in the segment not yet persisted
+}
+
+// entry locates a stat not yet persisted in the log
+type entry struct {
+	seg    *segment
+	offset int64 // of the stat's record in the segment
+}
+
+// Log appends stats to a directory of segment files. A segment is truncated
+// once every stat in it has been persisted by the StatRepo and the Bucketer has
+// finalized the buckets of every stat in it. A segment holding a stat that was
+// dropped, or failed to insert, is kept until the next run replays it.
+//
+// After a crash the stats of the remaining segments are replayed, including
+// any that were persisted, so the repo must store a stat written twice once.
+// The Origin of a stat, its position in the log, is the same when it is
+// replayed, for the repo to tell it was written before.
+//
+// Log is safe for concurrent use, since stats are appended, persisted and
+// finalized by different goroutines
+type Log struct {
+	dir string
+
+	mu        sync.Mutex
+	segments  []*segment // oldest first, the last is the active segment
+	active    *os.File
+	entries   map[*stat.Stat]entry // the position of each stat not yet persisted
+	replay    []*stat.Stat         // the stats of the segments found by Open
+	finalized time.Time            // stats before this time are in finalized buckets
+	drained   bool                 // whether the pipeline has shut down cleanly
+	now       func() time.Time
+}
+
+// Open opens the log in dir, creating dir if need be. The stats of any existing
+// segments are returned by Replay
+func Open(dir string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:     dir,
+		entries: make(map[*stat.Stat]entry),
+		now:     time.Now,
+	}
+
+	ids, err := segmentIds(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		seg := &segment{id: id, path: segmentPath(dir, id)}
+		stats, err := readSegment(seg.path)
+		if err != nil {
+			return nil, fmt.Errorf("wal: error reading %s: %v", seg.path, err)
+		}
+		l.segments = append(l.segments, seg)
+		for _, s := range stats {
+			l.track(s, seg)
+			seg.size += recordSize(s)
+		}
+		l.replay = append(l.replay, stats...)
+	}
+
+	if err := l.rotate(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Replay passes each stat of the segments found by Open to f, oldest first,
+// returning the number of stats replayed. Replayed stats must be persisted and
+// finalized like appended stats before their segments are truncated
+func (l *Log) Replay(f func(*stat.Stat)) int {
+	l.mu.Lock()
+	stats := l.replay
+	l.replay = nil
+	l.mu.Unlock()
+
+	for _, s := range stats {
+		f(s)
+	}
+	return len(stats)
+}
+
+// Append writes a stat to the active segment, starting a new segment if the
+// active one is too old or too big
+func (l *Log) Append(s *stat.Stat) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seg := l.segments[len(l.segments)-1]
+	if l.now().Sub(seg.created) >= SegmentAge || seg.size >= SegmentSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+		seg = l.segments[len(l.segments)-1]
+		l.truncate()
+	}
+
+	record := encode(s)
+	if _, err := l.active.Write(record); err != nil {
+		return err
+	}
+	l.track(s, seg)
+	seg.size += int64(len(record))
+	return nil
+}
+
+// track records that a stat at the end of seg is waiting to be persisted
+func (l *Log) track(s *stat.Stat, seg *segment) {
+	l.entries[s] = entry{seg, seg.size}
+	seg.pending++
+	if s.Timestamp.After(seg.newest) {
+		seg.newest = s.Timestamp
+	}
+}
+
+// Persisted records that a stat has been written to the repo. Stats that were
+// never appended are ignored
+func (l *Log) Persisted(s *stat.Stat) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[s]
+	if !ok {
+		return
+	}
+	e.seg.pending--
+	delete(l.entries, s)
+	l.truncate()
+}
+
+// Origin returns the position in the log of a stat not yet persisted, which is
+// the same when the stat is replayed after a crash, or false if the stat was
+// never appended
+func (l *Log) Origin(s *stat.Stat) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[s]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("wal/%d/%d", e.seg.id, e.offset), true
+}
+
+// Finalized records that the buckets of every stat before t are finalized
+func (l *Log) Finalized(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if t.After(l.finalized) {
+		l.finalized = t
+		l.truncate()
+	}
+}
+
+// Drained records that the pipeline has shut down cleanly, so that the Bucketer
+// has published every bucket and the StatRepo has written every stat it could.
+// Every segment whose stats have all been persisted is removed, the active one
+// included, and nothing is replayed from them. It must be called after Close
+func (l *Log) Drained() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.finalized = time.Unix(1<<62, 0)
+	l.drained = true
+	l.truncate()
+}
+
+// truncate removes the segments, other than the active one unless the log has
+// been drained, whose stats have all been persisted and finalized
+func (l *Log) truncate() {
+	kept := l.segments[:0]
+	for i, seg := range l.segments {
+		active := i == len(l.segments)-1 && !l.drained
+		if active || seg.pending > 0 || !seg.newest.Before(l.finalized) {
+			kept = append(kept, seg)
+			continue
+		}
+
+		if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+			log.Error("wal: error removing segment: ", err)
+			kept = append(kept, seg)
+			continue
+		}
+		log.Debug("wal: truncated segment ", seg.path)
+	}
+	l.segments = kept
+}
+
+// rotate closes the active segment, if any, and starts a new one. Ids start
+// from the clock rather than 1, so that no later run reuses the Origin of a stat
+// once every segment has been removed
+func (l *Log) rotate() error {
+	id := uint64(l.now().UnixNano())
+	if n := len(l.segments); n > 0 && l.segments[n-1].id >= id {
+		id = l.segments[n-1].id + 1
+	}
+
+	if l.active != nil {
+		if err := l.active.Sync(); err != nil {
+			return err
+		}
+		if err := l.active.Close(); err != nil {
+			return err
+		}
+	}
+
+	seg := &segment{id: id, path: segmentPath(l.dir, id), created: l.now()}
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	l.active = f
+	l.segments = append(l.segments, seg)
+	return nil
+}
+
+// Sync flushes the active segment to stable storage
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active.Sync()
+}
+
+// Close syncs and closes the active segment
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.active.Sync(); err != nil {
+		l.active.Close()
+		return err
+	}
+	return l.active.Close()
+}
+
+// Segments returns the number of segment files in the log
+func (l *Log) Segments() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.segments)
+}
+
+func segmentPath(dir string, id uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%016d%s", id, segmentSuffix))
+}
+
+// segmentIds returns the ids of the segments in dir, in ascending order
+func segmentIds(dir string) ([]uint64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// recordSize returns the length of the record of a stat
+func recordSize(s *stat.Stat) int64 {
+	n := len(s.Name)
+	if n > math.MaxUint16 {
+		n = math.MaxUint16
+	}
+	return int64(2 + n + 8 + 8 + 4)
+}
+
+// A record is the length of the name, the name, the timestamp in nanoseconds,
+// the value, and a CRC of all of the above:
+//
+//	uint16 | name | int64 | float64 bits | uint32
+func encode(s *stat.Stat) []byte {
+	name := s.Name
+	if len(name) > math.MaxUint16 {
+		name = name[:math.MaxUint16]
+	}
+
+	record := make([]byte, 2+len(name)+8+8+4)
+	binary.BigEndian.PutUint16(record, uint16(len(name)))
+	copy(record[2:], name)
+	i := 2 + len(name)
+	binary.BigEndian.PutUint64(record[i:], uint64(s.Timestamp.UnixNano()))
+	binary.BigEndian.PutUint64(record[i+8:], math.Float64bits(s.Value))
+	binary.BigEndian.PutUint32(record[i+16:], crc32.ChecksumIEEE(record[:i+16]))
+	return record
+}
+
+// readSegment reads the stats of a segment. A crash can leave the last record
+// partially written, so reading stops quietly at the first incomplete or
+// corrupt record
+func readSegment(path string) ([]*stat.Stat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var stats []*stat.Stat
+	r := bufio.NewReader(f)
+	for {
+		s, err := decode(r)
+		if err == io.EOF {
+			return stats, nil
+		} else if err != nil {
+			log.Warnf("wal: ignoring the rest of %s after %d stats: %v", path, len(stats), err)
+			return stats, nil
+		}
+		stats = append(stats, s)
+	}
+}
+
+// decode reads one record
+func decode(r io.Reader) (*stat.Stat, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	n := int(binary.BigEndian.Uint16(length[:]))
+	record := make([]byte, 2+n+8+8+4)
+	copy(record, length[:])
+	if _, err := io.ReadFull(r, record[2:]); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	i := 2 + n
+	if crc32.ChecksumIEEE(record[:i+16]) != binary.BigEndian.Uint32(record[i+16:]) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+
+	return &stat.Stat{
+		Name:      string(record[2:i]),
+		Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(record[i:]))).UTC(),
+		Value:     math.Float64frombits(binary.BigEndian.Uint64(record[i+8:])),
+	}, nil
+}
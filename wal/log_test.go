@@ -0,0 +1,218 @@
+package wal
+
+import (
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+var _ = Describe("Log", func() {
+
+	var dir string
+	var now time.Time
+
+	open := func() *Log {
+		l, err := Open(dir)
+		Expect(err).To(BeNil())
+		l.now = func() time.Time { return now }
+		return l
+	}
+
+	// firstSegment returns the path of the oldest segment in dir
+	firstSegment := func() string {
+		ids, err := segmentIds(dir)
+		Expect(err).To(BeNil())
+		Expect(ids).NotTo(BeEmpty())
+		return segmentPath(dir, ids[0])
+	}
+
+	replay := func(l *Log) []*stat.Stat {
+		var stats []*stat.Stat
+		l.Replay(func(s *stat.Stat) { stats = append(stats, s) })
+		return stats
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "gostat-wal")
+		Expect(err).To(BeNil())
+		now = time.Now().UTC().Add(time.Second) // after the first segment is created
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should replay the stats appended by the previous run", func() {
+		l := open()
+		Expect(replay(l)).To(BeEmpty())
+
+		s1 := &stat.Stat{Name: "foo;host=a", Timestamp: now.Add(time.Second), Value: 1.5}
+		s2 := &stat.Stat{Name: "bar", Timestamp: now.Add(time.Second * 2), Value: -2}
+		Expect(l.Append(s1)).To(BeNil())
+		Expect(l.Append(s2)).To(BeNil())
+		Expect(l.Close()).To(BeNil())
+
+		l = open()
+		Expect(replay(l)).To(Equal([]*stat.Stat{s1, s2}))
+		Expect(replay(l)).To(BeEmpty())
+	})
+
+	It("should give a replayed stat the origin it was appended with", func() {
+		l := open()
+		s1 := &stat.Stat{Name: "foo", Timestamp: now, Value: 1}
+		s2 := &stat.Stat{Name: "foo", Timestamp: now, Value: 1}
+		Expect(l.Append(s1)).To(BeNil())
+		Expect(l.Append(s2)).To(BeNil())
+		o1, ok := l.Origin(s1)
+		Expect(ok).To(BeTrue())
+		o2, _ := l.Origin(s2)
+		Expect(o2).NotTo(Equal(o1))
+		Expect(l.Close()).To(BeNil())
+
+		l = open()
+		stats := replay(l)
+		replayed1, _ := l.Origin(stats[0])
+		replayed2, _ := l.Origin(stats[1])
+		Expect(replayed1).To(Equal(o1))
+		Expect(replayed2).To(Equal(o2))
+
+		_, ok = l.Origin(&stat.Stat{Name: "foo", Timestamp: now, Value: 1})
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should ignore a partially written record at the end of a segment", func() {
+		l := open()
+		s1 := &stat.Stat{Name: "foo", Timestamp: now, Value: 1}
+		Expect(l.Append(s1)).To(BeNil())
+		Expect(l.Close()).To(BeNil())
+
+		f, err := os.OpenFile(firstSegment(), os.O_WRONLY|os.O_APPEND, 0644)
+		Expect(err).To(BeNil())
+		record := encode(&stat.Stat{Name: "bar", Timestamp: now, Value: 2})
+		f.Write(record[:len(record)-3])
+		f.Close()
+
+		Expect(replay(open())).To(Equal([]*stat.Stat{s1}))
+	})
+
+	It("should start a new segment once the active segment is a minute old", func() {
+		l := open()
+		Expect(l.Append(&stat.Stat{Name: "foo", Timestamp: now, Value: 1})).To(BeNil())
+		Expect(l.Segments()).To(Equal(1))
+
+		now = now.Add(SegmentAge)
+		Expect(l.Append(&stat.Stat{Name: "foo", Timestamp: now, Value: 2})).To(BeNil())
+		Expect(l.Segments()).To(Equal(2))
+	})
+
+	It("should truncate a segment once its stats are persisted and finalized", func() {
+		l := open()
+		s1 := &stat.Stat{Name: "foo", Timestamp: now, Value: 1}
+		s2 := &stat.Stat{Name: "foo", Timestamp: now.Add(time.Second), Value: 2}
+		Expect(l.Append(s1)).To(BeNil())
+		Expect(l.Append(s2)).To(BeNil())
+		first := firstSegment()
+
+		now = now.Add(SegmentAge)
+		s3 := &stat.Stat{Name: "foo", Timestamp: now, Value: 3}
+		Expect(l.Append(s3)).To(BeNil())
+		Expect(l.Segments()).To(Equal(2))
+
+		// finalized, but not yet persisted
+		l.Finalized(now)
+		Expect(l.Segments()).To(Equal(2))
+
+		l.Persisted(s1)
+		Expect(l.Segments()).To(Equal(2))
+
+		l.Persisted(s2)
+		Expect(l.Segments()).To(Equal(1))
+		_, err := os.Stat(first)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+
+		// the active segment is never truncated
+		l.Persisted(s3)
+		l.Finalized(now.Add(time.Hour))
+		Expect(l.Segments()).To(Equal(1))
+	})
+
+	It("should not truncate a segment whose buckets are not finalized", func() {
+		l := open()
+		s1 := &stat.Stat{Name: "foo", Timestamp: now.Add(time.Second * 30), Value: 1}
+		Expect(l.Append(s1)).To(BeNil())
+		now = now.Add(SegmentAge)
+		Expect(l.Append(&stat.Stat{Name: "foo", Timestamp: now, Value: 2})).To(BeNil())
+
+		l.Persisted(s1)
+		l.Finalized(s1.Timestamp)
+		Expect(l.Segments()).To(Equal(2))
+
+		l.Finalized(s1.Timestamp.Add(time.Nanosecond))
+		Expect(l.Segments()).To(Equal(1))
+	})
+
+	It("should keep a segment until every one of its stats is persisted", func() {
+		l := open()
+		failed := &stat.Stat{Name: "foo", Timestamp: now, Value: 1}
+		s2 := &stat.Stat{Name: "foo", Timestamp: now, Value: 2}
+		Expect(l.Append(failed)).To(BeNil())
+		Expect(l.Append(s2)).To(BeNil())
+		now = now.Add(SegmentAge)
+		Expect(l.Append(&stat.Stat{Name: "foo", Timestamp: now, Value: 3})).To(BeNil())
+
+		l.Finalized(now)
+		l.Persisted(&stat.Stat{Name: "never appended"})
+		l.Persisted(s2)
+		Expect(l.Segments()).To(Equal(2))
+		Expect(l.Close()).To(BeNil())
+
+		// the stat whose insert failed is replayed by the next run
+		Expect(replay(open())).To(ContainElement(failed))
+	})
+
+	It("should remove every persisted segment once the pipeline has drained", func() {
+		l := open()
+		s1 := &stat.Stat{Name: "foo", Timestamp: now, Value: 1}
+		Expect(l.Append(s1)).To(BeNil())
+		now = now.Add(SegmentAge)
+		s2 := &stat.Stat{Name: "foo", Timestamp: now, Value: 2}
+		Expect(l.Append(s2)).To(BeNil())
+		l.Persisted(s1)
+		l.Persisted(s2)
+		Expect(l.Close()).To(BeNil())
+
+		l.Drained()
+		Expect(l.Segments()).To(Equal(0))
+		Expect(replay(open())).To(BeEmpty())
+	})
+
+	It("should keep the segments of stats not persisted when the pipeline drains", func() {
+		l := open()
+		failed := &stat.Stat{Name: "foo", Timestamp: now, Value: 1}
+		Expect(l.Append(failed)).To(BeNil())
+		Expect(l.Close()).To(BeNil())
+
+		l.Drained()
+		Expect(l.Segments()).To(Equal(1))
+		Expect(replay(open())).To(Equal([]*stat.Stat{failed}))
+	})
+
+	It("should truncate replayed segments once their stats are persisted and finalized", func() {
+		l := open()
+		Expect(l.Append(&stat.Stat{Name: "foo", Timestamp: now, Value: 1})).To(BeNil())
+		Expect(l.Close()).To(BeNil())
+
+		l = open()
+		Expect(l.Segments()).To(Equal(2))
+		stats := replay(l)
+		l.Finalized(now.Add(time.Minute))
+		Expect(l.Segments()).To(Equal(2))
+
+		l.Persisted(stats[0])
+		Expect(l.Segments()).To(Equal(1))
+	})
+})
@@ -0,0 +1,13 @@
+package wal
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestWal(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Wal Suite")
+}
@@ -0,0 +1,100 @@
+package wal
+
+import (
+	"github.com/CapillarySoftware/gostat/stat"
+	log "github.com/cihub/seelog"
+	"time"
+)
+
+// Writer appends each accepted stat to a Log before passing it on to the
+// Bucketer and the StatRepo. When it starts it first replays the stats left in
+// the Log by the previous run
+type Writer struct {
+	log          *Log
+	syncInterval time.Duration
+
+	input    <-chan *stat.Stat   // accepted stats are read from this channel
+	outputs  []chan<- *stat.Stat // logged stats are written to each of these channels
+	shutdown <-chan bool         // signals a graceful shutdown
+}
+
+// NewWriter constructs a Writer. The Log is synced to stable storage every
+// syncInterval. If the Log is nil, stats are passed on without being logged
+func NewWriter(l *Log, syncInterval time.Duration, accepted <-chan *stat.Stat, shutdown <-chan bool, outputs ...chan<- *stat.Stat) *Writer {
+	return &Writer{
+		log:          l,
+		syncInterval: syncInterval,
+		input:        accepted,
+		outputs:      outputs,
+		shutdown:     shutdown,
+	}
+}
+
+// Run is a goroutine that replays the Log, then logs and passes on each stat
+// read from the input channel
+func (w *Writer) Run() {
+	done := false
+	syncTicker := time.NewTicker(w.syncInterval)
+
+	if w.log == nil {
+		syncTicker.Stop()
+	} else if n := w.log.Replay(w.forward); n > 0 {
+		log.Infof("wal: replayed %d stats", n)
+	}
+
+	for !done {
+		select {
+		case s := <-w.input:
+			w.write(s)
+		case <-syncTicker.C:
+			if err := w.log.Sync(); err != nil {
+				log.Error("wal: error syncing: ", err)
+			}
+		case <-w.shutdown:
+			log.Info("wal Writer shutting down ", time.Now())
+			done = true
+			syncTicker.Stop()
+			w.drain()
+			if w.log == nil {
+				break
+			}
+			if err := w.log.Close(); err != nil {
+				log.Error("wal: error closing: ", err)
+			}
+		}
+	}
+
+	log.Info("wal Writer Run() exiting ", time.Now())
+}
+
+// drain writes the stats already waiting on the input channel
+func (w *Writer) drain() {
+	for {
+		select {
+		case s := <-w.input:
+			w.write(s)
+		default:
+			return
+		}
+	}
+}
+
+// write logs a stat and passes it on. A stat that cannot be logged is still
+// passed on, it just will not survive a crash
+func (w *Writer) write(s *stat.Stat) {
+	if s == nil {
+		return
+	}
+	if w.log != nil {
+		if err := w.log.Append(s); err != nil {
+			log.Error("wal: error appending stat: ", err)
+		}
+	}
+	w.forward(s)
+}
+
+func (w *Writer) forward(s *stat.Stat) {
+	for _, output := range w.outputs {
+		output <- s
+	}
+}
@@ -0,0 +1,70 @@
+package wal
+
+import (
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+var _ = Describe("Writer", func() {
+
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "gostat-wal")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should replay, then log and pass on accepted stats until shut down", func(done Done) {
+		l, err := Open(dir)
+		Expect(err).To(BeNil())
+		replayed := &stat.Stat{Name: "foo", Timestamp: time.Now().UTC(), Value: 1}
+		Expect(l.Append(replayed)).To(BeNil())
+		Expect(l.Close()).To(BeNil())
+
+		l, err = Open(dir)
+		Expect(err).To(BeNil())
+
+		accepted := make(chan *stat.Stat, 1)
+		stats := make(chan *stat.Stat, 2)
+		rawStats := make(chan *stat.Stat, 2)
+		shutdown := make(chan bool)
+
+		s := &stat.Stat{Name: "bar", Timestamp: time.Now().UTC(), Value: 2}
+		accepted <- s
+		close(shutdown)
+
+		NewWriter(l, time.Second, accepted, shutdown, stats, rawStats).Run()
+
+		Expect((<-stats).Name).To(Equal("foo"))
+		Expect(<-stats).To(Equal(s))
+		Expect((<-rawStats).Name).To(Equal("foo"))
+		Expect(<-rawStats).To(Equal(s))
+
+		l, err = Open(dir)
+		Expect(err).To(BeNil())
+		Expect(l.Replay(func(*stat.Stat) {})).To(Equal(2))
+		close(done)
+	})
+
+	It("should pass on stats without a Log", func() {
+		accepted := make(chan *stat.Stat, 1)
+		stats := make(chan *stat.Stat, 1)
+		shutdown := make(chan bool)
+
+		s := &stat.Stat{Name: "bar", Timestamp: time.Now().UTC(), Value: 2}
+		accepted <- s
+		close(shutdown)
+
+		NewWriter(nil, time.Second, accepted, shutdown, stats).Run()
+		Expect(<-stats).To(Equal(s))
+	})
+})
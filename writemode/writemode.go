@@ -0,0 +1,108 @@
+// Package writemode decides what happens when a series records more than one
+// stat with the same timestamp, which is common when several hosts report under
+// the same name
+package writemode
+
+import (
+	"fmt"
+	"github.com/CapillarySoftware/gostat/query"
+	"github.com/CapillarySoftware/gostat/stat"
+	"strings"
+)
+
+// Mode is what a series does with stats that share a timestamp
+type Mode string
+
+const (
+	LastWriteWins Mode = "last" // the last stat received replaces the others
+	KeepAll       Mode = "keep" // every stat is kept
+	Sum           Mode = "sum"  // the stats are summed into one
+)
+
+// ParseMode parses a Mode
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case LastWriteWins, KeepAll, Sum:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown write mode %q, use last, keep or sum", s)
+}
+
+// Rules choose the Mode of each series by matching its name against patterns,
+// as in queries, in order
+type Rules struct {
+	rules       []rule
+	defaultMode Mode
+}
+
+type rule struct {
+	pattern string
+	mode    Mode
+}
+
+// ParseRules parses rules of the form pattern=mode, e.g. web.*.requests=sum.
+// Series matching none of the rules have the default mode
+func ParseRules(rules []string, defaultMode string) (*Rules, error) {
+	def, err := ParseMode(defaultMode)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Rules{defaultMode: def}
+	for _, s := range rules {
+		// patterns may hold tags, such as web.requests;dc=east, so split at the last =
+		i := strings.LastIndexByte(s, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("write mode rule %q is not pattern=mode", s)
+		}
+		mode, err := ParseMode(s[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, rule{s[:i], mode})
+	}
+	return r, nil
+}
+
+// Mode returns the Mode of the series name. Nil Rules give every series the
+// LastWriteWins mode
+func (r *Rules) Mode(name string) Mode {
+	if r == nil {
+		return LastWriteWins
+	}
+	for _, rule := range r.rules {
+		if query.MatchName(rule.pattern, name) {
+			return rule.mode
+		}
+	}
+	return r.defaultMode
+}
+
+// Collapse returns the stats of one series as the Mode keeps them: the last of
+// those with each timestamp, all of them, or one with the sum of their values.
+// The stats are in the order their timestamps were first seen, and the stats
+// given are never changed
+func Collapse(mode Mode, stats []*stat.Stat) []*stat.Stat {
+	if mode == KeepAll {
+		return append([]*stat.Stat(nil), stats...)
+	}
+
+	collapsed := make([]*stat.Stat, 0, len(stats))
+	index := make(map[int64]int, len(stats)) // by UnixNano timestamp
+	for _, s := range stats {
+		ts := s.Timestamp.UnixNano()
+		i, seen := index[ts]
+		switch {
+		case !seen:
+			index[ts] = len(collapsed)
+			collapsed = append(collapsed, s)
+		case mode == Sum:
+			summed := *collapsed[i]
+			summed.Value += s.Value
+			collapsed[i] = &summed
+		default:
+			collapsed[i] = s
+		}
+	}
+	return collapsed
+}
@@ -0,0 +1,13 @@
+package writemode
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestWritemode(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Writemode Suite")
+}
@@ -0,0 +1,67 @@
+package writemode
+
+import (
+	"github.com/CapillarySoftware/gostat/stat"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("Rules", func() {
+	It("should choose the mode of the first rule the name matches", func() {
+		r, err := ParseRules([]string{"web.*.requests=sum", "web.requests;dc=east=keep", "web.*.*=keep"}, "last")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(r.Mode("web.host1.requests")).To(Equal(Sum))
+		Expect(r.Mode("web.host1.errors")).To(Equal(KeepAll))
+		Expect(r.Mode("web.requests;dc=east;host=web1")).To(Equal(KeepAll))
+		Expect(r.Mode("db.queries")).To(Equal(LastWriteWins))
+	})
+
+	It("should give every series last-write-wins without rules", func() {
+		var r *Rules
+		Expect(r.Mode("web.requests")).To(Equal(LastWriteWins))
+	})
+
+	It("should reject malformed rules", func() {
+		for _, rules := range [][]string{{"web.requests"}, {"=sum"}, {"web.requests=max"}} {
+			_, err := ParseRules(rules, "last")
+			Expect(err).To(HaveOccurred(), "%v", rules)
+		}
+		_, err := ParseRules(nil, "first")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Collapse", func() {
+	t0 := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+	var stats []*stat.Stat
+
+	BeforeEach(func() {
+		stats = []*stat.Stat{
+			{Name: "foo", Timestamp: t0, Value: 1},
+			{Name: "foo", Timestamp: t0.Add(time.Second), Value: 2},
+			{Name: "foo", Timestamp: t0.Local(), Value: 3},
+		}
+	})
+
+	values := func(stats []*stat.Stat) (v []float64) {
+		for _, s := range stats {
+			v = append(v, s.Value)
+		}
+		return
+	}
+
+	It("should keep the last stat of each timestamp", func() {
+		Expect(values(Collapse(LastWriteWins, stats))).To(Equal([]float64{3, 2}))
+	})
+
+	It("should keep every stat", func() {
+		Expect(values(Collapse(KeepAll, stats))).To(Equal([]float64{1, 2, 3}))
+	})
+
+	It("should sum the stats of each timestamp without changing them", func() {
+		Expect(values(Collapse(Sum, stats))).To(Equal([]float64{4, 2}))
+		Expect(values(stats)).To(Equal([]float64{1, 2, 3}))
+	})
+})